@@ -0,0 +1,132 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Scalable is implemented by components whose concurrency (e.g. number of shard readers
+// or pool writers) can be changed while the pipe is running.
+type Scalable interface {
+	Concurrency() int
+	SetConcurrency(n int)
+}
+
+// Adapt returns a Valve that tunes the concurrency of the given components while the pipe
+// runs, rather than having to fix it up front (what's best depends on the workload, see
+// the benchmarks). The valve passes regions through untouched, and every interval it
+// compares the throughput and latency (how long it's blocked handing regions downstream)
+// to the previous interval, AIMD-style:
+//   - throughput dropped by more than 10% or latency more than doubled: the concurrency
+//     is halved (but not below floor)
+//   - otherwise the concurrency is increased by one (but not above ceiling)
+//
+// An interval of 0 or less fails the run, whether or not ticks are given (see Ticks).
+func Adapt(floor, ceiling int, interval time.Duration, targets ...Scalable) *controller {
+	return &controller{
+		min:      floor,
+		max:      ceiling,
+		interval: interval,
+		targets:  targets,
+	}
+}
+
+// Ticks has the controller adjust the concurrency on every tick of ticks rather than
+// every interval (the throughput is still worked out over the interval), to adjust it in
+// step with something else, and returns it.
+func (c *controller) Ticks(ticks <-chan time.Time) *controller {
+	c.ticks = ticks
+	return c
+}
+
+type controller struct {
+	min, max int
+	interval time.Duration
+	ticks    <-chan time.Time
+	targets  []Scalable
+
+	rate    float64 // bytes per second during the last interval
+	latency time.Duration
+}
+
+func (c *controller) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+
+		if c.interval <= 0 {
+			errs <- fmt.Errorf("adapt: invalid interval %v", c.interval)
+			discard(source)
+			return
+		}
+		// a controller is opened afresh by every run, comparing its first interval to
+		// nothing rather than to the end of the previous run
+		c.rate, c.latency = 0, 0
+
+		ticks := c.ticks
+		if ticks == nil {
+			ticker := time.NewTicker(c.interval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+
+		var bytes int64
+		var regions int
+		var blocked time.Duration
+		for {
+			select {
+			case r, more := <-source:
+				if !more || ctx.Err() != nil {
+					return
+				}
+
+				start := time.Now()
+				select {
+				case sink <- r:
+				case <-ctx.Done():
+					return
+				}
+				blocked += time.Since(start)
+
+				bytes += int64(len(r.Data))
+				regions++
+
+			case <-ticks:
+				var latency time.Duration
+				if regions > 0 {
+					latency = blocked / time.Duration(regions)
+				}
+				c.adjust(float64(bytes)/c.interval.Seconds(), latency)
+
+				bytes, regions, blocked = 0, 0, 0
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+func (c *controller) adjust(rate float64, latency time.Duration) {
+	if len(c.targets) == 0 {
+		return
+	}
+
+	n := c.targets[0].Concurrency()
+	congested := rate < c.rate*0.9 || (c.latency > 0 && latency > 2*c.latency)
+	if congested {
+		n = max(n/2, c.min)
+	} else {
+		n = min(n+1, c.max)
+	}
+
+	for _, t := range c.targets {
+		t.SetConcurrency(n)
+	}
+
+	c.rate, c.latency = rate, latency
+}
@@ -0,0 +1,376 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestAdapt(t *testing.T) {
+	// given: a sink that keeps up for a while, then slows down to a crawl, with as many
+	// regions handed through between every tick
+	target := &scalable{}
+	target.n.Store(1)
+
+	var slow atomic.Bool
+	received := make(chan struct{}, 1)
+	sinkFunc := func(r pipe.Region) error {
+		if slow.Load() {
+			time.Sleep(20 * time.Millisecond)
+		} else {
+			time.Sleep(2 * time.Millisecond)
+		}
+		received <- struct{}{}
+		return nil
+	}
+
+	feed := make(chan pipe.Region)
+	source := sourceFunc(func(ctx context.Context, sink chan pipe.Region, errs chan error) {
+		defer close(sink)
+		for r := range feed {
+			sink <- r
+		}
+	})
+
+	ticks := make(chan time.Time)
+	p := pipe.New(source, &sink{f: sinkFunc}, pipe.Adapt(1, 4, 20*time.Millisecond, target).Ticks(ticks))
+	done := make(chan error)
+	go func() { done <- p.Pipe(context.Background()) }()
+
+	interval := func() {
+		for i := range 5 {
+			feed <- pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+		}
+		for range 5 {
+			<-received
+		}
+		ticks <- time.Now()
+	}
+
+	// when
+	for range 4 {
+		interval()
+	}
+	slow.Store(true)
+	interval()
+	close(feed)
+	assert.NilError(t, <-done)
+
+	// then: it scaled up while the sink kept up, and backed off once it didn't
+	assert.DeepEqual(t, target.history(), []int{2, 3, 4, 4, 2})
+}
+
+func TestAdapt_rerun(t *testing.T) {
+	// given: a controller whose first run went faster than the next one starts out
+	target := &scalable{}
+	target.n.Store(2)
+	ticks := make(chan time.Time)
+	adapt := pipe.Adapt(1, 4, 20*time.Millisecond, target).Ticks(ticks)
+
+	run := func(regions int) {
+		ticked := make(chan struct{})
+		received := make(chan struct{}, regions)
+		source := sourceFunc(func(ctx context.Context, sink chan pipe.Region, errs chan error) {
+			defer close(sink)
+			for i := range regions {
+				sink <- pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+			}
+			<-ticked
+		})
+		sinkFunc := func(pipe.Region) error {
+			received <- struct{}{}
+			return nil
+		}
+
+		done := make(chan error)
+		go func() { done <- pipe.New(source, &sink{f: sinkFunc}, adapt).Pipe(context.Background()) }()
+		for range regions {
+			<-received
+		}
+		ticks <- time.Now()
+		close(ticked)
+		assert.NilError(t, <-done)
+	}
+
+	// when
+	run(10)
+	run(1)
+
+	// then: the second run isn't compared to the first
+	assert.DeepEqual(t, target.history(), []int{3, 4})
+}
+
+func TestAdapt_invalid(t *testing.T) {
+	// given
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}, pipe.Adapt(1, 4, 0, &scalable{}))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "invalid interval 0s")
+}
+
+func TestPool_SetConcurrency(t *testing.T) {
+	for _, n := range []int{1, 3} {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			// given
+			var c concurrency
+			writers := make([]io.WriterAt, 4)
+			for i := range writers {
+				writers[i] = writerFunc(func(p []byte, off int64) (int, error) {
+					defer c.enter()()
+					time.Sleep(2 * time.Millisecond)
+					return len(p), nil
+				})
+			}
+			pool := pipeio.Pool(pipeio.NewBuffer(10, 1), writers...)
+
+			many := make([]pipe.Region, 50)
+			for i := range many {
+				many[i] = pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+			}
+
+			// when
+			pool.SetConcurrency(n)
+			assert.NilError(t, pipe.New(&source{regions: many}, pool).Pipe(context.Background()))
+
+			// then: idle writers were parked right away
+			assert.Equal(t, pool.Concurrency(), n)
+			assert.Equal(t, c.peak(), int64(n))
+		})
+	}
+}
+
+func TestPool_Grow(t *testing.T) {
+	// given: a pool of a single writer, which can open more
+	var c concurrency
+	var grown sync.Once
+	var pool interface {
+		pipe.Sink
+		pipe.Scalable
+		Writers() []io.WriterAt
+	}
+	open := func() (io.WriterAt, error) {
+		return writerFunc(func(p []byte, off int64) (int, error) {
+			defer c.enter()()
+			time.Sleep(2 * time.Millisecond)
+			return len(p), nil
+		}), nil
+	}
+	first := writerFunc(func(p []byte, off int64) (int, error) {
+		// when: it's told to grow mid-run
+		grown.Do(func() { pool.SetConcurrency(5) })
+		defer c.enter()()
+		time.Sleep(2 * time.Millisecond)
+		return len(p), nil
+	})
+	pool = pipeio.Pool(pipeio.NewBuffer(10, 1), first).Grow(3, open)
+
+	many := make([]pipe.Region, 50)
+	for i := range many {
+		many[i] = pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+	}
+	p := pipe.New(&source{regions: many}, pool)
+	err := p.Pipe(context.Background())
+
+	// then: it grew as large as it could, and put the new writers to work
+	assert.NilError(t, err)
+	assert.Equal(t, pool.Concurrency(), 3)
+	assert.Equal(t, len(pool.Writers()), 3)
+	assert.Equal(t, c.peak(), int64(3))
+	assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 500}})
+}
+
+func TestPool_Grow_empty(t *testing.T) {
+	// given: a pool starting out empty
+	boom := errors.New("boom")
+	var opened int
+	open := func() (io.WriterAt, error) {
+		opened++
+		return &recordingWriter{}, nil
+	}
+
+	// when
+	err := pipe.New(&pipetest.Source{Regions: regions}, pipeio.Pool(pipeio.NewBuffer(10, 1)).Grow(2, open)).Pipe(context.Background())
+
+	// then: a writer is opened for the run
+	assert.NilError(t, err)
+	assert.Equal(t, opened, 1)
+
+	// and: failing to open one fails the run
+	pool := pipeio.Pool(pipeio.NewBuffer(10, 1)).Grow(2, func() (io.WriterAt, error) { return nil, boom })
+	err = pipe.New(&pipetest.Source{Regions: regions}, pool).Pipe(context.Background())
+	assert.ErrorIs(t, err, boom)
+	assert.ErrorIs(t, pool.Err(), boom)
+}
+
+func TestFan_SetConcurrency(t *testing.T) {
+	// given
+	var c concurrency
+	sources := make([]pipe.Source, 4)
+	for i := range sources {
+		sources[i] = sourceFunc(func(ctx context.Context, sink chan pipe.Region, errs chan error) {
+			defer close(sink)
+			defer c.enter()()
+
+			for j := range 3 {
+				time.Sleep(2 * time.Millisecond)
+				sink <- pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i*30 + j*10)}
+			}
+		})
+	}
+	fan := pipe.Fan(sources...)
+
+	var read int
+	sinkFunc := func(pipe.Region) error {
+		read++
+		return nil
+	}
+
+	// when
+	fan.SetConcurrency(2)
+	assert.NilError(t, pipe.New(fan, &sink{f: sinkFunc}).Pipe(context.Background()))
+
+	// then: the sources took turns
+	assert.Equal(t, read, 12)
+	assert.Equal(t, c.peak(), int64(2))
+}
+
+func TestFan_Limit(t *testing.T) {
+	// given: more sources than are read from at once
+	var c concurrency
+	sources := make([]pipe.Source, 10)
+	for i := range sources {
+		sources[i] = sourceFunc(func(ctx context.Context, sink chan pipe.Region, errs chan error) {
+			defer close(sink)
+			defer c.enter()()
+
+			time.Sleep(2 * time.Millisecond)
+			sink <- pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+		})
+	}
+	sink := &pipetest.Sink{}
+
+	// when
+	err := pipe.New(pipe.Fan(sources...).Limit(3), sink).Pipe(context.Background())
+
+	// then: every source was read from, up to three at a time
+	assert.NilError(t, err)
+	assert.Equal(t, len(sink.Regions()), 10)
+	assert.Equal(t, c.peak(), int64(3))
+}
+
+func TestFan_Fair(t *testing.T) {
+	tests := map[string]struct {
+		weights []int
+		share   int // of the first 40 regions, from the first source
+	}{
+		"even":     {share: 20},
+		"weighted": {weights: []int{3, 1}, share: 30},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given: two sources with plenty to pass on, and a slow sink
+			fan := pipe.Fan(
+				&pipetest.Source{Regions: pipetest.Regions(60, 10)},
+				&pipetest.Source{Regions: pipetest.Regions(120, 10)[60:]},
+			).Fair(test.weights...)
+			sink := &pipetest.Sink{Delay: 100 * time.Microsecond}
+
+			// when
+			assert.NilError(t, pipe.New(fan, sink).Pipe(context.Background()))
+
+			// then: the sources took turns, by their weights
+			regions := sink.Regions()
+			assert.Equal(t, len(regions), 120)
+			var first int
+			for _, r := range regions[:40] {
+				if r.Off < 600 {
+					first++
+				}
+			}
+			assert.Assert(t, first >= test.share-4 && first <= test.share+4, "%d of 40", first)
+		})
+	}
+}
+
+func TestFan_Isolate(t *testing.T) {
+	// given: one of three sources failing once it's passed on its regions
+	boom := errors.New("boom")
+	fan := pipe.Fan(
+		&pipetest.Source{Regions: pipetest.Regions(10, 10)},
+		&pipetest.Source{Regions: pipetest.Regions(20, 10)[10:], Err: boom},
+		&pipetest.Source{Regions: pipetest.Regions(30, 10)[20:], Delay: time.Millisecond},
+	).Isolate()
+	sink := &pipetest.Sink{}
+
+	// when
+	err := pipe.New(fan, sink).Pipe(context.Background())
+
+	// then: the others carried on, and the fan has the failure to report
+	assert.NilError(t, err)
+	assert.Equal(t, len(sink.Regions()), 30)
+	assert.ErrorIs(t, fan.Err(), boom)
+	assert.Error(t, fan.Err(), "fan: source 1: boom")
+}
+
+// concurrency tracks how many goroutines are inside a section at once
+type concurrency struct {
+	n, max atomic.Int64
+}
+
+func (c *concurrency) enter() (exit func()) {
+	n := c.n.Add(1)
+	for m := c.max.Load(); n > m && !c.max.CompareAndSwap(m, n); m = c.max.Load() {
+	}
+	return func() { c.n.Add(-1) }
+}
+
+func (c *concurrency) peak() int64 {
+	return c.max.Load()
+}
+
+type sourceFunc func(ctx context.Context, sink chan pipe.Region, errs chan error)
+
+func (f sourceFunc) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	f(ctx, sink, errs)
+}
+
+type scalable struct {
+	mu sync.Mutex
+	n  atomic.Int64
+	ns []int
+}
+
+func (s *scalable) Concurrency() int {
+	return int(s.n.Load())
+}
+
+func (s *scalable) SetConcurrency(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.n.Store(int64(n))
+	s.ns = append(s.ns, n)
+}
+
+func (s *scalable) history() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.ns)
+}
@@ -0,0 +1,87 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestBackfill(t *testing.T) {
+	want := bytes.Repeat([]byte("0123456789"), 10)
+	cached := func(offs ...int64) []pipe.Region {
+		var regions []pipe.Region
+		for _, off := range offs {
+			regions = append(regions, pipe.Region{Data: want[off : off+10], Off: off})
+		}
+		return regions
+	}
+
+	tests := []struct {
+		name    string
+		primary *pipetest.Source
+		fetched []pipe.Range
+	}{
+		{
+			name:    "hit",
+			primary: &pipetest.Source{Regions: cached(0, 10, 20, 30, 40, 50, 60, 70, 80, 90)},
+		},
+		{
+			name:    "holes",
+			primary: &pipetest.Source{Regions: cached(0, 10, 40, 50, 60, 90)},
+			fetched: []pipe.Range{{Off: 20, Len: 20}, {Off: 70, Len: 20}},
+		},
+		{
+			name:    "failed",
+			primary: &pipetest.Source{Regions: cached(0, 10, 20), Err: errors.New("cache went away")},
+			fetched: []pipe.Range{{Off: 30, Len: 70}},
+		},
+		{
+			name:    "miss",
+			primary: &pipetest.Source{},
+			fetched: []pipe.Range{{Off: 0, Len: 100}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			var fetched []pipe.Range
+			origin := func(off, n int64) pipe.Source {
+				fetched = append(fetched, pipe.Range{Off: off, Len: n})
+				return pipeio.Source(bytes.NewReader(want[off:off+n]), off, pipeio.NewBuffer(8, 1))
+			}
+			sink := pipeio.BytesSink()
+			p := pipe.New(pipeio.Backfill(test.primary, int64(len(want)), origin), sink)
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then: what the cache didn't have was fetched from the origin
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(sink.Bytes(), want))
+			assert.DeepEqual(t, fetched, test.fetched)
+			assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 100}})
+		})
+	}
+}
+
+func TestBackfill_fallbackFails(t *testing.T) {
+	// given
+	origin := func(off, n int64) pipe.Source {
+		return &pipetest.Source{Err: errors.New("origin went away")}
+	}
+	source := pipeio.Backfill(&pipetest.Source{Regions: pipetest.Regions(1, 10)}, 100, origin)
+
+	// when
+	err := pipe.New(source, &pipetest.Sink{}).Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "origin went away")
+}
@@ -0,0 +1,204 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+)
+
+// BatchSink can be implemented by a Sink that is able to take regions in batches (see
+// WithBatches).
+type BatchSink interface {
+	ReadBatches(ctx context.Context, source <-chan []Region, errs chan<- error)
+}
+
+// WithBatches has regions travel between stages in batches of up to n regions rather than
+// one at a time, amortizing the cost of the channel handoffs for pipes moving lots of
+// small regions. Regions are batched up as the source produces them (without waiting
+// for a batch to fill up), and a sink that doesn't implement BatchSink gets them one at
+// a time again.
+//
+// Since Valves deal in single regions, batches only work for pipes whose valves are all
+// Funcs (which are applied to every region of a batch in turn); other pipes ignore the
+// option.
+func WithBatches(n int) Option {
+	return func(p *Pipe) {
+		p.batch = n
+	}
+}
+
+// Unbatch passes the regions of the batches on in to the returned channel one by one,
+// for BatchSinks that need to fall back to handling single regions.
+func Unbatch(ctx context.Context, in <-chan []Region) <-chan Region {
+	out := make(chan Region)
+	go func() {
+		defer close(out)
+
+		for {
+			batch, more := Next(ctx, in)
+			if !more {
+				return
+			}
+			for _, r := range batch {
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// funcs returns the functions of the (fused) valves, if they're all Funcs
+func (p *Pipe) funcs() ([]Func, bool) {
+	valves := p.fused()
+	fns := make([]Func, 0, len(valves))
+	for _, v := range valves {
+		f, ok := v.(Func)
+		if !ok {
+			return nil, false
+		}
+		fns = append(fns, f)
+	}
+
+	return fns, true
+}
+
+func (p *Pipe) startBatches(ctx context.Context, r *run, fns []Func) {
+	defer r.stages.sunk(r.done)
+
+	in := p.connector(0)
+	sourceCtx, stopSource := context.WithCancelCause(ctx)
+	p.stage(ctx, "source")
+	go func() {
+		defer Pin(ctx)()
+		p.source.Write(sourceCtx, in, r.stages.reports(0))
+	}()
+
+	out := make(chan []Region)
+	p.stage(ctx, "gate")
+	go r.batchGate(ctx, in, out, stopSource, p.batch)
+
+	// unlike valves, the funcs are hooked up front to back
+	for i, f := range fns {
+		p.stage(ctx, fmt.Sprintf("valve %d", i))
+		out = f.batches(ctx, out, r.stages.reports(i+1))
+	}
+
+	p.stage(ctx, "sink")
+	defer Pin(ctx)()
+	if bs, ok := p.sink.(BatchSink); ok {
+		bs.ReadBatches(ctx, out, r.stages.reports(len(fns)+1))
+		return
+	}
+	p.sink.Read(ctx, Unbatch(ctx, out), r.stages.reports(len(fns)+1))
+}
+
+// batchGate is the gate for pipes running in batches: on top of what the gate does, it
+// groups whatever regions the source has ready into batches of up to n.
+func (r *run) batchGate(ctx context.Context, in chan Region, out chan []Region, stopSource context.CancelCauseFunc, n int) {
+	defer close(out)
+
+	for {
+		if !r.hold(ctx) {
+			r.halt(ctx, in, stopSource)
+			return
+		}
+
+		batch := make([]Region, 0, n)
+		select {
+		case region, more := <-in:
+			if !more {
+				return
+			}
+			batch = append(batch, r.stamp(region))
+		case <-r.stop:
+			r.halt(ctx, in, stopSource)
+			return
+		case <-ctx.Done():
+			r.halt(ctx, in, stopSource)
+			return
+		}
+
+		if !r.strike(ctx) || !r.admit(ctx, batch[0]) {
+			r.halt(ctx, in, stopSource)
+			return
+		}
+
+		// take whatever else the source has ready, without waiting on it
+		more := true
+	collect:
+		for more && len(batch) < n {
+			var region Region
+			select {
+			case region, more = <-in:
+				if !more {
+					break
+				}
+				if !r.admit(ctx, region) {
+					r.halt(ctx, in, stopSource)
+					return
+				}
+				batch = append(batch, r.stamp(region))
+			default:
+				break collect
+			}
+		}
+
+		// as with the gate, the batch is in flight already
+		select {
+		case out <- batch:
+		case <-ctx.Done():
+			r.halt(ctx, in, stopSource)
+			return
+		}
+
+		if !more {
+			return
+		}
+	}
+}
+
+func (f Func) batches(ctx context.Context, in <-chan []Region, errs chan error) chan []Region {
+	out := make(chan []Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(out)
+
+		yield := yielder(ctx)
+		for {
+			batch, more := Next(ctx, in)
+			if !more {
+				return
+			}
+
+			// regions diverted to the dead letters are dropped from the batch
+			kept := batch[:0]
+			for _, in := range batch {
+				r, err := f(in)
+				if err != nil {
+					if divert(ctx, in, err) {
+						continue
+					}
+					errs <- err
+					return
+				}
+				kept = append(kept, r)
+			}
+			if batch = kept; len(batch) == 0 {
+				continue
+			}
+
+			yield(batch[0])
+			select {
+			case out <- batch:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,142 @@
+package pipe_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestSink_WriteBehind(t *testing.T) {
+	// given
+	contiguous := make([]pipe.Region, 10)
+	for i := range contiguous {
+		contiguous[i] = pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+	}
+	sparse := pipe.Region{Data: []byte("JJJJJJJJJJ"), Off: 500}
+
+	w := &recordingWriter{}
+	sink := pipeio.Sink(w, pipeio.NewBuffer(10, 1), pipeio.WriteBehind(40, time.Hour))
+	p := pipe.New(&source{regions: append(contiguous, sparse)}, sink)
+
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then
+	assert.DeepEqual(t, w.writes, []pipe.Range{
+		{Off: 0, Len: 40},
+		{Off: 40, Len: 40},
+		{Off: 80, Len: 20},
+		{Off: 500, Len: 10},
+	})
+	assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 100}, {Off: 500, Len: 10}})
+}
+
+func TestSink_WriteBehind_canceled(t *testing.T) {
+	// given: a batch pending when the run is canceled
+	w := &recordingWriter{}
+	sink := pipeio.Sink(w, pipeio.NewBuffer(10, 1), pipeio.WriteBehind(40, time.Hour))
+	p := pipe.New(&stallingSource{regions: regions[:2]}, sink)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// when
+	err := p.Pipe(ctx)
+
+	// then: it's dropped rather than written
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	time.Sleep(10 * time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	assert.Equal(t, len(w.writes), 0)
+}
+
+func TestPipe_WithBatches(t *testing.T) {
+	many := make([]pipe.Region, 100)
+	for i := range many {
+		many[i] = pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+	}
+	count := func(n *int) pipe.Func {
+		return func(r pipe.Region) (pipe.Region, error) {
+			*n++
+			return r, nil
+		}
+	}
+
+	t.Run("success/sink", func(t *testing.T) {
+		// given
+		read := make([]pipe.Region, 0)
+		sinkFunc := func(r pipe.Region) error {
+			read = append(read, r)
+			return nil
+		}
+
+		var a, b int
+		p := pipe.New(&source{regions: many}, &sink{f: sinkFunc}, count(&a), count(&b)).
+			With(pipe.WithBatches(8))
+
+		// when
+		assert.NilError(t, p.Pipe(context.Background()))
+
+		// then
+		assert.DeepEqual(t, read, many)
+		assert.Equal(t, a, 100)
+		assert.Equal(t, b, 100)
+	})
+
+	t.Run("success/batch-sink", func(t *testing.T) {
+		// given
+		w := &recordingWriter{}
+		var a int
+		p := pipe.New(&source{regions: many}, pipeio.Sink(w, pipeio.NewBuffer(10, 1)), count(&a)).
+			With(pipe.WithBatches(8))
+
+		// when
+		assert.NilError(t, p.Pipe(context.Background()))
+
+		// then
+		assert.Equal(t, len(w.writes), 100)
+		assert.Equal(t, a, 100)
+		assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 1000}})
+	})
+
+	t.Run("success/unbatchable", func(t *testing.T) {
+		// given
+		read := make([]pipe.Region, 0)
+		sinkFunc := func(r pipe.Region) error {
+			read = append(read, r)
+			return nil
+		}
+
+		var a int
+		valve := &noopValve{f: func(pipe.Region) error { return nil }}
+		p := pipe.New(&source{regions: many}, &sink{f: sinkFunc}, count(&a), valve).
+			With(pipe.WithBatches(8))
+
+		// when
+		assert.NilError(t, p.Pipe(context.Background()))
+
+		// then
+		assert.DeepEqual(t, read, many)
+		assert.Equal(t, a, 100)
+	})
+}
+
+type recordingWriter struct {
+	mu     sync.Mutex
+	writes []pipe.Range
+}
+
+func (w *recordingWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.writes = append(w.writes, pipe.Range{Off: off, Len: int64(len(p))})
+	return len(p), nil
+}
@@ -0,0 +1,71 @@
+// Package bench measures the throughput of pipes, so that implementers of custom Sources,
+// Sinks and Valves can benchmark their components the same way the pipe package
+// benchmarks its own.
+//
+// General note on interpreting the numbers: using any b.N > 1 may be a bit misleading,
+// depending on what information you're interested in - it's decent for relative
+// comparison for experimenting with different parameters, but files get warmed up (and
+// sources are drained after the first iteration) so don't take the absolute numbers as
+// an accurate measure of real-life performance.
+package bench
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Result is the outcome of a single measured run of a pipe.
+type Result struct {
+	// Bytes is the number of bytes the sink reported as written.
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+// Throughput returns the number of bytes written per second.
+func (r Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+// Measure runs the pipe once, timing it and counting the bytes written by the sink.
+func Measure(ctx context.Context, p *pipe.Pipe) (Result, error) {
+	start := time.Now()
+	err := p.Pipe(ctx)
+	elapsed := time.Since(start)
+
+	var written int64
+	for _, r := range p.Report().Written {
+		written += r.Len
+	}
+
+	return Result{Bytes: written, Elapsed: elapsed}, err
+}
+
+// Run benchmarks the pipe for b.N iterations, checking the outcome of each run with
+// verify (if not nil) and reporting the throughput in MB/s.
+func Run(b *testing.B, p *pipe.Pipe, verify func() error) {
+	b.Helper()
+
+	var total Result
+	for i := 0; i < b.N; i++ {
+		result, err := Measure(context.Background(), p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if verify != nil {
+			if err := verify(); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		total.Bytes += result.Bytes
+		total.Elapsed += result.Elapsed
+	}
+
+	b.ReportMetric(total.Throughput()/float64(pipe.MiB), "MB/s")
+}
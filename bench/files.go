@@ -0,0 +1,125 @@
+package bench
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Fill creates the file at path with fill bytes of random data. If the file already exists
+// it's left as is, so (big) testdata files can be shared between runs.
+func Fill(path string, fill int64) error {
+	f, err := os.Open(path)
+	if err == nil {
+		// file is present, nothing to do
+		f.Close()
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+
+	// okay no file yet; let's set that up
+	f, err = os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if fill == 0 {
+		return nil
+	}
+
+	filler := &io.LimitedReader{
+		R: rand.Reader,
+		N: fill,
+	}
+
+	_, err = io.Copy(f, filler)
+	if err != nil {
+		return fmt.Errorf("error filling %s with %d bytes: %w", path, fill, err)
+	}
+
+	return nil
+}
+
+// CopyFile copies the contents of src to dst with io.Copy.
+func CopyFile(dst, src string) error {
+	dstFile, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	_, err = io.Copy(dstFile, srcFile)
+	return err
+}
+
+// Diff returns an error describing how the contents of files a and b differ, if they do.
+func Diff(a, b string) error {
+	fileA, err := os.Open(a)
+	if err != nil {
+		return err
+	}
+	defer fileA.Close()
+
+	fileB, err := os.Open(b)
+	if err != nil {
+		return err
+	}
+	defer fileB.Close()
+
+	statA, err := fileA.Stat()
+	if err != nil {
+		return err
+	}
+	statB, err := fileB.Stat()
+	if err != nil {
+		return err
+	}
+
+	if statA.Size() != statB.Size() {
+		return fmt.Errorf("file %s (size %d) is not same size as file %s (size %d)",
+			a, statA.Size(), b, statB.Size(),
+		)
+	}
+
+	buffA := make([]byte, 4*pipe.KiB)
+	buffB := make([]byte, 4*pipe.KiB)
+
+	var off int64
+	for {
+		n, err := io.ReadFull(fileA, buffA)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		m, err := io.ReadFull(fileB, buffB)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+
+		if n != m {
+			return fmt.Errorf("well that's annoying... n=%d, m=%d)", n, m)
+		}
+
+		if !bytes.Equal(buffA[:n], buffB[:m]) {
+			return fmt.Errorf("contents of file=%s do not equal file=%s at offset=%d", a, b, off)
+		}
+		off += int64(n)
+	}
+}
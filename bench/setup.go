@@ -0,0 +1,177 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+// Setup is a file-to-file pipe ready to be run.
+type Setup struct {
+	Pipe *pipe.Pipe
+	Src  string
+	Dst  string
+
+	// Close releases the files opened for the pipe and removes the src and dst files. It's
+	// set even when setting up fails, to clean up whatever was created along the way.
+	Close func()
+}
+
+// FileToFile sets up a pipe copying a file of fileSize random bytes to another file in
+// dir, reading with numReaders (sharded) readers and writing with numWriters (pooled)
+// writers, through the given valves.
+//
+// The random data is created once per file size and kept around in dir to be reused.
+func FileToFile(dir, name string, fileSize int64, numReaders, numWriters, bufferSize, maxBuffers int, valves ...pipe.Valve) (*Setup, error) {
+	var setup Setup
+	cleanup := make([]func(), 0)
+	setup.Close = func() {
+		for _, c := range cleanup {
+			if c != nil {
+				c()
+			}
+		}
+	}
+
+	// create shared/reusable data file filled with random data
+	// (if doesn't already exist)
+	base := filepath.Join(dir, fmt.Sprintf("%dMB.bin", fileSize/pipe.MiB))
+	if err := Fill(base, fileSize); err != nil {
+		return &setup, err
+	}
+
+	// create run-specific file with the same contents as the shared one
+	src := filepath.Join(dir, fmt.Sprintf("%s-src.bin", filepath.Base(name)))
+	if err := CopyFile(src, base); err != nil {
+		return &setup, err
+	}
+	if err := Diff(base, src); err != nil {
+		return &setup, err
+	}
+
+	// create pipe source: file reader
+	var source pipe.Source
+	buff := pipeio.NewBuffer(bufferSize, maxBuffers)
+
+	if numReaders == 1 {
+		f, err := os.Open(src)
+		if err != nil {
+			return &setup, err
+		}
+		cleanup = append(cleanup, func() { _ = f.Close() })
+
+		source = pipeio.Source(f, 0, buff)
+	} else {
+		sources, close, err := Shard(src, numReaders, buff)
+		cleanup = append(cleanup, close)
+		if err != nil {
+			return &setup, err
+		}
+
+		source = pipe.Fan(sources...)
+	}
+
+	// create pipe sink: file writer
+	dst := filepath.Join(dir, fmt.Sprintf("%s-dst.bin", filepath.Base(name)))
+	f, err := os.Create(dst)
+	if err != nil {
+		return &setup, err
+	}
+
+	cleanup = append(cleanup, func() {
+		_ = f.Close()
+		_ = os.Remove(src)
+		_ = os.Remove(dst)
+	})
+
+	var sink pipe.Sink
+	if numWriters == 1 {
+		sink = pipeio.Sink(f, buff)
+	} else {
+		pool, close, err := Pool(dst, numWriters, buff)
+		cleanup = append(cleanup, close)
+		if err != nil {
+			return &setup, err
+		}
+
+		sink = pool
+	}
+
+	setup.Pipe = pipe.New(source, sink, valves...)
+	setup.Src = src
+	setup.Dst = dst
+
+	return &setup, nil
+}
+
+// Pool opens n writers on the file at path and pools them in a single sink.
+func Pool(path string, n int, buff pipeio.Buffer) (pipe.Sink, func(), error) {
+	writers := make([]io.WriterAt, n)
+	closers := make([]func() error, 0, n)
+
+	close := func() {
+		for _, c := range closers {
+			_ = c()
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, os.ModePerm)
+		if err != nil {
+			return nil, close, err
+		}
+
+		writers[i] = f
+		closers = append(closers, f.Close)
+	}
+
+	return pipeio.Pool(buff, writers...), close, nil
+}
+
+// Shard splits the file at path into (roughly) equally sized shards, each with its own
+// reader.
+func Shard(path string, shards int, buff pipeio.Buffer) ([]pipe.Source, func(), error) {
+	// determine total size of the file
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	totalSize := stat.Size()
+
+	// determine max size of each reader
+	maxShardSize := int64(math.Ceil(float64(totalSize) / float64(shards)))
+
+	sources := make([]pipe.Source, shards)
+	closers := make([]func() error, 0, shards)
+
+	close := func() {
+		for _, c := range closers {
+			_ = c()
+		}
+	}
+
+	for i := 0; i < shards; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, close, err
+		}
+
+		closers = append(closers, f.Close)
+
+		rOff := maxShardSize * int64(i)
+		_, err = f.Seek(rOff, 0)
+		if err != nil {
+			return nil, close, err
+		}
+
+		limited := &io.LimitedReader{R: f, N: maxShardSize}
+		sources[i] = pipeio.Source(limited, rOff, buff)
+	}
+
+	return sources, close, nil
+}
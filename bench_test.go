@@ -1,38 +1,25 @@
 package pipe_test
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"testing"
 
 	"gotest.tools/v3/assert"
-)
 
-// General note on running these benchmarks: using any b.N > 1 may be a bit misleading,
-// depending on what information you're interested in - it's decent for relative comparison
-// for experimenting with different parameters, but don't interpret the metrics as an accurate
-// measure of how fast or how memory-intensive real-life uses will be.
-//
-// I think the compiler maybe is doing some optimization that I'm not sure how to dodge, or
-// perhaps it's partly because the files are warmed-up. Might be worth exploring one day but
-// in the meantime just take objective numbers for b.N > 1 with a scoop of salt.
+	"github.com/naylorpmax-joyent/pipe/bench"
+)
 
 func benchFileToFile(b *testing.B, fileSize int64, numReaders, numWriters, bufferSize, maxBuffers int) {
 	// given
-	setup, err := setupFileToFile(b.Name(), fileSize, numReaders, numWriters, bufferSize, maxBuffers)
-	if setup.close != nil {
-		b.Cleanup(setup.close)
+	setup, err := bench.FileToFile("testdata", b.Name(), fileSize, numReaders, numWriters, bufferSize, maxBuffers)
+	if setup.Close != nil {
+		b.Cleanup(setup.Close)
 	}
 	assert.NilError(b, err)
 
-	for i := 0; i < b.N; i++ {
-		// when
-		assert.NilError(b, setup.pipe.Pipe(context.Background()))
-
-		// then
-		assert.NilError(b, diffFiles(setup.dst, setup.src))
-	}
+	// when / then
+	bench.Run(b, setup.Pipe, func() error { return bench.Diff(setup.Dst, setup.Src) })
 }
 
 // baseline for comparison (doesn't use the pipe package at all, just io)
@@ -40,11 +27,11 @@ func benchFileToFile(b *testing.B, fileSize int64, numReaders, numWriters, buffe
 func BenchmarkPipe_IOCopy(b *testing.B) {
 	// given
 	base := "testdata/1GiB.bin"
-	assert.NilError(b, fillFile(base, GiB))
+	assert.NilError(b, bench.Fill(base, GiB))
 
 	src := fmt.Sprintf("testdata/1G-%s-src.bin", b.Name())
-	assert.NilError(b, copyFile(src, base))
-	assert.NilError(b, diffFiles(src, base))
+	assert.NilError(b, bench.CopyFile(src, base))
+	assert.NilError(b, bench.Diff(src, base))
 
 	dst := fmt.Sprintf("testdata/1G-%s-dst.bin", b.Name())
 
@@ -53,9 +40,9 @@ func BenchmarkPipe_IOCopy(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		// when
-		assert.NilError(b, copyFile(dst, src))
+		assert.NilError(b, bench.CopyFile(dst, src))
 		// then
-		assert.NilError(b, diffFiles(dst, src))
+		assert.NilError(b, bench.Diff(dst, src))
 	}
 }
 
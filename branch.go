@@ -0,0 +1,217 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Chain returns a Valve passing regions through valves, one after the other, as a pipe
+// does with its own: a chain of valves that can be handed around as one, as a branch of
+// Branch in particular.
+func Chain(valves ...Valve) Valve {
+	return &chain{valves: valves}
+}
+
+type chain struct {
+	valves []Valve
+}
+
+func (c *chain) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	out := make(chan Region)
+	rl := newRelay(ctx, errs, len(c.valves), nil)
+
+	in := out
+	for back := len(c.valves) - 1; back >= 0; back-- {
+		in = c.valves[back].Open(ctx, in, rl.errs[back])
+	}
+
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+		// the valves report their failures before letting the stages after them know the
+		// stream is over, which the chain mustn't change
+		defer rl.stop()
+
+		for {
+			r, more := Next(ctx, out)
+			if !more {
+				return
+			}
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return in
+}
+
+// Branch returns a Valve handing every region to one of branches, the one route returns
+// the index of, and merging the regions coming out of the branches back into one stream:
+// so that regions can go different ways through a pipe (to one of two compressors by the
+// parity of their offset, say) before they get to the sink. A branch is a Valve of its
+// own, a Chain of them in particular, and Branches and Chains nest: a pipe is then any
+// graph of stages that fork and join again.
+//
+// The branches run side by side, and regions come out of them in whatever order they're
+// done (see Reorder). A branch failing fails the valve, as do regions routed to a branch
+// that isn't there; the stream is over for the stages after the valve once it's over for
+// every branch.
+func Branch(route func(r Region) int, branches ...Valve) Valve {
+	return &branch{route: route, branches: branches}
+}
+
+type branch struct {
+	route    func(r Region) int
+	branches []Valve
+}
+
+func (b *branch) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	rl := newRelay(ctx, errs, len(b.branches)+1, nil)
+	own := rl.errs[len(b.branches)] // the valve's own failures
+
+	ins := make([]chan Region, len(b.branches))
+	var merging sync.WaitGroup
+	for i, v := range b.branches {
+		out := make(chan Region)
+		ins[i] = v.Open(ctx, out, rl.errs[i])
+
+		merging.Add(1)
+		go func() {
+			defer Pin(ctx)()
+			defer merging.Done()
+
+			for {
+				r, more := Next(ctx, out)
+				if !more {
+					return
+				}
+				select {
+				case sink <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		merging.Wait()
+		rl.stop()
+		close(sink)
+	}()
+
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer func() {
+			for _, in := range ins {
+				close(in)
+			}
+		}()
+
+		for {
+			r, more := Next(ctx, source)
+			if !more {
+				return
+			}
+
+			i := b.route(r)
+			if i < 0 || i >= len(ins) {
+				r.Release()
+				own <- fmt.Errorf("branch: region at offset=%d routed to branch %d, of %d", r.Off, i, len(ins))
+				return
+			}
+			select {
+			case ins[i] <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// relay passes on the results reported by the valves inside a valve to the errs of the
+// valve, as they're reported, until it's stopped
+type relay struct {
+	errs    []chan error // where each inner valve reports its result
+	out     chan error
+	seen    func(err error) // if set, told about every error passed on
+	ctx     context.Context
+	halt    chan struct{}
+	stopped chan struct{}
+}
+
+func newRelay(ctx context.Context, out chan error, n int, seen func(err error)) *relay {
+	rl := &relay{
+		seen:    seen,
+		errs:    make([]chan error, n),
+		out:     out,
+		ctx:     ctx,
+		halt:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for i := range rl.errs {
+		// every valve reports its result once, at most, and mustn't get stuck doing so
+		rl.errs[i] = make(chan error, 1)
+	}
+	go rl.run()
+	return rl
+}
+
+func (rl *relay) run() {
+	defer close(rl.stopped)
+
+	cases := make([]reflect.SelectCase, len(rl.errs)+1)
+	for i, c := range rl.errs {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)}
+	}
+	cases[len(rl.errs)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(rl.halt)}
+
+	for {
+		chosen, v, _ := reflect.Select(cases)
+		if chosen == len(rl.errs) {
+			break
+		}
+		err, _ := v.Interface().(error)
+		rl.pass(err)
+		// a valve reports once
+		cases[chosen].Chan = reflect.ValueOf((chan error)(nil))
+	}
+
+	// whatever was reported by the time the relay was stopped
+	for i, c := range rl.errs {
+		if !cases[i].Chan.IsNil() {
+			select {
+			case err := <-c:
+				rl.pass(err)
+			default:
+			}
+		}
+	}
+}
+
+// pass passes err on, unless it's nil
+func (rl *relay) pass(err error) {
+	if err == nil {
+		return
+	}
+	if rl.seen != nil {
+		rl.seen(err)
+	}
+	select {
+	case rl.out <- err:
+	case <-rl.ctx.Done():
+	}
+}
+
+// stop has the relay pass on whatever was reported so far, and returns once it has
+func (rl *relay) stop() {
+	close(rl.halt)
+	<-rl.stopped
+}
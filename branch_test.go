@@ -0,0 +1,92 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestBranch(t *testing.T) {
+	// given: regions going through one of two chains by the parity of their index, the
+	// odd ones through a nested branch
+	upper := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		r.Data = bytes.ToLower(r.Data)
+		return r, nil
+	})
+	parity := func(r pipe.Region) int { return int(r.Off/10) % 2 }
+	sink := &pipetest.Sink{}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink,
+		passthrough,
+		pipe.Branch(parity,
+			pipe.Chain(passthrough, upper),
+			pipe.Branch(func(pipe.Region) int { return 0 }, pipe.Chain()),
+		),
+		passthrough,
+	)
+
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: every region made it, through the branch it was routed to
+	got := sink.Regions()
+	slices.SortFunc(got, func(a, b pipe.Region) int { return int(a.Off - b.Off) })
+	assert.Equal(t, len(got), 10)
+	for i, r := range got {
+		want := pipetest.Regions(10, 10)[i].Data
+		if i%2 == 0 {
+			want = bytes.ToLower(want)
+		}
+		assert.DeepEqual(t, r.Data, want)
+	}
+}
+
+func TestBranch_failed(t *testing.T) {
+	boom := errors.New("boom")
+	failAt := func(off int64) pipe.Func {
+		return func(r pipe.Region) (pipe.Region, error) {
+			if r.Off == off {
+				return r, boom
+			}
+			return r, nil
+		}
+	}
+	parity := func(r pipe.Region) int { return int(r.Off/10) % 2 }
+
+	tests := map[string]struct {
+		valve    pipe.Valve
+		expected string
+	}{
+		"in a branch": {
+			valve:    pipe.Branch(parity, passthrough, pipe.Chain(passthrough, failAt(30))),
+			expected: "valve 0 (*pipe.branch): boom",
+		},
+		"in a chain": {
+			valve:    pipe.Chain(failAt(30), passthrough),
+			expected: "valve 0 (*pipe.chain): boom",
+		},
+		"routed nowhere": {
+			valve:    pipe.Branch(func(pipe.Region) int { return 2 }, passthrough, passthrough),
+			expected: "valve 0 (*pipe.branch): branch: region at offset=0 routed to branch 2, of 2",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}, test.valve)
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			assert.Error(t, err, test.expected)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Run("success/recovers", func(t *testing.T) {
+		// given
+		w := &flakyWriter{failures: 5, err: syscall.ECONNRESET}
+		b := pipeio.Breaker(w, 3, 10*time.Millisecond, nil)
+
+		// when
+		start := time.Now()
+		n, err := b.WriteAt([]byte("AAAA"), 0)
+
+		// then
+		assert.NilError(t, err)
+		assert.Equal(t, n, 4)
+		assert.Equal(t, w.attempts, 6)
+		assert.Assert(t, time.Since(start) >= 30*time.Millisecond) // 3 probes while open
+		assert.Equal(t, b.State(), pipeio.BreakerClosed)
+	})
+
+	t.Run("error/permanent", func(t *testing.T) {
+		// given
+		w := &flakyWriter{failures: 5, err: syscall.EIO}
+		b := pipeio.Breaker(w, 3, 10*time.Millisecond, nil)
+
+		// when
+		_, err := b.WriteAt([]byte("AAAA"), 0)
+
+		// then
+		assert.ErrorIs(t, err, syscall.EIO)
+		assert.Equal(t, w.attempts, 1)
+	})
+
+	t.Run("error/closed-while-open", func(t *testing.T) {
+		// given
+		w := &flakyWriter{failures: 1000, err: syscall.ETIMEDOUT}
+		b := pipeio.Breaker(w, 1, time.Hour, nil)
+
+		// when
+		state := make(chan pipeio.BreakerState, 1)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			state <- b.State()
+			_ = b.Close()
+		}()
+		_, err := b.WriteAt([]byte("AAAA"), 0)
+
+		// then
+		assert.Assert(t, errors.Is(err, pipeio.ErrBreakerOpen))
+		assert.Equal(t, <-state, pipeio.BreakerOpen)
+	})
+
+	t.Run("error/backoff", func(t *testing.T) {
+		// given
+		w := &flakyWriter{failures: 3, err: syscall.ECONNRESET}
+		b := pipeio.Breaker(w, 4, 40*time.Millisecond, nil)
+
+		// when
+		start := time.Now()
+		_, err := b.WriteAt([]byte("AAAA"), 0)
+
+		// then: 2.5ms + 5ms + 10ms, short of opening
+		assert.NilError(t, err)
+		assert.Assert(t, time.Since(start) >= 17*time.Millisecond)
+		assert.Equal(t, b.State(), pipeio.BreakerClosed)
+	})
+
+	t.Run("error/backoff-far-threshold", func(t *testing.T) {
+		// given: a threshold too high for the backoff to be shifted down from the probe
+		w := &flakyWriter{failures: 5, err: syscall.ECONNRESET}
+		b := pipeio.Breaker(w, 1000, 10*time.Millisecond, nil)
+
+		// when
+		start := time.Now()
+		_, err := b.WriteAt([]byte("AAAA"), 0)
+
+		// then: retries still wait a little in between
+		assert.NilError(t, err)
+		assert.Equal(t, w.attempts, 6)
+		assert.Assert(t, time.Since(start) >= 5*time.Millisecond)
+	})
+
+	t.Run("error/canceled", func(t *testing.T) {
+		// given
+		w := &flakyWriter{failures: 1000, err: syscall.ETIMEDOUT}
+		b := pipeio.Breaker(w, 1, time.Hour, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		p := pipe.New(&source{regions: regions}, pipeio.Sink(b, pipeio.NewBuffer(10, 1)))
+
+		// when
+		err := p.Pipe(ctx)
+
+		// then: the sink doesn't keep probing past the end of the run
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		attempts := w.count()
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, w.count(), attempts)
+	})
+}
+
+type flakyWriter struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	err      error
+}
+
+func (w *flakyWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.attempts++
+	if w.attempts <= w.failures {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+func (w *flakyWriter) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.attempts
+}
@@ -0,0 +1,142 @@
+package pipe_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestLimit(t *testing.T) {
+	// given
+	buff := pipeio.Limit(pipeio.NewBuffer(4*KiB, 10), 8*KiB)
+
+	a := buff.Get()
+	_ = buff.Get()
+
+	// when
+	got := make(chan []byte)
+	go func() { got <- buff.Get() }()
+
+	// then
+	select {
+	case <-got:
+		t.Fatal("expected Get to block while the limit is reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	buff.Put(a[:10]) // sinks put back the (possibly shortened) region data
+
+	select {
+	case c := <-got:
+		assert.Equal(t, len(c), 4*KiB)
+	case <-time.After(time.Second):
+		t.Fatal("expected Get to unblock once a buffer was released")
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	t.Run("canceled", func(t *testing.T) {
+		// given
+		inner := &countingBuffer{Buffer: pipeio.NewBuffer(4*KiB, 10)}
+		buff := pipeio.Limit(inner, 4*KiB)
+		_ = buff.Get()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		got := make(chan error)
+		go func() {
+			_, err := pipeio.Acquire(ctx, buff)
+			got <- err
+		}()
+
+		// when
+		cancel()
+
+		// then: it gives up, and nothing was allocated while waiting
+		select {
+		case err := <-got:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			t.Fatal("expected Acquire to give up once the context is done")
+		}
+		assert.Equal(t, inner.gets, 1)
+	})
+
+	t.Run("swapped", func(t *testing.T) {
+		// given: the limit reached, by buffers one of which was swapped for another along
+		// the way
+		buff := pipeio.Limit(pipeio.NewBuffer(4*KiB, 10), 8*KiB)
+		a := buff.Get()
+		_ = buff.Get()
+		buff.Put(make([]byte, 4*KiB))
+
+		// when
+		got := make(chan []byte)
+		go func() { got <- buff.Get() }()
+
+		// then: the buffer swapped in didn't release the charge of another
+		select {
+		case <-got:
+			t.Fatal("expected Get to block while the limit is reached")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		buff.Put(a)
+		select {
+		case <-got:
+		case <-time.After(time.Second):
+			t.Fatal("expected Get to unblock once a buffer was released")
+		}
+	})
+}
+
+type countingBuffer struct {
+	pipeio.Buffer
+	gets int
+}
+
+func (b *countingBuffer) Get() []byte {
+	b.gets++
+	return b.Buffer.Get()
+}
+
+func TestNewHugeBuffer(t *testing.T) {
+	// given
+	buff, err := pipeio.NewHugeBuffer(64*KiB, 4)
+	assert.NilError(t, err)
+	defer buff.Close()
+
+	// when
+	got := make([][]byte, 5)
+	for i := range got {
+		got[i] = buff.Get()
+	}
+
+	// then: the arena is carved up without overlap, and heap buffers fill in after that
+	for i := range got {
+		assert.Equal(t, len(got[i]), 64*KiB)
+		got[i][0] = byte(i)
+		got[i][len(got[i])-1] = byte(i)
+	}
+	for i := range got {
+		assert.Equal(t, got[i][0], byte(i))
+		assert.Equal(t, got[i][len(got[i])-1], byte(i))
+	}
+
+	// and: heap buffers don't take the place of the arena's once put back
+	arena := make(map[*byte]bool)
+	for _, b := range got[:4] {
+		arena[&b[0]] = true
+	}
+	for i := len(got) - 1; i >= 0; i-- {
+		buff.Put(got[i][:10])
+	}
+	for range 4 {
+		b := buff.Get()
+		assert.Equal(t, len(b), 64*KiB)
+		assert.Assert(t, arena[&b[0]])
+	}
+}
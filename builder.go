@@ -0,0 +1,162 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrConfig is what Build fails with when a pipe isn't put together right, joined with a
+// description of every problem found.
+var ErrConfig = errors.New("invalid pipe")
+
+// Builder returns a builder putting a pipe together step by step, which checks that it
+// makes sense before it's run rather than have it fail halfway through, or silently ignore
+// an option:
+//
+//	p, err := pipe.Builder().From(source).Through(decompress, verify).To(sink).
+//		With(pipe.WithBatches(64)).
+//		Build()
+func Builder() *builder {
+	return &builder{}
+}
+
+type builder struct {
+	source Source
+	sink   Sink
+	valves []Valve
+	opts   []Option
+}
+
+// From sets the source of the pipe.
+func (b *builder) From(source Source) *builder {
+	b.source = source
+	return b
+}
+
+// Through adds valves to the pipe, after those it has already.
+func (b *builder) Through(valves ...Valve) *builder {
+	b.valves = append(b.valves, valves...)
+	return b
+}
+
+// To sets the sink of the pipe.
+func (b *builder) To(sink Sink) *builder {
+	b.sink = sink
+	return b
+}
+
+// With adds options to the pipe, applied after those it has already.
+func (b *builder) With(opts ...Option) *builder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Build returns the pipe, or ErrConfig joined with whatever's wrong with it: a missing
+// component, options out of range, or options that can't take effect with the rest of the
+// pipe (batches or rings with valves that aren't Funcs, stats or timeouts with batches or
+// rings, a shortcut the sink can't take...).
+func (b *builder) Build() (*Pipe, error) {
+	p := New(b.source, b.sink, b.valves...)
+	for _, opt := range b.opts {
+		if opt == nil {
+			return nil, fmt.Errorf("%w: nil option", ErrConfig)
+		}
+		opt(p)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// validate returns ErrConfig joined with every problem with the pipe, if any
+func (p *Pipe) validate() error {
+	var errs []error
+	problem := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrConfig}, args...)...))
+	}
+
+	if isNil(p.source) {
+		problem("no source")
+	}
+	if isNil(p.sink) {
+		problem("no sink")
+	}
+	for i, v := range p.valves {
+		if isNil(v) {
+			problem("valve %d is nil", i)
+		}
+	}
+
+	for _, opt := range []struct {
+		name string
+		n    int64
+	}{
+		{"WithBatches", int64(p.batch)},
+		{"WithRing", int64(p.ring)},
+		{"WithRegionTimeout", int64(p.regionTimeout)},
+		{"WithStallTimeout", int64(p.stallTimeout)},
+		{"WithDrainOnCancel", int64(p.drain)},
+		{"WithMaxWorkers", int64(p.maxWorkers)},
+		{"WithTolerance", int64(p.tolerance)},
+	} {
+		if opt.n < 0 {
+			problem("%s can't be negative", opt.name)
+		}
+	}
+	if p.tolerancePercent < 0 || p.tolerancePercent > 100 {
+		problem("WithTolerancePercent must be between 0 and 100")
+	}
+	if p.checkpoint != nil && isNil(p.checkpoint.store) {
+		problem("WithCheckpoint has no store")
+	}
+
+	batched := p.batch > 1 || p.ring > 0
+	if p.batch > 1 && p.ring > 0 {
+		problem("WithBatches and WithRing don't go together")
+	}
+	if batched {
+		for i, v := range p.fused() {
+			if _, ok := v.(Func); ok {
+				continue
+			}
+			if v == Valve(p.digest) {
+				problem("batches and rings don't go with a digest (see WithDigest, WithExpectedDigest)")
+			} else {
+				problem("batches and rings need valves that are Funcs, valve %d is a %T", i, v)
+			}
+			break
+		}
+		if p.stats {
+			problem("WithStats doesn't go with batches or rings")
+		}
+		if p.regionTimeout > 0 || p.stallTimeout > 0 {
+			problem("timeouts don't go with batches or rings (see WithRegionTimeout, WithStallTimeout)")
+		}
+	}
+
+	if p.shortcut {
+		if _, ok := p.sink.(Shortcut); !ok && !isNil(p.sink) {
+			problem("WithShortcut needs a sink that can take it, %T can't", p.sink)
+		}
+		if len(p.valves) > 0 || p.digest != nil {
+			problem("WithShortcut doesn't go with valves or a digest")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// isNil returns whether v is nil, or a nil pointer (or func, map...) in an interface
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Func, reflect.Map, reflect.Chan, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package pipe_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestBuilder(t *testing.T) {
+	// given
+	sink := &pipetest.Sink{}
+
+	// when
+	p, err := pipe.Builder().
+		From(&pipetest.Source{Regions: pipetest.Regions(4, 10)}).
+		Through(passthrough, passthrough).
+		To(sink).
+		With(pipe.WithBatches(2)).
+		Build()
+
+	// then
+	assert.NilError(t, err)
+	assert.NilError(t, p.Pipe(context.Background()))
+	assert.Equal(t, len(sink.Regions()), 4)
+}
+
+func TestBuilder_invalid(t *testing.T) {
+	source := &pipetest.Source{Regions: pipetest.Regions(4, 10)}
+	var nilSink *pipetest.Sink
+	reorder := pipe.Reorder(0)
+
+	tests := map[string]struct {
+		builder  func() (*pipe.Pipe, error)
+		expected []string
+	}{
+		"nothing": {
+			builder:  pipe.Builder().Build,
+			expected: []string{"invalid pipe: no source", "invalid pipe: no sink"},
+		},
+		"nil components": {
+			builder:  pipe.Builder().From(source).Through(passthrough, nil).To(nilSink).Build,
+			expected: []string{"invalid pipe: no sink", "invalid pipe: valve 1 is nil"},
+		},
+		"negative": {
+			builder:  pipe.Builder().From(source).To(&pipetest.Sink{}).With(pipe.WithRegionTimeout(-1)).Build,
+			expected: []string{"invalid pipe: WithRegionTimeout can't be negative"},
+		},
+		"batches and ring": {
+			builder:  pipe.Builder().From(source).To(&pipetest.Sink{}).With(pipe.WithBatches(4), pipe.WithRing(4)).Build,
+			expected: []string{"invalid pipe: WithBatches and WithRing don't go together"},
+		},
+		"batches of valves": {
+			builder:  pipe.Builder().From(source).Through(passthrough, reorder).To(&pipetest.Sink{}).With(pipe.WithBatches(4)).Build,
+			expected: []string{"invalid pipe: batches and rings need valves that are Funcs, valve 1 is a *pipe.reorder"},
+		},
+		"ring with a digest and stats": {
+			builder: pipe.Builder().From(source).To(&pipetest.Sink{}).With(pipe.WithRing(4), pipe.WithDigest(sha256.New()), pipe.WithStats()).Build,
+			expected: []string{
+				"invalid pipe: batches and rings don't go with a digest",
+				"invalid pipe: WithStats doesn't go with batches or rings",
+			},
+		},
+		"shortcut": {
+			builder: pipe.Builder().From(source).Through(passthrough).To(&pipetest.Sink{}).With(pipe.WithShortcut()).Build,
+			expected: []string{
+				"invalid pipe: WithShortcut needs a sink that can take it, *pipetest.Sink can't",
+				"invalid pipe: WithShortcut doesn't go with valves or a digest",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// when
+			p, err := test.builder()
+
+			// then
+			assert.Assert(t, p == nil)
+			assert.ErrorIs(t, err, pipe.ErrConfig)
+			for _, expected := range test.expected {
+				assert.ErrorContains(t, err, expected)
+			}
+			var joined interface{ Unwrap() []error }
+			assert.Assert(t, errors.As(err, &joined))
+			assert.Equal(t, len(joined.Unwrap()), len(test.expected))
+		})
+	}
+}
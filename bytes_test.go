@@ -0,0 +1,77 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestBytes(t *testing.T) {
+	for _, size := range []int{0, 10, 150 * KiB} {
+		// given
+		want := bytes.Repeat([]byte("0123456789"), size/10)
+		sink := pipeio.BytesSink()
+		p := pipe.New(pipeio.BytesSource(want), sink)
+
+		// when
+		assert.NilError(t, p.Pipe(context.Background()))
+
+		// then
+		assert.Assert(t, bytes.Equal(sink.Bytes(), want))
+		if size > 0 {
+			assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: int64(size)}})
+		}
+	}
+}
+
+func TestBytesSink_gaps(t *testing.T) {
+	// given
+	sink := pipeio.BytesSink()
+	p := pipe.New(&source{regions: regions}, sink)
+
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then
+	want := make([]byte, 100)
+	copy(want, "AAAAAAAAAABBBBBBBBBB")
+	copy(want[90:], "JJJJJJJJJJ")
+	assert.DeepEqual(t, sink.Bytes(), want)
+}
+
+func TestBytesSink_From(t *testing.T) {
+	// given: a limit that only lets a couple of regions out at once
+	want := bytes.Repeat([]byte("0123456789"), 10*KiB)
+	buff := pipeio.Limit(pipeio.NewBuffer(4*KiB, 4), 8*KiB)
+	sink := pipeio.BytesSink().From(buff)
+	p := pipe.New(pipeio.Source(bytes.NewReader(want), 0, buff), sink)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// when
+	err := p.Pipe(ctx)
+
+	// then: the buffers were released, so the source never ran out of them
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(sink.Bytes(), want))
+}
+
+func TestBytesSink_Bytes(t *testing.T) {
+	// given
+	sink := pipeio.BytesSink()
+	assert.NilError(t, pipe.New(&source{regions: regions}, sink).Pipe(context.Background()))
+
+	// when
+	got := sink.Bytes()
+	got[0] = 'Z'
+
+	// then: the sink's data is left alone
+	assert.Equal(t, sink.Bytes()[0], byte('A'))
+}
@@ -0,0 +1,199 @@
+package pipe
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Settings are the knobs of a pipe that Calibrate tunes. What they stand for is up to
+// the sources and sinks built with them; typically the size and number of the buffers
+// regions are read into, and the number of shard readers or pool writers.
+type Settings struct {
+	BufferSize  int
+	PoolSize    int
+	Concurrency int
+}
+
+// cost is what the settings cost to run with: memory first, goroutines second
+func (s Settings) cost() (int, int) {
+	return s.BufferSize * s.PoolSize, s.Concurrency
+}
+
+// Grid is the settings Calibrate tries: every combination of them.
+type Grid struct {
+	BufferSizes []int
+	PoolSizes   []int
+	Concurrency []int
+}
+
+// DefaultGrid is the grid Calibrate tries unless told otherwise, much the same as the
+// benchmarks of the package.
+var DefaultGrid = Grid{
+	BufferSizes: []int{64 * KiB, 256 * KiB, MiB, 4 * MiB},
+	PoolSizes:   []int{8, 32},
+	Concurrency: []int{1, 2, 4, 8},
+}
+
+func (g Grid) settings() []Settings {
+	var all []Settings
+	for _, size := range g.BufferSizes {
+		for _, pool := range g.PoolSizes {
+			for _, n := range g.Concurrency {
+				all = append(all, Settings{BufferSize: size, PoolSize: pool, Concurrency: n})
+			}
+		}
+	}
+	return all
+}
+
+// CalibrateOption configures Calibrate.
+type CalibrateOption func(*calibration)
+
+// WithGrid has Calibrate try the settings of the grid (DefaultGrid by default).
+func WithGrid(g Grid) CalibrateOption {
+	return func(c *calibration) {
+		c.grid = g
+	}
+}
+
+// WithProbeTime sets how long every probe transfer runs for (2s by default).
+func WithProbeTime(d time.Duration) CalibrateOption {
+	return func(c *calibration) {
+		c.probe = d
+	}
+}
+
+type calibration struct {
+	grid  Grid
+	probe time.Duration
+}
+
+// Probe is the outcome of a probe transfer of Calibrate.
+type Probe struct {
+	Settings Settings
+	// Bytes is the number of bytes the sink reported as written.
+	Bytes   int64
+	Elapsed time.Duration
+	// Err is what the probe failed with, if it did (other than running out of time).
+	Err error
+}
+
+// Throughput returns the number of bytes written per second.
+func (p Probe) Throughput() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// Calibration is the outcome of Calibrate.
+type Calibration struct {
+	// Best is the settings recommended.
+	Best Settings
+	// Probes are all the probes, in the order they were run.
+	Probes []Probe
+}
+
+// tolerance is how much slower than the fastest probe the recommended settings may be,
+// if they're cheaper to run with
+const tolerance = 0.05
+
+// Calibrate runs short probe transfers with each of the settings of a grid (see
+// WithGrid), one after the other, and recommends the settings to run with: the cheapest
+// (in memory, then in goroutines) of those within 5% of the highest throughput, since
+// more buffers or workers than it takes don't make for a faster pipe, only a heavier
+// one. That's what the benchmarks of the package do, for applications to run where
+// they're deployed, against the storage they actually use.
+//
+// The source and sink of every probe are built by the functions given, source first,
+// so the two can share what they need to (a buffer, say):
+//
+//	var buff pipeio.Buffer
+//	c, err := pipe.Calibrate(ctx,
+//		func(s pipe.Settings) (pipe.Source, error) {
+//			buff = pipeio.NewBuffer(s.BufferSize, s.PoolSize)
+//			return pipeio.Source(io.NewSectionReader(src, 0, size), 0, buff), nil
+//		},
+//		func(s pipe.Settings) (pipe.Sink, error) {
+//			return pipeio.Pool(buff, writers[:s.Concurrency]...), nil
+//		})
+//
+// Sources and sinks that are Scalable have their concurrency set as well. Every probe
+// runs until its source runs dry or it runs out of time (see WithProbeTime); probes
+// that fail are recorded and left out of the running. Calibrate fails if building a
+// source or sink does, or if every probe does.
+func Calibrate(ctx context.Context, source func(Settings) (Source, error), sink func(Settings) (Sink, error), opts ...CalibrateOption) (Calibration, error) {
+	c := &calibration{grid: DefaultGrid, probe: 2 * time.Second}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	var result Calibration
+	var errs []error
+	for _, s := range c.grid.settings() {
+		src, err := source(s)
+		if err != nil {
+			return result, err
+		}
+		dst, err := sink(s)
+		if err != nil {
+			return result, err
+		}
+
+		probe := c.run(ctx, s, src, dst)
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if probe.Err != nil {
+			errs = append(errs, probe.Err)
+		}
+		result.Probes = append(result.Probes, probe)
+	}
+
+	ok := slices.DeleteFunc(slices.Clone(result.Probes), func(p Probe) bool { return p.Err != nil })
+	if len(ok) == 0 {
+		return result, errors.Join(append([]error{errors.New("every probe failed")}, errs...)...)
+	}
+
+	fastest := slices.MaxFunc(ok, func(a, b Probe) int {
+		return cmp.Compare(a.Throughput(), b.Throughput())
+	}).Throughput()
+	ok = slices.DeleteFunc(ok, func(p Probe) bool { return p.Throughput() < fastest*(1-tolerance) })
+	result.Best = slices.MinFunc(ok, func(a, b Probe) int {
+		am, ag := a.Settings.cost()
+		bm, bg := b.Settings.cost()
+		return cmp.Or(cmp.Compare(am, bm), cmp.Compare(ag, bg))
+	}).Settings
+
+	return result, nil
+}
+
+// run runs a probe transfer
+func (c *calibration) run(ctx context.Context, s Settings, source Source, sink Sink) Probe {
+	for _, x := range []any{source, sink} {
+		if scalable, ok := x.(Scalable); ok {
+			scalable.SetConcurrency(s.Concurrency)
+		}
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, c.probe)
+	defer cancel()
+
+	p := New(source, sink)
+	start := time.Now()
+	err := p.Pipe(probeCtx)
+	elapsed := time.Since(start)
+	if probeCtx.Err() != nil && ctx.Err() == nil {
+		// out of time, which is how most probes end
+		err = nil
+	}
+
+	var written int64
+	for _, r := range p.Report().Written {
+		written += r.Len
+	}
+	return Probe{Settings: s, Bytes: written, Elapsed: elapsed, Err: err}
+}
@@ -0,0 +1,87 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// slowWriter takes the same time to write however much it's given, like a store with a
+// fixed cost per request
+var slowWriter = writerFunc(func(p []byte, _ int64) (int, error) {
+	time.Sleep(2 * time.Millisecond)
+	return len(p), nil
+})
+
+func TestCalibrate(t *testing.T) {
+	// given: an endless source, and a sink paying per write whose writers run side by side
+	var buff pipeio.Buffer
+	source := func(s pipe.Settings) (pipe.Source, error) {
+		buff = pipeio.NewBuffer(s.BufferSize, s.PoolSize)
+		return &pipetest.Source{Regions: pipetest.Regions(16, s.BufferSize), Loop: true}, nil
+	}
+	sink := func(s pipe.Settings) (pipe.Sink, error) {
+		writers := make([]io.WriterAt, 4)
+		for i := range writers {
+			writers[i] = slowWriter
+		}
+		return pipeio.Pool(buff, writers...), nil
+	}
+	grid := pipe.Grid{
+		BufferSizes: []int{KiB, 64 * KiB},
+		PoolSizes:   []int{4},
+		Concurrency: []int{1, 4},
+	}
+
+	// when
+	c, err := pipe.Calibrate(context.Background(), source, sink, pipe.WithGrid(grid), pipe.WithProbeTime(100*time.Millisecond))
+
+	// then: larger writes, more of them at once
+	assert.NilError(t, err)
+	assert.Equal(t, len(c.Probes), 4)
+	assert.DeepEqual(t, c.Best, pipe.Settings{BufferSize: 64 * KiB, PoolSize: 4, Concurrency: 4})
+	for _, p := range c.Probes {
+		assert.NilError(t, p.Err)
+		assert.Assert(t, p.Bytes > 0)
+	}
+}
+
+func TestCalibrate_failures(t *testing.T) {
+	// given: a sink failing with small buffers
+	boom := errors.New("boom")
+	source := func(s pipe.Settings) (pipe.Source, error) {
+		return &pipetest.Source{Regions: pipetest.Regions(4, s.BufferSize)}, nil
+	}
+	sink := func(s pipe.Settings) (pipe.Sink, error) {
+		if s.BufferSize < KiB {
+			return &pipetest.Sink{Check: func(pipe.Region) error { return boom }}, nil
+		}
+		return &pipetest.Sink{}, nil
+	}
+	grid := pipe.Grid{BufferSizes: []int{10, KiB}, PoolSizes: []int{1}, Concurrency: []int{1}}
+
+	// when
+	c, err := pipe.Calibrate(context.Background(), source, sink, pipe.WithGrid(grid))
+
+	// then: the failed probe is left out
+	assert.NilError(t, err)
+	assert.ErrorIs(t, c.Probes[0].Err, boom)
+	assert.Equal(t, c.Best.BufferSize, KiB)
+
+	// and: if all probes fail, so does Calibrate
+	grid.BufferSizes = []int{10}
+	_, err = pipe.Calibrate(context.Background(), source, sink, pipe.WithGrid(grid))
+	assert.ErrorIs(t, err, boom)
+
+	// and: so it does if building a sink fails
+	_, err = pipe.Calibrate(context.Background(), source, func(pipe.Settings) (pipe.Sink, error) { return nil, boom })
+	assert.ErrorIs(t, err, boom)
+}
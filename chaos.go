@@ -0,0 +1,108 @@
+package pipe
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrChaos is the error injected into runs of pipes WithChaos. It's Transient.
+var ErrChaos error = chaosError{}
+
+type chaosError struct{}
+
+func (chaosError) Error() string {
+	return "pipe: error injected by chaos mode"
+}
+
+func (chaosError) Class() ErrorClass {
+	return Transient
+}
+
+// Chaos configures WithChaos. Rates are per region, from 0 (never) to 1 (every region).
+type Chaos struct {
+	// Seed seeds the dice; the same seed makes for the same rolls (though not necessarily
+	// on the same regions, as stages interleave differently from run to run).
+	Seed int64
+
+	// Cancel is the rate at which the run is canceled, as if by the caller.
+	Cancel float64
+	// Error is the rate at which the run fails with ErrChaos.
+	Error float64
+	// Stall is the rate at which a stage stalls before handing off a region, for StallFor
+	// or until the run is canceled.
+	Stall    float64
+	StallFor time.Duration
+}
+
+// WithChaos has the pipe randomly stall, fail and cancel its runs, for soak-testing
+// whatever is supposed to cope with that (retries, resumes, verification...) before
+// trusting it with real data. Cancellations and errors strike as regions enter the pipe,
+// stalls at every handoff between stages (see Yield).
+func WithChaos(c Chaos) Option {
+	return func(p *Pipe) {
+		p.chaos = &c
+	}
+}
+
+// chaos rolls the dice for a single run
+type chaos struct {
+	Chaos
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newChaos(c *Chaos) *chaos {
+	if c == nil {
+		return nil
+	}
+	return &chaos{Chaos: *c, rand: rand.New(rand.NewPCG(uint64(c.Seed), 0))}
+}
+
+func (c *chaos) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < rate
+}
+
+// stall stalls for StallFor at the rate of Stall, or until ctx is done
+func (c *chaos) stall(ctx context.Context) {
+	if !c.roll(c.Stall) {
+		return
+	}
+
+	timer := time.NewTimer(c.StallFor)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// strike lets chaos loose on a region about to enter the pipe, and returns false if that
+// ended the run. An injected error is delivered before strike returns (unless the run is
+// canceled first), so the run can't end up looking as if it had just been stopped.
+func (r *run) strike(ctx context.Context) bool {
+	if r.chaos == nil {
+		return true
+	}
+
+	switch {
+	case r.chaos.roll(r.chaos.Cancel):
+		r.cancel(ErrChaos)
+		return false
+	case r.chaos.roll(r.chaos.Error):
+		select {
+		case r.errs <- ErrChaos:
+		case <-ctx.Done():
+		}
+		return false
+	}
+	return true
+}
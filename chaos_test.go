@@ -0,0 +1,136 @@
+package pipe_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithChaos(t *testing.T) {
+	passthrough := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+
+	tests := []struct {
+		name   string
+		chaos  pipe.Chaos
+		opts   []pipe.Option
+		assert func(t *testing.T, err error, elapsed time.Duration)
+	}{
+		{
+			name:  "error",
+			chaos: pipe.Chaos{Error: 1},
+			assert: func(t *testing.T, err error, _ time.Duration) {
+				assert.ErrorIs(t, err, pipe.ErrChaos)
+				assert.Equal(t, pipe.DefaultClassifier.Classify(err), pipe.Transient)
+			},
+		},
+		{
+			name:  "error/batches",
+			chaos: pipe.Chaos{Error: 1},
+			opts:  []pipe.Option{pipe.WithBatches(4)},
+			assert: func(t *testing.T, err error, _ time.Duration) {
+				assert.ErrorIs(t, err, pipe.ErrChaos)
+			},
+		},
+		{
+			name:  "error/ring",
+			chaos: pipe.Chaos{Error: 1},
+			opts:  []pipe.Option{pipe.WithRing(4)},
+			assert: func(t *testing.T, err error, _ time.Duration) {
+				assert.ErrorIs(t, err, pipe.ErrChaos)
+			},
+		},
+		{
+			name:  "cancel",
+			chaos: pipe.Chaos{Cancel: 1},
+			assert: func(t *testing.T, err error, _ time.Duration) {
+				assert.ErrorIs(t, err, context.Canceled)
+			},
+		},
+		{
+			name:  "stall",
+			chaos: pipe.Chaos{Stall: 1, StallFor: time.Millisecond},
+			assert: func(t *testing.T, err error, elapsed time.Duration) {
+				assert.NilError(t, err)
+				// each of the 10 regions stalls at every handoff (and stages stall
+				// concurrently)
+				assert.Assert(t, elapsed >= 10*time.Millisecond)
+			},
+		},
+		{
+			name:  "none",
+			chaos: pipe.Chaos{},
+			assert: func(t *testing.T, err error, _ time.Duration) {
+				assert.NilError(t, err)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}, passthrough).
+				With(append(test.opts, pipe.WithChaos(test.chaos))...)
+
+			// when
+			start := time.Now()
+			err := p.Pipe(context.Background())
+
+			// then
+			test.assert(t, err, time.Since(start))
+		})
+	}
+}
+
+func TestPipe_WithChaos_stallCanceled(t *testing.T) {
+	// given: stalls longer than the run has
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}).
+		With(pipe.WithChaos(pipe.Chaos{Stall: 1, StallFor: time.Hour}))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// when
+	start := time.Now()
+	err := p.Pipe(ctx)
+
+	// then: they're cut short
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Assert(t, time.Since(start) < 10*time.Second)
+}
+
+func TestPipe_WithChaos_NotStopped(t *testing.T) {
+	// given
+	passthrough := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}, passthrough).
+		With(pipe.WithChaos(pipe.Chaos{Error: 1}))
+
+	// when
+	err := p.Pipe(context.Background())
+	mode, serr := p.Shutdown(context.Background())
+
+	// then: the run failed on chaos, it wasn't shut down
+	assert.ErrorIs(t, err, pipe.ErrChaos)
+	assert.NilError(t, serr)
+	assert.Equal(t, mode, pipe.ShutdownNone)
+}
+
+func TestPipe_WithChaos_released(t *testing.T) {
+	// given: a first region lent by the source, which chaos strikes down at the gate
+	var puts atomic.Int32
+	regions := pipetest.Regions(10, 10)
+	regions[0] = pipe.Lend(regions[0], func([]byte) { puts.Add(1) })
+	p := pipe.New(&pipetest.Source{Regions: regions}, &pipetest.Sink{}).
+		With(pipe.WithChaos(pipe.Chaos{Error: 1}))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: its buffer was handed back
+	assert.ErrorIs(t, err, pipe.ErrChaos)
+	assert.Equal(t, puts.Load(), int32(1))
+}
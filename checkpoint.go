@@ -0,0 +1,188 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// CheckpointStore keeps the ranges of a stream written so far across runs of a pipe, so
+// an interrupted copy can pick up where it left off rather than start over (see
+// WithCheckpoint and SkipCheckpointed). io.Progress provides one kept in a file.
+type CheckpointStore interface {
+	// Load returns the ranges saved so far, if any.
+	Load(ctx context.Context) ([]Range, error)
+	// Save replaces the ranges saved with written, every range written so far.
+	Save(ctx context.Context, written []Range) error
+}
+
+// WithCheckpoint has the pipe save the ranges its sink writes (see Commit) to store every
+// interval while it runs (unless it's 0 or less), and once more when the run is over,
+// along with the ranges saved by earlier runs. Pipe fails if those can't be loaded when
+// the run starts, or if the last save fails; failing to save meanwhile is made up for by
+// the next save. Paired with SkipCheckpointed, a copy that's restarted only writes what
+// it hadn't yet:
+//
+//	store := progress.Store(path)
+//	p := pipe.New(pipe.SkipCheckpointed(source, store, buff), sink).
+//		With(pipe.WithCheckpoint(store, time.Second))
+func WithCheckpoint(store CheckpointStore, interval time.Duration) Option {
+	return func(p *Pipe) {
+		p.checkpoint = &checkpoint{store: store, interval: interval}
+	}
+}
+
+type checkpoint struct {
+	store    CheckpointStore
+	interval time.Duration
+}
+
+// start loads the ranges saved by earlier runs, then saves them with those r writes every
+// interval until stopped, and once more then. Saving isn't interrupted by ctx being done,
+// so the last save still goes through once the run's been canceled.
+func (c *checkpoint) start(ctx context.Context, r *run) (stop func() error, err error) {
+	loaded, err := c.store.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading checkpoint: %w", err)
+	}
+	var saved ranges
+	for _, rg := range loaded {
+		saved = saved.add(rg)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	save := func() error {
+		written := slices.Clone(saved)
+		for _, rg := range r.tracker.report().Written {
+			written = written.add(rg)
+		}
+		return c.store.Save(ctx, written)
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		var ticks <-chan time.Time
+		if c.interval > 0 {
+			ticker := time.NewTicker(c.interval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+		for {
+			select {
+			case <-ticks:
+				_ = save()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		<-finished
+
+		if err := save(); err != nil {
+			return fmt.Errorf("error saving checkpoint: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// SkipCheckpointed wraps source, dropping whatever it produces of the ranges saved in
+// store (see WithCheckpoint), so a restarted copy only passes on what's left to write.
+// Regions written in part are trimmed down to the parts that weren't. The source still
+// produces the whole stream: sources that can seek are better off starting from where
+// the checkpoint leaves off (see io.Progress.Remaining).
+//
+// The buffers of regions dropped are released, and handed back to buff if they aren't
+// lent (see Lend). Regions that are trimmed or split are lent to buff if they aren't
+// already, so that their buffer is only handed back once every piece is released.
+func SkipCheckpointed(source Source, store CheckpointStore, buff Pool) Source {
+	return &skipper{source: source, store: store, buff: buff}
+}
+
+type skipper struct {
+	source Source
+	store  CheckpointStore
+	buff   Pool
+}
+
+func (s *skipper) Write(ctx context.Context, sink chan Region, errs chan error) {
+	defer close(sink)
+
+	loaded, err := s.store.Load(ctx)
+	if err != nil {
+		errs <- fmt.Errorf("error loading checkpoint: %w", err)
+		return
+	}
+	var skip ranges
+	for _, rg := range loaded {
+		skip = skip.add(rg)
+	}
+
+	in := make(chan Region)
+	go s.source.Write(ctx, in, errs)
+	// don't leave the source stuck handing over a region once the run's canceled
+	defer func() { go discard(in) }()
+
+	yield := yielder(ctx)
+	send := func(r Region) bool {
+		yield(r)
+		select {
+		case sink <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		r, more := Next(ctx, in)
+		if !more {
+			return
+		}
+		if len(r.Data) == 0 {
+			if !send(r) {
+				return
+			}
+			continue
+		}
+
+		whole := ranges{{Off: r.Off, Len: int64(len(r.Data))}}
+		left := whole.minus(skip)
+		switch {
+		case len(left) == 0:
+			if !r.Release() {
+				s.buff.Put(r.Data)
+			}
+			continue
+		case len(left) == 1 && left[0] == whole[0]:
+			if !send(r) {
+				return
+			}
+			continue
+		}
+
+		if _, lent := leaseKey.Get(r); !lent {
+			r = Lend(r, s.buff.Put)
+		}
+		// every piece holds on to the buffer they share, before any of them is released
+		for range left[1:] {
+			r.Retain()
+		}
+		for i, rg := range left {
+			piece := r
+			piece.Data = r.Data[rg.Off-r.Off : rg.End()-r.Off]
+			piece.Off = rg.Off
+			if !send(piece) {
+				for range left[i:] {
+					r.Release()
+				}
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithCheckpoint(t *testing.T) {
+	tests := map[string]func(t *testing.T) pipe.CheckpointStore{
+		"memory": func(t *testing.T) pipe.CheckpointStore {
+			return &memoryStore{}
+		},
+		"file": func(t *testing.T) pipe.CheckpointStore {
+			return pipeio.NewProgress(pipeio.Identity{Name: "src", Size: 100}).Store(filepath.Join(t.TempDir(), "progress"))
+		},
+	}
+
+	for name, newStore := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given: a copy that failed halfway through
+			store := newStore(t)
+			diskFull := errors.New("disk full")
+			failing := &pipetest.Sink{Check: func(r pipe.Region) error {
+				if r.Off >= 50 {
+					return diskFull
+				}
+				return nil
+			}}
+			err := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, failing).
+				With(pipe.WithCheckpoint(store, time.Hour)).
+				Pipe(context.Background())
+			assert.ErrorIs(t, err, diskFull)
+			saved, err := store.Load(context.Background())
+			assert.NilError(t, err)
+			assert.DeepEqual(t, saved, []pipe.Range{{Off: 0, Len: 50}})
+
+			// when: it's restarted
+			sink := &pipetest.Sink{}
+			source := pipe.SkipCheckpointed(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, store, pipeio.NewBuffer(10, 4))
+			err = pipe.New(source, sink).
+				With(pipe.WithCheckpoint(store, time.Hour)).
+				Pipe(context.Background())
+
+			// then: only what was left is written, and the checkpoint covers all of it
+			assert.NilError(t, err)
+			assert.DeepEqual(t, ranges(sink.Regions()), []pipe.Range{{Off: 50, Len: 10}, {Off: 60, Len: 10}, {Off: 70, Len: 10}, {Off: 80, Len: 10}, {Off: 90, Len: 10}})
+			saved, err = store.Load(context.Background())
+			assert.NilError(t, err)
+			assert.DeepEqual(t, saved, []pipe.Range{{Off: 0, Len: 100}})
+		})
+	}
+
+	t.Run("no interval", func(t *testing.T) {
+		// given
+		store := &memoryStore{}
+		p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}).
+			With(pipe.WithCheckpoint(store, 0))
+
+		// when
+		err := p.Pipe(context.Background())
+
+		// then: saved once the run is over
+		assert.NilError(t, err)
+		saved, err := store.Load(context.Background())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, saved, []pipe.Range{{Off: 0, Len: 100}})
+	})
+
+	t.Run("load failed", func(t *testing.T) {
+		// given
+		unreadable := errors.New("unreadable")
+		store := &memoryStore{loadErr: unreadable}
+		p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}).
+			With(pipe.WithCheckpoint(store, time.Hour))
+
+		// when
+		err := p.Pipe(context.Background())
+
+		// then
+		assert.ErrorIs(t, err, unreadable)
+	})
+
+	t.Run("save failed", func(t *testing.T) {
+		// given
+		readOnly := errors.New("read-only")
+		store := &memoryStore{saveErr: readOnly}
+		p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}).
+			With(pipe.WithCheckpoint(store, time.Hour))
+
+		// when
+		err := p.Pipe(context.Background())
+
+		// then
+		assert.ErrorIs(t, err, readOnly)
+	})
+}
+
+func TestSkipCheckpointed(t *testing.T) {
+	tests := map[string]struct {
+		saved    []pipe.Range
+		expected []pipe.Range
+	}{
+		"nothing saved": {
+			expected: []pipe.Range{{Off: 0, Len: 10}, {Off: 10, Len: 10}, {Off: 20, Len: 10}},
+		},
+		"regions written in part": {
+			saved:    []pipe.Range{{Off: 5, Len: 10}, {Off: 22, Len: 4}},
+			expected: []pipe.Range{{Off: 0, Len: 5}, {Off: 15, Len: 5}, {Off: 20, Len: 2}, {Off: 26, Len: 4}},
+		},
+		"everything saved": {
+			saved: []pipe.Range{{Off: 0, Len: 30}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			sink := &pipetest.Sink{}
+			source := pipe.SkipCheckpointed(&pipetest.Source{Regions: pipetest.Regions(3, 10)}, &memoryStore{saved: test.saved}, pipeio.NewBuffer(10, 4))
+
+			// when
+			err := pipe.New(source, sink).Pipe(context.Background())
+
+			// then
+			assert.NilError(t, err)
+			assert.DeepEqual(t, ranges(sink.Regions()), test.expected)
+		})
+	}
+}
+
+func TestSkipCheckpointed_limit(t *testing.T) {
+	// given: the first half of the stream saved, and a hole in the middle of every region
+	// of the second half
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	saved := []pipe.Range{{Off: 0, Len: 500}}
+	for off := int64(500); off < 1000; off += 10 {
+		saved = append(saved, pipe.Range{Off: off + 3, Len: 4})
+	}
+	expected := bytes.Clone(data)
+	for _, rg := range saved {
+		clear(expected[rg.Off:rg.End()])
+	}
+
+	tests := map[string][]pipeio.SourceOption{
+		"owned": nil,
+		"lent":  {pipeio.Lent()},
+	}
+
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			buff := pipeio.Limit(pipeio.NewBuffer(10, 4), 40)
+			sink := pipeio.BytesSink().From(buff)
+			source := pipe.SkipCheckpointed(pipeio.Source(bytes.NewReader(data), 0, buff, opts...), &memoryStore{saved: saved}, buff)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// when
+			err := pipe.New(source, sink).Pipe(ctx)
+
+			// then: the buffers of the regions dropped were released, and those of the
+			// regions split weren't reused before every piece was written
+			assert.NilError(t, err)
+			assert.DeepEqual(t, sink.Bytes(), expected)
+		})
+	}
+}
+
+// memoryStore is a pipe.CheckpointStore keeping the ranges saved in memory
+type memoryStore struct {
+	mu               sync.Mutex
+	saved            []pipe.Range
+	loadErr, saveErr error
+}
+
+func (s *memoryStore) Load(ctx context.Context) ([]pipe.Range, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saved, s.loadErr
+}
+
+func (s *memoryStore) Save(ctx context.Context, written []pipe.Range) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	s.saved = written
+	return nil
+}
+
+// ranges returns the ranges of the regions
+func ranges(regions []pipe.Region) []pipe.Range {
+	var out []pipe.Range
+	for _, r := range regions {
+		out = append(out, pipe.Range{Off: r.Off, Len: int64(len(r.Data))})
+	}
+	return out
+}
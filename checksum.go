@@ -0,0 +1,43 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrChecksumMismatch is what VerifyChecksums fails with when the data of a region isn't
+// what its checksum says it was.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// CRC32C is the key of the checksum of the data of a region (CRC-32, Castagnoli), as
+// StampChecksums computes it.
+var CRC32C = NewKey[uint32]("pipe.crc32c")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// StampChecksums returns a Valve computing the checksum of every region passing through
+// and attaching it to the region as its CRC32C, for VerifyChecksums to check further down.
+func StampChecksums() Func {
+	return func(r Region) (Region, error) {
+		return CRC32C.Set(r, crc32.Checksum(r.Data, castagnoli)), nil
+	}
+}
+
+// VerifyChecksums returns a Valve checking the data of every region against its CRC32C,
+// failing with ErrChecksumMismatch if it doesn't match (the region can be diverted, see
+// WithDeadLetters). Placed right before the sink, with StampChecksums right after the
+// source, it catches whatever corrupted the data on the way: a valve with a bug in it, a
+// flaky transport in between. Regions without a checksum go through unchecked.
+func VerifyChecksums() Func {
+	return func(r Region) (Region, error) {
+		want, ok := CRC32C.Get(r)
+		if !ok {
+			return r, nil
+		}
+		if got := crc32.Checksum(r.Data, castagnoli); got != want {
+			return r, fmt.Errorf("%w: region at offset %d has checksum %08x, expected %08x", ErrChecksumMismatch, r.Off, got, want)
+		}
+		return r, nil
+	}
+}
@@ -0,0 +1,61 @@
+package pipe_test
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestVerifyChecksums(t *testing.T) {
+	// corrupt flips a bit of the region at off
+	corrupt := func(off int64) pipe.Func {
+		return func(r pipe.Region) (pipe.Region, error) {
+			if r.Off == off {
+				data := append([]byte(nil), r.Data...)
+				data[3] ^= 1
+				r.Data = data
+			}
+			return r, nil
+		}
+	}
+
+	tests := map[string]struct {
+		valves   []pipe.Valve
+		expected string
+	}{
+		"intact": {
+			valves: []pipe.Valve{pipe.StampChecksums(), corrupt(-1), pipe.VerifyChecksums()},
+		},
+		"corrupted": {
+			valves:   []pipe.Valve{pipe.StampChecksums(), corrupt(20), pipe.VerifyChecksums()},
+			expected: "valve 2 (pipe.Func): checksum mismatch: region at offset 20 has checksum",
+		},
+		"unstamped": {
+			valves: []pipe.Valve{corrupt(20), pipe.VerifyChecksums()},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			sink := &pipetest.Sink{}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(5, 10)}, sink, test.valves...)
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			if test.expected == "" {
+				assert.NilError(t, err)
+				assert.Equal(t, len(sink.Regions()), 5)
+				return
+			}
+			assert.ErrorIs(t, err, pipe.ErrChecksumMismatch)
+			assert.ErrorContains(t, err, test.expected)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestCoalesce(t *testing.T) {
+	// given: contiguous regions, but for a gap after the sixth
+	regions := pipetest.Regions(10, 10)
+	for i := 6; i < len(regions); i++ {
+		regions[i].Off += 5
+	}
+	sink := &pipetest.Sink{}
+	buff := pipeio.Limit(pipeio.NewBuffer(10, 10), 30)
+
+	// when
+	p := pipe.New(&pipetest.Source{Regions: regions}, sink, pipeio.Coalesce(25, buff))
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: merged up to the target size, and not across the gap
+	var got []pipe.Region
+	for _, r := range sink.Regions() {
+		got = append(got, pipe.Region{Off: r.Off, Data: r.Data})
+	}
+	want := []pipe.Region{
+		{Off: 0, Data: []byte("AAAAAAAAAABBBBBBBBBB")},
+		{Off: 20, Data: []byte("CCCCCCCCCCDDDDDDDDDD")},
+		{Off: 40, Data: []byte("EEEEEEEEEEFFFFFFFFFF")},
+		{Off: 65, Data: []byte("GGGGGGGGGGHHHHHHHHHH")},
+		{Off: 85, Data: []byte("IIIIIIIIIIJJJJJJJJJJ")},
+	}
+	assert.DeepEqual(t, got, want)
+}
+
+func TestCoalesce_limited(t *testing.T) {
+	// given: a source that can only have a few buffers out at once
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	buff := pipeio.Limit(pipeio.NewBuffer(10, 4), 40)
+	sink := pipeio.BytesSink().From(buff)
+
+	// when: more regions are merged than the source has buffers
+	p := pipe.New(pipeio.Source(bytes.NewReader(data), 0, buff), sink, pipeio.Coalesce(100, buff))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// then: the merged regions release what their buffers were charged
+	assert.NilError(t, p.Pipe(ctx))
+	assert.DeepEqual(t, sink.Bytes(), data)
+}
+
+func TestCoalesce_lent(t *testing.T) {
+	// given: regions lent by a lender keeping count of the buffers handed back
+	var (
+		mu   sync.Mutex
+		puts = map[*byte]int{}
+	)
+	regions := pipetest.Regions(10, 10)
+	for i := range regions {
+		regions[i] = pipe.Lend(regions[i], func(data []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			puts[&data[0]]++
+		})
+	}
+	sink := pipeio.BytesSink()
+
+	// when: the merged regions are split up again, each piece retaining them
+	coalesce := pipeio.Coalesce(50, pipeio.NewBuffer(10, 10))
+	p := pipe.New(&pipetest.Source{Regions: regions}, sink, coalesce, pipe.Split(10))
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: every buffer was handed back once, merged or not
+	assert.Equal(t, len(sink.Bytes()), 100)
+	assert.Equal(t, len(puts), len(regions))
+	for _, n := range puts {
+		assert.Equal(t, n, 1)
+	}
+}
@@ -0,0 +1,124 @@
+package pipe_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("pipetest", func(t *testing.T) {
+		pipetest.RunSourceConformance(t, func(*testing.T) pipe.Source {
+			return &pipetest.Source{Regions: pipetest.Regions(10, 10)}
+		})
+		pipetest.RunValveConformance(t, func(*testing.T) pipe.Valve { return &pipetest.Valve{} })
+		pipetest.RunSinkConformance(t, func(*testing.T) pipe.Sink { return &pipetest.Sink{} })
+	})
+
+	t.Run("Fan", func(t *testing.T) {
+		pipetest.RunSourceConformance(t, func(*testing.T) pipe.Source {
+			return pipe.Fan(
+				&pipetest.Source{Regions: pipetest.Regions(10, 10)},
+				&pipetest.Source{Regions: pipetest.Regions(20, 10)[10:]},
+			)
+		})
+	})
+
+	t.Run("Fan/Fair", func(t *testing.T) {
+		pipetest.RunSourceConformance(t, func(*testing.T) pipe.Source {
+			return pipe.Fan(
+				&pipetest.Source{Regions: pipetest.Regions(10, 10)},
+				&pipetest.Source{Regions: pipetest.Regions(20, 10)[10:]},
+			).Fair(2, 1)
+		})
+	})
+
+	t.Run("FanOrdered", func(t *testing.T) {
+		pipetest.RunSourceConformance(t, func(*testing.T) pipe.Source {
+			return pipe.FanOrdered(
+				&pipetest.Source{Regions: pipetest.Regions(10, 10)},
+				&pipetest.Source{Regions: pipetest.Regions(20, 10)[10:]},
+			)
+		})
+	})
+
+	t.Run("Func", func(t *testing.T) {
+		pipetest.RunValveConformance(t, func(*testing.T) pipe.Valve {
+			return pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+		})
+	})
+
+	t.Run("Mirror", func(t *testing.T) {
+		pipetest.RunValveConformance(t, func(*testing.T) pipe.Valve {
+			return pipe.Mirror(&pipetest.Sink{}, 4)
+		})
+	})
+
+	t.Run("pipeio.Decompress", func(t *testing.T) {
+		pipetest.RunValveConformance(t, func(*testing.T) pipe.Valve {
+			return pipeio.Decompress(pipeio.NewBuffer(10, 1))
+		})
+	})
+
+	t.Run("pipeio.Detect", func(t *testing.T) {
+		pipetest.RunValveConformance(t, func(*testing.T) pipe.Valve {
+			return pipeio.Detect()
+		})
+	})
+
+	t.Run("pipeio.Encrypt", func(t *testing.T) {
+		pipetest.RunValveConformance(t, func(*testing.T) pipe.Valve {
+			return pipeio.Encrypt(pipeio.Keys{"k": bytes.Repeat([]byte{1}, 32)}, "k", pipeio.NewBuffer(10, 1))
+		})
+	})
+
+	t.Run("pipeio.Source", func(t *testing.T) {
+		for _, ahead := range []int{0, 2} {
+			pipetest.RunSourceConformance(t, func(*testing.T) pipe.Source {
+				data := bytes.Repeat([]byte("A"), 100*KiB)
+				return pipeio.Source(bytes.NewReader(data), 0, pipeio.NewBuffer(KiB, 4), pipeio.ReadAhead(ahead))
+			})
+		}
+	})
+
+	t.Run("pipeio.Sharded", func(t *testing.T) {
+		pipetest.RunSourceConformance(t, func(*testing.T) pipe.Source {
+			data := bytes.Repeat([]byte("A"), 100*KiB)
+			buff := pipeio.NewBuffer(KiB, 4)
+			return pipeio.Sharded(int64(len(data)), 10*KiB, 2, func(off, n int64) pipe.Source {
+				return pipeio.Source(bytes.NewReader(data[off:off+n]), off, buff)
+			})
+		})
+	})
+
+	t.Run("pipeio.Sink", func(t *testing.T) {
+		pipetest.RunSinkConformance(t, func(*testing.T) pipe.Sink {
+			return pipeio.Sink(pipeio.BytesSink(), pipeio.NewBuffer(10, 1))
+		})
+		pipetest.RunSinkConformance(t, func(*testing.T) pipe.Sink {
+			return pipeio.Pool(pipeio.NewBuffer(10, 1), []io.WriterAt{&recordingWriter{}, &recordingWriter{}}...)
+		})
+	})
+
+	t.Run("pipeio.Backfill", func(t *testing.T) {
+		pipetest.RunSourceConformance(t, func(*testing.T) pipe.Source {
+			data := bytes.Repeat([]byte("A"), 100*KiB)
+			primary := &pipetest.Source{Regions: pipetest.Regions(10, KiB), Err: errors.New("cache went away")}
+			return pipeio.Backfill(primary, int64(len(data)), func(off, n int64) pipe.Source {
+				return pipeio.Source(bytes.NewReader(data[off:off+n]), off, pipeio.NewBuffer(KiB, 4))
+			})
+		})
+	})
+
+	t.Run("pipeio.Bytes", func(t *testing.T) {
+		pipetest.RunSourceConformance(t, func(*testing.T) pipe.Source {
+			return pipeio.BytesSource(bytes.Repeat([]byte("A"), 200*KiB))
+		})
+		pipetest.RunSinkConformance(t, func(*testing.T) pipe.Sink { return pipeio.BytesSink() })
+	})
+}
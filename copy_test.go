@@ -0,0 +1,154 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestCopyFile(t *testing.T) {
+	for _, size := range []int{0, 10, 3*MiB + 7} {
+		for _, unbuffered := range []bool{false, true} {
+			// given
+			dir := t.TempDir()
+			want := make([]byte, size)
+			_, _ = rand.Read(want)
+			assert.NilError(t, os.WriteFile(filepath.Join(dir, "src"), want, 0o644))
+
+			opts := []pipeio.CopyOption{pipeio.BufferSize(256 * KiB)}
+			if unbuffered {
+				opts = append(opts, pipeio.FileOptions(pipeio.Unbuffered()))
+			}
+
+			// when
+			err := pipeio.CopyFile(context.Background(), filepath.Join(dir, "dst"), filepath.Join(dir, "src"), opts...)
+
+			// then
+			assert.NilError(t, err)
+			got, err := os.ReadFile(filepath.Join(dir, "dst"))
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(got, want))
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	want := make([]byte, 3*MiB+7)
+	_, _ = rand.Read(want)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "ranged",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(want))
+			},
+		},
+		{
+			name: "whole",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					return
+				}
+				_, _ = w.Write(want)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			srv := httptest.NewServer(test.handler)
+			defer srv.Close()
+			path := filepath.Join(t.TempDir(), "dst")
+
+			// when
+			err := pipeio.Download(context.Background(), srv.URL, path, pipeio.BufferSize(256*KiB))
+
+			// then
+			assert.NilError(t, err)
+			got, err := os.ReadFile(path)
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(got, want))
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	// given
+	want := make([]byte, 3*MiB+7)
+	_, _ = rand.Read(want)
+	path := filepath.Join(t.TempDir(), "src")
+	assert.NilError(t, os.WriteFile(path, want, 0o644))
+
+	var got []byte
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Method, http.MethodPut)
+		contentType = r.Header.Get("Content-Type")
+		got, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	// when
+	err := pipeio.Upload(context.Background(), path, srv.URL, pipeio.BufferSize(256*KiB))
+
+	// then
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, want))
+	assert.Equal(t, contentType, pipeio.DetectFormat(want).MIME)
+
+	// and: failures are reported
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer fail.Close()
+	err = pipeio.Upload(context.Background(), path, fail.URL)
+	assert.Assert(t, err != nil && strings.Contains(err.Error(), "403"))
+}
+
+func TestHTTPSource(t *testing.T) {
+	// given: a server sending the body in small chunks
+	want := make([]byte, 100*KiB+7)
+	_, _ = rand.Read(want)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPartialContent)
+		for b := want[10:]; len(b) > 0; b = b[min(len(b), 512):] {
+			_, _ = w.Write(b[:min(len(b), 512)])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	var got []pipe.Region
+	sinkFunc := func(r pipe.Region) error {
+		got = append(got, pipe.Region{Off: r.Off, Data: bytes.Clone(r.Data)})
+		return nil
+	}
+	source := pipeio.HTTPSource(srv.Client(), srv.URL, 10, -1, pipeio.NewBuffer(32*KiB, 1))
+
+	// when
+	assert.NilError(t, pipe.New(source, &sink{f: sinkFunc}).Pipe(context.Background()))
+
+	// then: regions are filled up rather than one per chunk
+	assert.Equal(t, len(got), 4)
+	for i, r := range got {
+		assert.Equal(t, r.Off, int64(10+i*32*KiB))
+		assert.Assert(t, bytes.Equal(r.Data, want[r.Off:r.Off+int64(len(r.Data))]))
+	}
+}
@@ -0,0 +1,219 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+// issuer hands out numbered tokens, counting how many it did
+type issuer struct {
+	fetched atomic.Int64
+	expiry  time.Duration // how long tokens are good for, 0 for ever
+	delay   time.Duration
+}
+
+func (i *issuer) fetch(ctx context.Context) (pipeio.Token, error) {
+	time.Sleep(i.delay)
+	tok := pipeio.Token{Value: fmt.Sprint("tok-", i.fetched.Add(1))}
+	if i.expiry > 0 {
+		tok.Expiry = time.Now().Add(i.expiry)
+	}
+	return tok, nil
+}
+
+func TestRefreshingCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("cached", func(t *testing.T) {
+		// given
+		i := &issuer{expiry: time.Hour}
+		creds := pipeio.RefreshingCredentials(i.fetch, time.Minute)
+
+		// when
+		first, err := creds.Token(ctx)
+		assert.NilError(t, err)
+		second, err := creds.Token(ctx)
+		assert.NilError(t, err)
+
+		// then
+		assert.Equal(t, first, second)
+		assert.Equal(t, i.fetched.Load(), int64(1))
+	})
+
+	t.Run("about to expire", func(t *testing.T) {
+		// given: tokens good for less than the margin
+		i := &issuer{expiry: 30 * time.Second}
+		creds := pipeio.RefreshingCredentials(i.fetch, time.Minute)
+
+		// when
+		first, err := creds.Token(ctx)
+		assert.NilError(t, err)
+		second, err := creds.Token(ctx)
+		assert.NilError(t, err)
+
+		// then
+		assert.Equal(t, first.Value, "tok-1")
+		assert.Equal(t, second.Value, "tok-2")
+	})
+
+	t.Run("refreshed once", func(t *testing.T) {
+		// given: a token refused by many requests at once
+		i := &issuer{delay: 20 * time.Millisecond}
+		creds := pipeio.RefreshingCredentials(i.fetch, time.Minute)
+		stale, err := creds.Token(ctx)
+		assert.NilError(t, err)
+
+		// when
+		var wg sync.WaitGroup
+		got := make([]pipeio.Token, 8)
+		for n := range got {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got[n], _ = creds.Refresh(ctx, stale)
+			}()
+		}
+		wg.Wait()
+
+		// then: they share the one fetch
+		for _, tok := range got {
+			assert.Equal(t, tok.Value, "tok-2")
+		}
+		assert.Equal(t, i.fetched.Load(), int64(2))
+
+		// and: refreshing a token that's been refreshed already doesn't fetch again
+		tok, err := creds.Refresh(ctx, stale)
+		assert.NilError(t, err)
+		assert.Equal(t, tok.Value, "tok-2")
+		assert.Equal(t, i.fetched.Load(), int64(2))
+	})
+}
+
+// authorized lets requests through to the handler as long as they bear the token of the
+// moment, which is revoked (as it expired) once a request with the method revoke is
+type authorized struct {
+	http.Handler
+	revoke string
+
+	mu      sync.Mutex
+	current string
+}
+
+func (a *authorized) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	if a.current == "" {
+		// the first token handed out is the good one
+		a.current = r.Header.Get("Authorization")
+	}
+	ok := r.Header.Get("Authorization") == a.current
+	if ok && r.Method == a.revoke {
+		a.revoke, a.current = "", "Bearer tok-2"
+	}
+	a.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	a.Handler.ServeHTTP(w, r)
+}
+
+func TestHTTPSource_Credentials(t *testing.T) {
+	// given: a token expiring as the response is halfway through, which breaks off
+	want := make([]byte, 100*KiB)
+	_, _ = rand.Read(want)
+	v := &versioned{data: want, etag: `"v1"`, breaks: 1}
+	srv := httptest.NewServer(&authorized{Handler: v, revoke: http.MethodGet})
+	defer srv.Close()
+
+	i := &issuer{}
+	var got bytes.Buffer
+	buff := pipeio.NewBuffer(32*KiB, 1)
+	source := pipeio.HTTPSource(srv.Client(), srv.URL, 0, -1, buff).Credentials(pipeio.RefreshingCredentials(i.fetch, time.Minute))
+
+	// when
+	err := pipe.New(source, pipeio.StreamSink(&got, 0, buff)).Pipe(context.Background())
+
+	// then: the rest was fetched with a fresh token
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got.Bytes(), want))
+	assert.Equal(t, i.fetched.Load(), int64(2))
+}
+
+func TestDownload_Authorize(t *testing.T) {
+	// given: a token expiring once the download is under way
+	want := make([]byte, 3*MiB+7)
+	_, _ = rand.Read(want)
+	srv := httptest.NewServer(&authorized{Handler: &versioned{data: want, etag: `"v1"`}, revoke: http.MethodHead})
+	defer srv.Close()
+
+	i := &issuer{}
+	path := filepath.Join(t.TempDir(), "dst")
+
+	// when
+	err := pipeio.Download(context.Background(), srv.URL, path,
+		pipeio.BufferSize(256*KiB), pipeio.Client(srv.Client()), pipeio.Authorize(pipeio.RefreshingCredentials(i.fetch, time.Minute)))
+
+	// then: every shard went on with the same fresh token
+	assert.NilError(t, err)
+	got, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, want))
+	assert.Equal(t, i.fetched.Load(), int64(2))
+}
+
+func TestDownload_Authorize_refused(t *testing.T) {
+	// given: a server refusing every token
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	i := &issuer{}
+
+	// when
+	err := pipeio.Download(context.Background(), srv.URL, filepath.Join(t.TempDir(), "dst"),
+		pipeio.Client(srv.Client()), pipeio.Authorize(pipeio.RefreshingCredentials(i.fetch, time.Minute)))
+
+	// then: it gave up after refreshing once
+	assert.ErrorContains(t, err, "403")
+	assert.Equal(t, i.fetched.Load(), int64(2))
+}
+
+func TestUpload_Authorize(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "src")
+	assert.NilError(t, os.WriteFile(path, []byte("hello"), 0o644))
+
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+	creds := pipeio.RefreshingCredentials(func(context.Context) (pipeio.Token, error) {
+		return pipeio.Token{Type: "Token", Value: "secret"}, nil
+	}, 0)
+
+	// when
+	err := pipeio.Upload(context.Background(), path, srv.URL, pipeio.Client(srv.Client()), pipeio.Authorize(creds))
+
+	// then
+	assert.NilError(t, err)
+	assert.Equal(t, auth, "Token secret")
+}
@@ -0,0 +1,117 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+var keys = pipeio.Keys{
+	"2023": bytes.Repeat([]byte{1}, 32),
+	"2024": bytes.Repeat([]byte{2}, 32),
+}
+
+// encrypt runs plain through Encrypt with the key of the given ID, and returns the frames
+func encrypt(t *testing.T, plain []byte, id string) []byte {
+	t.Helper()
+
+	buff := pipeio.NewBuffer(KiB, 4)
+	sink := pipeio.BytesSink()
+	p := pipe.New(pipeio.Source(bytes.NewReader(plain), 0, buff), sink, pipeio.Encrypt(keys, id, buff))
+	assert.NilError(t, p.Pipe(context.Background()))
+	return sink.Bytes()
+}
+
+// decrypt runs the frames through the valves, in chunks that don't line up with them
+func decrypt(ring pipeio.Keyring, frames []byte, valves ...func(pipeio.Buffer) pipe.Valve) ([]byte, error) {
+	buff := pipeio.NewBuffer(1000, 4)
+	sink := pipeio.BytesSink()
+	vs := []pipe.Valve{pipeio.Decrypt(ring, buff)}
+	for _, v := range valves {
+		vs = append(vs, v(buff))
+	}
+	err := pipe.New(pipeio.Source(bytes.NewReader(frames), 0, buff), sink, vs...).Pipe(context.Background())
+	return sink.Bytes(), err
+}
+
+func TestEncrypt(t *testing.T) {
+	// given
+	plain := bytes.Repeat([]byte("0123456789"), 10*KiB)
+
+	// when
+	frames := encrypt(t, plain, "2024")
+	got, err := decrypt(keys, frames)
+
+	// then
+	assert.NilError(t, err)
+	assert.Assert(t, !bytes.Contains(frames, []byte("0123456789")))
+	assert.Assert(t, bytes.Equal(got, plain))
+}
+
+func TestEncrypt_shuffled(t *testing.T) {
+	// given: regions coming in out of order
+	regions := pipetest.Regions(10, 10)
+	regions[2], regions[7] = regions[7], regions[2]
+	sink := pipeio.BytesSink()
+	buff := pipeio.NewBuffer(KiB, 1)
+	assert.NilError(t, pipe.New(&pipetest.Source{Regions: regions}, sink, pipeio.Encrypt(keys, "2024", buff)).Pipe(context.Background()))
+
+	// when
+	got, err := decrypt(keys, sink.Bytes())
+
+	// then: they're back where they belong
+	assert.NilError(t, err)
+	want := bytes.Buffer{}
+	for _, r := range pipetest.Regions(10, 10) {
+		want.Write(r.Data)
+	}
+	assert.DeepEqual(t, got, want.Bytes())
+}
+
+func TestDecrypt_rotated(t *testing.T) {
+	// given: an archive encrypted with last year's key, and a ring that moved on
+	plain := bytes.Repeat([]byte("0123456789"), 3*KiB)
+	old := encrypt(t, plain, "2023")
+
+	// when: re-encrypting it with this year's key
+	reencrypt := func(buff pipeio.Buffer) pipe.Valve { return pipeio.Encrypt(keys, "2024", buff) }
+	rotated, err := decrypt(keys, old, reencrypt)
+	assert.NilError(t, err)
+
+	// then: it takes this year's key alone to decrypt
+	got, err := decrypt(pipeio.Keys{"2024": keys["2024"]}, rotated)
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, plain))
+
+	_, err = decrypt(pipeio.Keys{"2024": keys["2024"]}, old)
+	assert.ErrorIs(t, err, pipeio.ErrUnknownKey)
+}
+
+func TestDecrypt_tampered(t *testing.T) {
+	// given
+	frames := encrypt(t, bytes.Repeat([]byte("0123456789"), KiB), "2024")
+	frames[100] ^= 0xff // inside the first frame's sealed data
+
+	// when
+	_, err := decrypt(keys, frames)
+
+	// then
+	assert.ErrorContains(t, err, "message authentication failed")
+}
+
+func TestDecrypt_truncated(t *testing.T) {
+	// given
+	frames := encrypt(t, bytes.Repeat([]byte("0123456789"), KiB), "2024")
+
+	// when
+	_, err := decrypt(keys, frames[:len(frames)-1])
+
+	// then
+	assert.ErrorContains(t, err, "mid-frame")
+}
@@ -0,0 +1,76 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+)
+
+// DeadLetter is a region diverted from the stream, and the error it failed with (see
+// WithDeadLetters).
+type DeadLetter struct {
+	Region Region
+	Err    error
+}
+
+// WithDeadLetters has regions that fail to go through a valve or to be written by the
+// sink diverted to ch along with their error, rather than failing the pipe: the rest of
+// the stream carries on, and the diverted regions are reported as failed (see Report).
+// Fatal errors still fail the pipe, as there's no point carrying on after those (see
+// ErrorClass).
+//
+// Components divert regions with Divert: Funcs do, and so do the sinks of package io;
+// other components fail the pipe as they would otherwise. The pipe never closes ch, and
+// waits for every letter to be received, so ch must be drained while the pipe runs.
+func WithDeadLetters(ch chan<- DeadLetter) Option {
+	return func(p *Pipe) {
+		p.deadLetters = ch
+	}
+}
+
+type deadLetterKey struct{}
+
+// Divert is called by components that failed to handle a region, to divert it to the
+// pipe's dead letters (see WithDeadLetters) and carry on with the rest of the stream. It
+// returns false if the component should fail as it would have otherwise: the pipe has no
+// dead letters and doesn't tolerate failures (see WithTolerance), err is fatal, the pipe
+// carried on past as many regions as it tolerates already, or ctx is done. The region's
+// data is copied, so its buffer is the component's to release as usual (see Release).
+//
+// Divert doesn't report the region as failed: sinks are expected to Fail it anyway.
+func Divert(ctx context.Context, r Region, err error) bool {
+	letters, diverted := ctx.Value(deadLetterKey{}).(chan<- DeadLetter)
+	tol, tolerant := ctx.Value(allowanceKey{}).(*allowance)
+	if !diverted && !tolerant || ctx.Err() != nil {
+		return false
+	}
+	if t, ok := ctx.Value(trackerKey{}).(*tracker); ok && t.classifier.Classify(err) == Fatal {
+		return false
+	}
+	if tolerant && !tol.admit(r.Off, diverted) {
+		return false
+	}
+	if !diverted {
+		// carried on past, with nowhere to send it (see WithTolerance)
+		return true
+	}
+
+	r = unlent(r)
+	r.Data = bytes.Clone(r.Data)
+	select {
+	case letters <- DeadLetter{Region: r, Err: err}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// divert diverts a region a Func failed on, reporting it as failed as a sink would (and
+// releasing it, see Lend)
+func divert(ctx context.Context, r Region, err error) bool {
+	if !Divert(ctx, r, err) {
+		return false
+	}
+	Fail(ctx, r, err)
+	r.Release()
+	return true
+}
@@ -0,0 +1,123 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithDeadLetters(t *testing.T) {
+	corrupt := errors.New("corrupt")
+	failAt := func(off int64, err error) func(pipe.Region) error {
+		return func(r pipe.Region) error {
+			if r.Off == off {
+				return err
+			}
+			return nil
+		}
+	}
+	valve := func(check func(pipe.Region) error) pipe.Func {
+		return func(r pipe.Region) (pipe.Region, error) {
+			return r, check(r)
+		}
+	}
+
+	tests := map[string]struct {
+		valves   []pipe.Valve
+		check    func(pipe.Region) error
+		opts     []pipe.Option
+		expected []int64 // offsets of the dead letters
+		err      error
+	}{
+		"valve": {
+			valves:   []pipe.Valve{valve(failAt(20, corrupt))},
+			expected: []int64{20},
+		},
+		"valve/batches": {
+			valves:   []pipe.Valve{valve(failAt(20, corrupt))},
+			opts:     []pipe.Option{pipe.WithBatches(4)},
+			expected: []int64{20},
+		},
+		"valve/ring": {
+			valves:   []pipe.Valve{valve(failAt(20, corrupt))},
+			opts:     []pipe.Option{pipe.WithRing(4)},
+			expected: []int64{20},
+		},
+		"sink": {
+			check:    failAt(30, corrupt),
+			expected: []int64{30},
+		},
+		"valve and sink": {
+			valves:   []pipe.Valve{valve(failAt(20, corrupt))},
+			check:    failAt(30, corrupt),
+			expected: []int64{20, 30},
+		},
+		"fatal": {
+			valves: []pipe.Valve{valve(failAt(20, syscall.ENOSPC))},
+			err:    syscall.ENOSPC,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			letters := make(chan pipe.DeadLetter, 10)
+			sink := &pipetest.Sink{Check: test.check}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, test.valves...).
+				With(append(test.opts, pipe.WithDeadLetters(letters))...)
+
+			// when
+			err := p.Pipe(context.Background())
+			close(letters)
+
+			// then
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				assert.Equal(t, len(letters), 0)
+				return
+			}
+			assert.NilError(t, err)
+
+			var diverted []int64
+			for letter := range letters {
+				assert.ErrorIs(t, letter.Err, corrupt)
+				assert.Equal(t, len(letter.Region.Data), 10)
+				diverted = append(diverted, letter.Region.Off)
+			}
+			assert.DeepEqual(t, diverted, test.expected)
+
+			var failed []int64
+			for _, f := range p.Report().Failed {
+				failed = append(failed, f.Off)
+			}
+			assert.DeepEqual(t, failed, test.expected)
+			assert.Equal(t, len(sink.Regions()), 10-len(test.expected))
+		})
+	}
+
+	t.Run("io sink", func(t *testing.T) {
+		// given: a writer failing its first write, for good
+		letters := make(chan pipe.DeadLetter, 10)
+		w := &flakyWriter{failures: 1, err: syscall.EIO}
+		p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(4, 10)}, pipeio.Sink(w, pipeio.NewBuffer(10, 1))).
+			With(pipe.WithDeadLetters(letters))
+
+		// when
+		err := p.Pipe(context.Background())
+
+		// then
+		assert.NilError(t, err)
+		assert.Equal(t, len(letters), 1)
+		letter := <-letters
+		assert.ErrorIs(t, letter.Err, syscall.EIO)
+		assert.Equal(t, letter.Region.Off, int64(0))
+		assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 10, Len: 30}})
+	})
+}
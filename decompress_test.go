@@ -0,0 +1,114 @@
+package pipe_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// bzipped is "hello, hello, hello, bzip2\n", compressed with bzip2 (which the standard
+// library can't do)
+var bzipped = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x13, 0x46,
+	0x92, 0xc3, 0x00, 0x00, 0x07, 0x59, 0x80, 0x00, 0x10, 0x40, 0x04, 0x10,
+	0x00, 0x12, 0x64, 0xc0, 0x10, 0x20, 0x00, 0x22, 0x3d, 0x51, 0xa6, 0x09,
+	0xe8, 0x40, 0xd0, 0x34, 0x1d, 0xcc, 0x3f, 0x16, 0x71, 0xa5, 0x60, 0x98,
+	0x4b, 0x45, 0xdc, 0x91, 0x4e, 0x14, 0x24, 0x04, 0xd1, 0xa4, 0xb0, 0xc0,
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write(data)
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	return b.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	plain := bytes.Repeat([]byte("0123456789"), 10*KiB)
+
+	// a stand-in for a real zstd decoder: the frame is the magic followed by plain data
+	zstd := pipeio.Codec{
+		Name:  "zstd",
+		Magic: pipeio.Zstd.Magic,
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			_, err := io.CopyN(io.Discard, r, int64(len(pipeio.Zstd.Magic)))
+			return r, err
+		},
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		codecs []pipeio.Codec
+		want   []byte
+		err    error
+	}{
+		{name: "gzip", data: gzipped(t, plain), want: plain},
+		{name: "bzip2", data: bzipped, want: []byte("hello, hello, hello, bzip2\n")},
+		{name: "plain", data: plain, want: plain},
+		{name: "tiny", data: []byte("hi"), want: []byte("hi")},
+		{name: "empty", data: nil, want: nil},
+		{name: "zstd", data: append(bytes.Clone(pipeio.Zstd.Magic), plain...), codecs: []pipeio.Codec{zstd}, want: plain},
+		{name: "zstd/no decoder", data: append(bytes.Clone(pipeio.Zstd.Magic), plain...), err: pipeio.ErrNoDecoder},
+		{name: "gzip/truncated", data: gzipped(t, plain)[:100], err: io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given: the compressed data in small regions
+			buff := pipeio.NewBuffer(KiB, 4)
+			sink := pipeio.BytesSink().From(buff)
+			source := pipeio.Source(bytes.NewReader(test.data), 0, buff)
+			p := pipe.New(source, sink, pipeio.Decompress(buff, test.codecs...))
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(sink.Bytes(), test.want))
+		})
+	}
+}
+
+func TestDecompress_outOfOrder(t *testing.T) {
+	// given
+	data := gzipped(t, bytes.Repeat([]byte("0123456789"), KiB))
+	source := &pipetest.Source{Regions: []pipe.Region{
+		{Data: bytes.Clone(data[:10]), Off: 0},
+		{Data: bytes.Clone(data[20:]), Off: 20},
+	}}
+
+	// when
+	err := pipe.New(source, &pipetest.Sink{}, pipeio.Decompress(pipeio.NewBuffer(KiB, 1))).Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "out of order")
+}
+
+func TestDecompress_notAtStart(t *testing.T) {
+	// given
+	source := &pipetest.Source{Regions: pipetest.Regions(10, 10)[1:]}
+
+	// when
+	err := pipe.New(source, &pipetest.Sink{}, pipeio.Decompress(pipeio.NewBuffer(KiB, 1))).Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "rather than 0")
+}
@@ -0,0 +1,128 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestDedup(t *testing.T) {
+	// given: regions repeating the content of earlier ones
+	regions := repeating()
+	sink := &pipetest.Sink{}
+	index := openIndex(t)
+
+	// when
+	p := pipe.New(&pipetest.Source{Regions: regions}, sink, pipeio.Dedup(index, pipeio.NewBuffer(10, 4)))
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: the duplicates refer to the regions they repeat
+	got := sink.Regions()
+	assert.Equal(t, len(got), 6)
+	for _, r := range got[:3] {
+		_, dup := pipeio.Duplicate.Get(r)
+		assert.Assert(t, !dup)
+		assert.Equal(t, len(r.Data), 10)
+	}
+	for i, off := range []int64{10, 0, 10} {
+		r := got[3+i]
+		ref, dup := pipeio.Duplicate.Get(r)
+		assert.Assert(t, dup)
+		assert.Equal(t, len(r.Data), 0)
+		assert.Equal(t, r.Off, int64(30+i*10))
+		assert.Equal(t, ref.Off, off)
+		assert.Equal(t, ref.Len, int64(10))
+		assert.Equal(t, ref.Digest, pipeio.ChunkDigest(regions[off/10].Data))
+	}
+	assert.Equal(t, index.Len(), 3)
+
+	// and: the next run finds them all indexed
+	sink = &pipetest.Sink{}
+	p = pipe.New(&pipetest.Source{Regions: regions[:3]}, sink, pipeio.Dedup(index, pipeio.NewBuffer(10, 4)))
+	assert.NilError(t, p.Pipe(context.Background()))
+	for _, r := range sink.Regions() {
+		_, dup := pipeio.Duplicate.Get(r)
+		assert.Assert(t, dup)
+	}
+}
+
+func TestResolveDuplicates(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []pipeio.SinkOption
+		err  error
+	}{
+		{
+			name: "resolved",
+			opts: []pipeio.SinkOption{pipeio.ResolveDuplicates()},
+		},
+		{
+			name: "write-behind",
+			opts: []pipeio.SinkOption{pipeio.ResolveDuplicates(), pipeio.WriteBehind(30, time.Hour)},
+		},
+		{
+			name: "unresolved",
+			err:  pipeio.ErrDuplicate,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			var data []byte
+			for _, r := range repeating() {
+				data = append(data, r.Data...)
+			}
+			f, err := os.Create(filepath.Join(t.TempDir(), "dst"))
+			assert.NilError(t, err)
+			defer f.Close()
+
+			buff := pipeio.Limit(pipeio.NewBuffer(10, 4), 40)
+			source := pipeio.Source(bytes.NewReader(data), 0, buff)
+			p := pipe.New(source, pipeio.Sink(f, buff, test.opts...), pipeio.Dedup(openIndex(t), buff))
+
+			// when
+			err = p.Pipe(context.Background())
+
+			// then: duplicates are copied from the regions they repeat, or fail
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 30}})
+				return
+			}
+			assert.NilError(t, err)
+			got, err := os.ReadFile(f.Name())
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(got, data))
+			assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 60}})
+		})
+	}
+}
+
+// repeating returns three regions, followed by three repeating the content of the first two
+func repeating() []pipe.Region {
+	regions := pipetest.Regions(3, 10)
+	for i, j := range []int{1, 0, 1} {
+		r := regions[j]
+		r.Off = int64(30 + i*10)
+		regions = append(regions, r)
+	}
+	return regions
+}
+
+// openIndex opens a chunk index of its own, closed at the end of the test
+func openIndex(t *testing.T) *pipeio.ChunkIndex {
+	index, err := pipeio.OpenChunkIndex(filepath.Join(t.TempDir(), "index"))
+	assert.NilError(t, err)
+	t.Cleanup(func() { index.Close() })
+	return index
+}
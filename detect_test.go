@@ -0,0 +1,128 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// at returns data of n bytes with magic at off
+func at(n, off int, magic string) []byte {
+	data := make([]byte, n)
+	copy(data[off:], magic)
+	return data
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want pipeio.Format
+	}{
+		{name: "text", data: []byte("hello, world\n"), want: pipeio.Format{MIME: "text/plain; charset=utf-8"}},
+		{name: "png", data: []byte("\x89PNG\x0d\x0a\x1a\x0a..."), want: pipeio.Format{MIME: "image/png"}},
+		{name: "binary", data: at(64*KiB, 0, "\x00\x01\x02"), want: pipeio.Format{MIME: "application/octet-stream"}},
+		{name: "empty", data: nil, want: pipeio.Format{MIME: "text/plain; charset=utf-8"}},
+		{name: "qcow2", data: at(64*KiB, 0, "QFI\xfb"), want: pipeio.Format{MIME: "application/octet-stream", Kind: "qcow2"}},
+		{name: "vhdx", data: at(64*KiB, 0, "vhdxfile"), want: pipeio.Format{MIME: "application/octet-stream", Kind: "vhdx"}},
+		{name: "iso9660", data: at(64*KiB, 0x8001, "CD001"), want: pipeio.Format{MIME: "application/x-iso9660-image", Kind: "iso9660"}},
+		{name: "tar", data: at(10*KiB, 257, "ustar"), want: pipeio.Format{MIME: "application/x-tar", Kind: "tar"}},
+		{name: "zip", data: at(KiB, 0, "PK\x03\x04"), want: pipeio.Format{MIME: "application/zip", Kind: "zip"}},
+		{name: "gzip", data: gzipped(t, []byte("hello")), want: pipeio.Format{MIME: "application/gzip", Kind: "gzip"}},
+		{name: "bzip2", data: bzipped, want: pipeio.Format{MIME: "application/x-bzip2", Kind: "bzip2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given: the data in small regions
+			buff := pipeio.NewBuffer(KiB, 4)
+			sink := pipeio.BytesSink().From(buff)
+			detect := pipeio.Detect()
+			p := pipe.New(pipeio.Source(bytes.NewReader(test.data), 0, buff), sink, detect)
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then: the format is told, and the data goes through untouched
+			assert.NilError(t, err)
+			got, ok := detect.Wait(context.Background())
+			assert.Assert(t, ok)
+			assert.DeepEqual(t, got, test.want)
+			assert.Assert(t, bytes.Equal(sink.Bytes(), test.data))
+		})
+	}
+}
+
+func TestDetect_Wait(t *testing.T) {
+	// given: a stream whose start has gone through, but which hasn't ended yet
+	data := at(64*KiB, 0, "QFI\xfb")
+	regions := make(chan pipe.Region)
+	source := sourceFunc(func(ctx context.Context, sink chan pipe.Region, errs chan error) {
+		defer close(sink)
+		for r := range regions {
+			sink <- r
+		}
+	})
+	detect := pipeio.Detect()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	piped := make(chan error, 1)
+	go func() { piped <- pipe.New(source, &pipetest.Sink{}, detect).Pipe(ctx) }()
+	regions <- pipe.Region{Data: data[:40*KiB], Off: 0}
+
+	// when
+	got, ok := detect.Wait(ctx)
+
+	// then: the format is known before the end of the stream
+	assert.Assert(t, ok)
+	assert.Equal(t, got.Kind, "qcow2")
+
+	regions <- pipe.Region{Data: data[40*KiB:], Off: 40 * KiB}
+	close(regions)
+	assert.NilError(t, <-piped)
+}
+
+func TestDetect_outOfOrder(t *testing.T) {
+	// given: the start of the stream arriving out of order
+	data := at(64*KiB, 0x8001, "CD001")
+	source := &pipetest.Source{Regions: []pipe.Region{
+		{Data: data[0x8000:], Off: 0x8000},
+		{Data: data[:0x8000], Off: 0},
+	}}
+	detect := pipeio.Detect()
+
+	// when
+	err := pipe.New(source, &pipetest.Sink{}, detect).Pipe(context.Background())
+
+	// then
+	assert.NilError(t, err)
+	got, ok := detect.Wait(context.Background())
+	assert.Assert(t, ok)
+	assert.Equal(t, got.Kind, "iso9660")
+}
+
+func TestDetect_canceled(t *testing.T) {
+	// given: a run canceled before the start of the stream arrives
+	ctx, cancel := context.WithCancel(context.Background())
+	source := sourceFunc(func(ctx context.Context, sink chan pipe.Region, errs chan error) {
+		defer close(sink)
+		cancel()
+		<-ctx.Done()
+	})
+	detect := pipeio.Detect()
+
+	// when
+	_ = pipe.New(source, &pipetest.Sink{}, detect).Pipe(ctx)
+
+	// then
+	_, ok := detect.Wait(context.Background())
+	assert.Assert(t, !ok)
+}
@@ -0,0 +1,123 @@
+package pipe_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// records makes n small, similar objects, a region each
+func records(n int) []pipe.Region {
+	regions := make([]pipe.Region, n)
+	for i := range regions {
+		data := fmt.Sprintf(`{"id":%d,"kind":"vm-image-delta","volume":"vol-%04d","block_size":4096,"compression":"none","checksum_algorithm":"crc32c","state":"committed","owner":"tenant-%d"}`, i, i%50, i%7)
+		regions[i] = pipe.Region{Data: []byte(data), Off: int64(i)}
+	}
+	return regions
+}
+
+// framed collects the frames coming out of Compress
+type framed struct {
+	mu     sync.Mutex
+	frames map[int64][]byte
+}
+
+func (f *framed) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	for {
+		r, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+		f.mu.Lock()
+		f.frames[r.Off] = r.Data
+		f.mu.Unlock()
+	}
+	errs <- ctx.Err()
+}
+
+func (f *framed) size() (n int) {
+	for _, frame := range f.frames {
+		n += len(frame)
+	}
+	return n
+}
+
+func compressed(t *testing.T, regions []pipe.Region, d *pipeio.Dictionary, valves ...pipe.Valve) *framed {
+	t.Helper()
+
+	f := &framed{frames: map[int64][]byte{}}
+	valves = append(valves, pipeio.Compress(pipeio.NewBuffer(KiB, 1), pipeio.Deflate, d))
+	assert.NilError(t, pipe.New(&pipetest.Source{Regions: regions}, f, valves...).Pipe(context.Background()))
+	assert.Equal(t, len(f.frames), len(regions))
+	return f
+}
+
+func TestCompress_Dictionary(t *testing.T) {
+	regions := records(500)
+	plain := compressed(t, regions, nil)
+
+	tests := []struct {
+		name  string
+		train func(d *pipeio.Dictionary) []pipe.Valve
+	}{
+		{
+			name: "during the run",
+			train: func(d *pipeio.Dictionary) []pipe.Valve {
+				return nil
+			},
+		},
+		{
+			name: "dedicated pass",
+			train: func(d *pipeio.Dictionary) []pipe.Valve {
+				err := pipe.New(&pipetest.Source{Regions: records(500)}, &pipetest.Sink{}, d.Sample()).Pipe(context.Background())
+				assert.NilError(t, err)
+				assert.NilError(t, d.Train())
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			d := pipeio.NewDictionary(8*KiB, 8*KiB, nil)
+			valves := test.train(d)
+
+			// when
+			got := compressed(t, regions, d, valves...)
+
+			// then: far smaller than compressed on their own
+			assert.Assert(t, d.Trained())
+			assert.Assert(t, got.size() < plain.size()/2, "%d bytes with a dictionary, %d without", got.size(), plain.size())
+
+			// and: every frame decompresses with the dictionary
+			for _, r := range regions {
+				data, err := io.ReadAll(flate.NewReaderDict(bytes.NewReader(got.frames[r.Off]), d.Bytes()))
+				assert.NilError(t, err)
+				assert.Assert(t, bytes.Equal(data, r.Data), r.Off)
+			}
+		})
+	}
+}
+
+func TestCompress_Dictionary_short(t *testing.T) {
+	// given: a run too short to sample all the dictionary wants
+	d := pipeio.NewDictionary(8*KiB, MiB, nil)
+
+	// when
+	got := compressed(t, records(10), d)
+
+	// then: the dictionary is trained on what there was
+	assert.Assert(t, d.Trained())
+	assert.Equal(t, len(got.frames), 10)
+}
@@ -0,0 +1,182 @@
+package pipe
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto"
+	_ "crypto/sha256" // the digests published alongside downloads are mostly these
+	_ "crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+)
+
+// ErrDigestMismatch is returned when the digest of the stream isn't the one expected
+// (see WithExpectedDigest).
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// DigestError is the error of a pipe whose stream doesn't have the expected digest.
+type DigestError struct {
+	Alg  crypto.Hash
+	Want []byte
+	Got  []byte
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("%v: %v of the stream is %x, expected %x", ErrDigestMismatch, e.Alg, e.Got, e.Want)
+}
+
+func (e *DigestError) Unwrap() error {
+	return ErrDigestMismatch
+}
+
+// Aborter can be implemented by a Sink that is able to undo what it wrote (e.g. by
+// removing the partial file, or aborting a multipart upload). Pipe aborts the sink when
+// the stream turns out not to have the expected digest (see WithExpectedDigest).
+type Aborter interface {
+	Abort(ctx context.Context) error
+}
+
+// WithExpectedDigest computes the digest of the stream as it reaches the sink, and fails
+// the pipe with a DigestError if it isn't sum once the stream is over, aborting the sink
+// if it's an Aborter. This is the usual check of a download against its published
+// sha256, without reading the destination back.
+//
+// Regions are hashed in order of offset, from offset 0: regions arriving early (from a
+// sharded source, say) are copied and held until the regions before them have gone by,
+// and a stream with a gap in it doesn't match. SHA-256 and SHA-512 are always available,
+// other algorithms need to be linked into the binary by the caller.
+//
+// Since the digest needs every region to go through it, the pipe runs neither rings,
+// batches nor its shortcut when it's set.
+func WithExpectedDigest(alg crypto.Hash, sum []byte) Option {
+	return func(p *Pipe) {
+		d := p.digester()
+		d.alg, d.sum = alg, sum
+	}
+}
+
+// WithDigest feeds the stream to h as it reaches the sink, in order of offset from offset
+// 0, so that once Pipe returns h.Sum is the digest of what was written: a backup gets the
+// digest of its stream without reading the destination back. h is reset at the start of
+// every run, and is the caller's to read once the run is over (not while it's going).
+//
+// Regions are hashed the way WithExpectedDigest hashes them, and the pipe fails if the
+// stream has a gap in it; nor does the pipe run rings, batches or its shortcut then. Both
+// options can be set together, for h to be fed alongside the digest being checked.
+func WithDigest(h hash.Hash) Option {
+	return func(p *Pipe) {
+		p.digester().h = h
+	}
+}
+
+// digester returns the digest valve of the pipe, adding it if there's none yet
+func (p *Pipe) digester() *digest {
+	if p.digest == nil {
+		p.digest = &digest{}
+	}
+	return p.digest
+}
+
+// digest is the valve hashing the stream on its way to the sink
+type digest struct {
+	alg crypto.Hash // the algorithm of sum, 0 if there's none to check (see WithExpectedDigest)
+	sum []byte
+	h   hash.Hash // fed the stream for the caller, if set (see WithDigest)
+}
+
+func (d *digest) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		// the verdict goes on errs before the sink learns the stream is over, so the
+		// sink's result can't beat it to the pipe
+		defer close(sink)
+
+		var check hash.Hash
+		if d.alg != 0 {
+			if !d.alg.Available() {
+				errs <- fmt.Errorf("digest %v isn't linked into the binary", d.alg)
+				return
+			}
+			check = d.alg.New()
+		}
+		var hashes []io.Writer
+		for _, h := range []hash.Hash{check, d.h} {
+			if h != nil {
+				h.Reset()
+				hashes = append(hashes, h)
+			}
+		}
+
+		var (
+			h       = io.MultiWriter(hashes...)
+			next    int64
+			pending []Region // arrived early, by offset
+		)
+		for {
+			r, more := Next(ctx, source)
+			if !more {
+				break
+			}
+
+			if r.Off <= next {
+				next = feed(h, r, next)
+				for len(pending) > 0 && pending[0].Off <= next {
+					next = feed(h, pending[0], next)
+					pending = pending[1:]
+				}
+			} else {
+				// the region is released once written, so hold on to a copy
+				early := Region{Data: bytes.Clone(r.Data), Off: r.Off}
+				i, _ := slices.BinarySearchFunc(pending, r.Off, func(p Region, off int64) int { return cmp.Compare(p.Off, off) })
+				pending = slices.Insert(pending, i, early)
+			}
+
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		switch {
+		case ctx.Err() != nil:
+		case len(pending) > 0 && check == nil:
+			errs <- fmt.Errorf("digest: %w: gap in the stream at offset=%d", ErrOutOfOrder, next)
+		case len(pending) > 0:
+			errs <- fmt.Errorf("%w: gap in the stream at offset=%d", ErrDigestMismatch, next)
+		case check != nil:
+			if got := check.Sum(nil); !bytes.Equal(got, d.sum) {
+				errs <- &DigestError{Alg: d.alg, Want: d.sum, Got: got}
+			}
+		}
+	}()
+
+	return source
+}
+
+// feed adds what r has past next to h, and returns where the hashed stream ends
+func feed(h io.Writer, r Region, next int64) int64 {
+	if skip := next - r.Off; skip < int64(len(r.Data)) {
+		_, _ = h.Write(r.Data[skip:])
+		return r.Off + int64(len(r.Data))
+	}
+	return next
+}
+
+// abort aborts the sink if the run failed for its digest, adding the failure to abort to
+// err
+func (p *Pipe) abort(ctx context.Context, err error) error {
+	a, ok := p.sink.(Aborter)
+	if !ok || !errors.Is(err, ErrDigestMismatch) {
+		return err
+	}
+	if aerr := a.Abort(context.WithoutCancel(ctx)); aerr != nil {
+		return errors.Join(err, fmt.Errorf("error aborting the sink: %w", aerr))
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package pipe_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// abortingSink is a sink recording whether it's been aborted
+type abortingSink struct {
+	pipetest.Sink
+	aborted atomic.Bool
+}
+
+func (s *abortingSink) Abort(ctx context.Context) error {
+	s.aborted.Store(true)
+	return nil
+}
+
+func TestWithExpectedDigest(t *testing.T) {
+	ordered := pipetest.Regions(10, 10)
+	sum := sha256.Sum256(pipetest.Sequence(ordered).Bytes())
+	shuffled := slices.Clone(ordered)
+	slices.Reverse(shuffled)
+
+	tests := []struct {
+		name    string
+		regions []pipe.Region
+		sum     []byte
+		opts    []pipe.Option
+		err     error
+	}{
+		{name: "in order", regions: ordered, sum: sum[:]},
+		{name: "out of order", regions: shuffled, sum: sum[:]},
+		{name: "overlapping", regions: append(slices.Clone(ordered), ordered[3]), sum: sum[:]},
+		{name: "fused", regions: ordered, sum: sum[:], opts: []pipe.Option{pipe.WithFusion()}},
+		{name: "batches", regions: ordered, sum: sum[:], opts: []pipe.Option{pipe.WithBatches(4)}},
+		{name: "ring", regions: ordered, sum: sum[:], opts: []pipe.Option{pipe.WithRing(4)}},
+		{name: "mismatch", regions: ordered, sum: make([]byte, sha256.Size), err: pipe.ErrDigestMismatch},
+		{name: "mismatch, stats", regions: ordered, sum: make([]byte, sha256.Size), opts: []pipe.Option{pipe.WithStats()}, err: pipe.ErrDigestMismatch},
+		{name: "gap", regions: append(slices.Clone(ordered[:5]), ordered[6:]...), sum: sum[:], err: pipe.ErrDigestMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sink := &abortingSink{}
+			opts := append(slices.Clone(test.opts), pipe.WithExpectedDigest(crypto.SHA256, test.sum))
+			p := pipe.New(&pipetest.Source{Regions: test.regions}, sink, passthrough).With(opts...)
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				assert.Assert(t, sink.aborted.Load())
+			} else {
+				assert.NilError(t, err)
+				assert.Assert(t, !sink.aborted.Load())
+			}
+		})
+	}
+}
+
+func TestWithExpectedDigest_error(t *testing.T) {
+	// given
+	regions := pipetest.Regions(4, 10)
+	sum := sha256.Sum256(pipetest.Sequence(regions).Bytes())
+	want := slices.Clone(sum[:])
+	want[0] ^= 0xff
+	p := pipe.New(&pipetest.Source{Regions: regions}, &pipetest.Sink{}).With(pipe.WithExpectedDigest(crypto.SHA256, want))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	var derr *pipe.DigestError
+	assert.Assert(t, errors.As(err, &derr))
+	assert.Equal(t, derr.Alg, crypto.SHA256)
+	assert.DeepEqual(t, derr.Got, sum[:])
+	assert.DeepEqual(t, derr.Want, want)
+}
+
+func TestWithExpectedDigest_unavailable(t *testing.T) {
+	// given: an algorithm that isn't linked in
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(4, 10)}, &pipetest.Sink{}).With(pipe.WithExpectedDigest(crypto.BLAKE2b_256, nil))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "isn't linked into the binary")
+}
+
+func TestWithDigest(t *testing.T) {
+	ordered := pipetest.Regions(10, 10)
+	sum := sha256.Sum256(pipetest.Sequence(ordered).Bytes())
+	shuffled := slices.Clone(ordered)
+	slices.Reverse(shuffled)
+
+	tests := []struct {
+		name     string
+		regions  []pipe.Region
+		opts     []pipe.Option
+		expected string
+	}{
+		{name: "in order", regions: ordered},
+		{name: "out of order", regions: shuffled},
+		{name: "checked too", regions: ordered, opts: []pipe.Option{pipe.WithExpectedDigest(crypto.SHA256, sum[:])}},
+		{name: "gap", regions: append(slices.Clone(ordered[:5]), ordered[6:]...), expected: "digest: regions out of order: gap in the stream at offset=50"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given: a hash that's been used before
+			h := sha256.New()
+			_, _ = h.Write([]byte("stale"))
+			opts := append(slices.Clone(test.opts), pipe.WithDigest(h))
+			sink := &abortingSink{}
+			p := pipe.New(&pipetest.Source{Regions: test.regions}, sink, passthrough).With(opts...)
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			if test.expected != "" {
+				assert.ErrorContains(t, err, test.expected)
+				assert.Assert(t, !errors.Is(err, pipe.ErrDigestMismatch))
+				assert.Assert(t, !sink.aborted.Load())
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, h.Sum(nil), sum[:])
+		})
+	}
+}
+
+func TestCopyFile_ExpectDigest(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	data := pipetest.Sequence(pipetest.Regions(64, KiB)).Bytes()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "src"), data, 0o644))
+	sum := sha256.Sum256(data)
+
+	tests := []struct {
+		name string
+		sum  []byte
+		err  error
+	}{
+		{name: "match", sum: sum[:]},
+		{name: "mismatch", sum: make([]byte, sha256.Size), err: pipe.ErrDigestMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dst := filepath.Join(dir, test.name)
+
+			// when
+			err := pipeio.CopyFile(context.Background(), dst, filepath.Join(dir, "src"),
+				pipeio.BufferSize(4*KiB), pipeio.Shards(4), pipeio.ExpectDigest(crypto.SHA256, test.sum))
+
+			// then
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				_, err := os.Stat(dst)
+				assert.Assert(t, errors.Is(err, os.ErrNotExist))
+			} else {
+				assert.NilError(t, err)
+				got, err := os.ReadFile(dst)
+				assert.NilError(t, err)
+				assert.DeepEqual(t, got, data)
+			}
+		})
+	}
+}
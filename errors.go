@@ -0,0 +1,191 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// The errors the components of pipes fail with wrap these (or are these), along with the
+// errors they're specific to (ErrStalled, ErrDigestMismatch...), so that callers can tell
+// failures apart with errors.Is rather than by their text.
+var (
+	// ErrCanceled is what a pipe fails with once its context is canceled: context.Canceled
+	// itself, for errors.Is to match either.
+	ErrCanceled = context.Canceled
+	// ErrShortWrite is what sinks fail with when a destination takes less of a region
+	// than it was given, without saying why: io.ErrShortWrite itself.
+	ErrShortWrite = io.ErrShortWrite
+	// ErrOutOfOrder is what components taking the stream in order of offset fail with
+	// when it isn't: regions come in out of order, overlap, or leave a gap.
+	ErrOutOfOrder = errors.New("regions out of order")
+)
+
+// ErrorClass describes how a failure should be treated by the policies that react to
+// errors (retries, circuit breakers, partial failure handling).
+type ErrorClass int
+
+const (
+	// Transient errors are expected to clear up on their own; retrying may succeed.
+	Transient ErrorClass = iota
+	// Permanent errors will happen again if retried, but only affect the operation (i.e.
+	// region) that failed, not the rest of the stream.
+	Permanent
+	// Fatal errors mean there's no point in carrying on with the pipe at all (the disk is
+	// full, the destination is read-only, the pipe was canceled...).
+	Fatal
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case Transient:
+		return "transient"
+	case Permanent:
+		return "permanent"
+	case Fatal:
+		return "fatal"
+	default:
+		return "unknown"
+	}
+}
+
+func (c ErrorClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *ErrorClass) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "transient":
+		*c = Transient
+	case "permanent":
+		*c = Permanent
+	case "fatal":
+		*c = Fatal
+	default:
+		return fmt.Errorf("unknown error class %q", text)
+	}
+	return nil
+}
+
+// ErrorClassifier decides the ErrorClass of an error. Policies should consult a
+// classifier instead of each inspecting errnos on their own.
+type ErrorClassifier interface {
+	Classify(err error) ErrorClass
+}
+
+// ClassifierFunc adapts a function to an ErrorClassifier.
+type ClassifierFunc func(err error) ErrorClass
+
+func (f ClassifierFunc) Classify(err error) ErrorClass {
+	return f(err)
+}
+
+// WithClassifier sets the classifier deciding the class of the failures in the pipe's
+// Report (DefaultClassifier by default).
+func WithClassifier(c ErrorClassifier) Option {
+	return func(p *Pipe) {
+		p.classifier = c
+	}
+}
+
+// Classify returns the class of err according to the classifier of the pipe running
+// under ctx (see WithClassifier), or DefaultClassifier outside of one, so that the
+// policies of components agree with the pipe's Report.
+func Classify(ctx context.Context, err error) ErrorClass {
+	if t, ok := ctx.Value(trackerKey{}).(*tracker); ok && t.classifier != nil {
+		return t.classifier.Classify(err)
+	}
+	return DefaultClassifier.Classify(err)
+}
+
+// Classified can be implemented by errors that know their own class; the
+// DefaultClassifier honors it before looking at anything else.
+type Classified interface {
+	Class() ErrorClass
+}
+
+// DefaultClassifier classifies common syscall and net errors:
+//   - timeouts, interrupted calls, resource temporarily unavailable and dropped
+//     connections are transient
+//   - a full disk, a read-only destination, missing permissions or a canceled context
+//     are fatal
+//   - everything else is permanent
+var DefaultClassifier ErrorClassifier = ClassifierFunc(classify)
+
+func classify(err error) ErrorClass {
+	var classified Classified
+	if errors.As(err, &classified) {
+		return classified.Class()
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return Fatal
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.ETIMEDOUT,
+			syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE,
+			syscall.ENETDOWN, syscall.ENETUNREACH, syscall.EHOSTUNREACH, syscall.ENOBUFS:
+			return Transient
+		case syscall.ENOSPC, syscall.EDQUOT, syscall.EROFS, syscall.EACCES, syscall.EPERM,
+			syscall.EBADF:
+			return Fatal
+		}
+		return Permanent
+	}
+
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrShortWrite) {
+		return Transient
+	}
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrClosed) {
+		return Fatal
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return Transient
+	}
+
+	return Permanent
+}
+
+// settle waits for the sink to be done once the run has failed with first, and returns
+// first along with whatever else the stages failed with meanwhile (joined), short of
+// being canceled: the sink failing to write may have made the source fail to read, or
+// the other way around, and both are worth knowing.
+func (r *run) settle(first error, done chan error) error {
+	errs := []error{first}
+	add := func(err error) {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			errs = append(errs, err)
+		}
+	}
+
+	for waiting := true; waiting; {
+		select {
+		case err := <-done:
+			add(err)
+		case <-r.stages.drained:
+			waiting = false
+		}
+	}
+	// and whatever was reported by the time the sink was done
+	for {
+		select {
+		case err := <-done:
+			add(err)
+		default:
+			if len(errs) == 1 {
+				return first
+			}
+			return errors.Join(errs...)
+		}
+	}
+}
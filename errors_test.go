@@ -0,0 +1,217 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestDefaultClassifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected pipe.ErrorClass
+	}{
+		{
+			name:     "transient/errno",
+			err:      &fs.PathError{Op: "write", Path: "/mnt/nfs/a", Err: syscall.ETIMEDOUT},
+			expected: pipe.Transient,
+		},
+		{
+			name:     "transient/wrapped",
+			err:      fmt.Errorf("error writing regions: %w", syscall.ECONNRESET),
+			expected: pipe.Transient,
+		},
+		{
+			name:     "fatal/no-space",
+			err:      &fs.PathError{Op: "write", Path: "/tmp/a", Err: syscall.ENOSPC},
+			expected: pipe.Fatal,
+		},
+		{
+			name:     "fatal/canceled",
+			err:      context.Canceled,
+			expected: pipe.Fatal,
+		},
+		{
+			name:     "permanent/io",
+			err:      syscall.EIO,
+			expected: pipe.Permanent,
+		},
+		{
+			name:     "permanent/unknown",
+			err:      errors.New("aw beans"),
+			expected: pipe.Permanent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, pipe.DefaultClassifier.Classify(test.err), test.expected)
+		})
+	}
+}
+
+func TestPipe_joinedErrors(t *testing.T) {
+	readErr := errors.New("error reading: connection reset")
+	flushErr := errors.New("error flushing: disk full")
+
+	tests := map[string]struct {
+		sourceErr, sinkErr error
+		expected           []error
+	}{
+		"source and sink": {sourceErr: readErr, sinkErr: flushErr, expected: []error{readErr, flushErr}},
+		"source only":     {sourceErr: readErr, expected: []error{readErr}},
+		"sink only":       {sinkErr: flushErr, expected: []error{flushErr}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given: a source failing once it's sent its regions, and a sink failing to
+			// flush whatever happens
+			source := &pipetest.Source{Regions: pipetest.Regions(4, 10), Err: test.sourceErr}
+			sink := &flushing{err: test.sinkErr}
+
+			// when
+			err := pipe.New(source, sink).Pipe(context.Background())
+
+			// then: the pipe fails with both
+			for _, expected := range test.expected {
+				assert.ErrorIs(t, err, expected)
+			}
+			if len(test.expected) == 1 {
+				var stageErr *pipe.StageError
+				assert.Assert(t, errors.As(err, &stageErr))
+				assert.Equal(t, stageErr.Err, test.expected[0])
+			}
+		})
+	}
+}
+
+// flushing is a sink failing with err once it's done with the stream, however it ended
+type flushing struct {
+	err error
+}
+
+func (s *flushing) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	for {
+		if _, more := pipe.Next(ctx, source); !more {
+			break
+		}
+	}
+	errs <- s.err
+}
+
+func TestPipe_cancelCause(t *testing.T) {
+	// given: a sink failing on the third region, behind a source that never ends
+	diskFull := errors.New("disk full")
+	sink := &pipetest.Sink{Check: func(r pipe.Region) error {
+		if r.Off == 20 {
+			return diskFull
+		}
+		return nil
+	}}
+	source := &causeSource{Source: pipetest.Source{Regions: pipetest.Regions(4, 10), Loop: true}, done: make(chan struct{})}
+
+	// when
+	err := pipe.New(source, sink).Pipe(context.Background())
+
+	// then: the source learned why it was interrupted
+	assert.ErrorIs(t, err, diskFull)
+	<-source.done
+	var stageErr *pipe.StageError
+	assert.Assert(t, errors.As(source.cause, &stageErr))
+	assert.Equal(t, stageErr.Stage, "sink")
+	assert.ErrorIs(t, source.cause, diskFull)
+}
+
+// causeSource is a source recording the cause of its context once it's done
+type causeSource struct {
+	pipetest.Source
+	cause error
+	done  chan struct{}
+}
+
+func (s *causeSource) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(s.done)
+
+	s.Source.Write(ctx, sink, errs)
+	s.cause = context.Cause(ctx)
+}
+
+func TestErrors_sentinels(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	regions := pipetest.Regions(4, 10)
+
+	tests := map[string]struct {
+		run      func() error
+		expected error
+	}{
+		"canceled": {
+			run: func() error {
+				source := &pipetest.Source{Regions: regions, Loop: true}
+				return pipe.New(source, &pipetest.Sink{}).Pipe(canceled)
+			},
+			expected: pipe.ErrCanceled,
+		},
+		"short write": {
+			run: func() error {
+				w := writerFunc(func([]byte, int64) (int, error) { return 0, nil })
+				return pipe.New(&pipetest.Source{Regions: regions}, pipeio.Sink(w, pipeio.NewBuffer(10, 1))).
+					Pipe(context.Background())
+			},
+			expected: pipe.ErrShortWrite,
+		},
+		"out of order": {
+			run: func() error {
+				source := &pipetest.Source{Regions: []pipe.Region{regions[0], regions[2]}}
+				return pipe.New(source, &pipetest.Sink{}, pipe.Reorder(0)).Pipe(context.Background())
+			},
+			expected: pipe.ErrOutOfOrder,
+		},
+		"buffer exhausted": {
+			run: func() error {
+				buff := pipeio.Limit(pipeio.NewBuffer(10, 1), 10)
+				buff.Get()
+				_, err := pipeio.Acquire(canceled, buff)
+				return err
+			},
+			expected: pipeio.ErrBufferExhausted,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, test.run(), test.expected)
+		})
+	}
+}
+
+func TestErrors_status(t *testing.T) {
+	// given: a server too busy to answer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// when
+	_, err := pipeio.HTTPIdentity(context.Background(), srv.Client(), srv.URL)
+
+	// then
+	var status *pipeio.StatusError
+	assert.Assert(t, errors.As(err, &status))
+	assert.Equal(t, status.StatusCode, http.StatusServiceUnavailable)
+	assert.Equal(t, pipe.DefaultClassifier.Classify(err), pipe.Transient)
+	assert.ErrorContains(t, err, "error fetching "+srv.URL)
+}
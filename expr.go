@@ -0,0 +1,725 @@
+package pipe
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter returns a Valve that drops, routes and tags regions by the rules of program,
+// written in a small expression language, so pipes configured from files can behave
+// conditionally without any Go being compiled for them. Every rule is a condition on the
+// region and what to do with the regions meeting it, one rule per line (or separated by
+// semicolons), with # starting a comment:
+//
+//	off in 0..4KiB => drop
+//	size < 512 && scope.tenant == "acme" => route small
+//	scope.kind =~ "^delta-" => tag tier="hot" kind="delta"
+//
+// The rules are tried in order for every region. Tags (string values set in the region's
+// Meta, under a Key named after the tag) add up, and evaluation carries on with the rules
+// after them; drop and route are final. Dropped regions go no further, and don't show in
+// the Report (see From for their buffers). Routed regions go to the sink registered under
+// the name with Route instead of down the pipe. Regions no final rule applies to go down
+// the pipe.
+//
+// Conditions are made of:
+//
+//   - off, end and size: the offset of the region, the offset just past its end and its
+//     length, in bytes; numbers take a KiB, MiB or GiB suffix
+//   - scope.<key>: the value of key in the region's Scope, "" if it has none
+//   - meta.<key>: the string value of the region's Meta for the Key named key (a tag,
+//     say), "" if it has none
+//   - "strings", in Go syntax, and true or false
+//   - comparisons (== != < <= > >=) of numbers or strings, x in lo..hi (lo <= x < hi) and
+//     s =~ "regexp"
+//   - && (and), || (or), ! (not) and parentheses
+//
+// Errors in the program are reported as a *SyntaxError.
+func Filter(program string) (*filter, error) {
+	rules, err := parseRules(program)
+	if err != nil {
+		return nil, err
+	}
+	return &filter{rules: rules, routes: make(map[string]Sink)}, nil
+}
+
+// SyntaxError is an error in the program of a Filter.
+type SyntaxError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+type filter struct {
+	rules  []rule
+	routes map[string]Sink
+	buff   Pool // the buffers of regions dropped go back to, see From
+}
+
+// From has the filter hand the buffers of the regions it drops back to buff, unless
+// they're lent (see Lend), in which case they're released: so a limit on how many
+// buffers are out at once (see io.Limit) isn't used up by regions that went nowhere.
+// Without it, only lent regions are released.
+func (f *filter) From(buff Pool) *filter {
+	f.buff = buff
+	return f
+}
+
+// drop releases r, a region that goes no further
+func (f *filter) drop(r Region) {
+	if !r.Release() && f.buff != nil {
+		f.buff.Put(r.Data)
+	}
+}
+
+// Route registers sink as where the rules routing to name send regions. Routed sinks
+// run alongside the pipe with its context, and the pipe isn't done until they are; if
+// one of them fails, so does the pipe.
+func (f *filter) Route(name string, sink Sink) *filter {
+	f.routes[name] = sink
+	return f
+}
+
+// action is what a rule does with the regions meeting its condition
+type action int
+
+const (
+	actDrop action = iota
+	actRoute
+	actTag
+)
+
+type rule struct {
+	cond   func(Region) bool
+	action action
+	route  string
+	tags   Meta // string values, by Key[string]
+}
+
+// route is a routed sink, running
+type route struct {
+	c    chan Region
+	done chan error
+}
+
+func (f *filter) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+
+		for _, r := range f.rules {
+			if _, ok := f.routes[r.route]; r.action == actRoute && !ok {
+				go func() {
+					for r := range source {
+						f.drop(r)
+					}
+				}()
+				errs <- fmt.Errorf("filter: no sink to route %q to", r.route)
+				return
+			}
+		}
+
+		routes := make(map[string]*route, len(f.routes))
+		for name, s := range f.routes {
+			rt := &route{c: make(chan Region), done: make(chan error, 1)}
+			routes[name] = rt
+			go func() {
+				defer Pin(ctx)()
+				s.Read(ctx, rt.c, rt.done)
+			}()
+		}
+		err := f.filter(ctx, source, sink, routes)
+
+		// the pipe is only done once the routed sinks are
+		for name, rt := range routes {
+			close(rt.c)
+			if rerr := <-rt.done; rerr != nil && err == nil && ctx.Err() == nil {
+				err = fmt.Errorf("filter: route %s: %w", name, rerr)
+			}
+		}
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return source
+}
+
+// filter applies the rules to the regions of source; it returns the error of a routed
+// sink that failed, if one did
+func (f *filter) filter(ctx context.Context, source, sink chan Region, routes map[string]*route) error {
+	for {
+		r, more := Next(ctx, source)
+		if !more {
+			return nil
+		}
+
+		out, to := sink, ""
+	rules:
+		for _, rule := range f.rules {
+			if !rule.cond(r) {
+				continue
+			}
+			switch rule.action {
+			case actDrop:
+				out = nil
+				break rules
+			case actRoute:
+				to = rule.route
+				break rules
+			case actTag:
+				// the metadata of r may be shared with its other copies (see Meta)
+				meta := make(Meta, len(r.Meta)+len(rule.tags))
+				maps.Copy(meta, r.Meta)
+				maps.Copy(meta, rule.tags)
+				r.Meta = meta
+			}
+		}
+
+		switch {
+		case to != "":
+			rt := routes[to]
+			select {
+			case rt.c <- r:
+			case err := <-rt.done:
+				// failed (or done) before the stream is
+				rt.done <- err
+				if err == nil {
+					return fmt.Errorf("filter: route %s is done before the stream is", to)
+				}
+				return fmt.Errorf("filter: route %s: %w", to, err)
+			case <-ctx.Done():
+				return nil
+			}
+		case out != nil:
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return nil
+			}
+		default:
+			f.drop(r)
+		}
+	}
+}
+
+// parseRules parses the rules of a Filter program
+func parseRules(program string) ([]rule, error) {
+	p := &parser{lex: lexer{src: program, line: 1, col: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var rules []rule
+	for {
+		for p.tok.kind == tokEnd {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if p.tok.kind == tokEOF {
+			return rules, nil
+		}
+
+		r, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+
+		if p.tok.kind != tokEnd && p.tok.kind != tokEOF {
+			return nil, p.errorf("unexpected %s after rule", p.tok)
+		}
+	}
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokEnd         // of a rule: newline or ;
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind      tokKind
+	text      string
+	num       int64
+	line, col int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of program"
+	case tokEnd:
+		return "end of rule"
+	default:
+		return strconv.Quote(t.text)
+	}
+}
+
+// ops are the operators of the language, longest first so they're matched greedily
+var ops = []string{"=>", "==", "!=", "<=", ">=", "=~", "&&", "||", "..", "<", ">", "!", "(", ")", "="}
+
+// comparisons are the comparison operators
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// units are the suffixes numbers can take
+var units = map[string]int64{"": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30}
+
+type lexer struct {
+	src       string
+	pos       int
+	line, col int
+}
+
+func (l *lexer) next() (token, error) {
+	// skip blanks and comments
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.step(1)
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\r' {
+			break
+		}
+		l.step(1)
+	}
+
+	t := token{line: l.line, col: l.col}
+	if l.pos == len(l.src) {
+		return t, nil
+	}
+
+	rest := l.src[l.pos:]
+	switch c := rest[0]; {
+	case c == '\n' || c == ';':
+		t.kind, t.text = tokEnd, rest[:1]
+		l.step(1)
+		return t, nil
+
+	case c == '"':
+		end := 1
+		for ; end < len(rest) && rest[end] != '"' && rest[end] != '\n'; end++ {
+			if rest[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(rest) || rest[end] != '"' {
+			return t, &SyntaxError{Line: t.line, Col: t.col, Msg: "unterminated string"}
+		}
+		s, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return t, &SyntaxError{Line: t.line, Col: t.col, Msg: fmt.Sprintf("invalid string %s", rest[:end+1])}
+		}
+		t.kind, t.text = tokString, s
+		l.step(end + 1)
+		return t, nil
+
+	case c >= '0' && c <= '9':
+		end := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsDigit(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		unit := strings.IndexFunc(rest[end:], func(r rune) bool { return !unicode.IsLetter(r) })
+		if unit < 0 {
+			unit = len(rest) - end
+		}
+		n, err := strconv.ParseInt(rest[:end], 10, 64)
+		mult, ok := units[rest[end:end+unit]]
+		if err != nil || !ok {
+			return t, &SyntaxError{Line: t.line, Col: t.col, Msg: fmt.Sprintf("invalid number %s", rest[:end+unit])}
+		}
+		t.kind, t.text, t.num = tokNumber, rest[:end+unit], n*mult
+		l.step(end + unit)
+		return t, nil
+
+	case c == '_' || unicode.IsLetter(rune(c)):
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		// a range's .. isn't part of the identifier before it
+		if dots := strings.Index(rest[:end], ".."); dots >= 0 {
+			end = dots
+		}
+		t.kind, t.text = tokIdent, rest[:end]
+		l.step(end)
+		return t, nil
+	}
+
+	for _, op := range ops {
+		if strings.HasPrefix(rest, op) {
+			t.kind, t.text = tokOp, op
+			l.step(len(op))
+			return t, nil
+		}
+	}
+	return t, &SyntaxError{Line: t.line, Col: t.col, Msg: fmt.Sprintf("unexpected %q", rest[0])}
+}
+
+func (l *lexer) step(n int) {
+	for _, c := range l.src[l.pos : l.pos+n] {
+		if c == '\n' {
+			l.line, l.col = l.line+1, 1
+		} else {
+			l.col++
+		}
+	}
+	l.pos += n
+}
+
+type parser struct {
+	lex lexer
+	tok token
+}
+
+func (p *parser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Line: p.tok.line, Col: p.tok.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// accept advances past the operator op if it's next
+func (p *parser) accept(op string) (bool, error) {
+	if p.tok.kind != tokOp || p.tok.text != op {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(op string) error {
+	ok, err := p.accept(op)
+	if err == nil && !ok {
+		err = p.errorf("expected %q, got %s", op, p.tok)
+	}
+	return err
+}
+
+// typ is the type of an expression
+type typ int
+
+const (
+	typNum typ = iota
+	typStr
+	typBool
+)
+
+func (t typ) String() string {
+	return [...]string{"number", "string", "boolean"}[t]
+}
+
+// expr is a compiled expression: the evaluator for its type
+type expr struct {
+	typ typ
+	num func(Region) int64
+	str func(Region) string
+	b   func(Region) bool
+}
+
+// rule parses `condition => action`
+func (p *parser) rule() (rule, error) {
+	tok := p.tok
+	cond, err := p.or()
+	if err != nil {
+		return rule{}, err
+	}
+	if cond.typ != typBool {
+		return rule{}, &SyntaxError{Line: tok.line, Col: tok.col, Msg: fmt.Sprintf("condition is a %s, not a boolean", cond.typ)}
+	}
+	if err := p.expect("=>"); err != nil {
+		return rule{}, err
+	}
+
+	r := rule{cond: cond.b}
+	verb := p.tok
+	if verb.kind != tokIdent {
+		return rule{}, p.errorf("expected drop, route or tag, got %s", verb)
+	}
+	if err := p.advance(); err != nil {
+		return rule{}, err
+	}
+
+	switch verb.text {
+	case "drop":
+		r.action = actDrop
+	case "route":
+		if p.tok.kind != tokIdent {
+			return rule{}, p.errorf("expected the name of a route, got %s", p.tok)
+		}
+		r.action, r.route = actRoute, p.tok.text
+		if err := p.advance(); err != nil {
+			return rule{}, err
+		}
+	case "tag":
+		r.action, r.tags = actTag, make(Meta)
+		for p.tok.kind == tokIdent {
+			key := p.tok.text
+			if err := p.advance(); err != nil {
+				return rule{}, err
+			}
+			if err := p.expect("="); err != nil {
+				return rule{}, err
+			}
+			if p.tok.kind != tokString {
+				return rule{}, p.errorf("expected the value of tag %s, got %s", key, p.tok)
+			}
+			r.tags[NewKey[string](key)] = p.tok.text
+			if err := p.advance(); err != nil {
+				return rule{}, err
+			}
+		}
+		if len(r.tags) == 0 {
+			return rule{}, p.errorf("expected key=\"value\" tags, got %s", p.tok)
+		}
+	default:
+		return rule{}, &SyntaxError{Line: verb.line, Col: verb.col, Msg: fmt.Sprintf("unknown action %q", verb.text)}
+	}
+	return r, nil
+}
+
+// or parses `and {|| and}`
+func (p *parser) or() (expr, error) {
+	return p.logical("||", p.and, func(a, b func(Region) bool) func(Region) bool {
+		return func(r Region) bool { return a(r) || b(r) }
+	})
+}
+
+// and parses `not {&& not}`
+func (p *parser) and() (expr, error) {
+	return p.logical("&&", p.not, func(a, b func(Region) bool) func(Region) bool {
+		return func(r Region) bool { return a(r) && b(r) }
+	})
+}
+
+func (p *parser) logical(op string, operand func() (expr, error), join func(a, b func(Region) bool) func(Region) bool) (expr, error) {
+	tok := p.tok
+	x, err := operand()
+	if err != nil {
+		return expr{}, err
+	}
+	for {
+		ok, err := p.accept(op)
+		if err != nil {
+			return expr{}, err
+		}
+		if !ok {
+			return x, nil
+		}
+
+		right := p.tok
+		y, err := operand()
+		if err != nil {
+			return expr{}, err
+		}
+		for _, e := range []struct {
+			x   expr
+			tok token
+		}{{x, tok}, {y, right}} {
+			if e.x.typ != typBool {
+				return expr{}, &SyntaxError{Line: e.tok.line, Col: e.tok.col, Msg: fmt.Sprintf("%s needs booleans, got a %s", op, e.x.typ)}
+			}
+		}
+		x = expr{typ: typBool, b: join(x.b, y.b)}
+	}
+}
+
+// not parses `!not | comparison`
+func (p *parser) not() (expr, error) {
+	tok := p.tok
+	ok, err := p.accept("!")
+	if err != nil || !ok {
+		if err != nil {
+			return expr{}, err
+		}
+		return p.comparison()
+	}
+
+	x, err := p.not()
+	if err != nil {
+		return expr{}, err
+	}
+	if x.typ != typBool {
+		return expr{}, &SyntaxError{Line: tok.line, Col: tok.col, Msg: fmt.Sprintf("! needs a boolean, got a %s", x.typ)}
+	}
+	return expr{typ: typBool, b: func(r Region) bool { return !x.b(r) }}, nil
+}
+
+// comparison parses `operand [op operand | in operand..operand | =~ "regexp"]`
+func (p *parser) comparison() (expr, error) {
+	x, err := p.operand()
+	if err != nil {
+		return expr{}, err
+	}
+
+	tok := p.tok
+	switch {
+	case tok.kind == tokIdent && tok.text == "in":
+		if err := p.advance(); err != nil {
+			return expr{}, err
+		}
+		lo, err := p.operand()
+		if err != nil {
+			return expr{}, err
+		}
+		if err := p.expect(".."); err != nil {
+			return expr{}, err
+		}
+		hi, err := p.operand()
+		if err != nil {
+			return expr{}, err
+		}
+		if x.typ != typNum || lo.typ != typNum || hi.typ != typNum {
+			return expr{}, &SyntaxError{Line: tok.line, Col: tok.col, Msg: "in needs numbers"}
+		}
+		return expr{typ: typBool, b: func(r Region) bool {
+			n := x.num(r)
+			return lo.num(r) <= n && n < hi.num(r)
+		}}, nil
+
+	case tok.kind == tokOp && tok.text == "=~":
+		if err := p.advance(); err != nil {
+			return expr{}, err
+		}
+		if p.tok.kind != tokString {
+			return expr{}, p.errorf("=~ needs a regexp in a string, got %s", p.tok)
+		}
+		re, err := regexp.Compile(p.tok.text)
+		if err != nil {
+			return expr{}, p.errorf("invalid regexp: %v", err)
+		}
+		if x.typ != typStr {
+			return expr{}, &SyntaxError{Line: tok.line, Col: tok.col, Msg: fmt.Sprintf("=~ needs a string, got a %s", x.typ)}
+		}
+		if err := p.advance(); err != nil {
+			return expr{}, err
+		}
+		return expr{typ: typBool, b: func(r Region) bool { return re.MatchString(x.str(r)) }}, nil
+
+	case tok.kind == tokOp && comparisons[tok.text]:
+		if err := p.advance(); err != nil {
+			return expr{}, err
+		}
+		y, err := p.operand()
+		if err != nil {
+			return expr{}, err
+		}
+		if x.typ != y.typ || x.typ == typBool && tok.text != "==" && tok.text != "!=" {
+			return expr{}, &SyntaxError{Line: tok.line, Col: tok.col, Msg: fmt.Sprintf("can't compare a %s %s a %s", x.typ, tok.text, y.typ)}
+		}
+		return expr{typ: typBool, b: compare(tok.text, x, y)}, nil
+	}
+	return x, nil
+}
+
+// compare returns the comparison op of x and y, of the same type
+func compare(op string, x, y expr) func(Region) bool {
+	var c func(Region) int
+	switch x.typ {
+	case typNum:
+		c = func(r Region) int { return cmp.Compare(x.num(r), y.num(r)) }
+	case typStr:
+		c = func(r Region) int { return strings.Compare(x.str(r), y.str(r)) }
+	case typBool:
+		c = func(r Region) int {
+			if x.b(r) == y.b(r) {
+				return 0
+			}
+			return 1
+		}
+	}
+
+	switch op {
+	case "==":
+		return func(r Region) bool { return c(r) == 0 }
+	case "!=":
+		return func(r Region) bool { return c(r) != 0 }
+	case "<":
+		return func(r Region) bool { return c(r) < 0 }
+	case "<=":
+		return func(r Region) bool { return c(r) <= 0 }
+	case ">":
+		return func(r Region) bool { return c(r) > 0 }
+	default:
+		return func(r Region) bool { return c(r) >= 0 }
+	}
+}
+
+// operand parses a number, a string, a field, true or false, or a parenthesized expression
+func (p *parser) operand() (expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		n := tok.num
+		return expr{typ: typNum, num: func(Region) int64 { return n }}, p.advance()
+
+	case tokString:
+		s := tok.text
+		return expr{typ: typStr, str: func(Region) string { return s }}, p.advance()
+
+	case tokIdent:
+		var x expr
+		switch name := tok.text; {
+		case name == "off":
+			x = expr{typ: typNum, num: func(r Region) int64 { return r.Off }}
+		case name == "end":
+			x = expr{typ: typNum, num: func(r Region) int64 { return r.Off + int64(len(r.Data)) }}
+		case name == "size":
+			x = expr{typ: typNum, num: func(r Region) int64 { return int64(len(r.Data)) }}
+		case name == "true" || name == "false":
+			v := name == "true"
+			x = expr{typ: typBool, b: func(Region) bool { return v }}
+		case strings.HasPrefix(name, "scope.") && len(name) > len("scope."):
+			key := strings.TrimPrefix(name, "scope.")
+			x = expr{typ: typStr, str: func(r Region) string { return r.Scope[key] }}
+		case strings.HasPrefix(name, "meta.") && len(name) > len("meta."):
+			key := NewKey[string](strings.TrimPrefix(name, "meta."))
+			x = expr{typ: typStr, str: func(r Region) string {
+				v, _ := key.Get(r)
+				return v
+			}}
+		default:
+			return expr{}, p.errorf("unknown field %q", name)
+		}
+		return x, p.advance()
+
+	case tokOp:
+		if tok.text == "(" {
+			if err := p.advance(); err != nil {
+				return expr{}, err
+			}
+			x, err := p.or()
+			if err != nil {
+				return expr{}, err
+			}
+			return x, p.expect(")")
+		}
+	}
+	return expr{}, p.errorf("unexpected %s", tok)
+}
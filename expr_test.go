@@ -0,0 +1,233 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// offsets returns the offsets of the regions, in order
+func offsets(regions []pipe.Region) []int64 {
+	offs := make([]int64, 0, len(regions))
+	for _, r := range regions {
+		offs = append(offs, r.Off)
+	}
+	slices.Sort(offs)
+	return offs
+}
+
+func TestFilter(t *testing.T) {
+	// eight regions: 1KiB each up to 4KiB, 100 bytes each after that
+	regions := func() []pipe.Region {
+		var regions []pipe.Region
+		for i := range 4 {
+			regions = append(regions, pipe.Region{Data: make([]byte, KiB), Off: int64(i * KiB)})
+		}
+		for i := range 4 {
+			regions = append(regions, pipe.Region{Data: make([]byte, 100), Off: int64(4*KiB + i*100)})
+		}
+		return regions
+	}
+
+	tests := []struct {
+		name    string
+		program string
+		scope   pipe.Scope
+		passed  []int64
+		routed  []int64
+	}{
+		{
+			name:    "no rules",
+			program: "# nothing to do\n",
+			passed:  []int64{0, 1024, 2048, 3072, 4096, 4196, 4296, 4396},
+		},
+		{
+			name:    "offset range",
+			program: "off in 1KiB..3KiB => drop",
+			passed:  []int64{0, 3072, 4096, 4196, 4296, 4396},
+		},
+		{
+			name:    "size",
+			program: "size < 512 => route small",
+			passed:  []int64{0, 1024, 2048, 3072},
+			routed:  []int64{4096, 4196, 4296, 4396},
+		},
+		{
+			name:    "first final rule wins",
+			program: "off == 0 => route small; size <= 1KiB => drop",
+			routed:  []int64{0},
+		},
+		{
+			name:    "logic",
+			program: "!(off >= 4KiB || end == 1KiB) && size == 1024 => drop",
+			passed:  []int64{0, 4096, 4196, 4296, 4396},
+		},
+		{
+			name:    "scope",
+			program: "scope.tenant == \"acme\" && scope.kind =~ \"^delta-\" => drop",
+			scope:   pipe.Scope{"tenant": "acme", "kind": "delta-7"},
+		},
+		{
+			name:    "scope mismatch",
+			program: "scope.tenant == \"acme\" => drop\nscope.missing != \"\" => drop",
+			scope:   pipe.Scope{"tenant": "globex"},
+			passed:  []int64{0, 1024, 2048, 3072, 4096, 4196, 4296, 4396},
+		},
+		{
+			name: "tags",
+			program: `
+				size < 512 => tag tier="cold"
+				meta.tier == "cold" && off >= 4296 => route small
+			`,
+			passed: []int64{0, 1024, 2048, 3072, 4096, 4196},
+			routed: []int64{4296, 4396},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			f, err := pipe.Filter(test.program)
+			assert.NilError(t, err)
+			small := &pipetest.Sink{}
+			f.Route("small", small)
+			sink := &pipetest.Sink{}
+			ctx := context.Background()
+			if test.scope != nil {
+				ctx = pipe.WithScope(ctx, test.scope)
+			}
+
+			// when
+			err = pipe.New(&pipetest.Source{Regions: regions()}, sink, f).Pipe(ctx)
+
+			// then
+			assert.NilError(t, err)
+			assert.DeepEqual(t, offsets(sink.Regions()), append([]int64{}, test.passed...))
+			assert.DeepEqual(t, offsets(small.Regions()), append([]int64{}, test.routed...))
+		})
+	}
+}
+
+func TestFilter_From(t *testing.T) {
+	// given: a source that can only have a few buffers out at once, most of whose regions
+	// are dropped
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	buff := pipeio.Limit(pipeio.NewBuffer(10, 4), 40)
+	sink := pipeio.BytesSink().From(buff)
+	filter, err := pipe.Filter("off < 900 => drop")
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// when
+	err = pipe.New(pipeio.Source(bytes.NewReader(data), 0, buff), sink, filter.From(buff)).Pipe(ctx)
+
+	// then: the buffers of the regions dropped were handed back
+	assert.NilError(t, err)
+	assert.DeepEqual(t, sink.Bytes()[900:], data[900:])
+}
+
+func TestFilter_tag(t *testing.T) {
+	// given
+	f, err := pipe.Filter(`size < 512 => tag tier="cold" kind="tail"`)
+	assert.NilError(t, err)
+	sink := &pipetest.Sink{}
+	ctx := pipe.WithScope(context.Background(), pipe.Scope{"tenant": "acme"})
+	regions := []pipe.Region{{Data: make([]byte, KiB)}, {Data: make([]byte, 100), Off: KiB}}
+
+	// when
+	err = pipe.New(&pipetest.Source{Regions: regions}, sink, f).Pipe(ctx)
+
+	// then: tags add to the metadata of the regions meeting the condition, and only
+	// those, leaving the scope be
+	assert.NilError(t, err)
+	tier, kind := pipe.NewKey[string]("tier"), pipe.NewKey[string]("kind")
+	for _, r := range sink.Regions() {
+		assert.DeepEqual(t, r.Scope, pipe.Scope{"tenant": "acme"})
+		gotTier, _ := tier.Get(r)
+		gotKind, _ := kind.Get(r)
+		if r.Off == KiB {
+			assert.Equal(t, gotTier, "cold")
+			assert.Equal(t, gotKind, "tail")
+		} else {
+			assert.Equal(t, gotTier, "")
+			assert.Equal(t, gotKind, "")
+		}
+	}
+}
+
+func TestFilter_route(t *testing.T) {
+	tests := []struct {
+		name string
+		sink pipe.Sink
+		want string
+	}{
+		{
+			name: "failing sink",
+			sink: &pipetest.Sink{Check: func(pipe.Region) error { return errors.New("no room") }},
+			want: "filter: route small: no room",
+		},
+		{
+			name: "no sink",
+			want: `filter: no sink to route "small" to`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			f, err := pipe.Filter("size < 512 => route small")
+			assert.NilError(t, err)
+			if test.sink != nil {
+				f.Route("small", test.sink)
+			}
+
+			// when
+			err = pipe.New(&pipetest.Source{Regions: pipetest.Regions(8, 100)}, &pipetest.Sink{}, f).Pipe(context.Background())
+
+			// then
+			assert.ErrorContains(t, err, test.want)
+		})
+	}
+}
+
+func TestFilter_syntax(t *testing.T) {
+	tests := []struct {
+		program string
+		want    string
+	}{
+		{program: "size < 512", want: `line 1:11: expected "=>", got end of program`},
+		{program: "size => drop", want: "line 1:1: condition is a number, not a boolean"},
+		{program: "off in 0..4KB => drop", want: "line 1:11: invalid number 4KB"},
+		{program: "\n  scope.a < 3 => drop", want: "line 2:11: can't compare a string < a number"},
+		{program: "color == \"red\" => drop", want: `line 1:1: unknown field "color"`},
+		{program: "true => explode", want: `line 1:9: unknown action "explode"`},
+		{program: "true => tag a=1", want: `line 1:15: expected the value of tag a, got "1"`},
+		{program: "scope.a =~ \"(\" => drop", want: "line 1:12: invalid regexp"},
+		{program: "true => drop drop", want: `line 1:14: unexpected "drop" after rule`},
+		{program: "scope.a == \"open => drop", want: "line 1:12: unterminated string"},
+		{program: "size > 1 && scope.a => drop", want: "line 1:13: && needs booleans, got a string"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.program, func(t *testing.T) {
+			// when
+			_, err := pipe.Filter(test.program)
+
+			// then
+			var serr *pipe.SyntaxError
+			assert.Assert(t, errors.As(err, &serr), err)
+			assert.ErrorContains(t, err, test.want)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestOpenFile_Preallocate(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "file")
+
+	// when
+	f, err := pipeio.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644, pipeio.Preallocate(4*MiB))
+
+	// then: the space is allocated, but the file is still empty
+	assert.NilError(t, err)
+	defer f.Close()
+	info, err := os.Stat(path)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Size(), int64(0))
+	if blocks := info.Sys().(*syscall.Stat_t).Blocks; blocks == 0 {
+		t.Skip("no preallocation on this filesystem")
+	} else {
+		assert.Assert(t, blocks*512 >= 4*MiB)
+	}
+}
+
+// cached returns the number of pages of the file at path in the page cache
+func cached(t *testing.T, path string) int {
+	t.Helper()
+
+	f, err := os.Open(path)
+	assert.NilError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	assert.NilError(t, err)
+
+	m, err := unix.Mmap(int(f.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	assert.NilError(t, err)
+	defer unix.Munmap(m)
+
+	pages := make([]byte, (len(m)+os.Getpagesize()-1)/os.Getpagesize())
+	_, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(&m[0])), uintptr(len(m)), uintptr(unsafe.Pointer(&pages[0])))
+	assert.Assert(t, errno == 0, errno)
+
+	var n int
+	for _, p := range pages {
+		n += int(p & 1)
+	}
+	return n
+}
+
+func TestOpenFile_DropBehind(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), MiB/16)
+	write := func(path string, opts ...pipeio.FileOption) {
+		f, err := pipeio.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644, opts...)
+		assert.NilError(t, err)
+		defer f.Close()
+
+		buff := pipeio.NewBuffer(64*KiB, 4)
+		err = pipe.New(pipeio.Source(bytes.NewReader(data), 0, buff), pipeio.Pool(buff, f, f)).Pipe(context.Background())
+		assert.NilError(t, err)
+	}
+
+	// given: a file written the usual way, which stays in the cache
+	dir := t.TempDir()
+	write(filepath.Join(dir, "cached"))
+	if cached(t, filepath.Join(dir, "cached")) == 0 {
+		t.Skip("no page cache to speak of here")
+	}
+
+	// when
+	write(filepath.Join(dir, "dropped"), pipeio.DropBehind())
+
+	// then: the file was written, and left the cache behind it
+	assert.Equal(t, cached(t, filepath.Join(dir, "dropped")), 0)
+	got, err := os.ReadFile(filepath.Join(dir, "dropped"))
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, data))
+}
@@ -0,0 +1,91 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestOpenFile(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []pipeio.FileOption
+	}{
+		{name: "cached"},
+		{name: "unbuffered", opts: []pipeio.FileOption{pipeio.Unbuffered()}},
+		{name: "sparse", opts: []pipeio.FileOption{pipeio.Sparse()}},
+		{name: "unbuffered/sparse", opts: []pipeio.FileOption{pipeio.Unbuffered(), pipeio.Sparse()}},
+		{name: "preallocated", opts: []pipeio.FileOption{pipeio.Preallocate(2 * MiB)}},
+		{name: "unbuffered/preallocated", opts: []pipeio.FileOption{pipeio.Unbuffered(), pipeio.Preallocate(MiB)}},
+		{name: "sequential", opts: []pipeio.FileOption{pipeio.Sequential()}},
+		{name: "drop behind", opts: []pipeio.FileOption{pipeio.DropBehind()}},
+		{name: "unbuffered/drop behind", opts: []pipeio.FileOption{pipeio.Unbuffered(), pipeio.DropBehind()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given: aligned regions, and an unaligned tail
+			want := make([]byte, MiB+7)
+			_, _ = rand.Read(want)
+			path := filepath.Join(t.TempDir(), "file")
+			f, err := pipeio.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644, test.opts...)
+			assert.NilError(t, err)
+			defer f.Close()
+			assert.NilError(t, f.Truncate(int64(len(want))))
+
+			buff := pipeio.NewAlignedBuffer(64*KiB, 8)
+			source := pipeio.Source(bytes.NewReader(want), 0, buff)
+
+			// when: written by several writers at once
+			err = pipe.New(source, pipeio.Pool(buff, f, f, f, f)).Pipe(context.Background())
+
+			// then
+			assert.NilError(t, err)
+			assert.NilError(t, f.Sync())
+			got := make([]byte, len(want)+10)
+			n, err := f.ReadAt(got, 0)
+			assert.ErrorIs(t, err, io.EOF)
+			assert.Assert(t, bytes.Equal(got[:n], want))
+			assert.NilError(t, f.Close())
+
+			got, err = os.ReadFile(path)
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(got, want))
+		})
+	}
+}
+
+func TestOpenFile_missing(t *testing.T) {
+	// when
+	_, err := pipeio.OpenFile(filepath.Join(t.TempDir(), "missing"), os.O_RDONLY, 0, pipeio.Unbuffered())
+
+	// then
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestNewAlignedBuffer(t *testing.T) {
+	// given
+	buff := pipeio.NewAlignedBuffer(1000, 2)
+
+	for i := 0; i < 4; i++ {
+		// when
+		b := buff.Get()
+
+		// then
+		assert.Equal(t, len(b), pipeio.Alignment)
+		assert.Equal(t, uintptr(unsafe.Pointer(&b[0]))%pipeio.Alignment, uintptr(0))
+		if i%2 == 0 {
+			buff.Put(b)
+		}
+	}
+}
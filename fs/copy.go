@@ -0,0 +1,203 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+// shardsAtOnce bounds the shards of a file (or files of a pack) read at once
+const shardsAtOnce = 4
+
+// writers is the number of concurrent writers of a large file
+const writers = 4
+
+// Progress sums up a tree copy so far.
+type Progress struct {
+	Files, FilesDone int64
+	Bytes, BytesDone int64
+}
+
+type progress struct {
+	files, filesDone atomic.Int64
+	bytes, bytesDone atomic.Int64
+}
+
+func (pr *progress) snapshot() Progress {
+	return Progress{
+		Files:     pr.files.Load(),
+		FilesDone: pr.filesDone.Load(),
+		Bytes:     pr.bytes.Load(),
+		BytesDone: pr.bytesDone.Load(),
+	}
+}
+
+// Copy copies the directory tree at src to dst (creating it if need be), see NewPlan.
+func Copy(ctx context.Context, dst, src string, opts ...Option) error {
+	pl, err := NewPlan(src, opts...)
+	if err != nil {
+		return err
+	}
+	return pl.Copy(ctx, dst)
+}
+
+// Copy carries out the plan, copying the tree to dst (creating it if need be). Files
+// already at dst are overwritten. The directories are created up front, then the files
+// and packs are copied side by side, a pipe each; the errors of the pipes that failed
+// are returned joined (see pipe.Group.Wait). Metadata is carried over last, as the
+// policy set with Preserving calls for.
+func (pl *Plan) Copy(ctx context.Context, dst string) error {
+	c := pl.config
+
+	pr := &progress{}
+	pr.bytes.Store(pl.Size)
+	if c.onProgress != nil {
+		stop := pr.report(c.interval, c.onProgress)
+		defer stop()
+	}
+
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	for _, dir := range pl.Dirs {
+		if err := os.MkdirAll(filepath.Join(dst, dir), 0o755); err != nil {
+			return err
+		}
+	}
+	if c.preserve.Symlinks {
+		for _, link := range pl.Links {
+			if err := pl.link(dst, link); err != nil {
+				return err
+			}
+		}
+	}
+
+	buff := pipeio.NewBuffer(c.bufferSize, c.maxRunning*(writers+shardsAtOnce))
+	g := pipe.NewGroup(pipe.WithMaxRunning(c.maxRunning), pipe.WithGroupBuffer(c.inFlight))
+
+	var targets []*target
+	open := func(f File) (*target, error) {
+		pr.files.Add(1)
+		t := &target{path: filepath.Join(dst, f.Path), size: f.Size, mode: f.Mode, progress: pr}
+		if f.Size == 0 {
+			// nothing to write, so nothing would ever create it
+			return t, t.create()
+		}
+		targets = append(targets, t)
+		return t, nil
+	}
+
+	var err error
+	for _, f := range pl.Files {
+		t, terr := open(f)
+		if terr != nil {
+			err = terr
+			break
+		}
+
+		var shards []pipe.Source
+		for off := int64(0); off < f.Size; off += c.shardSize {
+			shards = append(shards, &fileSource{
+				path: filepath.Join(pl.Root, f.Path),
+				off:  off,
+				base: 0,
+				n:    min(c.shardSize, f.Size-off),
+				buff: buff,
+			})
+		}
+		if len(shards) == 0 {
+			continue
+		}
+		ws := make([]io.WriterAt, writers)
+		for i := range ws {
+			ws[i] = t
+		}
+		g.Go(ctx, pipe.New(fan(shards), pipeio.Pool(buff, ws...)).With(c.opts...))
+	}
+
+	for _, pack := range pl.Packs {
+		if err != nil {
+			break
+		}
+
+		var (
+			sources []pipe.Source
+			w       = &packWriter{}
+		)
+		for _, f := range pack.Files {
+			t, terr := open(f)
+			if terr != nil {
+				err = terr
+				break
+			}
+			if f.Size == 0 {
+				continue
+			}
+			sources = append(sources, &fileSource{
+				path: filepath.Join(pl.Root, f.Path),
+				base: w.size,
+				n:    f.Size,
+				buff: buff,
+			})
+			w.add(t)
+		}
+		if len(sources) > 0 {
+			g.Go(ctx, pipe.New(fan(sources), pipeio.Pool(buff, w)).With(c.opts...))
+		}
+	}
+
+	err = errors.Join(err, g.Wait())
+	for _, t := range targets {
+		err = errors.Join(err, t.close())
+	}
+	if err != nil || !c.preserve.applies() {
+		return err
+	}
+	return pl.apply(dst)
+}
+
+func fan(sources []pipe.Source) pipe.Source {
+	if len(sources) == 1 {
+		return sources[0]
+	}
+	f := pipe.Fan(sources...)
+	f.SetConcurrency(shardsAtOnce)
+	return f
+}
+
+// report calls fn with the progress every interval until stopped, and once more then
+func (pr *progress) report(interval time.Duration, fn func(Progress)) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-tick:
+				fn(pr.snapshot())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		fn(pr.snapshot())
+	}
+}
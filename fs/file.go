@@ -0,0 +1,167 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+// fileSource reads n bytes of the file at path from off, and hands them out at base+off.
+// The file is only opened once the source is started, so a tree with lots of files
+// doesn't hold them all open.
+type fileSource struct {
+	path   string
+	off, n int64
+	base   int64
+	buff   pipeio.Buffer
+}
+
+func (s *fileSource) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		close(sink)
+		errs <- err
+		return
+	}
+	defer f.Close()
+
+	r := io.NewSectionReader(f, s.off, s.n)
+	pipeio.Source(r, s.base+s.off, s.buff).Write(ctx, sink, errs)
+}
+
+// target is a file being copied to. It's opened on the first write and closed once all
+// of it has been written, for the same reason fileSource opens files late.
+type target struct {
+	path     string
+	size     int64
+	mode     fs.FileMode
+	progress *progress
+
+	mu      sync.Mutex
+	f       writeCloser
+	err     error
+	written int64
+}
+
+func (t *target) WriteAt(b []byte, off int64) (int, error) {
+	f, err := t.open()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := f.WriteAt(b, off)
+	t.progress.bytesDone.Add(int64(n))
+	if err != nil {
+		return n, err
+	}
+	return n, t.wrote(int64(n))
+}
+
+// writeCloser is an open target, see pipeio.OpenFile
+type writeCloser interface {
+	io.WriterAt
+	io.Closer
+}
+
+func (t *target) open() (writeCloser, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil && t.err == nil {
+		// written by several writers at once, which takes overlapped I/O on Windows
+		f, err := pipeio.OpenFile(t.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, t.mode.Perm())
+		if err != nil {
+			t.err = err
+			return nil, err
+		}
+		t.f = f
+	}
+	return t.f, t.err
+}
+
+// wrote accounts for n bytes written, closing the file once it's complete
+func (t *target) wrote(n int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.written += n
+	if t.written < t.size || t.f == nil {
+		return nil
+	}
+
+	err := t.f.Close()
+	t.f = nil
+	t.err = fs.ErrClosed
+	if err != nil {
+		return err
+	}
+	t.progress.filesDone.Add(1)
+	return nil
+}
+
+// create creates an empty file
+func (t *target) create() error {
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, t.mode.Perm())
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	t.progress.filesDone.Add(1)
+	return nil
+}
+
+// close closes the file if it's still open, having not been written in full
+func (t *target) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil {
+		return nil
+	}
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
+
+// packWriter writes the stream of a pack to the files in it, each at the offset where
+// the previous one ends
+type packWriter struct {
+	targets []*target
+	bases   []int64
+	size    int64
+}
+
+func (w *packWriter) add(t *target) {
+	w.targets = append(w.targets, t)
+	w.bases = append(w.bases, w.size)
+	w.size += t.size
+}
+
+func (w *packWriter) WriteAt(b []byte, off int64) (int, error) {
+	var written int
+	for len(b) > 0 {
+		// the last file starting at or before off
+		i := sort.Search(len(w.bases), func(i int) bool { return w.bases[i] > off }) - 1
+		if i < 0 || off >= w.size {
+			return written, io.ErrShortWrite
+		}
+
+		t := w.targets[i]
+		chunk := b[:min(int64(len(b)), w.bases[i]+t.size-off)]
+		n, err := t.WriteAt(chunk, off-w.bases[i])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b, off = b[n:], off+int64(n)
+	}
+	return written, nil
+}
@@ -0,0 +1,158 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Preserve is the metadata of a tree carried over to its copy, on top of the data (see
+// Preserving). Metadata is applied once all the data has been copied, directories last,
+// so that writing to them doesn't undo it.
+type Preserve struct {
+	// Mode has the permission bits (setuid, setgid and sticky included) carried over,
+	// rather than the permission bits less the umask.
+	Mode bool
+	// Owner has the user and group carried over, which takes privileges. There's no
+	// such thing on Windows.
+	Owner bool
+	// Times has the modification times carried over.
+	Times bool
+	// Symlinks has symlinks copied as symlinks, rather than skipped.
+	Symlinks bool
+	// Xattrs has the extended attributes carried over. There's only such a thing on
+	// Linux and macOS.
+	Xattrs bool
+
+	// IgnoreUnsupported skips the metadata the destination can't hold (extended
+	// attributes on a filesystem that has none, say), rather than failing the copy.
+	IgnoreUnsupported bool
+}
+
+// PreserveAll carries over all the metadata there is.
+var PreserveAll = Preserve{Mode: true, Owner: true, Times: true, Symlinks: true, Xattrs: true}
+
+// Preserving has the copy carry over the metadata of the tree the policy calls for;
+// none of it is by default, and symlinks are skipped.
+func Preserving(p Preserve) Option {
+	return func(c *config) {
+		c.preserve = p
+	}
+}
+
+// MetadataError is returned when the metadata of the tree can't be carried over to the
+// copy. Err is errors.ErrUnsupported if the destination (or the platform) has no such
+// thing, see Preserve.IgnoreUnsupported.
+type MetadataError struct {
+	// Path is the path of the copy.
+	Path string
+	// Attr is the metadata that couldn't be carried over: "mode", "owner", "times",
+	// "symlink" or "xattr".
+	Attr string
+	Err  error
+}
+
+func (e *MetadataError) Error() string {
+	return fmt.Sprintf("can't preserve %s of %s: %v", e.Attr, e.Path, e.Err)
+}
+
+func (e *MetadataError) Unwrap() error {
+	return e.Err
+}
+
+// link copies the symlink at path of the tree to dst
+func (pl *Plan) link(dst, path string) error {
+	target, err := os.Readlink(filepath.Join(pl.Root, path))
+	if err != nil {
+		return err
+	}
+
+	to := filepath.Join(dst, path)
+	if err := os.Symlink(target, to); err != nil {
+		return pl.config.preserve.fail(to, "symlink", err)
+	}
+	return nil
+}
+
+// apply carries over the metadata of every entry of the tree to its copy at dst
+func (pl *Plan) apply(dst string) error {
+	var paths []string
+	for _, f := range pl.Files {
+		paths = append(paths, f.Path)
+	}
+	for _, pack := range pl.Packs {
+		for _, f := range pack.Files {
+			paths = append(paths, f.Path)
+		}
+	}
+	if pl.config.preserve.Symlinks {
+		paths = append(paths, pl.Links...)
+	}
+	// children before their parents, the root last
+	dirs := slices.Clone(pl.Dirs)
+	slices.Reverse(dirs)
+	paths = append(append(paths, dirs...), ".")
+
+	for _, path := range paths {
+		if err := pl.config.preserve.apply(filepath.Join(dst, path), filepath.Join(pl.Root, path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applies tells whether there's any metadata to apply once the data is copied
+func (p Preserve) applies() bool {
+	return p.Mode || p.Owner || p.Times || p.Xattrs
+}
+
+// apply carries over the metadata of the entry at src to its copy at dst
+func (p Preserve) apply(dst, src string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	link := info.Mode()&fs.ModeSymlink != 0
+
+	// the owner goes first, since changing it may clear the setuid and setgid bits
+	if p.Owner {
+		if err := chown(dst, info); err != nil {
+			return p.fail(dst, "owner", err)
+		}
+	}
+	if p.Mode && !link {
+		mode := info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+		if err := os.Chmod(dst, mode); err != nil {
+			return p.fail(dst, "mode", err)
+		}
+	}
+	if p.Xattrs {
+		if err := copyXattrs(dst, src); err != nil {
+			return p.fail(dst, "xattr", err)
+		}
+	}
+	// the times go last, since carrying over anything else may change them
+	if p.Times && !link {
+		if err := os.Chtimes(dst, time.Time{}, info.ModTime()); err != nil {
+			return p.fail(dst, "times", err)
+		}
+	}
+	return nil
+}
+
+// fail returns the error carrying over attr to path failed with, nil if it's not to be
+// reported
+func (p Preserve) fail(path, attr string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	if p.IgnoreUnsupported && errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	return &MetadataError{Path: path, Attr: attr, Err: err}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package fs
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// no owners here
+func chown(_ string, _ fs.FileInfo) error {
+	return errors.ErrUnsupported
+}
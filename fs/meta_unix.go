@@ -0,0 +1,18 @@
+//go:build unix
+
+package fs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+func chown(path string, info fs.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return os.Lchown(path, int(st.Uid), int(st.Gid))
+}
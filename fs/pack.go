@@ -0,0 +1,231 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+// PackIndex tells where the files packed by Plan.PackInto are in the pack objects.
+type PackIndex struct {
+	Packs   []PackObject `json:"packs"`
+	Entries []PackEntry  `json:"entries"`
+}
+
+// PackObject is a pack written by Plan.PackInto.
+type PackObject struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// PackEntry is where a file is in a pack: its data is the Len bytes at offset Off of
+// the pack.
+type PackEntry struct {
+	Path string      `json:"path"`
+	Pack string      `json:"pack"`
+	Off  int64       `json:"off"`
+	Len  int64       `json:"len"`
+	Mode fs.FileMode `json:"mode"`
+}
+
+// ReadPackIndex decodes a PackIndex previously stored with PackIndex.WriteTo.
+func ReadPackIndex(r io.Reader) (*PackIndex, error) {
+	var x PackIndex
+	if err := json.NewDecoder(r).Decode(&x); err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
+
+// WriteTo stores the index (as JSON) so it can be loaded by ReadPackIndex.
+func (x *PackIndex) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.Marshal(x)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Lookup returns the entry of the file at path (relative to the root of the tree).
+func (x *PackIndex) Lookup(path string) (PackEntry, bool) {
+	for _, e := range x.Entries {
+		if e.Path == path {
+			return e, true
+		}
+	}
+	return PackEntry{}, false
+}
+
+// PackName returns the name Plan.PackInto gives to the i-th pack.
+func PackName(i int) string {
+	return fmt.Sprintf("pack-%06d", i)
+}
+
+// PackInto packs the small files of the tree into pack objects, rather than copying
+// them one by one: object stores that handle a few large objects well choke on millions
+// of tiny ones. Every pack of the plan (see PackSize) makes for one object, its files
+// one after the other with nothing in between; the returned index records where each
+// file landed. Files larger than SmallFiles are left out, to be copied on their own
+// (raising SmallFiles packs everything).
+//
+// open is called for every pack with its name (see PackName) and size, and returns the
+// sink the pack is written to (pipeio.StreamSink feeding an upload, say), which can put
+// the data of the regions it's done with back into buff. Packs are streamed in order, so
+// sinks that can only be written sequentially are fine. The packs are written side by
+// side, as jobs of a pipe.Group bound by MaxRunning and InFlight; if any of them fails
+// the errors are returned joined, and no index.
+func (pl *Plan) PackInto(ctx context.Context, open func(name string, size int64, buff pipeio.Buffer) (pipe.Sink, error)) (*PackIndex, error) {
+	c := pl.config
+
+	pr := &progress{}
+	for _, pack := range pl.Packs {
+		pr.files.Add(int64(len(pack.Files)))
+		pr.bytes.Add(pack.Size)
+	}
+	if c.onProgress != nil {
+		stop := pr.report(c.interval, c.onProgress)
+		defer stop()
+	}
+
+	buff := pipeio.NewBuffer(c.bufferSize, c.maxRunning*shardsAtOnce)
+	g := pipe.NewGroup(pipe.WithMaxRunning(c.maxRunning), pipe.WithGroupBuffer(c.inFlight))
+
+	x := &PackIndex{Packs: []PackObject{}, Entries: []PackEntry{}}
+	var err error
+	for i, pack := range pl.Packs {
+		name := PackName(i)
+		sink, serr := open(name, pack.Size, buff)
+		if serr != nil {
+			err = fmt.Errorf("error opening pack %s: %w", name, serr)
+			break
+		}
+		x.Packs = append(x.Packs, PackObject{Name: name, Size: pack.Size})
+
+		var (
+			sources concat
+			ends    []int64 // where every file of the pack ends
+			off     int64
+		)
+		for _, f := range pack.Files {
+			x.Entries = append(x.Entries, PackEntry{Path: f.Path, Pack: name, Off: off, Len: f.Size, Mode: f.Mode})
+			if f.Size > 0 {
+				sources = append(sources, &fileSource{
+					path: filepath.Join(pl.Root, f.Path),
+					base: off,
+					n:    f.Size,
+					buff: buff,
+				})
+			}
+			off += f.Size
+			ends = append(ends, off)
+		}
+
+		if pack.Size == 0 {
+			// no region will ever account for them
+			pr.filesDone.Add(int64(len(pack.Files)))
+		}
+		g.Go(ctx, pipe.New(sources, sink, packProgress(pr, ends)).With(c.opts...))
+	}
+
+	if err = errors.Join(err, g.Wait()); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// PackTo packs the small files of the tree into files in the directory dir (created if
+// need be), named after their packs, and stores the index there too, as index.json. See
+// PackInto.
+func (pl *Plan) PackTo(ctx context.Context, dir string) (*PackIndex, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	x, err := pl.PackInto(ctx, func(name string, size int64, buff pipeio.Buffer) (pipe.Sink, error) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return pipeio.StreamSink(f, 0, buff), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+	}
+	files = nil
+
+	f, err := os.Create(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := x.WriteTo(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return x, f.Close()
+}
+
+// concat is a Source reading sources one after the other, so the stream comes in order
+type concat []pipe.Source
+
+func (s concat) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	for _, source := range s {
+		c := make(chan pipe.Region)
+		go source.Write(ctx, c, errs)
+		for {
+			r, more := pipe.Next(ctx, c)
+			if !more {
+				break
+			}
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// packProgress counts the files and bytes of a pack handed to its sink; regions come in
+// order, so the files done are those ending before the last region does
+func packProgress(pr *progress, ends []int64) pipe.Func {
+	var done int
+	return func(r pipe.Region) (pipe.Region, error) {
+		end := r.Off + int64(len(r.Data))
+		n := sort.Search(len(ends), func(i int) bool { return ends[i] > end })
+		if n > done {
+			pr.filesDone.Add(int64(n - done))
+			done = n
+		}
+		pr.bytesDone.Add(int64(len(r.Data)))
+		return r, nil
+	}
+}
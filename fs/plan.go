@@ -0,0 +1,208 @@
+// Package fs copies directory trees with pipes. A tree is planned first (see NewPlan):
+// large files are copied one pipe each, read in shards and written by several writers,
+// while small files are batched into packs - a pipe per pack, so millions of tiny files
+// don't cost a pipe each. The pipes then run side by side as jobs of a pipe.Group,
+// sharing a bound on how many run at once and on the bytes they have in flight, with
+// the progress of the whole tree reported as they go. Small files can also be packed
+// into a few large objects rather than copied (see Plan.PackInto).
+//
+//	err := pipefs.Copy(ctx, "/backup/home", "/home", pipefs.OnProgress(time.Second, show))
+package fs
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Option configures the planning and execution of a tree copy.
+type Option func(*config)
+
+type config struct {
+	smallFiles int64
+	packSize   int64
+	shardSize  int64
+	maxRunning int
+	bufferSize int
+	inFlight   int64
+
+	interval   time.Duration
+	onProgress func(Progress)
+	preserve   Preserve
+
+	opts []pipe.Option
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		smallFiles: pipe.MiB,
+		packSize:   64 * pipe.MiB,
+		shardSize:  64 * pipe.MiB,
+		maxRunning: 4,
+		bufferSize: pipe.MiB,
+		inFlight:   256 * pipe.MiB,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SmallFiles sets the size up to which files are batched into packs (1MiB by default);
+// n <= 0 has every file copied on its own.
+func SmallFiles(n int64) Option {
+	return func(c *config) {
+		c.smallFiles = n
+	}
+}
+
+// PackSize sets the number of bytes of small files batched into a single pack (64MiB by
+// default).
+func PackSize(n int64) Option {
+	return func(c *config) {
+		c.packSize = max(n, 1)
+	}
+}
+
+// ShardSize sets the size of the shards large files are read in (64MiB by default).
+func ShardSize(n int64) Option {
+	return func(c *config) {
+		c.shardSize = max(n, 1)
+	}
+}
+
+// MaxRunning bounds the number of pipes (files or packs) copying at once (4 by default).
+func MaxRunning(n int) Option {
+	return func(c *config) {
+		c.maxRunning = max(n, 1)
+	}
+}
+
+// BufferSize sets the size of the regions read from files (1MiB by default).
+func BufferSize(n int) Option {
+	return func(c *config) {
+		c.bufferSize = n
+	}
+}
+
+// InFlight bounds the bytes held in regions by all pipes combined (256MiB by default),
+// see pipe.WithGroupBuffer.
+func InFlight(n int64) Option {
+	return func(c *config) {
+		c.inFlight = n
+	}
+}
+
+// OnProgress has fn called with the progress of the copy every interval, and once more
+// when it's over.
+func OnProgress(interval time.Duration, fn func(Progress)) Option {
+	return func(c *config) {
+		c.interval = interval
+		c.onProgress = fn
+	}
+}
+
+// PipeOptions applies the options to every pipe of the copy.
+func PipeOptions(opts ...pipe.Option) Option {
+	return func(c *config) {
+		c.opts = append(c.opts, opts...)
+	}
+}
+
+// Plan is how a directory tree is to be copied. Paths are relative to the root of the
+// tree, and use the separator of the platform.
+type Plan struct {
+	// Root is the root of the tree.
+	Root string
+	// Dirs are the directories of the tree, parents first.
+	Dirs []string
+	// Files are the files copied on their own, in shards.
+	Files []File
+	// Packs are the small files, batched together.
+	Packs []Pack
+	// Links are the symlinks of the tree, only copied if they're to be preserved (see
+	// Preserve).
+	Links []string
+	// Skipped are the entries that aren't copied: devices, sockets and the like.
+	Skipped []string
+	// Size is the number of bytes of all the files of the tree.
+	Size int64
+
+	config *config
+}
+
+// File is a regular file of a tree.
+type File struct {
+	Path string
+	Size int64
+	Mode fs.FileMode
+}
+
+// Pack is a batch of small files copied by a single pipe, as if they were one stream:
+// each file is at the offset where the previous one ends.
+type Pack struct {
+	Files []File
+	Size  int64
+}
+
+// maxPackFiles bounds the number of files in a pack, however small they are
+const maxPackFiles = 1024
+
+// NewPlan walks the tree at root and plans how to copy it.
+func NewPlan(root string, opts ...Option) (*Plan, error) {
+	pl := &Plan{Root: root, config: newConfig(opts)}
+
+	var pack Pack
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if rel != "." {
+				pl.Dirs = append(pl.Dirs, rel)
+			}
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			pl.Links = append(pl.Links, rel)
+			return nil
+		case !d.Type().IsRegular():
+			pl.Skipped = append(pl.Skipped, rel)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := File{Path: rel, Size: info.Size(), Mode: info.Mode()}
+		pl.Size += f.Size
+
+		if f.Size > pl.config.smallFiles {
+			pl.Files = append(pl.Files, f)
+			return nil
+		}
+		if len(pack.Files) > 0 && (pack.Size+f.Size > pl.config.packSize || len(pack.Files) == maxPackFiles) {
+			pl.Packs = append(pl.Packs, pack)
+			pack = Pack{}
+		}
+		pack.Files = append(pack.Files, f)
+		pack.Size += f.Size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pack.Files) > 0 {
+		pl.Packs = append(pl.Packs, pack)
+	}
+
+	return pl, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package fs
+
+import "errors"
+
+// no extended attributes here (that this knows of)
+func copyXattrs(_, _ string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,56 @@
+//go:build linux || darwin
+
+package fs
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies the extended attributes of src to dst, neither of which is followed
+// if it's a symlink
+func copyXattrs(dst, src string) error {
+	names, err := xattr(func(b []byte) (int, error) { return unix.Llistxattr(src, b) })
+	if errors.Is(err, errors.ErrUnsupported) {
+		// no such thing where the tree is, so there's nothing to carry over
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := xattr(func(b []byte) (int, error) { return unix.Lgetxattr(src, string(name), b) })
+		if err != nil {
+			return err
+		}
+		if err := unix.Lsetxattr(dst, string(name), value, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xattr calls get with a buffer large enough for what it gets, which may be growing
+// meanwhile
+func xattr(get func(b []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := get(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		b := make([]byte, size)
+		n, err := get(b)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
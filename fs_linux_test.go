@@ -0,0 +1,36 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	"gotest.tools/v3/assert"
+
+	pipefs "github.com/naylorpmax-joyent/pipe/fs"
+)
+
+func TestCopy_Preserving_xattrs(t *testing.T) {
+	// given
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "dst")
+	path := filepath.Join(src, "file")
+	assert.NilError(t, os.WriteFile(path, []byte("hello"), 0o644))
+	if err := unix.Setxattr(path, "user.pipe", []byte("value"), 0); errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("no extended attributes here")
+	} else {
+		assert.NilError(t, err)
+	}
+
+	// when
+	err := pipefs.Copy(context.Background(), dst, src, pipefs.Preserving(pipefs.Preserve{Xattrs: true}))
+
+	// then
+	assert.NilError(t, err)
+	value := make([]byte, 16)
+	n, err := unix.Getxattr(filepath.Join(dst, "file"), "user.pipe", value)
+	assert.NilError(t, err)
+	assert.Equal(t, string(value[:n]), "value")
+}
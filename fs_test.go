@@ -0,0 +1,198 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	pipefs "github.com/naylorpmax-joyent/pipe/fs"
+)
+
+// tree creates files of the given sizes under root, returning their contents
+func tree(t *testing.T, root string, sizes map[string]int) map[string][]byte {
+	t.Helper()
+
+	files := map[string][]byte{}
+	for path, size := range sizes {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+		path = filepath.FromSlash(path)
+		assert.NilError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0o755))
+		assert.NilError(t, os.WriteFile(filepath.Join(root, path), data, 0o644))
+		files[path] = data
+	}
+	return files
+}
+
+func TestNewPlan(t *testing.T) {
+	// given
+	root := t.TempDir()
+	tree(t, root, map[string]int{
+		"big":      10 * KiB,
+		"a/one":    300,
+		"a/two":    300,
+		"a/three":  300,
+		"a/b/four": 300,
+		"empty":    0,
+	})
+
+	if runtime.GOOS != "windows" {
+		assert.NilError(t, os.Symlink("big", filepath.Join(root, "link")))
+	}
+
+	// when
+	pl, err := pipefs.NewPlan(root, pipefs.SmallFiles(KiB), pipefs.PackSize(700))
+
+	// then: the big file goes on its own, the small ones in packs of up to 700 bytes
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pl.Dirs, []string{"a", filepath.Join("a", "b")})
+	assert.Equal(t, len(pl.Files), 1)
+	assert.Equal(t, pl.Files[0].Path, "big")
+	assert.Equal(t, pl.Size, int64(10*KiB+4*300))
+
+	var packed int
+	for _, pack := range pl.Packs {
+		assert.Assert(t, pack.Size <= 700)
+		packed += len(pack.Files)
+	}
+	assert.Equal(t, packed, 5)
+	assert.Equal(t, len(pl.Packs), 2)
+
+	// and: symlinks are planned apart
+	if runtime.GOOS != "windows" {
+		assert.DeepEqual(t, pl.Links, []string{"link"})
+	}
+}
+
+func TestCopy(t *testing.T) {
+	// given: a tree of large, small and empty files
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "dst")
+	files := tree(t, src, map[string]int{
+		"big":           100*KiB + 7,
+		"a/bigger":      300 * KiB,
+		"a/small":       700,
+		"a/b/smaller":   3,
+		"a/b/c/empty":   0,
+		"d/small":       KiB,
+		"d/e/small":     900,
+		"d/e/f/g/small": 1,
+	})
+	if runtime.GOOS != "windows" {
+		assert.NilError(t, os.Symlink("big", filepath.Join(src, "link")))
+	}
+
+	var mu sync.Mutex
+	var last pipefs.Progress
+	opts := []pipefs.Option{
+		pipefs.SmallFiles(KiB),
+		pipefs.PackSize(2 * KiB),
+		pipefs.ShardSize(32 * KiB),
+		pipefs.BufferSize(4 * KiB),
+		pipefs.InFlight(64 * KiB),
+		pipefs.OnProgress(0, func(p pipefs.Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			last = p
+		}),
+	}
+
+	// when
+	err := pipefs.Copy(context.Background(), dst, src, opts...)
+
+	// then: every file made it, and the progress adds up
+	assert.NilError(t, err)
+	for path, want := range files {
+		got, err := os.ReadFile(filepath.Join(dst, path))
+		assert.NilError(t, err, path)
+		assert.Assert(t, bytes.Equal(got, want), path)
+	}
+	_, err = os.Lstat(filepath.Join(dst, "link"))
+	assert.Assert(t, os.IsNotExist(err))
+
+	var size int64
+	for _, data := range files {
+		size += int64(len(data))
+	}
+	assert.DeepEqual(t, last, pipefs.Progress{
+		Files: int64(len(files)), FilesDone: int64(len(files)),
+		Bytes: size, BytesDone: size,
+	})
+}
+
+func TestCopy_missing(t *testing.T) {
+	// given: a plan whose files have gone since
+	src := t.TempDir()
+	tree(t, src, map[string]int{"big": 10 * KiB, "small": 10})
+	pl, err := pipefs.NewPlan(src, pipefs.SmallFiles(KiB))
+	assert.NilError(t, err)
+	assert.NilError(t, os.Remove(filepath.Join(src, "big")))
+	assert.NilError(t, os.Remove(filepath.Join(src, "small")))
+
+	// when
+	err = pl.Copy(context.Background(), t.TempDir())
+
+	// then
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCopy_Preserving(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission bits, owners or symlinks to speak of")
+	}
+
+	// given: a tree with permissions, times and a symlink of its own
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "dst")
+	tree(t, src, map[string]int{"a/big": 10 * KiB, "a/small": 10})
+	mtime := time.Date(2020, 2, 2, 2, 2, 2, 0, time.UTC)
+	for path, mode := range map[string]os.FileMode{"a/big": 0o600, "a/small": 0o751 | os.ModeSetuid, "a": 0o750} {
+		path = filepath.Join(src, filepath.FromSlash(path))
+		assert.NilError(t, os.Chmod(path, mode))
+		assert.NilError(t, os.Chtimes(path, mtime, mtime))
+	}
+	assert.NilError(t, os.Symlink("a/big", filepath.Join(src, "link")))
+
+	// when
+	err := pipefs.Copy(context.Background(), dst, src, pipefs.SmallFiles(KiB), pipefs.Preserving(pipefs.PreserveAll))
+
+	// then
+	assert.NilError(t, err)
+	for path, mode := range map[string]os.FileMode{"a/big": 0o600, "a/small": 0o751 | os.ModeSetuid, "a": 0o750 | os.ModeDir} {
+		info, err := os.Stat(filepath.Join(dst, filepath.FromSlash(path)))
+		assert.NilError(t, err)
+		assert.Equal(t, info.Mode(), mode, path)
+		assert.Assert(t, info.ModTime().Equal(mtime), path)
+	}
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	assert.NilError(t, err)
+	assert.Equal(t, target, "a/big")
+}
+
+func TestCopy_Preserving_error(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no symlinks to speak of")
+	}
+
+	// given: a symlink whose copy is in the way
+	src, dst := t.TempDir(), t.TempDir()
+	assert.NilError(t, os.Symlink("nowhere", filepath.Join(src, "link")))
+	assert.NilError(t, os.WriteFile(filepath.Join(dst, "link"), nil, 0o644))
+
+	// when
+	err := pipefs.Copy(context.Background(), dst, src, pipefs.Preserving(pipefs.Preserve{Symlinks: true}))
+
+	// then
+	var me *pipefs.MetadataError
+	assert.Assert(t, errors.As(err, &me))
+	assert.Equal(t, me.Attr, "symlink")
+	assert.Equal(t, me.Path, filepath.Join(dst, "link"))
+	assert.ErrorIs(t, err, os.ErrExist)
+}
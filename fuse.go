@@ -0,0 +1,108 @@
+package pipe
+
+import (
+	"context"
+	"slices"
+)
+
+// Func is a Valve made from a function that's applied to every region passing through,
+// for simple transforms that don't need to manage their own channels.
+type Func func(r Region) (Region, error)
+
+func (f Func) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+
+		yield := yielder(ctx)
+		for {
+			in, more := Next(ctx, source)
+			if !more {
+				break
+			}
+
+			r, err := f(in)
+			if err != nil {
+				if divert(ctx, in, err) {
+					continue
+				}
+				errs <- err
+				break
+			}
+
+			yield(r)
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// Fuse composes the funcs into a single Func that applies them in sequence, all from the
+// same goroutine. Every valve in a chain costs a channel handoff (and a couple of context
+// switches) per region, which adds up with many valves and small regions.
+func Fuse(fns ...Func) Func {
+	return func(r Region) (Region, error) {
+		var err error
+		for _, f := range fns {
+			if r, err = f(r); err != nil {
+				return r, err
+			}
+		}
+		return r, nil
+	}
+}
+
+// WithFusion has the pipe Fuse every run of consecutive Func valves into one.
+func WithFusion() Option {
+	return func(p *Pipe) {
+		p.fuse = true
+	}
+}
+
+// fused returns the valves of the pipe, with consecutive Funcs fused if requested (and
+// the digest last, if expected)
+func (p *Pipe) fused() []Valve {
+	if !p.fuse {
+		return p.digested(p.valves)
+	}
+
+	valves := make([]Valve, 0, len(p.valves))
+	var run []Func
+	flush := func() {
+		switch len(run) {
+		case 0:
+		case 1:
+			valves = append(valves, run[0])
+		default:
+			valves = append(valves, Fuse(run...))
+		}
+		run = nil
+	}
+
+	for _, v := range p.valves {
+		if f, ok := v.(Func); ok {
+			run = append(run, f)
+			continue
+		}
+		flush()
+		valves = append(valves, v)
+	}
+	flush()
+
+	return p.digested(valves)
+}
+
+// digested appends the valve computing the digest of the stream to valves, if the pipe
+// expects one (see WithExpectedDigest)
+func (p *Pipe) digested(valves []Valve) []Valve {
+	if p.digest == nil {
+		return valves
+	}
+	return append(slices.Clip(valves), p.digest)
+}
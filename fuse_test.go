@@ -0,0 +1,83 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+func TestPipe_WithFusion(t *testing.T) {
+	lower := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		r.Data = bytes.ToLower(r.Data)
+		return r, nil
+	})
+	shift := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		r.Off += 1000
+		return r, nil
+	})
+	fail := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		if r.Off == 1090 {
+			return r, errors.New("welp")
+		}
+		return r, nil
+	})
+
+	for _, fused := range []bool{false, true} {
+		name := "unfused"
+		if fused {
+			name = "fused"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			t.Run("success", func(t *testing.T) {
+				// given
+				read := make([]pipe.Region, 0)
+				sinkFunc := func(r pipe.Region) error {
+					read = append(read, r)
+					return nil
+				}
+				var firsts string
+				valveFunc := func(r pipe.Region) error {
+					firsts += string(r.Data[0])
+					return nil
+				}
+
+				p := pipe.New(&source{regions: regions}, &sink{f: sinkFunc},
+					lower, shift, &noopValve{f: valveFunc}, lower,
+				)
+				if fused {
+					p = p.With(pipe.WithFusion())
+				}
+
+				// when
+				assert.NilError(t, p.Pipe(context.Background()))
+
+				// then
+				assert.Equal(t, firsts, "abj")
+				assert.DeepEqual(t, read, []pipe.Region{
+					{Off: 1000, Data: []byte("aaaaaaaaaa")},
+					{Off: 1010, Data: []byte("bbbbbbbbbb")},
+					{Off: 1090, Data: []byte("jjjjjjjjjj")},
+				})
+			})
+
+			t.Run("error", func(t *testing.T) {
+				// given
+				p := pipe.New(&source{regions: regions}, &sink{f: func(pipe.Region) error { return nil }},
+					lower, shift, fail,
+				)
+				if fused {
+					p = p.With(pipe.WithFusion())
+				}
+
+				// when / then
+				assert.ErrorContains(t, p.Pipe(context.Background()), "welp")
+			})
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package pipe_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func FuzzFunc(f *testing.F) {
+	pipetest.FuzzValve(f, func(*testing.T) pipe.Valve {
+		return pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+	}, pipetest.SameRegions)
+}
+
+func FuzzFan(f *testing.F) {
+	pipetest.FuzzSource(f, func(_ *testing.T, data []byte) pipe.Source {
+		half := len(data) / 2
+		return pipe.Fan(
+			&pipetest.Source{Regions: []pipe.Region{{Data: data[:half], Off: 0}}},
+			&pipetest.Source{Regions: []pipe.Region{{Data: data[half:], Off: int64(half)}}},
+		)
+	})
+}
+
+func FuzzSource(f *testing.F) {
+	pipetest.FuzzSource(f, func(_ *testing.T, data []byte) pipe.Source {
+		return pipeio.Source(bytes.NewReader(data), 0, pipeio.NewBuffer(64, 4), pipeio.ReadAhead(2))
+	})
+}
+
+func FuzzBytesSource(f *testing.F) {
+	pipetest.FuzzSource(f, func(_ *testing.T, data []byte) pipe.Source {
+		return pipeio.BytesSource(data)
+	})
+}
+
+func FuzzSink(f *testing.F) {
+	var w interface{ Bytes() []byte }
+	pipetest.FuzzSink(f, func(_ *testing.T, buff pipeio.Buffer) pipe.Sink {
+		dst := pipeio.BytesSink()
+		w = dst
+		return pipeio.Sink(dst, buff)
+	}, func(*testing.T) []byte {
+		return w.Bytes()
+	})
+}
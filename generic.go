@@ -0,0 +1,99 @@
+package pipe
+
+import "context"
+
+// SourceOf is a Source of values of type T rather than regions: records, events, structs
+// of any kind, streamed with the same plumbing as regions (see PipeOf). Source is the
+// SourceOf regions.
+type SourceOf[T any] interface {
+	Write(ctx context.Context, sink chan T, errs chan error)
+}
+
+// SinkOf is a Sink of values of type T (see SourceOf). Sink is the SinkOf regions.
+type SinkOf[T any] interface {
+	Read(ctx context.Context, source <-chan T, errs chan<- error)
+}
+
+// ValveOf is a Valve of values of type T (see SourceOf). Valve is the ValveOf regions.
+type ValveOf[T any] interface {
+	// Open is a non-blocking method that returns the channel off of which the Valve
+	// will read values from.
+	Open(ctx context.Context, sink chan T, errs chan error) (source chan T)
+}
+
+// FuncOf is a ValveOf values of type T made from a function that's applied to every
+// value passing through, as Func is for regions.
+type FuncOf[T any] func(v T) (T, error)
+
+func (f FuncOf[T]) Open(ctx context.Context, sink chan T, errs chan error) chan T {
+	source := make(chan T)
+	go func() {
+		defer close(sink)
+
+		for {
+			v, more := Next(ctx, source)
+			if !more {
+				break
+			}
+
+			v, err := f(v)
+			if err != nil {
+				errs <- err
+				break
+			}
+
+			select {
+			case sink <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// NewOf constructs a pipe streaming values of type T from a source to a sink, through a
+// sequence of valves, connected the way New connects the components of a Pipe.
+//
+// Only the plumbing is generic: what a Pipe does with the regions going through it
+// (reports, shutdown, pausing, batches and rings, scheduling, chaos, digests...) takes
+// regions, and isn't available to pipes of other values.
+func NewOf[T any](source SourceOf[T], sink SinkOf[T], valves ...ValveOf[T]) *PipeOf[T] {
+	return &PipeOf[T]{source: source, sink: sink, valves: valves}
+}
+
+// PipeOf is a pipe of values of type T (see NewOf).
+type PipeOf[T any] struct {
+	source SourceOf[T]
+	sink   SinkOf[T]
+	valves []ValveOf[T]
+}
+
+// Pipe runs the pipe until the sink is done, a component fails (the error it failed with
+// is returned) or ctx is done, as Pipe.Pipe does.
+func (p *PipeOf[T]) Pipe(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// room for a result from every component, so none of them get stuck reporting theirs
+	// once the run is over
+	done := make(chan error, len(p.valves)+2)
+
+	last := make(chan T)
+	first := last
+	for back := len(p.valves) - 1; back >= 0; back-- {
+		first = p.valves[back].Open(ctx, first, done)
+	}
+	go p.source.Write(ctx, first, done)
+	go p.sink.Read(ctx, last, done)
+
+	select {
+	case err := <-done:
+		if ctx.Err() == nil {
+			return err
+		}
+	case <-ctx.Done():
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,103 @@
+package pipe_test
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+type event struct {
+	ID   int
+	Kind string
+}
+
+func TestPipeOf(t *testing.T) {
+	boom := errors.New("boom")
+	upper := pipe.FuncOf[event](func(e event) (event, error) {
+		if e.Kind == "bad" {
+			return e, boom
+		}
+		e.Kind = "seen " + e.Kind
+		return e, nil
+	})
+
+	tests := map[string]struct {
+		sources  [][]event
+		expected []event
+		err      error
+	}{
+		"one source": {
+			sources:  [][]event{{{1, "click"}, {2, "view"}}},
+			expected: []event{{1, "seen click"}, {2, "seen view"}},
+		},
+		"fan": {
+			sources:  [][]event{{{1, "click"}, {3, "view"}}, {{2, "scroll"}}},
+			expected: []event{{1, "seen click"}, {2, "seen scroll"}, {3, "seen view"}},
+		},
+		"failed": {
+			sources: [][]event{{{1, "click"}, {2, "bad"}, {3, "view"}}},
+			err:     boom,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			sources := make([]pipe.SourceOf[event], len(test.sources))
+			for i, events := range test.sources {
+				sources[i] = &sliceSource[event]{values: events}
+			}
+			sink := &sliceSink[event]{}
+			p := pipe.NewOf[event](pipe.FanOf(sources...), sink, upper)
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+			assert.NilError(t, err)
+			got := slices.SortedFunc(slices.Values(sink.values), func(a, b event) int { return cmp.Compare(a.ID, b.ID) })
+			assert.DeepEqual(t, got, test.expected)
+		})
+	}
+}
+
+type sliceSource[T any] struct {
+	values []T
+}
+
+func (s *sliceSource[T]) Write(ctx context.Context, sink chan T, errs chan error) {
+	defer close(sink)
+
+	for _, v := range s.values {
+		select {
+		case sink <- v:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type sliceSink[T any] struct {
+	values []T
+}
+
+func (s *sliceSink[T]) Read(ctx context.Context, source <-chan T, errs chan<- error) {
+	for {
+		v, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+		s.values = append(s.values, v)
+	}
+	errs <- ctx.Err()
+}
@@ -4,6 +4,9 @@ go 1.24.0
 
 toolchain go1.24.1
 
-require gotest.tools/v3 v3.5.2
+require (
+	golang.org/x/sys v0.30.0
+	gotest.tools/v3 v3.5.2
+)
 
 require github.com/google/go-cmp v0.7.0 // indirect
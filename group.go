@@ -0,0 +1,354 @@
+package pipe
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Group runs many pipes (jobs) side by side, sharing resources between them: a bound on
+// how many jobs run at once (the rest queue up), a bound on the worker goroutines of all
+// jobs combined (see WithMaxWorkers), a rate limit for all of them combined (see
+// Throttle) and a budget for the bytes they have in flight. Jobs are held back at the
+// point their regions enter the pipe, so none of that changes the pipes themselves: they
+// keep their valves and options, and run the same way outside of a group. They never
+// take a Shortcut though, since that would bypass the group altogether.
+//
+// Jobs can be given priorities (see Priority): queued jobs start in order of priority,
+// and a job that can't start because the group is full preempts the lowest priority job
+// running, if that's lower than its own. Preempted jobs are paused - they stop taking in
+// regions from their source, and sit idle once their regions in flight have landed -
+// until there's room for them again.
+//
+// A Group is the building block for a transfer service queueing up lots of jobs:
+//
+//	g := pipe.NewGroup(pipe.WithMaxRunning(8), pipe.WithGroupRate(schedule))
+//	for _, job := range jobs {
+//		g.Go(ctx, pipe.New(job.source, job.sink))
+//	}
+//	err := g.Wait()
+//
+// Cancel stops every job of the group at once, and Stats sums up what they've done so
+// far, those still running included.
+func NewGroup(opts ...GroupOption) *Group {
+	g := &Group{}
+	g.ctx, g.cancel = context.WithCancelCause(context.Background())
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// GroupOption configures a Group.
+type GroupOption func(*Group)
+
+// WithMaxRunning bounds the number of jobs of the group running at once.
+func WithMaxRunning(n int) GroupOption {
+	return func(g *Group) {
+		g.maxRunning = n
+	}
+}
+
+// WithGroupWorkers bounds the number of worker goroutines across all jobs of the group,
+// like WithMaxWorkers does for a single pipe (and in its place).
+func WithGroupWorkers(n int) GroupOption {
+	return func(g *Group) {
+		g.share.workers = newSlots(n)
+	}
+}
+
+// WithGroupRate limits the combined rate of the jobs of the group to the schedule, as
+// regions enter their pipes.
+func WithGroupRate(s Schedule) GroupOption {
+	return func(g *Group) {
+		g.share.throttle = Throttle(s)
+	}
+}
+
+// WithGroupBuffer bounds the bytes the jobs of the group have in flight combined. A
+// region counts from the moment it enters its pipe until the sink commits or fails it
+// (see Commit); regions that never make it that far (dropped by a valve, say) count
+// until their job ends. A single region larger than max still goes through when nothing
+// else is in flight.
+func WithGroupBuffer(max int64) GroupOption {
+	return func(g *Group) {
+		g.share.budget = newBudget(max)
+	}
+}
+
+// JobOption configures a job of a Group.
+type JobOption func(*job)
+
+// Priority sets the priority of a job (0 by default); higher goes first.
+func Priority(n int) JobOption {
+	return func(j *job) {
+		j.priority = n
+	}
+}
+
+type Group struct {
+	maxRunning int
+	share      share
+
+	// ctx is done once the group is canceled (see Cancel)
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	jobs    int
+	queued  []*job // waiting to start, or paused
+	running []*job
+	stats   GroupStats
+	errs    []error
+}
+
+type job struct {
+	id       int
+	priority int
+	p        *Pipe
+
+	started  chan struct{} // closed once the job is let in
+	admitted bool
+	paused   bool
+}
+
+// GroupStats sums up the jobs of a Group.
+type GroupStats struct {
+	Queued, Running, Paused, Done, Failed int
+	// Written is the number of bytes written by the jobs so far, those still running (or
+	// paused) included.
+	Written int64
+	// InFlight is the number of bytes the jobs have in flight against the budget of the
+	// group (see WithGroupBuffer), 0 if it has none.
+	InFlight int64
+}
+
+// Go queues the pipe up to run as a job of the group, once the group has room for it.
+// The pipe belongs to the group from then on, and is canceled along with it (see
+// Cancel).
+func (g *Group) Go(ctx context.Context, p *Pipe, opts ...JobOption) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(g.ctx, func() { cancel(context.Cause(g.ctx)) })
+
+	g.mu.Lock()
+	g.jobs++
+	j := &job{id: g.jobs, p: p, started: make(chan struct{})}
+	for _, opt := range opts {
+		opt(j)
+	}
+	g.queued = append(g.queued, j)
+	g.schedule()
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		defer cancel(nil)
+		defer stop()
+
+		select {
+		case <-j.started:
+		case <-ctx.Done():
+			g.mu.Lock()
+			admitted := j.admitted
+			g.mu.Unlock()
+			if !admitted {
+				g.finish(j, context.Cause(ctx))
+				return
+			}
+		}
+
+		err := p.pipe(ctx, &g.share)
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			// canceled, whether with the group or on its own: say why
+			err = context.Cause(ctx)
+		}
+		g.finish(j, err)
+	}()
+}
+
+// schedule lets in as many queued jobs as there's room for, highest priority first,
+// preempting lower priority jobs to make room; g.mu must be held
+func (g *Group) schedule() {
+	for len(g.queued) > 0 {
+		// highest priority first, first come first served among equals
+		next := slices.MaxFunc(g.queued, func(a, b *job) int {
+			return cmp.Or(cmp.Compare(a.priority, b.priority), cmp.Compare(b.id, a.id))
+		})
+
+		if g.maxRunning > 0 && len(g.running) >= g.maxRunning {
+			victim := slices.MinFunc(g.running, func(a, b *job) int {
+				return cmp.Or(cmp.Compare(a.priority, b.priority), cmp.Compare(b.id, a.id))
+			})
+			if victim.priority >= next.priority {
+				return
+			}
+
+			victim.p.pauser.pause(pausedByGroup)
+			victim.paused = true
+			g.running = remove(g.running, victim)
+			g.queued = append(g.queued, victim)
+		}
+
+		g.queued = remove(g.queued, next)
+		g.running = append(g.running, next)
+		if next.paused {
+			next.paused = false
+			next.p.pauser.resume(pausedByGroup)
+			continue
+		}
+		next.admitted = true
+		close(next.started)
+	}
+}
+
+// finish accounts for a job that's done, and makes room for the next one
+func (g *Group) finish(j *job, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.queued = remove(g.queued, j)
+	g.running = remove(g.running, j)
+	g.schedule()
+
+	g.stats.Written += written(j.p)
+	if err != nil {
+		g.stats.Failed++
+		g.errs = append(g.errs, fmt.Errorf("job %d: %w", j.id, err))
+		return
+	}
+	g.stats.Done++
+}
+
+// share is what the jobs of a Group share, handed to each of their runs
+type share struct {
+	workers  *slots
+	throttle *throttle
+	budget   *budget
+}
+
+// admit holds a region at the gate until the group the run is part of lets it in,
+// returning false if the context is done first
+func (r *run) admit(ctx context.Context, region Region) bool {
+	if r.share == nil {
+		return true
+	}
+
+	n := len(region.Data)
+	if r.share.throttle != nil && !r.share.throttle.wait(ctx, n) {
+		return false
+	}
+	if r.charges != nil {
+		return r.charges.charge(ctx, int64(n))
+	}
+	return true
+}
+
+// charges is what a run owes the buffer budget of its group
+type charges struct {
+	budget *budget
+
+	mu      sync.Mutex
+	owed    int64
+	settled bool
+}
+
+func (c *charges) charge(ctx context.Context, n int64) bool {
+	if !c.budget.acquire(ctx, n) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.settled {
+		// the run is over, the region isn't going anywhere
+		c.budget.release(n)
+		return false
+	}
+	c.owed += n
+	return true
+}
+
+// landed gives back what regions that reached the sink were charged. Valves may have
+// resized them along the way, so the run never gives back more than it owes.
+func (c *charges) landed(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n = min(n, c.owed)
+	if n > 0 {
+		c.owed -= n
+		c.budget.release(n)
+	}
+}
+
+// settle gives back whatever the run still owes once it's over
+func (c *charges) settle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.settled = true
+	if c.owed > 0 {
+		c.budget.release(c.owed)
+		c.owed = 0
+	}
+}
+
+// written returns the number of bytes the current (or most recent) run of p wrote
+func written(p *Pipe) int64 {
+	var n int64
+	for _, r := range p.Report().Written {
+		n += r.Len
+	}
+	return n
+}
+
+func remove(jobs []*job, j *job) []*job {
+	return slices.DeleteFunc(jobs, func(other *job) bool { return other == j })
+}
+
+// Wait blocks until all jobs queued up so far are done, and returns the errors of the
+// jobs that failed (joined, see errors.Join).
+func (g *Group) Wait() error {
+	g.wg.Wait()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.errs...)
+}
+
+// Cancel cancels every job of the group, queued or running, and those queued up after
+// it: they fail with cause (context.Canceled if nil), which Wait returns once they're
+// done.
+func (g *Group) Cancel(cause error) {
+	g.cancel(cause)
+}
+
+// Stats returns a snapshot of the jobs of the group.
+func (g *Group) Stats() GroupStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats := g.stats
+	stats.Running = len(g.running)
+	for _, j := range g.running {
+		stats.Written += written(j.p)
+	}
+	for _, j := range g.queued {
+		if j.paused {
+			stats.Paused++
+			stats.Written += written(j.p)
+		} else {
+			stats.Queued++
+		}
+	}
+	if g.share.budget != nil {
+		stats.InFlight = g.share.budget.inUse()
+	}
+	return stats
+}
@@ -0,0 +1,337 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestGroup(t *testing.T) {
+	// given: 6 jobs, one of which fails
+	var mu sync.Mutex
+	var running, peak int
+	track := func(r pipe.Region) (pipe.Region, error) {
+		if r.Off == 0 {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+		}
+		time.Sleep(time.Millisecond)
+		if r.Off == 90 {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}
+		return r, nil
+	}
+
+	g := pipe.NewGroup(pipe.WithMaxRunning(2), pipe.WithGroupWorkers(4), pipe.WithGroupRate(pipe.Schedule{}))
+	for i := range 6 {
+		sink := &pipetest.Sink{}
+		if i == 3 {
+			sink.Check = func(r pipe.Region) error {
+				if r.Off == 90 {
+					mu.Lock()
+					running--
+					mu.Unlock()
+					return errors.New("welp")
+				}
+				return nil
+			}
+		}
+		g.Go(context.Background(), pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, pipe.Func(track)))
+	}
+
+	// when
+	err := g.Wait()
+
+	// then
+	assert.ErrorContains(t, err, "job 4: sink (*pipetest.Sink): welp")
+	assert.Assert(t, peak <= 2)
+	assert.DeepEqual(t, g.Stats(), pipe.GroupStats{Done: 5, Failed: 1, Written: 5*100 + 90})
+}
+
+func TestGroup_canceled(t *testing.T) {
+	// given: a job that never ends, holding up the one queued after it
+	ctx, cancel := context.WithCancel(context.Background())
+	g := pipe.NewGroup(pipe.WithMaxRunning(1))
+	first := newArrivals()
+	g.Go(ctx, pipe.New(&pipetest.Source{Regions: pipetest.Regions(1, 10), Loop: true}, &pipetest.Sink{Check: first.check}))
+	first.await(t, 1)
+	g.Go(ctx, pipe.New(&pipetest.Source{Regions: pipetest.Regions(1, 10)}, &pipetest.Sink{}))
+
+	// when
+	assert.Equal(t, g.Stats().Queued, 1)
+	cancel()
+
+	// then
+	assert.ErrorIs(t, g.Wait(), context.Canceled)
+	assert.Equal(t, g.Stats().Failed, 2)
+}
+
+func TestGroup_Cancel(t *testing.T) {
+	// given: a job that never ends, counted while it runs, and one queued after it
+	g := pipe.NewGroup(pipe.WithMaxRunning(1), pipe.WithGroupBuffer(100))
+	first := newArrivals()
+	g.Go(context.Background(), pipe.New(&pipetest.Source{Regions: pipetest.Regions(1, 10), Loop: true}, &pipetest.Sink{Check: first.check}))
+	first.await(t, 2)
+	g.Go(context.Background(), pipe.New(&pipetest.Source{Regions: pipetest.Regions(1, 10)}, &pipetest.Sink{}))
+	stats := g.Stats()
+	assert.Equal(t, stats.Running, 1)
+	assert.Equal(t, stats.Queued, 1)
+	assert.Assert(t, stats.Written >= 10)
+
+	// when
+	errStop := errors.New("stop")
+	g.Cancel(errStop)
+
+	// then: both fail with the cause
+	err := g.Wait()
+	assert.ErrorContains(t, err, "job 1: stop")
+	assert.ErrorContains(t, err, "job 2: stop")
+	stats = g.Stats()
+	assert.Equal(t, stats.Failed, 2)
+	assert.Equal(t, stats.InFlight, int64(0))
+}
+
+func TestGroup_Priority(t *testing.T) {
+	// given: a background job hogging the only slot
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	background := newArrivals()
+	g := pipe.NewGroup(pipe.WithMaxRunning(1))
+	g.Go(ctx, pipe.New(&pipetest.Source{Regions: pipetest.Regions(1, 10), Loop: true}, &pipetest.Sink{Check: background.check}))
+	background.await(t, 1)
+
+	// when: an urgent job comes in
+	urgent := newArrivals()
+	g.Go(ctx, pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{Check: urgent.check}), pipe.Priority(1))
+
+	// then: it preempts the background job...
+	urgent.await(t, 10)
+
+	// ...which picks up where it left off once the urgent job is done
+	background.await(t, background.count()+10)
+	stats := g.Stats()
+	assert.Equal(t, stats.Running, 1)
+	assert.Equal(t, stats.Done, 1)
+	assert.Assert(t, stats.Written >= 100+10)
+
+	cancel()
+	assert.ErrorIs(t, g.Wait(), context.Canceled)
+}
+
+func TestGroup_Priority_paused(t *testing.T) {
+	// given
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	background := newArrivals()
+	g := pipe.NewGroup(pipe.WithMaxRunning(1))
+	g.Go(ctx, pipe.New(&pipetest.Source{Regions: pipetest.Regions(1, 10), Loop: true}, &pipetest.Sink{Check: background.check}))
+	background.await(t, 1)
+
+	// when: the urgent job takes a while, and holds on to its last region until the
+	// background job has been checked on
+	urgent := newArrivals()
+	checked := make(chan struct{})
+	check := func(r pipe.Region) error {
+		_ = urgent.check(r)
+		if r.Off == 20 {
+			select {
+			case <-checked:
+			case <-ctx.Done():
+			}
+		}
+		return nil
+	}
+	g.Go(ctx, pipe.New(&pipetest.Source{Regions: pipetest.Regions(3, 10)}, &pipetest.Sink{Check: check, Delay: 20 * time.Millisecond}), pipe.Priority(1))
+
+	// then: the background job sits idle in the meantime, once what it had in flight
+	// has landed (a region at the gate and one at the sink at most)
+	before := background.count()
+	stats := g.Stats()
+	assert.Equal(t, stats.Running, 1)
+	assert.Equal(t, stats.Paused, 1)
+	urgent.await(t, 3)
+	assert.Assert(t, background.count() <= before+2, "%d region(s) landed while paused", background.count()-before)
+	close(checked)
+
+	cancel()
+	assert.ErrorIs(t, g.Wait(), context.Canceled)
+}
+
+func TestGroup_shortcut(t *testing.T) {
+	// given: a pipe that would take a shortcut if it ran on its own
+	sink := &shortcutSink{}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink).With(pipe.WithShortcut())
+	g := pipe.NewGroup()
+
+	// when
+	g.Go(context.Background(), p)
+
+	// then: it goes through the group's gate instead
+	assert.NilError(t, g.Wait())
+	assert.Assert(t, !sink.shortcut)
+	assert.Equal(t, len(sink.Regions()), 10)
+}
+
+func TestGroup_WithGroupRate(t *testing.T) {
+	// given: a pipe on the batch path, in a group limited to 100 bytes per second
+	passthrough := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(15, 10)}, &pipetest.Sink{}, passthrough).
+		With(pipe.WithBatches(4))
+	g := pipe.NewGroup(pipe.WithGroupRate(pipe.Schedule{Default: 100}))
+
+	// when
+	start := time.Now()
+	g.Go(context.Background(), p)
+	assert.NilError(t, g.Wait())
+
+	// then: the first second's worth goes through in a burst, the rest has to wait
+	assert.Assert(t, time.Since(start) >= 400*time.Millisecond)
+
+	// and the pipe itself was left alone: outside of the group, it isn't throttled
+	start = time.Now()
+	assert.NilError(t, p.Pipe(context.Background()))
+	assert.Assert(t, time.Since(start) < 100*time.Millisecond)
+}
+
+func TestGroup_WithGroupBuffer(t *testing.T) {
+	// given: jobs that hang on to their regions for a bit, sharing a 30 byte budget
+	var mu sync.Mutex
+	var inflight, peak int
+	enter := func(r pipe.Region) (pipe.Region, error) {
+		mu.Lock()
+		inflight += len(r.Data)
+		peak = max(peak, inflight)
+		mu.Unlock()
+		return r, nil
+	}
+	land := func(r pipe.Region) error {
+		mu.Lock()
+		inflight -= len(r.Data)
+		mu.Unlock()
+		return nil
+	}
+
+	g := pipe.NewGroup(pipe.WithGroupBuffer(30))
+	sinks := make([]*pipetest.Sink, 4)
+	for i := range sinks {
+		sinks[i] = &pipetest.Sink{Check: land, Delay: time.Millisecond}
+		g.Go(context.Background(), pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sinks[i], pipe.Func(enter)))
+	}
+
+	// when
+	err := g.Wait()
+
+	// then: every job got through, never more than 3 regions in flight at once
+	assert.NilError(t, err)
+	for _, sink := range sinks {
+		assert.Equal(t, len(sink.Regions()), 10)
+	}
+	assert.Assert(t, peak > 0 && peak <= 30, "peak: %d", peak)
+}
+
+func TestGroup_WithGroupBuffer_dropped(t *testing.T) {
+	// given: a job whose regions never make it to the sink, with a budget only big enough
+	// for one region at a time
+	g := pipe.NewGroup(pipe.WithGroupBuffer(10), pipe.WithMaxRunning(1))
+	g.Go(context.Background(), pipe.New(&pipetest.Source{Regions: pipetest.Regions(1, 10)}, &pipetest.Sink{}, dropValve{}))
+
+	sink := &pipetest.Sink{}
+	g.Go(context.Background(), pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink))
+
+	// when
+	err := g.Wait()
+
+	// then: the first job gave back its share once it was done
+	assert.NilError(t, err)
+	assert.Equal(t, len(sink.Regions()), 10)
+}
+
+// dropValve drops every region
+type dropValve struct{}
+
+func (dropValve) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer close(sink)
+		for {
+			if _, more := pipe.Next(ctx, source); !more {
+				return
+			}
+		}
+	}()
+	return source
+}
+
+// arrivals counts the regions arriving at a sink (see pipetest.Sink.Check), so tests can
+// wait on them
+type arrivals struct {
+	mu      sync.Mutex
+	n       int
+	arrived chan struct{} // closed (and replaced) on every arrival
+}
+
+func newArrivals() *arrivals {
+	return &arrivals{arrived: make(chan struct{})}
+}
+
+func (a *arrivals) check(pipe.Region) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.n++
+	close(a.arrived)
+	a.arrived = make(chan struct{})
+	return nil
+}
+
+func (a *arrivals) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.n
+}
+
+// await blocks until n regions have arrived
+func (a *arrivals) await(t *testing.T, n int) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		a.mu.Lock()
+		got, arrived := a.n, a.arrived
+		a.mu.Unlock()
+		if got >= n {
+			return
+		}
+
+		select {
+		case <-arrived:
+		case <-timeout:
+			t.Fatalf("only %d of %d regions arrived", got, n)
+		}
+	}
+}
+
+// shortcutSink takes every shortcut it's offered (and does nothing with it)
+type shortcutSink struct {
+	pipetest.Sink
+	shortcut bool
+}
+
+func (s *shortcutSink) Shortcut(context.Context, pipe.Source) (bool, error) {
+	s.shortcut = true
+	return true, nil
+}
@@ -0,0 +1,75 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+)
+
+// Handle is a run of a pipe started in the background (see Start), to be waited on,
+// looked into while it runs, or canceled.
+type Handle struct {
+	p      *Pipe
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	err    error // set once done is closed
+}
+
+// Start runs the pipe in the background as Pipe does, and returns a handle on the run
+// right away: the caller keeps hold of the run (its stats in particular) rather than
+// wrapping Pipe in a goroutine of its own.
+func (p *Pipe) Start(ctx context.Context) *Handle {
+	ctx, cancel := context.WithCancelCause(ctx)
+	h := &Handle{p: p, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		defer cancel(nil)
+
+		err := p.Pipe(ctx)
+		if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			// canceled, by the handle or with ctx: say why
+			err = context.Cause(ctx)
+		}
+		h.err = err
+	}()
+	return h
+}
+
+// Wait blocks until the run is over, and returns what Pipe would have.
+func (h *Handle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Done returns a channel closed once the run is over.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err returns what the run failed with once it's over (nil if it succeeded), and nil
+// while it's still running.
+func (h *Handle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Stats returns what went through each stage of the pipe so far, if it keeps stats (see
+// Pipe.Stats).
+func (h *Handle) Stats() []StageStats {
+	return h.p.Stats()
+}
+
+// Report returns the ranges of the stream written and failed so far, which are what the
+// run got through once it's failed: its Committed prefix in particular (see Pipe.Report).
+func (h *Handle) Report() Report {
+	return h.p.Report()
+}
+
+// Cancel cancels the run, which then fails with cause (context.Canceled if nil), unless
+// it's over already. It doesn't wait for the run to be over: see Wait.
+func (h *Handle) Cancel(cause error) {
+	h.cancel(cause)
+}
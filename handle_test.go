@@ -0,0 +1,54 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_Start(t *testing.T) {
+	// given
+	sink := &pipetest.Sink{}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, passthrough)
+
+	// when
+	h := p.Start(context.Background())
+
+	// then
+	assert.NilError(t, h.Wait())
+	assert.NilError(t, h.Err())
+	assert.Equal(t, len(sink.Regions()), 10)
+}
+
+func TestPipe_Start_Cancel(t *testing.T) {
+	// given: a run that never ends on its own
+	arrived := newArrivals()
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(1, 10), Loop: true}, &pipetest.Sink{Check: arrived.check}, passthrough).
+		With(pipe.WithStats())
+	h := p.Start(context.Background())
+	arrived.await(t, 2)
+
+	// then: it can be looked into while it runs
+	assert.NilError(t, h.Err())
+	stats := h.Stats()
+	assert.Assert(t, stats[len(stats)-1].RegionsIn >= 2)
+	select {
+	case <-h.Done():
+		t.Fatal("done before it was canceled")
+	default:
+	}
+
+	// when
+	errStop := errors.New("stop")
+	h.Cancel(errStop)
+
+	// then: it fails with the cause
+	assert.ErrorIs(t, h.Wait(), errStop)
+	assert.ErrorIs(t, h.Err(), errStop)
+	assert.Assert(t, h.Report().Committed() >= 20)
+}
@@ -0,0 +1,185 @@
+package pipe_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestChunkIndex(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "index")
+	x, err := pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+
+	a, b := pipeio.ChunkDigest([]byte("a")), pipeio.ChunkDigest([]byte("b"))
+
+	// when
+	_, dup, err := x.Put(a, 1, "chunks/a")
+	assert.NilError(t, err)
+	assert.Assert(t, !dup)
+	c, dup, err := x.Put(a, 1, "elsewhere")
+	assert.NilError(t, err)
+	assert.Assert(t, dup)
+	assert.Equal(t, c.Location, "chunks/a")
+	_, _, err = x.Put(b, 1, "chunks/b")
+	assert.NilError(t, err)
+	assert.NilError(t, x.Release(b))
+	assert.NilError(t, x.Close())
+
+	// then: the index is the same once reopened
+	x, err = pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+	defer x.Close()
+
+	c, ok := x.Get(a)
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, c, pipeio.Chunk{Digest: a, Size: 1, Location: "chunks/a", Refs: 2})
+	c, ok = x.Get(b)
+	assert.Assert(t, ok)
+	assert.Equal(t, c.Refs, int64(0))
+	assert.ErrorContains(t, x.Release(b), "isn't referenced")
+}
+
+func TestChunkIndex_Collect(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "index")
+	x, err := pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+
+	a, b, c := pipeio.ChunkDigest([]byte("a")), pipeio.ChunkDigest([]byte("b")), pipeio.ChunkDigest([]byte("c"))
+	for _, d := range []pipeio.Digest{a, b, c} {
+		_, _, err := x.Put(d, 1, d.String())
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, x.Release(b))
+	assert.NilError(t, x.Release(c))
+
+	// when: removing c fails
+	boom := os.ErrPermission
+	var removed []pipeio.Digest
+	collected, err := x.Collect(func(chunk pipeio.Chunk) error {
+		if chunk.Digest == c {
+			return boom
+		}
+		removed = append(removed, chunk.Digest)
+		return nil
+	})
+
+	// then
+	assert.ErrorIs(t, err, boom)
+	assert.DeepEqual(t, removed, []pipeio.Digest{b})
+	assert.Equal(t, len(collected), 1)
+	assert.Equal(t, x.Len(), 2)
+	_, ok := x.Get(b)
+	assert.Assert(t, !ok)
+
+	assert.NilError(t, x.Close())
+	x, err = pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+	defer x.Close()
+	assert.Equal(t, x.Len(), 2)
+}
+
+func TestChunkIndex_torn(t *testing.T) {
+	// given: a log whose last record didn't make it in full
+	path := filepath.Join(t.TempDir(), "index")
+	x, err := pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+	a := pipeio.ChunkDigest([]byte("a"))
+	_, _, err = x.Put(a, 1, "a")
+	assert.NilError(t, err)
+	assert.NilError(t, x.Close())
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	assert.NilError(t, err)
+	_, err = f.WriteString(`{"digest":"`)
+	assert.NilError(t, err)
+	assert.NilError(t, f.Close())
+
+	// when
+	x, err = pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+	_, _, err = x.Put(a, 1, "a")
+	assert.NilError(t, err)
+	assert.NilError(t, x.Close())
+
+	// then: the torn record is dropped, and what follows it is readable
+	x, err = pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+	defer x.Close()
+	c, ok := x.Get(a)
+	assert.Assert(t, ok)
+	assert.Equal(t, c.Refs, int64(2))
+}
+
+func TestChunkIndex_Compact(t *testing.T) {
+	// given: a log of many changes to few chunks
+	path := filepath.Join(t.TempDir(), "index")
+	x, err := pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+	a := pipeio.ChunkDigest([]byte("a"))
+	for range 100 {
+		_, _, err := x.Put(a, 1, "a")
+		assert.NilError(t, err)
+	}
+	before, err := os.Stat(path)
+	assert.NilError(t, err)
+
+	// when
+	assert.NilError(t, x.Compact())
+	_, _, err = x.Put(a, 1, "a")
+	assert.NilError(t, err)
+	assert.NilError(t, x.Close())
+
+	// then
+	after, err := os.Stat(path)
+	assert.NilError(t, err)
+	assert.Assert(t, after.Size() < before.Size()/10)
+
+	x, err = pipeio.OpenChunkIndex(path)
+	assert.NilError(t, err)
+	defer x.Close()
+	c, ok := x.Get(a)
+	assert.Assert(t, ok)
+	assert.Equal(t, c.Refs, int64(101))
+}
+
+func TestChunkIndex_Import(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	a, b := pipeio.ChunkDigest([]byte("a")), pipeio.ChunkDigest([]byte("b"))
+
+	src, err := pipeio.OpenChunkIndex(filepath.Join(dir, "src"))
+	assert.NilError(t, err)
+	defer src.Close()
+	_, _, err = src.Put(a, 1, "src/a")
+	assert.NilError(t, err)
+	_, _, err = src.Put(b, 1, "src/b")
+	assert.NilError(t, err)
+
+	dst, err := pipeio.OpenChunkIndex(filepath.Join(dir, "dst"))
+	assert.NilError(t, err)
+	defer dst.Close()
+	_, _, err = dst.Put(a, 1, "dst/a")
+	assert.NilError(t, err)
+
+	var exported bytes.Buffer
+	_, err = src.WriteTo(&exported)
+	assert.NilError(t, err)
+
+	// when
+	err = dst.Import(&exported)
+
+	// then
+	assert.NilError(t, err)
+	c, _ := dst.Get(a)
+	assert.DeepEqual(t, c, pipeio.Chunk{Digest: a, Size: 1, Location: "dst/a", Refs: 2})
+	c, _ = dst.Get(b)
+	assert.DeepEqual(t, c, pipeio.Chunk{Digest: b, Size: 1, Location: "src/b", Refs: 1})
+}
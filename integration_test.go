@@ -1,20 +1,19 @@
 package pipe_test
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"gotest.tools/v3/assert"
 
 	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/bench"
 	pipeio "github.com/naylorpmax-joyent/pipe/io"
 )
 
@@ -26,209 +25,156 @@ const (
 
 func TestPipe_FileToFile(t *testing.T) {
 	// given
-	setup, err := setupFileToFile(t.Name(), MiB, 1, 1, 4*KiB, 10)
-	if setup.close != nil {
-		t.Cleanup(setup.close)
+	setup, err := bench.FileToFile("testdata", t.Name(), MiB, 1, 1, 4*KiB, 10)
+	if setup.Close != nil {
+		t.Cleanup(setup.Close)
 	}
 	assert.NilError(t, err)
 
 	// when
-	assert.NilError(t, setup.pipe.Pipe(context.Background()))
+	assert.NilError(t, setup.Pipe.Pipe(context.Background()))
 
 	// then
-	assert.NilError(t, diffFiles(setup.dst, setup.src))
+	assert.NilError(t, bench.Diff(setup.Dst, setup.Src))
 }
 
-type setup struct {
-	pipe  *pipe.Pipe
-	close func()
-	src   string
-	dst   string
-}
-
-func setupFileToFile(name string, fileSize int64, numReaders, numWriters, bufferSize, maxBuffers int, valves ...pipe.Valve) (*setup, error) {
-	var setup setup
-	cleanup := make([]func(), 0)
-	defer func() {
-		setup.close = func() {
-			for _, c := range cleanup {
-				if c != nil {
-					c()
-				}
-			}
-		}
-	}()
-
-	// create shared/reusable testdata file filled with random data
-	// (if doesn't already exist)
-	base := fmt.Sprintf("testdata/%dMB.bin", fileSize/MiB)
-	if err := fillFile(base, fileSize); err != nil {
-		return &setup, err
-	}
-
-	// create test-specific file with the same contents as the testdata
-	src := fmt.Sprintf("testdata/%s-src.bin", name)
-	if err := copyFile(src, base); err != nil {
-		return &setup, err
-	}
-	if err := diffFiles(base, src); err != nil {
-		return &setup, err
+func TestPipe_ReadAhead(t *testing.T) {
+	// given
+	setup, err := bench.FileToFile("testdata", t.Name(), MiB, 1, 1, 4*KiB, 10)
+	if setup.Close != nil {
+		t.Cleanup(setup.Close)
 	}
+	assert.NilError(t, err)
 
-	// create pipe source: file reader
-	var source pipe.Source
-	buff := pipeio.NewBuffer(bufferSize, maxBuffers)
-
-	if numReaders == 1 {
-		f, err := os.Open(src)
-		if err != nil {
-			return &setup, err
-		}
-		cleanup = append(cleanup, func() { _ = f.Close() })
-
-		source = pipeio.Source(f, 0, buff)
-	} else {
-		sources, close, err := shard(src, numReaders, buff)
-		if close != nil {
-			cleanup = append(cleanup, close)
-		}
-		if err != nil {
-			return &setup, err
-		}
+	src, err := os.Open(setup.Src)
+	assert.NilError(t, err)
+	defer src.Close()
+	dst, err := os.OpenFile(setup.Dst, os.O_RDWR, 0)
+	assert.NilError(t, err)
+	defer dst.Close()
 
-		source = pipe.Fan(sources...)
-	}
+	buff := pipeio.NewBuffer(4*KiB, 10)
+	p := pipe.New(
+		pipeio.Source(src, 0, buff, pipeio.ReadAhead(4)),
+		pipeio.Sink(dst, buff),
+	)
 
-	// create pipe sink: file writer
-	dst := fmt.Sprintf("testdata/%s-dst.bin", name)
-	f, err := os.Create(dst)
-	if err != nil {
-		return &setup, err
-	}
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
 
-	cleanup = append(cleanup, func() {
-		_ = f.Close()
-		_ = os.Remove(src)
-		_ = os.Remove(dst)
-	})
-
-	var sink pipe.Sink
-	if numWriters == 1 {
-		sink = pipeio.Sink(f, buff)
-	} else {
-		pool, close, err := pool(dst, numWriters, buff)
-		if close != nil {
-			cleanup = append(cleanup, close)
-		}
-		if err != nil {
-			return &setup, err
-		}
+	// then
+	assert.NilError(t, bench.Diff(setup.Dst, setup.Src))
+}
 
-		sink = pool
+func TestSource_ReadAhead(t *testing.T) {
+	tests := []struct {
+		ahead int
+		reads int64
+	}{
+		// the regions the sink and the gate hold, and the one waiting on the gate
+		{ahead: 0, reads: 3},
+		// plus the regions read ahead, and the one waiting on those
+		{ahead: 4, reads: 8},
 	}
 
-	setup.pipe = pipe.New(source, sink, valves...)
-	setup.src = src
-	setup.dst = dst
+	for _, test := range tests {
+		ahead := test.ahead
+		t.Run(fmt.Sprintf("ahead=%d", ahead), func(t *testing.T) {
+			// given: a sink stuck on the first region
+			r := newCountingReader()
+			release := make(chan struct{})
+			sinkFunc := func(pipe.Region) error {
+				<-release
+				return nil
+			}
 
-	return &setup, nil
-}
+			var opts []pipeio.SourceOption
+			if ahead > 0 {
+				opts = append(opts, pipeio.ReadAhead(ahead))
+			}
+			p := pipe.New(pipeio.Source(r, 0, pipeio.NewBuffer(4*KiB, 10), opts...), &sink{f: sinkFunc})
 
-func pool(path string, n int, buff pipeio.Buffer) (pipe.Sink, func(), error) {
-	writers := make([]io.WriterAt, n)
-	closers := make([]func() error, n)
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error)
+			go func() { errs <- p.Pipe(ctx) }()
 
-	var close func()
-	defer func() {
-		close = func() {
-			for _, c := range closers {
-				if c != nil {
-					_ = c()
-				}
-			}
-		}
-	}()
+			// when
+			r.await(t, test.reads)
 
-	for i := 0; i < n; i++ {
-		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, os.ModePerm)
-		if err != nil {
-			return nil, close, err
-		}
+			// then: reads went ahead of the sink, up to the limit
+			r.quiet(t, test.reads)
 
-		writers[i] = f
-		closers[i] = f.Close
+			// and: they stop once the run is canceled, rather than reading on
+			cancel()
+			close(release)
+			assert.ErrorIs(t, <-errs, context.Canceled)
+			r.quiet(t, test.reads+1)
+		})
 	}
+}
 
-	return pipeio.Pool(buff, writers...), close, nil
+// countingReader is an endless reader counting its reads, so tests can wait on them
+type countingReader struct {
+	mu    sync.Mutex
+	reads int64
+	read  chan struct{} // closed (and replaced) on every read
 }
 
-func shard(path string, shards int, bufferPool pipeio.Buffer) ([]pipe.Source, func(), error) {
-	// determine total size of the file
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer f.Close()
+func newCountingReader() *countingReader {
+	return &countingReader{read: make(chan struct{})}
+}
 
-	stat, err := f.Stat()
-	if err != nil {
-		return nil, nil, err
-	}
-	totalSize := stat.Size()
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	// determine max size of each reader
-	maxShardSize := int64(math.Ceil(float64(totalSize) / float64(shards)))
+	r.reads++
+	close(r.read)
+	r.read = make(chan struct{})
+	return len(p), nil
+}
 
-	sources := make([]pipe.Source, shards)
-	closers := make([]func() error, shards)
+// await blocks until n reads have happened
+func (r *countingReader) await(t *testing.T, n int64) {
+	t.Helper()
 
-	var close func()
-	defer func() {
-		close = func() {
-			for _, c := range closers {
-				if c != nil {
-					_ = c()
-				}
-			}
-		}
-	}()
-
-	for i := 0; i < shards; i++ {
-		f, err := os.Open(path)
-		if err != nil {
-			return nil, close, err
+	timeout := time.After(5 * time.Second)
+	for {
+		r.mu.Lock()
+		got, read := r.reads, r.read
+		r.mu.Unlock()
+		if got >= n {
+			return
 		}
 
-		closers[i] = f.Close
-
-		rOff := maxShardSize * int64(i)
-		_, err = f.Seek(rOff, 0)
-		if err != nil {
-			return nil, close, err
+		select {
+		case <-read:
+		case <-timeout:
+			t.Fatalf("expected %d reads, got %d", n, got)
 		}
-
-		limited := &io.LimitedReader{R: f, N: int64(maxShardSize)}
-		sources[i] = pipeio.Source(limited, rOff, bufferPool)
 	}
-
-	return sources, close, nil
 }
 
-func copyFile(dst, src string) error {
-	dstFile, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, os.ModePerm)
-	if err != nil {
-		return err
-	}
-	defer dstFile.Close()
+// quiet fails the test if more than n reads have happened, or happen for a while: reads
+// going on past where they should stop don't take long to show up
+func (r *countingReader) quiet(t *testing.T, n int64) {
+	t.Helper()
 
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
+	timeout := time.After(20 * time.Millisecond)
+	for {
+		r.mu.Lock()
+		got, read := r.reads, r.read
+		r.mu.Unlock()
+		if got > n {
+			t.Fatalf("expected no more than %d reads, got %d", n, got)
+		}
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+		select {
+		case <-read:
+		case <-timeout:
+			return
+		}
+	}
 }
 
 // Copy is yoinked from the io package to serve as a performance baseline.
@@ -300,105 +246,6 @@ func copyBuffer(dst io.Writer, src io.Reader, buf []byte) (written int64, err er
 
 var errInvalidWrite = errors.New("invalid write result")
 
-func fillFile(path string, fill int64) error {
-	f, err := os.Open(path)
-	if err == nil {
-		// file is present, nothing to do
-		f.Close()
-		return nil
-	}
-	if !os.IsNotExist(err) {
-		return fmt.Errorf("error opening %s: %w", path, err)
-	}
-
-	// okay no file yet; let's set that up
-	f.Close()
-
-	f, err = os.Create(path)
-	if err != nil {
-		return fmt.Errorf("error creating %s: %w", path, err)
-	}
-	defer f.Close()
-
-	if fill == 0 {
-		return nil
-	}
-
-	filler := &io.LimitedReader{
-		R: rand.Reader,
-		N: fill,
-	}
-
-	_, err = io.Copy(f, filler)
-	if err != nil {
-		return fmt.Errorf("error filling %s with %d bytes: %w", path, fill, err)
-	}
-
-	return nil
-}
-
-func diffFiles(a, b string) error {
-	fileA, err := os.Open(a)
-	if err != nil {
-		return err
-	}
-	defer fileA.Close()
-
-	fileB, err := os.Open(b)
-	if err != nil {
-		return err
-	}
-	defer fileB.Close()
-
-	statA, err := fileA.Stat()
-	if err != nil {
-		return err
-	}
-	statB, err := fileB.Stat()
-	if err != nil {
-		return err
-	}
-
-	if statA.Size() != statB.Size() {
-		return fmt.Errorf("file %s (size %d) is not same size as file %s (size %d)",
-			a, statA.Size(), b, statB.Size(),
-		)
-	}
-
-	buffA := make([]byte, 4*KiB)
-	buffB := make([]byte, 4*KiB)
-
-	var done bool
-	var off int64
-	for !done {
-		n, err := fileA.Read(buffA)
-		if errors.Is(err, io.EOF) || n == 0 {
-			done = true
-		} else if err != nil {
-			return err
-		}
-		if n == 0 {
-			break
-		}
-
-		m, err := fileB.Read(buffB)
-		if err != nil && !errors.Is(err, io.EOF) {
-			return err
-		}
-
-		if n != m {
-			return fmt.Errorf("well that's annoying... n=%d, m=%d)", n, m)
-		}
-
-		off += int64(n)
-		if !bytes.Equal(buffA, buffB) {
-			return fmt.Errorf("contents of file=%s do not equal file=%s at offset=%d", a, b, off)
-		}
-	}
-
-	return nil
-}
-
 type delayValve struct {
 	delay time.Duration
 }
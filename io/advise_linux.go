@@ -0,0 +1,63 @@
+package io
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// willNeed advises the kernel that the next n bytes of f (from its current position) are
+// about to be read.
+func willNeed(f *os.File, n int64) {
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	fadvise(f, pos, n, unix.FADV_WILLNEED)
+}
+
+func fadvise(f *os.File, off, n int64, advice int) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	// advice is best-effort; there's nothing useful to do if the kernel won't take it
+	_ = rc.Control(func(fd uintptr) {
+		_ = unix.Fadvise(int(fd), off, n, advice)
+	})
+}
+
+var fadvice = map[fileAdvice]int{
+	adviseSequential: unix.FADV_SEQUENTIAL,
+	adviseWillNeed:   unix.FADV_WILLNEED,
+	adviseDontNeed:   unix.FADV_DONTNEED,
+}
+
+// advise advises the kernel about n bytes of the file at off (n=0 is up to the end)
+func advise(h handle, off, n int64, a fileAdvice) {
+	if f, ok := h.(*os.File); ok {
+		fadvise(f, off, n, fadvice[a])
+	}
+}
+
+// writeBack writes n bytes of the file at off out to disk, and waits for them to be
+func writeBack(h handle, off, n int64) error {
+	f, ok := h.(*os.File)
+	if !ok {
+		return nil
+	}
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	flags := unix.SYNC_FILE_RANGE_WAIT_BEFORE | unix.SYNC_FILE_RANGE_WRITE | unix.SYNC_FILE_RANGE_WAIT_AFTER
+	if cerr := rc.Control(func(fd uintptr) {
+		err = unix.SyncFileRange(int(fd), off, n, flags)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
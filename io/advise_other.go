@@ -0,0 +1,13 @@
+//go:build !linux
+
+package io
+
+import "os"
+
+func willNeed(_ *os.File, _ int64) {}
+
+func advise(_ handle, _, _ int64, _ fileAdvice) {}
+
+func writeBack(_ handle, _, _ int64) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package io
+
+import (
+	"context"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Backfill implements pipe.Source and produces the first size bytes of a stream from
+// primary, falling back on the sources made by fallback for whatever primary can't
+// supply: the ranges it skips over, and everything it didn't get to once it fails. It's
+// the way to read through a cache (local first, origin on a miss):
+//
+//	pipeio.Backfill(pipeio.Source(cached, 0, buff), size, func(off, n int64) pipe.Source {
+//		return pipeio.HTTPSource(client, url, off, n, buff)
+//	})
+//
+// Errors from primary aren't reported, as they're made up for; errors from the fallback
+// sources are.
+func Backfill(primary pipe.Source, size int64, fallback func(off, n int64) pipe.Source) pipe.Source {
+	return &backfill{primary: primary, size: size, fallback: fallback}
+}
+
+type backfill struct {
+	primary  pipe.Source
+	size     int64
+	fallback func(off, n int64) pipe.Source
+}
+
+func (b *backfill) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	covered, ok := b.first(ctx, sink)
+	if !ok {
+		return
+	}
+
+	for _, gap := range gaps(covered, b.size) {
+		if !b.fill(ctx, gap, sink, errs) {
+			return
+		}
+	}
+}
+
+// first passes on what primary produces until it's done or fails, and returns the ranges
+// it covered; it returns false if the context is done first
+func (b *backfill) first(ctx context.Context, sink chan pipe.Region) ([]pipe.Range, bool) {
+	pctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	in, perrs := make(chan pipe.Region), make(chan error, 1)
+	go b.primary.Write(pctx, in, perrs)
+
+	var covered []pipe.Range
+	for {
+		select {
+		case r, more := <-in:
+			if !more {
+				return merge(covered, nil), ctx.Err() == nil
+			}
+
+			select {
+			case sink <- r:
+				covered = append(covered, pipe.Range{Off: r.Off, Len: int64(len(r.Data))})
+			case <-ctx.Done():
+				go discard(in)
+				return nil, false
+			}
+		case <-perrs:
+			// whatever primary didn't get to is made up for by the fallback
+			cancel()
+			go discard(in)
+			return merge(covered, nil), ctx.Err() == nil
+		case <-ctx.Done():
+			go discard(in)
+			return nil, false
+		}
+	}
+}
+
+// fill passes on what the fallback produces for a gap, and returns false if that failed
+// or the context is done first
+func (b *backfill) fill(ctx context.Context, gap pipe.Range, sink chan pipe.Region, errs chan error) bool {
+	fctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	in, ferrs := make(chan pipe.Region), make(chan error, 1)
+	go b.fallback(gap.Off, gap.Len).Write(fctx, in, ferrs)
+
+	for {
+		select {
+		case r, more := <-in:
+			if !more {
+				return ctx.Err() == nil
+			}
+
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				go discard(in)
+				return false
+			}
+		case err := <-ferrs:
+			cancel()
+			go discard(in)
+			select {
+			case errs <- err:
+			case <-ctx.Done():
+			}
+			return false
+		case <-ctx.Done():
+			go discard(in)
+			return false
+		}
+	}
+}
+
+// gaps returns the parts of [0, size) that aren't covered by the ranges, which are
+// sorted and don't overlap (see merge)
+func gaps(covered []pipe.Range, size int64) []pipe.Range {
+	var missing []pipe.Range
+	var off int64
+	for _, r := range covered {
+		if r.Off >= size {
+			break
+		}
+		if r.Off > off {
+			missing = append(missing, pipe.Range{Off: off, Len: r.Off - off})
+		}
+		off = max(off, r.End())
+	}
+	if off < size {
+		missing = append(missing, pipe.Range{Off: off, Len: size - off})
+	}
+	return missing
+}
@@ -0,0 +1,147 @@
+package io
+
+import (
+	"context"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// WriteBehind has the sink accumulate contiguous regions into a batch of up to size bytes
+// and write the batch in one go: once it's full, once a region arrives that doesn't pick
+// up where the batch left off, or once the first region in the batch has been waiting for
+// linger - whichever comes first. Object stores and RPC-backed writers pay a fixed cost
+// per request, so fewer and larger writes go a long way.
+//
+// Only contiguous regions are batched, so write-behind pays off on streams arriving in
+// order (a single source, or in-order valves); interleaved shards (see pipe.Fan) break
+// batches up all the time. Pool doesn't batch. Batches pending when the pipe is canceled
+// are dropped rather than written.
+//
+// Region buffers are released as soon as their data has been copied into the batch.
+func WriteBehind(size int, linger time.Duration) SinkOption {
+	return func(s *sink) {
+		s.batch = &batch{size: size, linger: linger}
+	}
+}
+
+type batch struct {
+	size   int
+	linger time.Duration
+}
+
+func (w *sink) readBatched(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	pending := pipe.Region{Data: make([]byte, 0, w.batch.size)}
+
+	timer := time.NewTimer(w.batch.linger)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() error {
+		timer.Stop()
+		if len(pending.Data) == 0 {
+			return nil
+		}
+
+		err := w.write(ctx, pending)
+		pending.Data = pending.Data[:0]
+		return err
+	}
+
+	for {
+		select {
+		case data, more := <-source:
+			if ctx.Err() != nil {
+				// canceled: whatever's pending is dropped
+				errs <- ctx.Err()
+				return
+			}
+			if !more {
+				// all out of data to write ! (once the batch is out the door)
+				if err := flush(); err != nil {
+					errs <- err
+					return
+				}
+				errs <- nil
+				return
+			}
+
+			end := pending.Off + int64(len(pending.Data))
+			_, dup := Duplicate.Get(data)
+			if len(pending.Data) > 0 && (dup || data.Off != end || len(pending.Data)+len(data.Data) > w.batch.size) {
+				if err := flush(); err != nil {
+					errs <- err
+					return
+				}
+			}
+
+			if dup || len(data.Data) >= w.batch.size {
+				// no point copying a region that fills a batch on its own, nor one that
+				// has no data to copy (see Dedup)
+				if err := w.write(ctx, data); err != nil {
+					errs <- err
+					return
+				}
+				Release(w.buff, data) // release buffer
+				continue
+			}
+
+			if len(pending.Data) == 0 {
+				pending.Off = data.Off
+				timer.Reset(w.batch.linger)
+			}
+			pending.Data = append(pending.Data, data.Data...)
+			Release(w.buff, data) // release buffer
+
+		case <-timer.C:
+			if err := flush(); err != nil {
+				errs <- err
+				return
+			}
+
+		case <-ctx.Done():
+			errs <- ctx.Err()
+			return
+		}
+	}
+}
+
+// ReadBatches implements pipe.BatchSink.
+func (w *sink) ReadBatches(ctx context.Context, source <-chan []pipe.Region, errs chan<- error) {
+	if err := truncate(w.w, w.size); err != nil {
+		go func() {
+			for batch := range source {
+				for _, r := range batch {
+					Release(w.buff, r)
+				}
+			}
+		}()
+		errs <- err
+		return
+	}
+
+	if w.batch != nil {
+		// batching writes is done region by region
+		w.readBatched(ctx, pipe.Unbatch(ctx, source), errs)
+		return
+	}
+
+	for {
+		batch, more := pipe.Next(ctx, source)
+		if !more {
+			// all out of data to write !
+			break
+		}
+
+		for _, data := range batch {
+			if err := w.write(ctx, data); err != nil {
+				errs <- err
+				return
+			}
+
+			Release(w.buff, data) // release buffer
+		}
+	}
+
+	errs <- ctx.Err()
+}
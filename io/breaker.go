@@ -0,0 +1,126 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// ErrBreakerOpen is returned by writes that were waiting on an open breaker when the
+// breaker was closed for good.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// minBackoff is the shortest a breaker backs off between retries before opening
+const minBackoff = time.Millisecond
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed breakers pass writes straight through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen breakers hold writes back, only probing the destination periodically.
+	BreakerOpen
+)
+
+// Breaker wraps a WriterAt with a circuit breaker. Transient failures (according to the
+// classifier) are retried with a backoff growing up to the probe interval until threshold
+// consecutive failures have piled up, at which point the breaker opens: the write blocks
+// and is retried as a probe once every probe interval, until a probe succeeds and the
+// breaker closes again.
+//
+// Blocking the write stops the sink from reading more regions, which in turn pauses the
+// source - so a flapping destination sees a trickle of probes rather than the full
+// throughput of the pipe. Errors that aren't transient are returned right away. When used
+// by the sinks of this package, writes also give up once the pipe is done.
+func Breaker(w io.WriterAt, threshold int, probe time.Duration, classifier pipe.ErrorClassifier) *breaker {
+	if classifier == nil {
+		classifier = pipe.DefaultClassifier
+	}
+
+	return &breaker{
+		w:          w,
+		threshold:  threshold,
+		probe:      probe,
+		classifier: classifier,
+		done:       make(chan struct{}),
+	}
+}
+
+type breaker struct {
+	w          io.WriterAt
+	threshold  int
+	probe      time.Duration
+	classifier pipe.ErrorClassifier
+
+	mu       sync.Mutex
+	failures int
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (b *breaker) WriteAt(p []byte, off int64) (int, error) {
+	return b.writeAt(context.Background(), p, off)
+}
+
+// writeAt is WriteAt, giving up on retries once ctx is done
+func (b *breaker) writeAt(ctx context.Context, p []byte, off int64) (int, error) {
+	for retries := 0; ; retries++ {
+		n, err := b.w.WriteAt(p, off)
+		if err == nil {
+			b.mu.Lock()
+			b.failures = 0
+			b.mu.Unlock()
+			return n, nil
+		}
+		if b.classifier.Classify(err) != pipe.Transient {
+			return n, err
+		}
+
+		b.mu.Lock()
+		b.failures++
+		open := b.failures >= b.threshold
+		b.mu.Unlock()
+
+		wait := b.probe
+		if !open {
+			// back off exponentially, getting to the probe interval as the breaker opens, but
+			// never busy-looping on a threshold too far off for the shift
+			wait = b.probe >> min(max(b.threshold-retries, 0), 62)
+			wait = max(wait, min(minBackoff, b.probe))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-b.done:
+			timer.Stop()
+			return n, ErrBreakerOpen
+		case <-ctx.Done():
+			timer.Stop()
+			return n, ctx.Err()
+		}
+	}
+}
+
+// State reports whether the breaker is currently open or closed.
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= b.threshold {
+		return BreakerOpen
+	}
+	return BreakerClosed
+}
+
+// Close gives up on any writes blocked on the open breaker (they return ErrBreakerOpen)
+// and stops probing. Subsequent failures are returned immediately.
+func (b *breaker) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return nil
+}
@@ -1,6 +1,17 @@
 package io
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// ErrBufferExhausted is what Acquire fails with when it gives up waiting for a buffer
+// (see Limit), along with why.
+var ErrBufferExhausted = errors.New("no buffer to be had")
 
 // Buffer is basically a sync.Pool except a) objects can't get evicted and b) there's
 // a soft limit on the number of objects that can be allocated at once
@@ -14,13 +25,21 @@ func NewBuffer(bufferSize, poolSize int) Buffer {
 }
 
 type pooledBuffer struct {
-	pool chan []byte
-	size int
+	pool  chan []byte
+	size  int
+	alloc func(size int) []byte // make by default
 }
 
 func (b *pooledBuffer) Put(buff []byte) {
+	if cap(buff) != b.size {
+		// not one of ours (or not anymore): it'd only make for short reads if smaller, and
+		// weigh more than the others if larger (e.g. regions merged by Coalesce)
+		return
+	}
+
+	// sinks put back the region data, which may have been shortened
 	select {
-	case b.pool <- buff:
+	case b.pool <- buff[:b.size]:
 	default:
 	}
 }
@@ -30,10 +49,150 @@ func (b *pooledBuffer) Get() []byte {
 	case buff := <-b.pool:
 		return buff
 	default:
+		if b.alloc != nil {
+			return b.alloc(b.size)
+		}
 		return make([]byte, b.size)
 	}
 }
 
+// Release is for sinks and valves done with r, to hand its buffer back to buff, or to
+// release it if r is lent (see pipe.Lend), in which case the buffer is handed back once
+// every stage holding on to the region has released it. A nil buff only releases r, for
+// stages whose regions didn't come from a buffer of theirs.
+func Release(buff Buffer, r pipe.Region) {
+	if !r.Release() && buff != nil {
+		buff.Put(r.Data)
+	}
+}
+
+// ChannelCapacity returns a capacity for each of the n connectors of a pipe whose regions
+// come from buff (see pipe.WithChannelCapacity), such that the regions waiting in all of
+// them hold no more than half of its pool, leaving the other half to the stages working
+// on regions. It's 1 at least, and 1 for buffers with no pool to speak of.
+func ChannelCapacity(buff Buffer, n int) int {
+	var pool int
+	switch b := buff.(type) {
+	case *pooledBuffer:
+		pool = cap(b.pool)
+	case *limitedBuffer:
+		if b.size > 0 {
+			pool = int(b.max / b.size)
+		}
+	}
+	return max(pool/2/max(n, 1), 1)
+}
+
+// Limit wraps a Buffer with a semaphore on the total number of bytes currently checked
+// out: Get blocks until enough bytes have been released by Put. Buffers that weren't
+// handed out by Get are ignored by Put, rather than release the charge of another. Since
+// sinks release buffers once their regions have been written, this bounds the amount of
+// data held in regions between the source and the sink - regardless of how many stages
+// or how much channel buffering there is in between.
+//
+// A single buffer larger than max is still handed out when nothing else is checked out,
+// otherwise it could never be acquired at all. Sources should get their buffers with
+// Acquire, so they don't wait on the limit past the end of the run.
+func Limit(buff Buffer, max int64) Buffer {
+	l := &limitedBuffer{
+		buff:     buff,
+		max:      max,
+		charged:  make(map[*byte]int64),
+		released: make(chan struct{}),
+	}
+	if b, ok := buff.(*pooledBuffer); ok {
+		l.size = int64(b.size)
+	}
+	return l
+}
+
+// Acquire gets a buffer from b, giving up once ctx is done if b has to wait for one (see
+// Limit).
+func Acquire(ctx context.Context, b Buffer) ([]byte, error) {
+	if l, ok := b.(*limitedBuffer); ok {
+		return l.acquire(ctx)
+	}
+	return b.Get(), nil
+}
+
+type limitedBuffer struct {
+	buff Buffer
+	max  int64
+
+	mu       sync.Mutex
+	inUse    int64
+	size     int64           // what the next buffer is expected to weigh
+	charged  map[*byte]int64 // what each checked out buffer was charged
+	released chan struct{}   // closed (and replaced) whenever bytes are released
+}
+
+func (b *limitedBuffer) Get() []byte {
+	buff, _ := b.acquire(context.Background())
+	return buff
+}
+
+func (b *limitedBuffer) acquire(ctx context.Context) ([]byte, error) {
+	b.mu.Lock()
+	size := b.size
+	for b.inUse > 0 && b.inUse+size > b.max {
+		released := b.released
+		b.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrBufferExhausted, ctx.Err())
+		}
+		b.mu.Lock()
+	}
+	// charge before allocating, so that waiting on the limit doesn't hold memory
+	b.inUse += size
+	b.mu.Unlock()
+
+	buff := b.buff.Get()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// settle the difference if the buffer didn't weigh what was expected
+	actual := int64(cap(buff))
+	b.inUse += actual - size
+	b.size = actual
+	if actual > 0 {
+		b.charged[first(buff)] = actual
+	}
+
+	return buff, nil
+}
+
+func (b *limitedBuffer) Put(buff []byte) {
+	b.mu.Lock()
+	key := first(buff)
+	charge, ok := b.charged[key]
+	if !ok {
+		// not one that was handed out (e.g. a valve swapped in its own, having handed
+		// back the one it replaced): releasing a charge for it would release that of a
+		// buffer still in use
+		b.mu.Unlock()
+		return
+	}
+	delete(b.charged, key)
+	b.inUse -= charge
+	close(b.released)
+	b.released = make(chan struct{})
+	b.mu.Unlock()
+
+	b.buff.Put(buff)
+}
+
+// first identifies a buffer by its first byte, whatever its length
+func first(buff []byte) *byte {
+	if cap(buff) == 0 {
+		return nil
+	}
+	return &buff[:1][0]
+}
+
 // sync.Pool-based implementation just for comparison (the memory usage tends to
 // be multiple scales of magnititude higher than the channel-based implementation
 // though in the bench results, presumably because the pool size is unlimited and
@@ -0,0 +1,96 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// bytesRegion is the size of the regions BytesSource slices its data into
+const bytesRegion = 64 * pipe.KiB
+
+// BytesSource implements pipe.Source and produces the data in regions, for small
+// transfers (and tests) that don't warrant a file. The regions point straight into data,
+// which must not be changed until the pipe is done with it.
+func BytesSource(data []byte) pipe.Source {
+	return &bytesSource{data: data}
+}
+
+type bytesSource struct {
+	data []byte
+}
+
+func (s *bytesSource) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	for off := 0; off < len(s.data); off += bytesRegion {
+		end := min(off+bytesRegion, len(s.data))
+		select {
+		case sink <- pipe.Region{Data: s.data[off:end:end], Off: int64(off)}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// BytesSink implements pipe.Sink and writes regions to memory, at their offsets (growing
+// the buffer as needed). It's also an io.WriterAt, so it can be used with Sink or Pool.
+//
+// Regions read into a Buffer (by Source, say) have to be handed back to it once they're
+// copied, or a Limit on it never lifts: see From.
+func BytesSink() *bytesSink {
+	return &bytesSink{}
+}
+
+type bytesSink struct {
+	mu   sync.Mutex
+	data []byte
+	buff Buffer
+}
+
+// From has the sink put every region back into buff once it's copied.
+func (s *bytesSink) From(buff Buffer) *bytesSink {
+	s.buff = buff
+	return s
+}
+
+func (s *bytesSink) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	for {
+		data, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+
+		// can't fail, but this takes care of the reporting
+		_ = writeAll(ctx, s, data, nil)
+		if !data.Release() && s.buff != nil {
+			s.buff.Put(data.Data)
+		}
+	}
+
+	errs <- ctx.Err()
+}
+
+func (s *bytesSink) WriteAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(s.data)) {
+		s.data = append(s.data, make([]byte, end-int64(len(s.data)))...)
+	}
+	return copy(s.data[off:], p), nil
+}
+
+// Bytes returns a copy of what's been written so far; gaps between regions are zeroed.
+func (s *bytesSink) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return bytes.Clone(s.data)
+}
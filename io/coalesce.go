@@ -0,0 +1,110 @@
+package io
+
+import (
+	"context"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Coalesce returns a Valve that merges contiguous regions (each one picking up where the
+// one before it left off) into regions of up to size bytes, so that a sink paying a fixed
+// cost per write (an object store, an RPC) is handed fewer and larger ones. A region is
+// handed on once the next one doesn't pick up where it left off or wouldn't fit, or once
+// the stream is over; regions of size bytes or more, and empty ones, go through as they
+// are.
+//
+// Merged regions are copied into a buffer of their own, not got from buff: the buffers
+// merged into it are handed back to buff as they're copied, and the merged one is dropped
+// once the sink releases it. Regions carry on with the scope and metadata of the first
+// region merged into them. Regions held when the pipe is canceled are handed back too.
+func Coalesce(size int, buff Buffer) pipe.Valve {
+	return &coalesce{size: size, buff: buff}
+}
+
+type coalesce struct {
+	size int
+	buff Buffer
+}
+
+func (c *coalesce) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+		defer close(sink)
+
+		var (
+			pending pipe.Region
+			merged  bool // whether pending's data is a buffer of our own
+		)
+		flush := func() bool {
+			if len(pending.Data) == 0 {
+				return true
+			}
+			select {
+			case sink <- pending:
+			case <-ctx.Done():
+				Release(c.buff, pending)
+				return false
+			}
+			pending, merged = pipe.Region{}, false
+			return true
+		}
+
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				if ctx.Err() == nil {
+					flush()
+				} else if len(pending.Data) > 0 {
+					Release(c.buff, pending)
+				}
+				return
+			}
+			if len(r.Data) == 0 {
+				// nothing to merge
+				select {
+				case sink <- r:
+				case <-ctx.Done():
+					Release(c.buff, r)
+					if len(pending.Data) > 0 {
+						Release(c.buff, pending)
+					}
+					return
+				}
+				continue
+			}
+
+			end := pending.Off + int64(len(pending.Data))
+			if len(pending.Data) > 0 && (r.Off != end || len(pending.Data)+len(r.Data) > c.size) {
+				if !flush() {
+					Release(c.buff, r)
+					return
+				}
+			}
+
+			switch {
+			case len(pending.Data) == 0:
+				// held as it is, in case nothing comes along to merge it with
+				pending = r
+				if len(r.Data) >= c.size && !flush() {
+					return
+				}
+			case !merged:
+				data := make([]byte, 0, c.size)
+				data = append(append(data, pending.Data...), r.Data...)
+				Release(c.buff, pending)
+				// lent to nobody, for the lease of the buffer just handed back not to be
+				// released again along with the merged region
+				pending = pipe.Lend(pending, func([]byte) {})
+				pending.Data = data
+				merged = true
+				Release(c.buff, r)
+			default:
+				pending.Data = append(pending.Data, r.Data...)
+				Release(c.buff, r)
+			}
+		}
+	}()
+
+	return source
+}
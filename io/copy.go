@@ -0,0 +1,504 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// ErrVerify is returned when the destination of a copy doesn't match what was written
+// to it.
+var ErrVerify = errors.New("destination doesn't match what was written")
+
+// CopyOption configures CopyFile, Download and Upload.
+//
+// The helpers live in this package rather than in pipe itself since they're assembled
+// from the components here, and this package already depends on pipe.
+type CopyOption func(*copyConfig)
+
+type copyConfig struct {
+	shards     int
+	writers    int
+	bufferSize int
+	inFlight   int64
+	verify     bool
+	client     *http.Client
+	creds      Credentials
+
+	valves   []pipe.Valve
+	opts     []pipe.Option
+	fileOpts []FileOption
+
+	digestAlg crypto.Hash
+	digestSum []byte
+	resume    string
+}
+
+func newCopyConfig(opts []CopyOption) *copyConfig {
+	c := &copyConfig{
+		shards:     4,
+		writers:    4,
+		bufferSize: pipe.MiB,
+		inFlight:   64 * pipe.MiB,
+		verify:     true,
+		client:     http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Shards sets the number of shards of the source read at once (4 by default). Shards
+// are at most 64MiB, so large sources are read in more shards than that, one after the
+// other.
+func Shards(n int) CopyOption {
+	return func(c *copyConfig) {
+		c.shards = max(n, 1)
+	}
+}
+
+// Writers sets the number of concurrent writers to the destination (4 by default).
+func Writers(n int) CopyOption {
+	return func(c *copyConfig) {
+		c.writers = max(n, 1)
+	}
+}
+
+// BufferSize sets the size of the regions read from the source (1MiB by default).
+func BufferSize(n int) CopyOption {
+	return func(c *copyConfig) {
+		c.bufferSize = n
+	}
+}
+
+// InFlight bounds the bytes held in regions at once (64MiB by default), see Limit.
+func InFlight(n int64) CopyOption {
+	return func(c *copyConfig) {
+		c.inFlight = n
+	}
+}
+
+// SkipVerify skips reading the destination back once the copy is done. By default the
+// checksum of every region is recorded on the way, and the destination is checked
+// against them (see Scrub).
+func SkipVerify() CopyOption {
+	return func(c *copyConfig) {
+		c.verify = false
+	}
+}
+
+// Client sets the HTTP client of Download and Upload (http.DefaultClient by default).
+func Client(client *http.Client) CopyOption {
+	return func(c *copyConfig) {
+		c.client = client
+	}
+}
+
+// Authorize has the requests of Download and Upload authorized by creds (see
+// Credentials): downloads go on with fresh tokens, however long they take. An upload is
+// a single request, streamed as the file is read, so it can't be sent again if its token
+// is refused; it's authorized with a token good for now (see RefreshingCredentials).
+func Authorize(creds Credentials) CopyOption {
+	return func(c *copyConfig) {
+		c.creds = creds
+	}
+}
+
+// Via has the data go through the valves on its way to the destination.
+func Via(valves ...pipe.Valve) CopyOption {
+	return func(c *copyConfig) {
+		c.valves = append(c.valves, valves...)
+	}
+}
+
+// PipeOptions applies the options to the pipe doing the copy.
+func PipeOptions(opts ...pipe.Option) CopyOption {
+	return func(c *copyConfig) {
+		c.opts = append(c.opts, opts...)
+	}
+}
+
+// ExpectDigest fails the copy if the digest of what's copied isn't sum (see
+// pipe.WithExpectedDigest), the way a download is checked against its published sha256.
+// CopyFile and Download remove the destination file when it doesn't match, so nothing
+// is left behind that could be mistaken for the real thing.
+//
+// A download resumed from where an earlier run left it (see ResumeFrom) only pipes what
+// was left, so the digest is computed by reading the file back once it's complete.
+func ExpectDigest(alg crypto.Hash, sum []byte) CopyOption {
+	return func(c *copyConfig) {
+		c.digestAlg, c.digestSum = alg, sum
+	}
+}
+
+// ResumeFrom keeps the progress of Download in a sidecar file at path (see Checkpoint),
+// so a download that was interrupted - the process exiting included - picks up where it
+// left off when run again with the same path, rather than starting over. The rest is
+// only fetched from the version of the resource the progress was saved for (see
+// IfRange): if the resource changed in the meantime, the download starts over. The
+// sidecar is removed once the download is done.
+//
+// Resuming takes a server that supports ranged requests, and a resource with a
+// validator (a strong ETag or a Last-Modified date); without those, downloads are
+// started over every time.
+func ResumeFrom(path string) CopyOption {
+	return func(c *copyConfig) {
+		c.resume = path
+	}
+}
+
+// checkpointInterval is how often the progress of a resumable download is saved
+const checkpointInterval = time.Second
+
+// FileOptions opens the destination file of CopyFile and Download with the options (see
+// OpenFile).
+func FileOptions(opts ...FileOption) CopyOption {
+	return func(c *copyConfig) {
+		c.fileOpts = append(c.fileOpts, opts...)
+	}
+}
+
+func (c *copyConfig) buffer() Buffer {
+	if newFileConfig(c.fileOpts).direct {
+		return Limit(NewAlignedBuffer(c.bufferSize, c.writers+c.shards), c.inFlight)
+	}
+	return Limit(NewBuffer(c.bufferSize, c.writers+c.shards), c.inFlight)
+}
+
+// maxShardSize bounds the shards of a copy, so there are shards left to pick up if
+// readers are added along the way
+const maxShardSize = 64 * pipe.MiB
+
+// shard splits [0, size) into the configured number of shards (or more, see
+// maxShardSize), and reads them with a source made for each
+func (c *copyConfig) shard(size int64, source func(off, n int64) pipe.Source) pipe.Source {
+	shards := int64(c.shards)
+	shardSize := min(max((size+shards-1)/shards, 1), maxShardSize)
+	if newFileConfig(c.fileOpts).direct {
+		// so the regions of every shard are aligned
+		shardSize = (shardSize + Alignment - 1) / Alignment * Alignment
+	}
+
+	if shardSize >= size {
+		return source(0, size)
+	}
+	return Sharded(size, shardSize, c.shards, source)
+}
+
+// shardRanges shards each of the ranges (see shard), reading them one after the other
+func (c *copyConfig) shardRanges(ranges []pipe.Range, source func(off, n int64) pipe.Source) pipe.Source {
+	sources := make([]pipe.Source, len(ranges))
+	for i, r := range ranges {
+		sources[i] = c.shard(r.Len, func(off, n int64) pipe.Source {
+			return source(r.Off+off, n)
+		})
+	}
+	if len(sources) == 1 {
+		return sources[0]
+	}
+
+	f := pipe.Fan(sources...)
+	f.SetConcurrency(1)
+	return f
+}
+
+// pipeOptions returns the options of the pipe of a copy, the digest included unless it's
+// to be checked otherwise
+func (c *copyConfig) pipeOptions(digest bool) []pipe.Option {
+	if !digest || c.digestAlg == 0 {
+		return c.opts
+	}
+	return append(slices.Clip(c.opts), pipe.WithExpectedDigest(c.digestAlg, c.digestSum))
+}
+
+// toFile runs the copy from source to the file at path, verifying it if need be. If pr
+// is set, the copy picks up from there (see ResumeFrom).
+func (c *copyConfig) toFile(ctx context.Context, path string, size int64, source pipe.Source, buff Buffer, pr *Progress) error {
+	resumed := pr != nil && len(pr.Written) > 0
+	flag := os.O_RDWR | os.O_CREATE
+	if !resumed {
+		flag |= os.O_TRUNC
+	}
+
+	// shards land all over the file, allocating it up front keeps it in one piece
+	opts := append([]FileOption{Preallocate(size)}, c.fileOpts...)
+	dst, err := OpenFile(path, flag, 0o666, opts...)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := dst.Truncate(size); err != nil {
+		return err
+	}
+
+	writers := make([]io.WriterAt, c.writers)
+	for i := range writers {
+		writers[i] = dst
+	}
+
+	var m *Manifest
+	valves := slices.Clone(c.valves)
+	if c.verify {
+		m = &Manifest{}
+		if resumed && pr.Manifest != nil {
+			m = pr.Manifest
+		}
+		valves = append(valves, Record(m))
+	}
+
+	p := pipe.New(source, Pool(buff, writers...), valves...).With(c.pipeOptions(!resumed)...)
+	if pr != nil {
+		pr.Manifest = m
+		stop := pr.Durable(dst).Checkpoint(c.resume, p, checkpointInterval)
+		err = p.Pipe(ctx)
+		if serr := stop(); err == nil {
+			err = serr
+		}
+	} else {
+		err = p.Pipe(ctx)
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err == nil && resumed && c.digestAlg != 0 {
+		err = checkDigest(dst, size, c.digestAlg, c.digestSum)
+	}
+	if err != nil {
+		if errors.Is(err, pipe.ErrDigestMismatch) {
+			return errors.Join(err, c.discard(dst, path))
+		}
+		return err
+	}
+
+	if c.verify {
+		damaged, err := Scrub(ctx, dst, m)
+		if err != nil {
+			return err
+		}
+		if len(damaged) > 0 {
+			return fmt.Errorf("%w: %d regions differ, the first at offset=%d", ErrVerify, len(damaged), damaged[0].Off)
+		}
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if pr != nil {
+		// done, there's nothing left to resume
+		return os.Remove(c.resume)
+	}
+	return nil
+}
+
+// discard removes the destination file at path (and the progress of copying it), so
+// nothing is left behind that could be mistaken for the real thing
+func (c *copyConfig) discard(dst *file, path string) error {
+	dst.Close()
+	err := os.Remove(path)
+	if c.resume != "" {
+		if rerr := os.Remove(c.resume); !errors.Is(rerr, os.ErrNotExist) {
+			err = errors.Join(err, rerr)
+		}
+	}
+	return err
+}
+
+// checkDigest reads the n bytes of r back, and checks that their digest is sum
+func checkDigest(r io.ReaderAt, n int64, alg crypto.Hash, sum []byte) error {
+	if !alg.Available() {
+		return fmt.Errorf("digest %v isn't linked into the binary", alg)
+	}
+	h := alg.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, n)); err != nil {
+		return err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, sum) {
+		return &pipe.DigestError{Alg: alg, Want: sum, Got: got}
+	}
+	return nil
+}
+
+// CopyFile copies the file at src to dst (creating or truncating it), reading it in
+// shards and writing it with several writers, and verifies the copy once done. The
+// defaults can be changed with opts.
+func CopyFile(ctx context.Context, dst, src string, opts ...CopyOption) error {
+	c := newCopyConfig(opts)
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	buff := c.buffer()
+	source := c.shard(info.Size(), func(off, n int64) pipe.Source {
+		return Source(io.NewSectionReader(f, off, n), off, buff)
+	})
+
+	return c.toFile(ctx, dst, info.Size(), source, buff, nil)
+}
+
+// Download downloads the resource at url to the file at path (creating or truncating
+// it, unless resuming, see ResumeFrom), and verifies the copy once done. If the server
+// supports ranged requests the resource is downloaded in shards, otherwise in one go.
+func Download(ctx context.Context, url, path string, opts ...CopyOption) error {
+	c := newCopyConfig(opts)
+
+	resp, err := do(ctx, c.client, c.creds, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	buff := c.buffer()
+	size := resp.ContentLength
+	ranged := size >= 0 && resp.Header.Get("Accept-Ranges") == "bytes"
+	if !ranged {
+		c.shards = 1
+	}
+
+	// every shard is fetched from the version of the resource the HEAD request saw, so
+	// the download isn't stitched together from two of them
+	id := identify(url, resp)
+	pr, err := c.progress(path, id, ranged)
+	if err != nil {
+		return err
+	}
+
+	var source pipe.Source
+	switch {
+	case !ranged:
+		source = HTTPSource(c.client, url, 0, -1, buff).Credentials(c.creds)
+	case pr != nil:
+		source = c.shardRanges(pr.Remaining(), func(off, n int64) pipe.Source {
+			return HTTPSource(c.client, url, off, n, buff).IfRange(id).Credentials(c.creds)
+		})
+	default:
+		source = c.shard(size, func(off, n int64) pipe.Source {
+			return HTTPSource(c.client, url, off, n, buff).IfRange(id).Credentials(c.creds)
+		})
+	}
+
+	return c.toFile(ctx, path, max(size, 0), source, buff, pr)
+}
+
+// progress returns the progress of the download to path to pick up from, if it can be
+// resumed (see ResumeFrom): what was saved for the version of the resource identified by
+// id if anything was, or else a fresh start
+func (c *copyConfig) progress(path string, id Identity, ranged bool) (*Progress, error) {
+	if c.resume == "" || !ranged || id.validator() == "" {
+		return nil, nil
+	}
+
+	pr, err := LoadProgress(c.resume, id)
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, ErrStaleProgress):
+		return NewProgress(id), nil
+	case err != nil:
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		// what was written is gone
+		return NewProgress(id), nil
+	}
+	return pr, nil
+}
+
+// Upload uploads the file at path to url, with a PUT request. The upload is a single
+// stream, so the file is read in one go rather than in shards, and there's no
+// verification (the destination can't be read back). The Content-Type of the upload is
+// told from the start of what's uploaded (see Detect).
+func Upload(ctx context.Context, path, url string, opts ...CopyOption) error {
+	c := newCopyConfig(opts)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	body, w := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+
+	buff := c.buffer()
+	detect := Detect()
+	valves := append(slices.Clone(c.valves), detect)
+	p := pipe.New(Source(f, 0, buff), StreamSink(w, 0, buff), valves...).With(c.pipeOptions(true)...)
+
+	piped := make(chan error, 1)
+	go func() {
+		err := p.Pipe(ctx)
+		w.CloseWithError(err)
+		piped <- err
+	}()
+
+	format, ok := detect.Wait(ctx)
+	if !ok {
+		body.CloseWithError(context.Canceled)
+		if err := <-piped; err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+	req.Header.Set("Content-Type", format.MIME)
+	if c.creds != nil {
+		tok, err := c.creds.Token(ctx)
+		if err != nil {
+			body.CloseWithError(err)
+			<-piped
+			return err
+		}
+		authorize(req, tok)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		body.CloseWithError(err)
+		<-piped
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// the server may have answered before reading the whole body, report why rather
+		// than the pipe failing to write to it
+		err := statusError(resp)
+		body.CloseWithError(err)
+		<-piped
+		return err
+	}
+	return <-piped
+}
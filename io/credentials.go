@@ -0,0 +1,151 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Token is a credential requests to remote storage are authorized with.
+type Token struct {
+	// Type is the scheme of the Authorization header, Bearer if empty.
+	Type  string
+	Value string
+	// Expiry is when the token stops being good, the zero time if it doesn't.
+	Expiry time.Time
+}
+
+// Credentials hand out the tokens requests to remote storage are authorized with, so
+// transfers outlasting a token (multi-hour transfers, with tokens good for an hour) go on
+// with fresh ones rather than failing halfway.
+type Credentials interface {
+	// Token returns a token good for a request about to be made.
+	Token(ctx context.Context) (Token, error)
+	// Refresh returns a token other than stale, which the server refused (as it expired
+	// sooner than expected, or was revoked).
+	Refresh(ctx context.Context, stale Token) (Token, error)
+}
+
+// RefreshingCredentials returns Credentials getting tokens from fetch, and fetching a new
+// one once the current one is within margin of expiring, or is refused. Requests made at
+// the same time share the same fetch.
+func RefreshingCredentials(fetch func(ctx context.Context) (Token, error), margin time.Duration) Credentials {
+	return &refreshing{fetch: fetch, margin: margin}
+}
+
+type refreshing struct {
+	fetch  func(ctx context.Context) (Token, error)
+	margin time.Duration
+
+	mu       sync.Mutex
+	tok      Token
+	fetching chan struct{} // closed once the fetch under way is done, nil if there's none
+}
+
+func (c *refreshing) Token(ctx context.Context) (Token, error) {
+	return c.get(ctx, nil)
+}
+
+func (c *refreshing) Refresh(ctx context.Context, stale Token) (Token, error) {
+	return c.get(ctx, &stale)
+}
+
+// get returns the current token, unless it's stale or about to expire, in which case it
+// fetches another one (or waits for the fetch under way)
+func (c *refreshing) get(ctx context.Context, stale *Token) (Token, error) {
+	for {
+		c.mu.Lock()
+		if c.good(stale) {
+			tok := c.tok
+			c.mu.Unlock()
+			return tok, nil
+		}
+		if fetching := c.fetching; fetching != nil {
+			c.mu.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return Token{}, ctx.Err()
+			}
+		}
+		fetching := make(chan struct{})
+		c.fetching = fetching
+		c.mu.Unlock()
+
+		tok, err := c.fetch(ctx)
+
+		c.mu.Lock()
+		if err == nil {
+			c.tok = tok
+		}
+		c.fetching = nil
+		close(fetching)
+		c.mu.Unlock()
+
+		if err != nil {
+			return Token{}, fmt.Errorf("error fetching credentials: %w", err)
+		}
+		return tok, nil
+	}
+}
+
+// good returns whether the current token is good to use, other than stale; c.mu must be
+// held
+func (c *refreshing) good(stale *Token) bool {
+	switch {
+	case c.tok.Value == "":
+		return false
+	case stale != nil && stale.Value == c.tok.Value:
+		return false
+	case c.tok.Expiry.IsZero():
+		return true
+	default:
+		return time.Until(c.tok.Expiry) > c.margin
+	}
+}
+
+// authorize sets the Authorization header of req to tok
+func authorize(req *http.Request, tok Token) {
+	typ := tok.Type
+	if typ == "" {
+		typ = "Bearer"
+	}
+	req.Header.Set("Authorization", typ+" "+tok.Value)
+}
+
+// refused returns whether the server refused the credentials of a request
+func refused(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+}
+
+// do sends the request made by newReq with client, authorized by creds (unless it's nil).
+// A request refused is made and sent again once, with a refreshed token.
+func do(ctx context.Context, client *http.Client, creds Credentials, newReq func() (*http.Request, error)) (*http.Response, error) {
+	var tok Token
+	for refreshed := false; ; refreshed = true {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		if creds != nil {
+			if refreshed {
+				tok, err = creds.Refresh(ctx, tok)
+			} else {
+				tok, err = creds.Token(ctx)
+			}
+			if err != nil {
+				return nil, err
+			}
+			authorize(req, tok)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil || creds == nil || refreshed || !refused(resp) {
+			return resp, err
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,254 @@
+package io
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Keyring hands out the keys regions are encrypted with, by ID, so that data encrypted
+// with a key that's been rotated out since can still be decrypted.
+type Keyring interface {
+	Key(id string) ([]byte, error)
+}
+
+// Keys is a Keyring held in memory: AES keys (16, 24 or 32 bytes) by ID.
+type Keys map[string][]byte
+
+func (k Keys) Key(id string) ([]byte, error) {
+	key, ok := k[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// ErrUnknownKey is returned by Keys for IDs it doesn't have.
+var ErrUnknownKey = errors.New("unknown key")
+
+// A frame holds a region encrypted with AES-GCM, along with what it takes to decrypt it:
+//
+//	magic (4) | key ID length (1) | key ID | offset (8) | nonce (12) | sealed length (4) | sealed
+//
+// where the offset is the region's offset in the plaintext stream, and everything up to
+// the sealed data is authenticated along with it.
+var frameMagic = [4]byte{'P', 'F', 'R', '1'}
+
+const (
+	nonceSize = 12
+	// maxSealed bounds the sealed data of a frame, so a corrupted length can't have
+	// Decrypt allocate whatever it says
+	maxSealed = 1 << 30
+)
+
+// Encrypt returns a Valve that encrypts every region with the key of the given ID in
+// ring, into a frame of its own. The frames make up a stream of their own, at offsets
+// starting at 0 in the order the regions come in (which needn't be their order in the
+// plaintext); Decrypt puts the plaintext back at its original offsets. Regions are handed
+// back to buff once encrypted; the frames are larger than its buffers, so they don't come
+// from it (and mustn't be handed back to a Limit on it).
+//
+// The key ID goes into every frame, so an archive can be decrypted after the keys are
+// rotated, as long as the ring still has the old ones; re-encrypting with a new key is a
+// Decrypt followed by an Encrypt.
+func Encrypt(ring Keyring, id string, buff Buffer) pipe.Valve {
+	return &encrypt{ring: ring, id: id, buff: buff}
+}
+
+type encrypt struct {
+	ring Keyring
+	id   string
+	buff Buffer
+}
+
+func (e *encrypt) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+		defer close(sink)
+
+		aead, err := newAEAD(e.ring, e.id)
+		if err == nil && len(e.id) > 255 {
+			err = fmt.Errorf("key ID %q is too long", e.id)
+		}
+		if err != nil {
+			reject(ctx, source, errs, e.buff, fmt.Errorf("encrypting: %w", err))
+			return
+		}
+
+		var off int64
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				return
+			}
+
+			frame, err := seal(aead, e.id, r)
+			Release(e.buff, r)
+			if err != nil {
+				reject(ctx, source, errs, e.buff, fmt.Errorf("encrypting: %w", err))
+				return
+			}
+
+			select {
+			case sink <- pipe.Region{Data: frame, Off: off, Scope: r.Scope, Meta: r.Meta}:
+			case <-ctx.Done():
+				return
+			}
+			off += int64(len(frame))
+		}
+	}()
+
+	return source
+}
+
+func newAEAD(ring Keyring, id string) (cipher.AEAD, error) {
+	key, err := ring.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, id string, r pipe.Region) ([]byte, error) {
+	sealed := len(r.Data) + aead.Overhead()
+	header := make([]byte, 0, len(frameMagic)+1+len(id)+8+nonceSize+4)
+	header = append(header, frameMagic[:]...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	header = binary.BigEndian.AppendUint64(header, uint64(r.Off))
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	header = binary.BigEndian.AppendUint32(header, uint32(sealed))
+
+	frame := make([]byte, len(header), len(header)+sealed)
+	copy(frame, header)
+	return aead.Seal(frame, nonce, r.Data, header), nil
+}
+
+// Decrypt returns a Valve that decrypts the frames made by Encrypt, with the keys of ring,
+// into regions at their offsets in the plaintext stream. The frames have to come in as a
+// stream, in order from offset 0 (the way they were written, however they're split up
+// into regions); regions are handed back to buff once decrypted.
+func Decrypt(ring Keyring, buff Buffer) pipe.Valve {
+	return &decrypt{ring: ring, buff: buff}
+}
+
+type decrypt struct {
+	ring Keyring
+	buff Buffer
+}
+
+func (d *decrypt) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+		defer close(sink)
+
+		keys := make(map[string]cipher.AEAD)
+		var (
+			pending []byte // what's been taken in and not decrypted yet
+			next    int64
+		)
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				if len(pending) > 0 && ctx.Err() == nil {
+					reject(ctx, source, errs, d.buff, errors.New("decrypting: stream ends mid-frame"))
+				}
+				return
+			}
+
+			if r.Off != next {
+				Release(d.buff, r)
+				reject(ctx, source, errs, d.buff, fmt.Errorf("decrypting: %w: region at offset %d (expected %d)", pipe.ErrOutOfOrder, r.Off, next))
+				return
+			}
+			next += int64(len(r.Data))
+			pending = append(pending, r.Data...)
+			Release(d.buff, r)
+
+			consumed := false
+			for {
+				plain, n, err := d.open(keys, pending)
+				if err != nil {
+					reject(ctx, source, errs, d.buff, fmt.Errorf("decrypting: %w", err))
+					return
+				}
+				if n == 0 {
+					break
+				}
+				pending, consumed = pending[n:], true
+
+				plain.Scope = r.Scope
+				select {
+				case sink <- plain:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if consumed {
+				// don't hold on to the frames already decrypted
+				pending = append([]byte(nil), pending...)
+			}
+		}
+	}()
+
+	return source
+}
+
+// open decrypts the frame at the start of b, returning how much of b it took up, or 0 if
+// b doesn't hold all of it yet
+func (d *decrypt) open(keys map[string]cipher.AEAD, b []byte) (pipe.Region, int, error) {
+	if len(b) < len(frameMagic)+1 {
+		return pipe.Region{}, 0, nil
+	}
+	if [4]byte(b[:4]) != frameMagic {
+		return pipe.Region{}, 0, errors.New("not an encrypted frame")
+	}
+
+	idLen := int(b[4])
+	headerLen := len(frameMagic) + 1 + idLen + 8 + nonceSize + 4
+	if len(b) < headerLen {
+		return pipe.Region{}, 0, nil
+	}
+	id := string(b[5 : 5+idLen])
+	off := int64(binary.BigEndian.Uint64(b[5+idLen:]))
+	nonce := b[5+idLen+8 : 5+idLen+8+nonceSize]
+	sealed := int(binary.BigEndian.Uint32(b[headerLen-4:]))
+	if sealed > maxSealed {
+		return pipe.Region{}, 0, fmt.Errorf("frame of %d bytes is too large", sealed)
+	}
+	if len(b) < headerLen+sealed {
+		return pipe.Region{}, 0, nil
+	}
+
+	aead, ok := keys[id]
+	if !ok {
+		var err error
+		if aead, err = newAEAD(d.ring, id); err != nil {
+			return pipe.Region{}, 0, err
+		}
+		keys[id] = aead
+	}
+
+	plain, err := aead.Open(nil, nonce, b[headerLen:headerLen+sealed], b[:headerLen])
+	if err != nil {
+		return pipe.Region{}, 0, fmt.Errorf("frame at offset %d: %w", off, err)
+	}
+	return pipe.Region{Data: plain, Off: off}, headerLen + sealed, nil
+}
@@ -0,0 +1,238 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Codec is a compression format Decompress can recognize by its magic bytes and undo.
+type Codec struct {
+	Name  string
+	Magic []byte
+	// NewReader decompresses what's read from r; nil if there's no decoder at hand, in
+	// which case data in the format is reported rather than passed through.
+	NewReader func(r io.Reader) (io.Reader, error)
+}
+
+var (
+	Gzip = Codec{
+		Name:      "gzip",
+		Magic:     []byte{0x1f, 0x8b},
+		NewReader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	}
+	Bzip2 = Codec{
+		Name:      "bzip2",
+		Magic:     []byte("BZh"),
+		NewReader: func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+	}
+	// Zstd and Xz have no decoder in the standard library: pass Decompress a Codec with
+	// the same magic and a NewReader of your choosing to handle them.
+	Zstd = Codec{Name: "zstd", Magic: []byte{0x28, 0xb5, 0x2f, 0xfd}}
+	Xz   = Codec{Name: "xz", Magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}}
+)
+
+// ErrNoDecoder is reported by Decompress for data in a format it recognizes but has no
+// decoder for.
+var ErrNoDecoder = errors.New("no decoder for the compression format")
+
+// Decompress returns a Valve that sniffs the magic bytes at the start of the stream and
+// decompresses it accordingly, so a pipe doesn't need to know in advance how its source
+// is encoded. Gzip, Bzip2, Zstd and Xz are recognized; codecs, if given, are tried first
+// (to plug in decoders for Zstd or Xz, say). Data in none of the formats goes through
+// untouched.
+//
+// The regions coming out make up the decompressed stream, at offsets of its own starting
+// at 0, with data taken from buff; the compressed regions are handed back to buff as
+// they're consumed. Decompressing needs the compressed stream in order from offset 0, so
+// the valve has to be fed by a single sequential source rather than shards of one.
+func Decompress(buff Buffer, codecs ...Codec) pipe.Valve {
+	return &decompress{buff: buff, codecs: append(codecs, Gzip, Bzip2, Zstd, Xz)}
+}
+
+type decompress struct {
+	buff   Buffer
+	codecs []Codec
+}
+
+// sniff is how many bytes it takes to tell the formats apart
+func (d *decompress) sniff() int {
+	n := 0
+	for _, c := range d.codecs {
+		n = max(n, len(c.Magic))
+	}
+	return n
+}
+
+func (d *decompress) detect(head []byte) (Codec, bool) {
+	for _, c := range d.codecs {
+		if bytes.HasPrefix(head, c.Magic) {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+func (d *decompress) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+
+		first, more := pipe.Next(ctx, source)
+		if !more {
+			close(sink)
+			return
+		}
+		if first.Off != 0 {
+			close(sink)
+			Release(d.buff, first)
+			reject(ctx, source, errs, d.buff, fmt.Errorf("decompressing: %w: stream starts at offset %d rather than 0", pipe.ErrOutOfOrder, first.Off))
+			return
+		}
+
+		if _, ok := d.detect(first.Data); !ok && len(first.Data) >= d.sniff() {
+			// plain data, as far as can be told
+			d.pass(ctx, first, source, sink)
+			return
+		}
+		d.decode(ctx, first, source, sink, errs)
+	}()
+
+	return source
+}
+
+// pass passes regions on untouched
+func (d *decompress) pass(ctx context.Context, first pipe.Region, source, sink chan pipe.Region) {
+	defer close(sink)
+
+	for r, more := first, true; more; r, more = pipe.Next(ctx, source) {
+		select {
+		case sink <- r:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// decode feeds the compressed stream to the decoder, and passes on what comes out of it
+func (d *decompress) decode(ctx context.Context, first pipe.Region, source, sink chan pipe.Region, errs chan error) {
+	pr, pw := io.Pipe()
+	fed := make(chan error, 1)
+	go func() {
+		fed <- d.feed(ctx, first, source, pw)
+	}()
+
+	err := d.drain(ctx, pr, sink)
+	pr.Close()
+	if ferr := <-fed; err == nil {
+		err = ferr
+	}
+
+	close(sink)
+	if err != nil && ctx.Err() == nil {
+		errs <- fmt.Errorf("decompressing: %w", err)
+	}
+}
+
+// feed writes the compressed regions to w in order, handing them back to the buffer as
+// they're consumed
+func (d *decompress) feed(ctx context.Context, first pipe.Region, source chan pipe.Region, w *io.PipeWriter) error {
+	next := int64(0)
+	for r, more := first, true; more; r, more = pipe.Next(ctx, source) {
+		if r.Off != next {
+			err := fmt.Errorf("%w: region at offset %d (expected %d)", pipe.ErrOutOfOrder, r.Off, next)
+			w.CloseWithError(err)
+			Release(d.buff, r)
+			go d.discard(source)
+			return err
+		}
+
+		_, err := w.Write(r.Data)
+		Release(d.buff, r)
+		if err != nil {
+			// the decoder is done, with or without the rest of the stream
+			go d.discard(source)
+			return nil
+		}
+		next += int64(len(r.Data))
+	}
+
+	if err := ctx.Err(); err != nil {
+		w.CloseWithError(err)
+		return nil
+	}
+	return w.Close()
+}
+
+// drain detects the format of what's read from r and passes on the decompressed stream
+func (d *decompress) drain(ctx context.Context, r io.Reader, sink chan pipe.Region) error {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(d.sniff())
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	var dec io.Reader = br
+	if c, ok := d.detect(head); ok {
+		if c.NewReader == nil {
+			return fmt.Errorf("%w: %s", ErrNoDecoder, c.Name)
+		}
+		if dec, err = c.NewReader(br); err != nil {
+			return err
+		}
+	}
+
+	var off int64
+	for {
+		data, err := Acquire(ctx, d.buff)
+		if err != nil {
+			return nil
+		}
+
+		n, err := fill(dec, data)
+		if n > 0 {
+			select {
+			case sink <- pipe.Region{Data: data[:n], Off: off}:
+			case <-ctx.Done():
+				d.buff.Put(data)
+				return nil
+			}
+			off += int64(n)
+		} else {
+			d.buff.Put(data)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// fill reads into data until it's full or r fails, unlike io.ReadFull telling the end of
+// the stream (io.EOF) from a stream cut short (as a decoder reports it)
+func fill(r io.Reader, data []byte) (int, error) {
+	n := 0
+	for n < len(data) {
+		m, err := r.Read(data[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (d *decompress) discard(source chan pipe.Region) {
+	for r := range source {
+		Release(d.buff, r)
+	}
+}
@@ -0,0 +1,143 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// ErrDuplicate is what sinks fail duplicates with (see Dedup) when they can't copy their
+// data from the region they duplicate (see ResolveDuplicates), rather than write nothing
+// in their place.
+var ErrDuplicate = errors.New("duplicate region")
+
+// Ref is what Dedup hands on in place of a region whose content went through before:
+// where the region it duplicates was, in the stream.
+type Ref struct {
+	Digest Digest
+	Off    int64
+	Len    int64
+}
+
+// Duplicate is the key of the Ref of a region whose data Dedup dropped.
+var Duplicate = pipe.NewKey[Ref]("io.duplicate")
+
+// Dedup returns a Valve that fingerprints every region (see ChunkDigest) and looks it up
+// in index, dropping the data of regions whose content is indexed already and handing on
+// in their place an empty region at the same offset, whose Duplicate is where the region
+// with the same content was written. A dedup-aware sink copies the data from there rather
+// than have it sent over again (see ResolveDuplicates), which goes a long way with highly
+// redundant data (VM images, say); other sinks fail duplicates with ErrDuplicate.
+//
+// Regions that aren't duplicates are indexed at their offset, so the index is that of the
+// destination: the regions indexed by earlier runs are taken to be where those runs wrote
+// them, and every region adds a reference to its chunk. Regions are compared by content
+// alone, whatever their offset. The data of duplicates is handed back to buff.
+func Dedup(index *ChunkIndex, buff Buffer) pipe.Valve {
+	return &dedup{index: index, buff: buff}
+}
+
+type dedup struct {
+	index *ChunkIndex
+	buff  Buffer
+}
+
+func (d *dedup) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+		defer close(sink)
+
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				return
+			}
+
+			if len(r.Data) > 0 {
+				ref, dup, err := d.lookup(r)
+				if err != nil {
+					Release(d.buff, r)
+					reject(ctx, source, errs, d.buff, fmt.Errorf("deduplicating: %w", err))
+					return
+				}
+				if dup {
+					Release(d.buff, r)
+					r.Data = nil
+					r = Duplicate.Set(r, ref)
+				}
+			}
+
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// lookup indexes the content of r, and returns where it was written if it's a duplicate
+func (d *dedup) lookup(r pipe.Region) (Ref, bool, error) {
+	digest := ChunkDigest(r.Data)
+	c, dup, err := d.index.Put(digest, int64(len(r.Data)), strconv.FormatInt(r.Off, 10))
+	if err != nil || !dup {
+		return Ref{}, false, err
+	}
+
+	off, err := strconv.ParseInt(c.Location, 10, 64)
+	if err != nil {
+		return Ref{}, false, fmt.Errorf("chunk %v isn't at an offset: %q", digest, c.Location)
+	}
+	return Ref{Digest: digest, Off: off, Len: c.Size}, true, nil
+}
+
+// ResolveDuplicates has the sink write duplicates (see Dedup) by copying the data of the
+// region they duplicate from where it was written, rather than fail them. The destination
+// must have a ReadAt method, as files do; the data is copied by way of buffers of the
+// sink's Buffer.
+func ResolveDuplicates() SinkOption {
+	return func(s *sink) {
+		s.resolve = true
+	}
+}
+
+// copyDuplicate reads the data of the region ref refers to back from w, into a buffer
+// acquired from buff, to be handed back to it once written
+func copyDuplicate(ctx context.Context, w io.WriterAt, buff Buffer, ref Ref) ([]byte, error) {
+	ra, ok := w.(io.ReaderAt)
+	if !ok {
+		return nil, fmt.Errorf("can't read duplicates back from %T", w)
+	}
+
+	data, err := Acquire(ctx, buff)
+	if err != nil {
+		return nil, err
+	}
+	if int64(cap(data)) < ref.Len {
+		buff.Put(data)
+		data = make([]byte, ref.Len)
+	}
+	data = data[:ref.Len]
+
+	if n, err := ra.ReadAt(data, ref.Off); n < len(data) {
+		buff.Put(data)
+		return nil, fmt.Errorf("error reading duplicate of offset=%d: %w", ref.Off, err)
+	}
+	return data, nil
+}
+
+// unresolved returns ErrDuplicate if r is a duplicate (see Dedup), for sinks that write
+// its data as is
+func unresolved(r pipe.Region) error {
+	if ref, ok := Duplicate.Get(r); ok {
+		return fmt.Errorf("%w at offset=%d of offset=%d", ErrDuplicate, r.Off, ref.Off)
+	}
+	return nil
+}
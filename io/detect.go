@@ -0,0 +1,183 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Format is what Detect makes of the start of a stream.
+type Format struct {
+	// MIME is the media type of the stream, "application/octet-stream" if there's no
+	// telling (see net/http.DetectContentType).
+	MIME string
+	// Kind names the disk image or archive format of the stream, if it's one of those:
+	// "qcow2", "vhd", "vhdx", "vmdk", "iso9660", "zip", "tar", "7z", "gzip", "bzip2",
+	// "zstd" or "xz".
+	Kind string
+}
+
+// sniffLen is how much of the start of a stream Detect looks at: enough to take in the
+// volume descriptor of an ISO 9660 image, the furthest in of the signatures it knows
+const sniffLen = 0x8001 + 5
+
+// signatures are the disk image and archive formats Detect knows, by their signature and
+// where it's found
+var signatures = []struct {
+	kind  string
+	mime  string
+	off   int
+	magic []byte
+}{
+	{kind: "qcow2", magic: []byte("QFI\xfb")},
+	{kind: "vhd", magic: []byte("conectix")},
+	{kind: "vhdx", magic: []byte("vhdxfile")},
+	{kind: "vmdk", magic: []byte("KDMV")},
+	{kind: "iso9660", mime: "application/x-iso9660-image", off: 0x8001, magic: []byte("CD001")},
+	{kind: "zip", mime: "application/zip", magic: []byte("PK\x03\x04")},
+	{kind: "tar", mime: "application/x-tar", off: 257, magic: []byte("ustar")},
+	{kind: "7z", mime: "application/x-7z-compressed", magic: []byte("7z\xbc\xaf\x27\x1c")},
+	{kind: Gzip.Name, mime: "application/gzip", magic: Gzip.Magic},
+	{kind: Bzip2.Name, mime: "application/x-bzip2", magic: Bzip2.Magic},
+	{kind: Zstd.Name, mime: "application/zstd", magic: Zstd.Magic},
+	{kind: Xz.Name, mime: "application/x-xz", magic: Xz.Magic},
+}
+
+// DetectFormat tells the Format of a stream from its start (see Detect).
+func DetectFormat(head []byte) Format {
+	f := Format{MIME: http.DetectContentType(head)}
+	for _, s := range signatures {
+		if len(head) >= s.off && bytes.HasPrefix(head[s.off:], s.magic) {
+			f.Kind = s.kind
+			if s.mime != "" {
+				f.MIME = s.mime
+			}
+			break
+		}
+	}
+	return f
+}
+
+// Detect returns a Valve that tells the Format of the stream going through it from its
+// first bytes, so that a sink (an object store, say) can label what it writes without
+// reading the start of the stream separately beforehand. Regions at the start of the
+// stream are held back until the format is known; the rest go straight through. The
+// format is available as soon as it's known (see Wait), and once the run is over.
+//
+// A detector is good for a single run.
+func Detect() *detector {
+	return &detector{detected: make(chan struct{})}
+}
+
+type detector struct {
+	detected chan struct{} // closed once the format is known, or the run is over
+
+	once   sync.Once
+	format Format
+	ok     bool
+}
+
+func (d *detector) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+		defer close(sink)
+		defer d.settle(Format{}, false)
+
+		var (
+			head    = make([]byte, sniffLen)
+			covered int // bytes of head filled in
+			held    []pipe.Region
+		)
+		for d.pending() {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				if ctx.Err() == nil {
+					d.settle(DetectFormat(prefix(head, held)), true)
+				}
+				break
+			}
+
+			if r.Off >= sniffLen {
+				if !send(ctx, sink, r) {
+					return
+				}
+				continue
+			}
+
+			covered += copy(head[r.Off:], r.Data)
+			held = append(held, r)
+			if covered >= sniffLen {
+				d.settle(DetectFormat(head), true)
+			}
+		}
+
+		for _, r := range held {
+			if !send(ctx, sink, r) {
+				return
+			}
+		}
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more || !send(ctx, sink, r) {
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// prefix returns the part of head that's been filled in from the start, by the regions
+// held (which don't overlap)
+func prefix(head []byte, held []pipe.Region) []byte {
+	end := int64(0)
+	for grown := true; grown; {
+		grown = false
+		for _, r := range held {
+			if r.Off <= end && r.Off+int64(len(r.Data)) > end {
+				end, grown = r.Off+int64(len(r.Data)), true
+			}
+		}
+	}
+	return head[:min(end, int64(len(head)))]
+}
+
+func send(ctx context.Context, sink chan pipe.Region, r pipe.Region) bool {
+	select {
+	case sink <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (d *detector) pending() bool {
+	select {
+	case <-d.detected:
+		return false
+	default:
+		return true
+	}
+}
+
+func (d *detector) settle(f Format, ok bool) {
+	d.once.Do(func() {
+		d.format, d.ok = f, ok
+		close(d.detected)
+	})
+}
+
+// Wait blocks until the format of the stream is known, and returns it. It returns false
+// if the run ended before the format could be told, or ctx is done first.
+func (d *detector) Wait(ctx context.Context) (Format, bool) {
+	select {
+	case <-d.detected:
+		return d.format, d.ok
+	case <-ctx.Done():
+		return Format{}, false
+	}
+}
@@ -0,0 +1,296 @@
+package io
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Encoder is a compression format Compress can encode regions in.
+type Encoder struct {
+	Name string
+	// NewWriter compresses what's written to w, with the dictionary dict unless it's nil.
+	NewWriter func(w io.Writer, dict []byte) (io.WriteCloser, error)
+}
+
+// Deflate encodes regions as raw deflate, with the dictionary as its preset history.
+// Zstd has no encoder in the standard library: pass Compress an Encoder of your choosing
+// to use it (its dictionaries make the most of training, see Trainer).
+var Deflate = Encoder{
+	Name: "deflate",
+	NewWriter: func(w io.Writer, dict []byte) (io.WriteCloser, error) {
+		return flate.NewWriterDict(w, flate.DefaultCompression, dict)
+	},
+}
+
+// Trainer builds a dictionary of up to size bytes out of samples of the data to be
+// compressed (zstd.BuildDict, say).
+type Trainer func(samples [][]byte, size int) ([]byte, error)
+
+// ConcatTrainer builds a dictionary out of the samples themselves, as many of them as
+// fit. It's the best formats with no dictionary format of their own (deflate) can do: the
+// dictionary is history for the compressor to find matches in.
+func ConcatTrainer(samples [][]byte, size int) ([]byte, error) {
+	dict := make([]byte, 0, size)
+	for _, s := range slices.Backward(samples) {
+		if len(dict)+len(s) > size {
+			continue
+		}
+		dict = append(dict, s...)
+	}
+	return dict, nil
+}
+
+// Dictionary is a compression dictionary trained on samples of the regions of pipes. A
+// dictionary makes for far better ratios when a lot of small, similar objects (VM image
+// deltas, records, messages...) are compressed on their own, as each of them has too
+// little data to find matches in, but they have much in common.
+//
+// A dictionary can be trained by a dedicated pass, sampling the regions of a pipe (see
+// Sample) before being trained (see Train), or by the compression valve itself, on the
+// first regions of a run (see Compress). Once trained, it can be stored (see Bytes) so
+// that what's compressed with it can be decompressed, and loaded again (see Load).
+type Dictionary struct {
+	size   int
+	sample int64
+	train  Trainer
+
+	mu      sync.Mutex
+	samples [][]byte
+	sampled int64 // bytes in samples
+	seen    int   // regions offered
+	dict    []byte
+	trained bool
+}
+
+// NewDictionary returns a dictionary of up to size bytes, to be trained by train (by
+// ConcatTrainer if nil) on up to sample bytes of regions.
+func NewDictionary(size int, sample int64, train Trainer) *Dictionary {
+	if train == nil {
+		train = ConcatTrainer
+	}
+	return &Dictionary{size: size, sample: sample, train: train}
+}
+
+// Sample returns a valve sampling the regions going through it for the dictionary. Once
+// it has sampled enough, regions replace the samples at random (reservoir sampling), so
+// the samples stand for the whole of the run rather than its start.
+func (d *Dictionary) Sample() pipe.Valve {
+	return pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		d.add(r.Data)
+		return r, nil
+	})
+}
+
+// add samples data
+func (d *Dictionary) add(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.seen++
+	if d.sampled < d.sample {
+		d.samples = append(d.samples, bytes.Clone(data))
+		d.sampled += int64(len(data))
+		return
+	}
+	if i := rand.IntN(d.seen); i < len(d.samples) {
+		d.sampled += int64(len(data) - len(d.samples[i]))
+		d.samples[i] = bytes.Clone(data)
+	}
+}
+
+// full returns whether the dictionary has sampled enough to be trained
+func (d *Dictionary) full() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.sampled >= d.sample
+}
+
+// Train trains the dictionary on the samples so far.
+func (d *Dictionary) Train() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.trainLocked()
+}
+
+// trainLocked trains the dictionary; d.mu must be held
+func (d *Dictionary) trainLocked() error {
+	if len(d.samples) == 0 {
+		return errors.New("no samples to train the dictionary on")
+	}
+	dict, err := d.train(d.samples, d.size)
+	if err != nil {
+		return err
+	}
+	d.dict, d.trained = dict, true
+	return nil
+}
+
+// ensure trains the dictionary unless it's been trained already, and returns it
+func (d *Dictionary) ensure() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.trained {
+		if err := d.trainLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return d.dict, nil
+}
+
+// Trained returns whether the dictionary has been trained (or loaded).
+func (d *Dictionary) Trained() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.trained
+}
+
+// Bytes returns the dictionary, nil until it's trained.
+func (d *Dictionary) Bytes() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.dict
+}
+
+// Load sets the dictionary to dict, as returned by Bytes.
+func (d *Dictionary) Load(dict []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dict, d.trained = dict, true
+}
+
+// Compress returns a Valve compressing the data of every region on its own, with enc and
+// the dictionary d (nil for none). The regions coming out carry a frame each, at the
+// offset of the region it's made from, so they make up a set of frames (the objects of a
+// pack, messages...) rather than a stream: each is decompressed on its own, with the same
+// dictionary. The data of the regions compressed is handed back to buff.
+//
+// If d isn't trained yet, the valve trains it on the first regions of the run: they're
+// held back until enough of them have been sampled (or the stream ends), so every region
+// of the run is compressed with the same dictionary.
+func Compress(buff Buffer, enc Encoder, d *Dictionary) pipe.Valve {
+	return &compress{buff: buff, enc: enc, d: d}
+}
+
+type compress struct {
+	buff Buffer
+	enc  Encoder
+	d    *Dictionary
+}
+
+func (c *compress) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+		defer close(sink)
+
+		var (
+			dict []byte
+			held []pipe.Region // waiting for the dictionary to be trained
+		)
+		ready := c.d == nil || c.d.Trained()
+		if ready && c.d != nil {
+			dict = c.d.Bytes()
+		}
+
+		pass := func(r pipe.Region) bool {
+			out, err := c.frame(r.Data, dict)
+			if err != nil {
+				pipe.Fail(ctx, r, err)
+				errs <- err
+				return false
+			}
+			Release(c.buff, r)
+			r.Data = out
+			select {
+			case sink <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		train := func() bool {
+			var err error
+			if dict, err = c.d.ensure(); err != nil && len(held) > 0 {
+				c.discard(held)
+				errs <- err
+				return false
+			}
+			ready = true
+			for i, r := range held {
+				if !pass(r) {
+					c.discard(held[i+1:])
+					return false
+				}
+			}
+			held = nil
+			return true
+		}
+
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				break
+			}
+
+			if ready {
+				if !pass(r) {
+					return
+				}
+				continue
+			}
+
+			c.d.add(r.Data)
+			held = append(held, r)
+			if c.d.full() && !train() {
+				return
+			}
+		}
+
+		if ctx.Err() == nil && !ready {
+			train()
+		}
+	}()
+
+	return source
+}
+
+// frame compresses data with the dictionary
+func (c *compress) frame(data, dict []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w, err := c.enc.NewWriter(&out, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// discard hands back the data of the regions that won't be passed on
+func (c *compress) discard(regions []pipe.Region) {
+	for _, r := range regions {
+		Release(c.buff, r)
+	}
+}
@@ -0,0 +1,240 @@
+package io
+
+import (
+	"errors"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// Alignment is what offsets, lengths and buffers of unbuffered I/O must be multiples of:
+// the size of a sector (or a page) on any disk worth mentioning.
+const Alignment = 4096
+
+// FileOption configures a file opened with OpenFile.
+type FileOption func(*fileConfig)
+
+type fileConfig struct {
+	direct     bool
+	sparse     bool
+	allocate   int64
+	sequential bool
+	dropBehind bool
+}
+
+func newFileConfig(opts []FileOption) *fileConfig {
+	c := &fileConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Unbuffered has the file bypass the page cache where the platform and filesystem
+// support it (O_DIRECT on Linux, F_NOCACHE on macOS, FILE_FLAG_NO_BUFFERING on Windows),
+// so copying hundreds of GBs doesn't evict everything else from it. Unbuffered I/O must be
+// aligned (see Alignment): aligned reads and writes bypass the cache, while the
+// others (the tail of a file, say) go through it as usual. Regions from a Buffer made
+// with NewAlignedBuffer are aligned, as long as they're read at aligned offsets.
+func Unbuffered() FileOption {
+	return func(c *fileConfig) {
+		c.direct = true
+	}
+}
+
+// Sparse marks the file as sparse, so that the ranges never written to (once the file
+// is extended with Truncate, say) take up no space. That's the way of files on most
+// Unix filesystems already; on Windows, it takes asking.
+func Sparse() FileOption {
+	return func(c *fileConfig) {
+		c.sparse = true
+	}
+}
+
+// Preallocate has the first size bytes of the file allocated on disk up front where the
+// platform and filesystem support it (fallocate on Linux, F_PREALLOCATE on macOS, the
+// allocation size on Windows), so a copy runs out of space right away rather than
+// halfway through, and the file isn't fragmented by writes landing out of order. The
+// size of the file is left as it is.
+func Preallocate(size int64) FileOption {
+	return func(c *fileConfig) {
+		c.allocate = size
+	}
+}
+
+// Sequential advises the kernel that the file is read from start to end, so that it
+// reads ahead further (posix_fadvise SEQUENTIAL), and that what's after every read is
+// about to be read next (WILLNEED). That's only on Linux; it's just advice anyway.
+func Sequential() FileOption {
+	return func(c *fileConfig) {
+		c.sequential = true
+	}
+}
+
+// DropBehind has what's read from or written to the file dropped from the page cache
+// right after, so a copy of hundreds of GBs doesn't evict what the rest of the host keeps
+// in there. Written data can't be dropped before it's on disk, so every write waits for
+// it to be (which Pool hides by writing with several writers). It's the same idea as
+// Unbuffered, without the alignment. That's only on Linux.
+func DropBehind() FileOption {
+	return func(c *fileConfig) {
+		c.dropBehind = true
+	}
+}
+
+// fileAdvice is advice to the kernel about the use of a file, see Sequential and
+// DropBehind
+type fileAdvice int
+
+const (
+	adviseSequential fileAdvice = iota
+	adviseWillNeed
+	adviseDontNeed
+)
+
+// handle is an open file, the way the platform does it
+type handle interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenFile opens the file at path like os.OpenFile, for the sources and sinks of a pipe
+// to read and write at offsets. The file can be written to from several writers at once
+// (see Pool) on any platform: on Windows, writes are overlapped rather than serialized
+// as they are with an os.File.
+func OpenFile(path string, flag int, perm os.FileMode, opts ...FileOption) (*file, error) {
+	c := newFileConfig(opts)
+
+	cached, err := openHandle(path, flag, perm, false)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{name: path, cached: cached, sequential: c.sequential, dropBehind: c.dropBehind}
+	if c.sequential {
+		advise(cached, 0, 0, adviseSequential)
+	}
+
+	if c.sparse {
+		if err := sparse(cached); err != nil {
+			f.Close()
+			return nil, &os.PathError{Op: "sparse", Path: path, Err: err}
+		}
+	}
+
+	if c.allocate > 0 {
+		if err := preallocate(cached, c.allocate); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			f.Close()
+			return nil, &os.PathError{Op: "preallocate", Path: path, Err: err}
+		}
+	}
+
+	if c.direct {
+		// the file is created (or truncated) by now, the second handle only opens it
+		f.direct, err = openHandle(path, flag&^(os.O_CREATE|os.O_EXCL|os.O_TRUNC), perm, true)
+		if errors.Is(err, errors.ErrUnsupported) {
+			// not on this platform or filesystem, so it's all cached I/O
+			f.direct, err = nil, nil
+		} else if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+type file struct {
+	name   string
+	cached handle
+	direct handle // nil unless the file is unbuffered
+
+	sequential bool
+	dropBehind bool
+}
+
+// Name returns the path the file was opened with.
+func (f *file) Name() string {
+	return f.name
+}
+
+func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	h := f.handle(b, off)
+	n, err := h.ReadAt(b, off)
+	if h == f.cached && n > 0 {
+		if f.sequential {
+			advise(h, off+int64(n), int64(n), adviseWillNeed)
+		}
+		if f.dropBehind {
+			advise(h, off, int64(n), adviseDontNeed)
+		}
+	}
+	return n, err
+}
+
+func (f *file) WriteAt(b []byte, off int64) (int, error) {
+	h := f.handle(b, off)
+	n, err := h.WriteAt(b, off)
+	if err != nil || h != f.cached || !f.dropBehind || n == 0 {
+		return n, err
+	}
+
+	if err := writeBack(h, off, int64(n)); err != nil {
+		return n, &os.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+	advise(h, off, int64(n), adviseDontNeed)
+	return n, nil
+}
+
+// handle returns the handle to read or write b at off with
+func (f *file) handle(b []byte, off int64) handle {
+	if f.direct != nil && aligned(b, off) {
+		return f.direct
+	}
+	return f.cached
+}
+
+// Truncate changes the size of the file.
+func (f *file) Truncate(size int64) error {
+	return f.cached.Truncate(size)
+}
+
+// Sync commits what's been written to the file to stable storage.
+func (f *file) Sync() error {
+	if f.direct != nil {
+		if err := f.direct.Sync(); err != nil {
+			return err
+		}
+	}
+	return f.cached.Sync()
+}
+
+// Close closes the file.
+func (f *file) Close() error {
+	err := f.cached.Close()
+	if f.direct != nil {
+		err = errors.Join(err, f.direct.Close())
+	}
+	return err
+}
+
+func aligned(b []byte, off int64) bool {
+	return len(b) > 0 && off%Alignment == 0 && len(b)%Alignment == 0 &&
+		uintptr(unsafe.Pointer(unsafe.SliceData(b)))%Alignment == 0
+}
+
+// NewAlignedBuffer is like NewBuffer, except that the buffers are aligned for unbuffered
+// I/O (see Unbuffered): they start at a multiple of Alignment in memory, and their size
+// is rounded up to one.
+func NewAlignedBuffer(bufferSize, poolSize int) Buffer {
+	size := (bufferSize + Alignment - 1) / Alignment * Alignment
+	return &pooledBuffer{pool: make(chan []byte, poolSize), size: size, alloc: alignedAlloc}
+}
+
+func alignedAlloc(size int) []byte {
+	b := make([]byte, size+Alignment)
+	skip := (Alignment - int(uintptr(unsafe.Pointer(unsafe.SliceData(b)))%Alignment)) % Alignment
+	return b[skip : skip+size : skip+size]
+}
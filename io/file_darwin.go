@@ -0,0 +1,56 @@
+package io
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func openHandle(path string, flag int, perm os.FileMode, direct bool) (handle, error) {
+	f, err := os.OpenFile(path, flag, perm)
+	if err != nil || !direct {
+		return f, err
+	}
+
+	// there's no O_DIRECT here, caching is turned off on the open file instead
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_NOCACHE, 1); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "fcntl", Path: path, Err: err}
+	}
+	return f, nil
+}
+
+// files are sparse already (on APFS)
+func sparse(_ handle) error {
+	return nil
+}
+
+// preallocate allocates the file up to size from where it ends on disk, contiguously if
+// there's room
+func preallocate(h handle, size int64) error {
+	f := h.(*os.File)
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if size <= info.Size() {
+		return nil
+	}
+
+	store := unix.Fstore_t{
+		Flags:   unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  size - info.Size(),
+	}
+	if err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store); err == nil {
+		return nil
+	}
+
+	store.Flags = unix.F_ALLOCATEALL
+	err = unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	if errors.Is(err, unix.ENOTSUP) {
+		return errors.ErrUnsupported
+	}
+	return err
+}
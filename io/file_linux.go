@@ -0,0 +1,36 @@
+package io
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func openHandle(path string, flag int, perm os.FileMode, direct bool) (handle, error) {
+	if !direct {
+		return os.OpenFile(path, flag, perm)
+	}
+
+	f, err := os.OpenFile(path, flag|syscall.O_DIRECT, perm)
+	if errors.Is(err, syscall.EINVAL) {
+		// the filesystem doesn't do direct I/O (tmpfs, say)
+		return nil, errors.ErrUnsupported
+	}
+	return f, err
+}
+
+// files are sparse already
+func sparse(_ handle) error {
+	return nil
+}
+
+func preallocate(h handle, size int64) error {
+	f := h.(*os.File)
+	err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		return errors.ErrUnsupported
+	}
+	return err
+}
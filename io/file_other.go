@@ -0,0 +1,26 @@
+//go:build !linux && !windows && !darwin
+
+package io
+
+import (
+	"errors"
+	"os"
+)
+
+func openHandle(path string, flag int, perm os.FileMode, direct bool) (handle, error) {
+	if direct {
+		// no way to bypass the cache here (that this knows of)
+		return nil, errors.ErrUnsupported
+	}
+	return os.OpenFile(path, flag, perm)
+}
+
+// files are sparse already (or can't be)
+func sparse(_ handle) error {
+	return nil
+}
+
+// no way to preallocate here (that this knows of)
+func preallocate(_ handle, _ int64) error {
+	return errors.ErrUnsupported
+}
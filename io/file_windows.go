@@ -0,0 +1,158 @@
+package io
+
+import (
+	"errors"
+	"io"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// maxIO bounds a single ReadFile or WriteFile, whose lengths are 32 bits
+const maxIO = 1 << 30
+
+// winHandle is a file opened for overlapped I/O, so that reads and writes at offsets
+// run concurrently rather than one at a time, as they do with an os.File
+type winHandle struct {
+	h    windows.Handle
+	name string
+}
+
+func openHandle(path string, flag int, perm os.FileMode, direct bool) (handle, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	var access uint32
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		access = windows.GENERIC_READ
+	case os.O_WRONLY:
+		access = windows.GENERIC_WRITE
+	case os.O_RDWR:
+		access = windows.GENERIC_READ | windows.GENERIC_WRITE
+	}
+
+	var disposition uint32
+	switch {
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		disposition = windows.CREATE_NEW
+	case flag&(os.O_CREATE|os.O_TRUNC) == os.O_CREATE|os.O_TRUNC:
+		disposition = windows.CREATE_ALWAYS
+	case flag&os.O_CREATE == os.O_CREATE:
+		disposition = windows.OPEN_ALWAYS
+	case flag&os.O_TRUNC == os.O_TRUNC:
+		disposition = windows.TRUNCATE_EXISTING
+	default:
+		disposition = windows.OPEN_EXISTING
+	}
+
+	attrs := uint32(windows.FILE_ATTRIBUTE_NORMAL | windows.FILE_FLAG_OVERLAPPED)
+	if flag&os.O_CREATE != 0 && perm&0o200 == 0 {
+		attrs |= windows.FILE_ATTRIBUTE_READONLY
+	}
+	if direct {
+		attrs |= windows.FILE_FLAG_NO_BUFFERING | windows.FILE_FLAG_WRITE_THROUGH
+	}
+
+	// the cached and the unbuffered handles of a file are open side by side
+	share := uint32(windows.FILE_SHARE_READ | windows.FILE_SHARE_WRITE | windows.FILE_SHARE_DELETE)
+	h, err := windows.CreateFile(name, access, share, nil, disposition, attrs, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &winHandle{h: h, name: path}, nil
+}
+
+func (f *winHandle) ReadAt(b []byte, off int64) (int, error) {
+	var read int
+	for len(b) > 0 {
+		chunk := b[:min(len(b), maxIO)]
+		n, err := f.overlapped(off, func(ov *windows.Overlapped) error {
+			return windows.ReadFile(f.h, chunk, nil, ov)
+		})
+		read += int(n)
+		if errors.Is(err, windows.ERROR_HANDLE_EOF) || (err == nil && n == 0) {
+			return read, io.EOF
+		} else if err != nil {
+			return read, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		b, off = b[n:], off+int64(n)
+	}
+	return read, nil
+}
+
+func (f *winHandle) WriteAt(b []byte, off int64) (int, error) {
+	var written int
+	for len(b) > 0 {
+		chunk := b[:min(len(b), maxIO)]
+		n, err := f.overlapped(off, func(ov *windows.Overlapped) error {
+			return windows.WriteFile(f.h, chunk, nil, ov)
+		})
+		written += int(n)
+		if err != nil {
+			return written, &os.PathError{Op: "write", Path: f.name, Err: err}
+		}
+		b, off = b[n:], off+int64(n)
+	}
+	return written, nil
+}
+
+// overlapped starts the I/O at off and waits for it to complete, returning the number of
+// bytes transferred
+func (f *winHandle) overlapped(off int64, start func(ov *windows.Overlapped) error) (uint32, error) {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(event)
+
+	ov := &windows.Overlapped{Offset: uint32(off), OffsetHigh: uint32(off >> 32), HEvent: event}
+	if err := start(ov); err != nil && !errors.Is(err, windows.ERROR_IO_PENDING) {
+		return 0, err
+	}
+
+	var n uint32
+	err = windows.GetOverlappedResult(f.h, ov, &n, true)
+	return n, err
+}
+
+func (f *winHandle) Truncate(size int64) error {
+	if err := windows.Ftruncate(f.h, size); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *winHandle) Sync() error {
+	if err := windows.FlushFileBuffers(f.h); err != nil {
+		return &os.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *winHandle) Close() error {
+	if err := windows.CloseHandle(f.h); err != nil {
+		return &os.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// sparse marks the file as sparse (FSCTL_SET_SPARSE)
+func sparse(h handle) error {
+	f := h.(*winHandle)
+	_, err := f.overlapped(0, func(ov *windows.Overlapped) error {
+		var n uint32
+		return windows.DeviceIoControl(f.h, windows.FSCTL_SET_SPARSE, nil, 0, nil, 0, &n, ov)
+	})
+	return err
+}
+
+// preallocate sets the allocation size of the file, which leaves its end where it is
+func preallocate(h handle, size int64) error {
+	f := h.(*winHandle)
+	info := struct{ AllocationSize int64 }{size}
+	return windows.SetFileInformationByHandle(f.h, windows.FileAllocationInfo, (*byte)(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+}
@@ -0,0 +1,268 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// StatusError is what HTTP sources and sinks fail with when a server answers with a
+// status other than the one they expect. Server errors and throttling (5xx, 429, 408) are
+// transient, other statuses are permanent (see pipe.Classified).
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	if e.Method == http.MethodPut || e.Method == http.MethodPost {
+		return fmt.Sprintf("error uploading to %s: %s", e.URL, e.Status)
+	}
+	return fmt.Sprintf("error fetching %s: %s", e.URL, e.Status)
+}
+
+func (e *StatusError) Class() pipe.ErrorClass {
+	switch {
+	case e.StatusCode >= 500, e.StatusCode == http.StatusTooManyRequests, e.StatusCode == http.StatusRequestTimeout:
+		return pipe.Transient
+	}
+	return pipe.Permanent
+}
+
+// statusError returns the StatusError of resp
+func statusError(resp *http.Response) error {
+	return &StatusError{Method: resp.Request.Method, URL: resp.Request.URL.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// httpResumes is how many times an HTTP source picks a body that broke off back up
+const httpResumes = 3
+
+// HTTPSource implements pipe.Source and reads n bytes of the resource at url starting at
+// off, with a ranged GET (n < 0 reads up to the end of the resource). Several of them
+// can be combined with pipe.Fan to download a resource in shards.
+//
+// If the body breaks off (the connection drops, say), the rest is requested again, up to
+// a few times, as long as the resource has a validator (a strong ETag or a Last-Modified
+// date) to make sure the rest comes from the same version of it (see IfRange).
+func HTTPSource(client *http.Client, url string, off, n int64, buff Buffer, opts ...SourceOption) *httpSource {
+	return &httpSource{client: client, url: url, off: off, n: n, buff: buff, opts: opts}
+}
+
+type httpSource struct {
+	client *http.Client
+	url    string
+	off, n int64
+	id     Identity
+	creds  Credentials
+
+	buff Buffer
+	opts []SourceOption
+}
+
+// IfRange has the source read from the version of the resource identified by id only
+// (see HTTPIdentity), e.g. when resuming a download from progress saved by an earlier
+// run: ranges are requested with If-Range, and the source fails with ErrStaleProgress if
+// the resource changed since.
+func (s *httpSource) IfRange(id Identity) *httpSource {
+	s.id = id
+	return s
+}
+
+// Credentials has the requests of the source authorized by creds (see Credentials). A
+// request refused is made again with a refreshed token, and so is a body that broke off
+// halfway (as the server dropped the connection once the token expired, say), as long as
+// the resource has a validator.
+func (s *httpSource) Credentials(creds Credentials) *httpSource {
+	s.creds = creds
+	return s
+}
+
+// HTTPIdentity returns the Identity of the resource at url, as told by a HEAD request:
+// its size, Last-Modified date and ETag.
+func HTTPIdentity(ctx context.Context, client *http.Client, url string) (Identity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, statusError(resp)
+	}
+
+	return identify(url, resp), nil
+}
+
+// identify returns the Identity of the resource at url, as told by resp
+func identify(url string, resp *http.Response) Identity {
+	id := Identity{Name: url, Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		id.ModTime = t
+	}
+	return id
+}
+
+// validator returns what tells the version identified by id apart for If-Range: a strong
+// ETag (weak ones aren't allowed), or else the Last-Modified date; empty if there's none
+func (id Identity) validator() string {
+	if id.ETag != "" && !strings.HasPrefix(id.ETag, "W/") {
+		return id.ETag
+	}
+	if !id.ModTime.IsZero() {
+		return id.ModTime.UTC().Format(http.TimeFormat)
+	}
+	return ""
+}
+
+// changed returns whether got is a different version of the resource than id, as far as
+// they tell: by their ETags if they both have one, or else their Last-Modified dates
+func (id Identity) changed(got Identity) bool {
+	if id.ETag != "" && got.ETag != "" {
+		return got.ETag != id.ETag
+	}
+	return !id.ModTime.IsZero() && !got.ModTime.IsZero() && !got.ModTime.Equal(id.ModTime)
+}
+
+func (s *httpSource) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	end := int64(-1)
+	if s.n >= 0 {
+		end = s.off + s.n
+	}
+
+	body, id, err := s.get(ctx, s.off, end, s.id)
+	if err != nil {
+		close(sink)
+		errs <- err
+		return
+	}
+	r := &resumer{ctx: ctx, s: s, body: body, off: s.off, end: end, id: id, left: httpResumes}
+	defer r.Close()
+
+	// network bodies trickle in a few KiB at a time; fill each region to the brim rather
+	// than handing out a region per read
+	Source(fullReader{r}, s.off, s.buff, s.opts...).Write(ctx, sink, errs)
+}
+
+// get requests the resource from off up to end (the end of it if end < 0), from the
+// version identified by id if it's known. It returns the body, and the identity of the
+// resource as known by then.
+func (s *httpSource) get(ctx context.Context, off, end int64, id Identity) (io.ReadCloser, Identity, error) {
+	ranged := off > 0 || end >= 0
+	validator := id.validator()
+	resp, err := do(ctx, s.client, s.creds, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ranged {
+			last := ""
+			if end >= 0 {
+				last = fmt.Sprint(end - 1)
+			}
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", off, last))
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, id, err
+	}
+
+	switch {
+	case ranged && validator != "" && resp.StatusCode == http.StatusOK:
+		// the server sends the whole of the resource when it doesn't pass If-Range (or
+		// doesn't do ranges at all)
+		resp.Body.Close()
+		return nil, id, fmt.Errorf("%w: %s changed, or can't be fetched in ranges", ErrStaleProgress, s.url)
+	case ranged && resp.StatusCode != http.StatusPartialContent || !ranged && resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, id, statusError(resp)
+	}
+
+	got := identify(s.url, resp)
+	if id.changed(got) {
+		// for requests without If-Range (or servers that don't do it), that still tell
+		// the version they serve
+		resp.Body.Close()
+		return nil, id, fmt.Errorf("%w: %s changed", ErrStaleProgress, s.url)
+	}
+	if id.validator() == "" {
+		id.ETag, id.ModTime = got.ETag, got.ModTime
+	}
+
+	return resp.Body, id, nil
+}
+
+// resumer reads the body of an httpSource, requesting the rest of it again if it breaks
+// off, from the same version of the resource
+type resumer struct {
+	ctx  context.Context
+	s    *httpSource
+	body io.ReadCloser
+	off  int64 // where the body is at
+	end  int64 // where it ends, < 0 if at the end of the resource
+	id   Identity
+	left int // resumes left
+}
+
+func (r *resumer) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.off += int64(n)
+		switch {
+		case err == nil || errors.Is(err, io.EOF):
+			return n, err
+		case !r.resumable():
+			return n, fmt.Errorf("error reading %s at offset=%d: %w", r.s.url, r.off, err)
+		}
+
+		r.left--
+		r.body.Close()
+		body, _, gerr := r.s.get(r.ctx, r.off, r.end, r.id)
+		if gerr != nil {
+			r.body = http.NoBody
+			return n, fmt.Errorf("error resuming %s at offset=%d after %w: %w", r.s.url, r.off, err, gerr)
+		}
+		r.body = body
+
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resumable returns whether the body can be requested again after breaking off
+func (r *resumer) resumable() bool {
+	return r.left > 0 && r.ctx.Err() == nil && r.id.validator() != "" && (r.end < 0 || r.off < r.end)
+}
+
+func (r *resumer) Close() error {
+	return r.body.Close()
+}
+
+// fullReader reads until p is full (or the underlying reader is done)
+type fullReader struct {
+	r io.Reader
+}
+
+func (f fullReader) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(f.r, p)
+	if err == io.ErrUnexpectedEOF {
+		// the rest was short, the next Read reports io.EOF (errors of r itself come
+		// wrapped, so they're never mistaken for this one)
+		err = nil
+	}
+	return n, err
+}
@@ -0,0 +1,56 @@
+package io
+
+import "github.com/naylorpmax-joyent/pipe"
+
+// hugePage is the size of a (2MiB) huge page on the platforms that have them
+const hugePage = 2 * pipe.MiB
+
+// NewHugeBuffer is like NewBuffer, except that the pooled buffers are carved out of a
+// single arena backed by huge pages where the platform supports it (on Linux: explicit
+// hugetlb pages if any are reserved, transparent huge pages otherwise). With many GBs of
+// data in flight, that takes a lot of pressure off the TLB.
+//
+// Buffers handed out once the arena is exhausted come from the heap as usual, and are
+// dropped when put back so they never take the place of the arena's. The arena is only
+// released by Close, after which none of its buffers may be used anymore.
+func NewHugeBuffer(bufferSize, poolSize int) (*hugeBuffer, error) {
+	size := bufferSize * poolSize
+	size = (size + hugePage - 1) / hugePage * hugePage
+
+	arena, err := mapHuge(size)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &hugeBuffer{
+		pooledBuffer: pooledBuffer{pool: make(chan []byte, poolSize), size: bufferSize},
+		arena:        arena,
+		chunks:       make(map[*byte]bool, poolSize),
+	}
+	for i := 0; i < poolSize; i++ {
+		start, end := i*bufferSize, (i+1)*bufferSize
+		b.chunks[&arena[start]] = true
+		b.pool <- arena[start:end:end]
+	}
+
+	return b, nil
+}
+
+type hugeBuffer struct {
+	pooledBuffer
+	arena  []byte
+	chunks map[*byte]bool // the start of every buffer carved out of the arena
+}
+
+func (b *hugeBuffer) Put(buff []byte) {
+	if !b.chunks[first(buff)] {
+		// from the heap, or not one of ours at all
+		return
+	}
+	b.pooledBuffer.Put(buff)
+}
+
+// Close releases the arena.
+func (b *hugeBuffer) Close() error {
+	return unmapHuge(b.arena)
+}
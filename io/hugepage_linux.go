@@ -0,0 +1,27 @@
+package io
+
+import "golang.org/x/sys/unix"
+
+func mapHuge(size int) ([]byte, error) {
+	const prot = unix.PROT_READ | unix.PROT_WRITE
+	const flags = unix.MAP_PRIVATE | unix.MAP_ANONYMOUS
+
+	// explicit huge pages only work if the admin reserved some (vm.nr_hugepages)
+	b, err := unix.Mmap(-1, 0, size, prot, flags|unix.MAP_HUGETLB)
+	if err == nil {
+		return b, nil
+	}
+
+	b, err = unix.Mmap(-1, 0, size, prot, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	// transparent huge pages are best-effort (and may be disabled altogether)
+	_ = unix.Madvise(b, unix.MADV_HUGEPAGE)
+	return b, nil
+}
+
+func unmapHuge(b []byte) error {
+	return unix.Munmap(b)
+}
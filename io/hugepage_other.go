@@ -0,0 +1,11 @@
+//go:build !linux
+
+package io
+
+func mapHuge(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+func unmapHuge(_ []byte) error {
+	return nil
+}
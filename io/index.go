@@ -0,0 +1,383 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Digest identifies a chunk by its content (the SHA-256 of its data).
+type Digest [sha256.Size]byte
+
+// ChunkDigest returns the Digest of the data of a chunk.
+func ChunkDigest(data []byte) Digest {
+	return sha256.Sum256(data)
+}
+
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Digest) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(d) {
+		return fmt.Errorf("invalid digest %q", text)
+	}
+	_, err := hex.Decode(d[:], text)
+	return err
+}
+
+// Chunk is an entry of a ChunkIndex: where a chunk is stored, and how many times it's
+// referenced.
+type Chunk struct {
+	Digest Digest `json:"digest"`
+	Size   int64  `json:"size"`
+	// Location is where the chunk is stored, as understood by whoever stores it (a path,
+	// an object key, an offset in a pack...).
+	Location string `json:"location"`
+	Refs     int64  `json:"refs"`
+}
+
+// indexRecord is a line of the log of a ChunkIndex: the state of a chunk after a change
+// to it
+type indexRecord struct {
+	Chunk
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// compactAfter is how many superseded records the log of a ChunkIndex may hold before
+// it's compacted (as long as they're at least as many as the live ones)
+const compactAfter = 4096
+
+// ChunkIndex is a durable index of content-addressed chunks, counting the references to
+// every chunk so that chunks nobody refers to anymore can be collected. It's what makes
+// deduplication last across runs: chunks indexed by one run are found by the next.
+//
+// The index is kept in memory, and every change is appended to a log file as it's made,
+// so a crash loses nothing but the change being written (a torn last line is ignored
+// when the index is opened again). The log is compacted, rewriting it with just the live
+// chunks, once it's mostly made of superseded records, or when asked to. Changes reach
+// the disk on Sync and Close.
+type ChunkIndex struct {
+	mu         sync.Mutex
+	path       string
+	log        *os.File
+	chunks     map[Digest]*Chunk
+	superseded int // records in the log that a later one replaced
+}
+
+// OpenChunkIndex opens the index logged at path, creating it if there's none.
+func OpenChunkIndex(path string) (*ChunkIndex, error) {
+	x := &ChunkIndex{path: path, chunks: make(map[Digest]*Chunk)}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := x.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading chunk index: %w", err)
+	}
+	// drop whatever a crash left torn at the end, so appending starts on a fresh line
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	x.log = f
+	return x, nil
+}
+
+// replay applies the records of the log to the index, returning where the last complete
+// one ends
+func (x *ChunkIndex) replay(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	var end int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a line without its newline didn't make it to the log in full
+			return end, nil
+		}
+		if err != nil {
+			return end, err
+		}
+
+		var rec indexRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return end, fmt.Errorf("corrupt record at offset=%d: %w", end, err)
+		}
+		x.apply(rec)
+		end += int64(len(line))
+	}
+}
+
+// apply applies rec to the index
+func (x *ChunkIndex) apply(rec indexRecord) {
+	if _, ok := x.chunks[rec.Digest]; ok {
+		x.superseded++
+	}
+	if rec.Deleted {
+		if _, ok := x.chunks[rec.Digest]; ok {
+			// the deletion itself is dead weight once applied
+			x.superseded++
+		}
+		delete(x.chunks, rec.Digest)
+		return
+	}
+	c := rec.Chunk
+	x.chunks[c.Digest] = &c
+}
+
+// append logs rec and applies it to the index; x.mu must be held
+func (x *ChunkIndex) append(rec indexRecord) error {
+	if x.log == nil {
+		return os.ErrClosed
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := x.log.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("error logging chunk %v: %w", rec.Digest, err)
+	}
+	x.apply(rec)
+
+	if x.superseded > compactAfter && x.superseded >= len(x.chunks) {
+		return x.compact()
+	}
+	return nil
+}
+
+// Get returns the chunk with the digest, if it's indexed.
+func (x *ChunkIndex) Get(d Digest) (Chunk, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	c, ok := x.chunks[d]
+	if !ok {
+		return Chunk{}, false
+	}
+	return *c, true
+}
+
+// Put adds a reference to the chunk with the digest, indexing it (as stored at location)
+// if it isn't already. It returns the chunk as indexed, and whether it was already: in
+// which case the data is already stored at the chunk's location, and needn't be again.
+func (x *ChunkIndex) Put(d Digest, size int64, location string) (Chunk, bool, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	c, ok := x.chunks[d]
+	rec := indexRecord{Chunk: Chunk{Digest: d, Size: size, Location: location, Refs: 1}}
+	if ok {
+		rec.Chunk = *c
+		rec.Refs++
+	}
+	if err := x.append(rec); err != nil {
+		return Chunk{}, false, err
+	}
+	return rec.Chunk, ok, nil
+}
+
+// Release drops a reference to the chunk with the digest. Chunks left without references
+// stay indexed (and stored) until they're collected, so that a run adding them back in
+// the meantime finds them.
+func (x *ChunkIndex) Release(d Digest) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	c, ok := x.chunks[d]
+	if !ok {
+		return fmt.Errorf("chunk %v: %w", d, os.ErrNotExist)
+	}
+	if c.Refs == 0 {
+		return fmt.Errorf("chunk %v isn't referenced", d)
+	}
+
+	rec := indexRecord{Chunk: *c}
+	rec.Refs--
+	return x.append(rec)
+}
+
+// Collect removes the chunks nobody refers to from the index, calling remove first for
+// every one of them to delete its data. A chunk remove fails for stays indexed (to be
+// collected another time); the errors are joined. It returns the chunks collected.
+func (x *ChunkIndex) Collect(remove func(Chunk) error) ([]Chunk, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var (
+		collected []Chunk
+		errs      []error
+	)
+	for _, c := range x.sorted() {
+		if c.Refs > 0 {
+			continue
+		}
+		if err := remove(c); err != nil {
+			errs = append(errs, fmt.Errorf("error removing chunk %v: %w", c.Digest, err))
+			continue
+		}
+		if err := x.append(indexRecord{Chunk: c, Deleted: true}); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		collected = append(collected, c)
+	}
+	return collected, errors.Join(errs...)
+}
+
+// Len returns how many chunks are indexed.
+func (x *ChunkIndex) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return len(x.chunks)
+}
+
+// sorted returns copies of the chunks in order of digest; x.mu must be held
+func (x *ChunkIndex) sorted() []Chunk {
+	chunks := make([]Chunk, 0, len(x.chunks))
+	for _, d := range slices.SortedFunc(maps.Keys(x.chunks), func(a, b Digest) int { return bytes.Compare(a[:], b[:]) }) {
+		chunks = append(chunks, *x.chunks[d])
+	}
+	return chunks
+}
+
+// Compact rewrites the log with just the live chunks.
+func (x *ChunkIndex) Compact() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.log == nil {
+		return os.ErrClosed
+	}
+	return x.compact()
+}
+
+// compact rewrites the log with just the live chunks; x.mu must be held
+func (x *ChunkIndex) compact() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, c := range x.sorted() {
+		if err := enc.Encode(indexRecord{Chunk: c}); err != nil {
+			return err
+		}
+	}
+
+	// the log is closed first, as open files can't be renamed over everywhere; it's
+	// reopened either way, so a failed compaction leaves the index usable
+	if err := x.log.Close(); err != nil {
+		x.log = nil
+		return err
+	}
+	rerr := replaceFile(x.path, buf.Bytes())
+
+	f, err := os.OpenFile(x.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		x.log = nil
+		return errors.Join(rerr, err)
+	}
+	x.log = f
+	if rerr != nil {
+		return fmt.Errorf("error compacting chunk index: %w", rerr)
+	}
+	x.superseded = 0
+	return nil
+}
+
+// indexExport is the format of an exported ChunkIndex
+type indexExport struct {
+	Version int     `json:"version"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+// exportVersion is the version of the format written by ChunkIndex.WriteTo
+const exportVersion = 1
+
+// WriteTo exports the index (as JSON, the chunks in order of digest), so it can be moved
+// elsewhere, inspected or merged into another index with Import.
+func (x *ChunkIndex) WriteTo(w io.Writer) (int64, error) {
+	x.mu.Lock()
+	b, err := json.Marshal(indexExport{Version: exportVersion, Chunks: x.sorted()})
+	x.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Import merges an index exported by WriteTo into this one. References to chunks that
+// are indexed already add up (their location here is kept); other chunks are indexed as
+// exported.
+func (x *ChunkIndex) Import(r io.Reader) error {
+	var e indexExport
+	if err := json.NewDecoder(r).Decode(&e); err != nil {
+		return fmt.Errorf("error decoding chunk index: %w", err)
+	}
+	if e.Version != exportVersion {
+		return fmt.Errorf("unknown chunk index version %d", e.Version)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, c := range e.Chunks {
+		if have, ok := x.chunks[c.Digest]; ok {
+			c.Size, c.Location, c.Refs = have.Size, have.Location, have.Refs+c.Refs
+		}
+		if err := x.append(indexRecord{Chunk: c}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync commits the changes to the index to disk.
+func (x *ChunkIndex) Sync() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.log == nil {
+		return os.ErrClosed
+	}
+	return x.log.Sync()
+}
+
+// Close syncs and closes the index.
+func (x *ChunkIndex) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.log == nil {
+		return os.ErrClosed
+	}
+	err := x.log.Sync()
+	if cerr := x.log.Close(); err == nil {
+		err = cerr
+	}
+	x.log = nil
+	return err
+}
@@ -0,0 +1,113 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Manifest is a per-region record of what was written to a destination, kept around
+// so the destination can later be checked (and repaired) against it.
+type Manifest struct {
+	mu      sync.Mutex
+	Entries []Entry `json:"entries"`
+}
+
+// Entry describes a single region in a Manifest.
+type Entry struct {
+	Off int64  `json:"off"`
+	Len int64  `json:"len"`
+	Sum uint32 `json:"crc32c"`
+}
+
+// ReadManifest decodes a Manifest previously stored with Manifest.WriteTo.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// WriteTo stores the manifest (as JSON) so it can be loaded by ReadManifest.
+func (m *Manifest) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	b, err := json.Marshal(m)
+	m.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (m *Manifest) add(r pipe.Region) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Entries = append(m.Entries, Entry{
+		Off: r.Off,
+		Len: int64(len(r.Data)),
+		Sum: crc32.Checksum(r.Data, castagnoli),
+	})
+}
+
+// sorted returns a copy of the entries in offset order
+func (m *Manifest) sorted() []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := slices.Clone(m.Entries)
+	slices.SortFunc(entries, func(a, b Entry) int {
+		switch {
+		case a.Off < b.Off:
+			return -1
+		case a.Off > b.Off:
+			return 1
+		}
+		return 0
+	})
+	return entries
+}
+
+// Record implements pipe.Valve and adds an Entry to the manifest for every region
+// passing through it.
+func Record(m *Manifest) pipe.Valve {
+	return &record{m: m}
+}
+
+type record struct {
+	m *Manifest
+}
+
+func (v *record) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+		defer close(sink)
+
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				break
+			}
+
+			v.m.add(r)
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
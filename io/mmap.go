@@ -0,0 +1,156 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Mmap maps f into memory and returns a pipe.Source whose regions point straight into
+// the mapping, so nothing gets copied (or allocated) on the way to the sink. Pair it with
+// a Sink or Pool using the mapping as their Buffer, e.g.
+//
+//	src, _ := pipeio.Mmap(in, 4*pipe.MiB)
+//	p := pipe.New(src, pipeio.Sink(out, src))
+//
+// and regions go from page cache to pwrite as-is. The mapping stays alive while any of
+// its regions are out: the source keeps track of the regions handed out and not yet put
+// back, and unmaps once it's done and all of them are.
+//
+// Buffers put back that aren't regions of the mapping are ignored, so a valve swapping a
+// region's data for a new buffer keeps the mapping alive until Close, unless it puts back
+// the region it swapped out. Regions dropped by a failed run never come back either;
+// Close releases the mapping regardless.
+//
+// On platforms without mmap the whole file is read into memory up front instead, which
+// is only reasonable for files that fit comfortably.
+func Mmap(f *os.File, regionSize int) (*mapped, error) {
+	if regionSize <= 0 {
+		return nil, fmt.Errorf("invalid region size %d", regionSize)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if info.Size() > 0 {
+		if data, err = mapFile(f, int(info.Size())); err != nil {
+			return nil, err
+		}
+	}
+
+	return &mapped{data: data, size: regionSize}, nil
+}
+
+var errUnmapped = errors.New("mapping already released")
+
+type mapped struct {
+	data []byte
+	size int
+
+	mu       sync.Mutex
+	out      map[*byte]bool // regions handed out and not put back yet, by their first byte
+	done     bool           // no more regions are going to be handed out
+	unmapped bool
+}
+
+func (m *mapped) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer m.finish()
+	defer close(sink)
+
+	if !m.start() {
+		errs <- errUnmapped
+		return
+	}
+
+	for off := 0; off < len(m.data); off += m.size {
+		end := min(off+m.size, len(m.data))
+		data := m.data[off:end:end]
+
+		m.handOut(data)
+		select {
+		case sink <- pipe.Region{Data: data, Off: int64(off)}:
+		case <-ctx.Done():
+			m.Put(data)
+			return
+		}
+	}
+}
+
+// Get implements Buffer; the mapping only ever hands out its own regions through Write,
+// so anyone else asking gets a fresh buffer.
+func (m *mapped) Get() []byte {
+	return make([]byte, m.size)
+}
+
+// Put implements Buffer, and gives back a region handed out by Write; anything else is
+// ignored.
+func (m *mapped) Put(buff []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := first(buff)
+	if !m.out[key] {
+		return
+	}
+	delete(m.out, key)
+	m.unmap()
+}
+
+// Close releases the mapping, whether or not all regions have been put back; the data of
+// any regions still out must not be used afterwards.
+func (m *mapped) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.done, m.out = true, nil
+	return m.unmap()
+}
+
+// start returns whether the source can hand out regions, as the mapping's still there
+func (m *mapped) start() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return !m.unmapped
+}
+
+// handOut keeps track of data, a region about to be handed out
+func (m *mapped) handOut(data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.out == nil {
+		m.out = make(map[*byte]bool)
+	}
+	m.out[first(data)] = true
+}
+
+func (m *mapped) finish() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.done = true
+	m.unmap()
+}
+
+// unmap releases the mapping once it's not referenced anymore; m.mu must be held
+func (m *mapped) unmap() error {
+	if !m.done || len(m.out) > 0 || m.unmapped {
+		return nil
+	}
+	m.unmapped = true
+
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return unmapFile(data)
+}
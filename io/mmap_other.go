@@ -0,0 +1,21 @@
+//go:build !unix
+
+package io
+
+import (
+	"io"
+	"os"
+)
+
+// no mmap here, so fall back to reading the whole file up front
+func mapFile(f *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+func unmapFile(_ []byte) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package io
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+}
+
+func unmapFile(b []byte) error {
+	return unix.Munmap(b)
+}
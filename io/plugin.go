@@ -0,0 +1,436 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Plugin returns a Valve running regions through cmd, an external program (in any
+// language) started when the pipe opens the valve and killed if the pipe is canceled.
+//
+// The program speaks the wire format of RemoteSink over its standard input and output:
+// every region comes in as a frame
+//
+//	'R' | offset (int64, big endian) | length (uint32, big endian) | data
+//
+// and the stream ends with a single 'E' byte, after which standard input is closed. The
+// program writes back region frames of its own (as many as it likes, at the offsets it
+// likes), and ends its output with 'E' once it's done, or with
+//
+//	'S' | length (uint32, big endian) | message
+//
+// to fail the pipe with the message. The pipe fails too if the program exits with an
+// error, the end of its standard error telling why (unless cmd.Stderr is set).
+//
+// Programs that filter a byte stream (zstd, a scanner...) rather than regions can be run
+// as they are with Raw. Programs listening on a socket rather than started by the pipe
+// are reached with Exchange (as valves) or RemoteSink (as sinks).
+//
+// The regions coming back are read into buffers got from buff. The regions sent to the
+// program are released once they're sent (see pipe.Lend), but their buffers aren't handed
+// to buff, which didn't hand them out.
+func Plugin(cmd *exec.Cmd, buff Buffer) *plugin {
+	return &plugin{cmd: cmd, buff: buff}
+}
+
+type plugin struct {
+	cmd  *exec.Cmd
+	buff Buffer
+	raw  bool
+}
+
+// Raw has the program filter the stream itself: the regions are written to its standard
+// input in order from offset 0 (so the valve has to be fed by a single sequential source,
+// as with Decompress), and what it writes out makes up a stream of its own, in regions at
+// offsets starting at 0.
+func (p *plugin) Raw() *plugin {
+	p.raw = true
+	return p
+}
+
+func (p *plugin) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+
+		proc, err := startProcess(ctx, p.cmd, true)
+		if err != nil {
+			close(sink)
+			reject(ctx, source, errs, nil, err)
+			return
+		}
+
+		l := link{r: proc.stdout, w: proc.stdin, closeWrite: proc.stdin.Close, abort: proc.kill}
+		if p.raw {
+			err = l.filter(ctx, source, sink, p.buff)
+		} else {
+			err = l.exchange(ctx, source, sink, p.buff)
+		}
+		err = proc.wait(err)
+
+		if err != nil && ctx.Err() == nil {
+			errs <- err
+		}
+		close(sink)
+	}()
+
+	return source
+}
+
+// PluginSink implements pipe.Sink and hands the regions to cmd, an external program
+// started when the pipe starts and killed if it's canceled. Regions are written to its
+// standard input as frames, ending with 'E' (see Plugin), or in order from offset 0 if
+// Raw; they count as written once they're sent, and the pipe succeeds once the program
+// exits without an error.
+func PluginSink(cmd *exec.Cmd, buff Buffer) *pluginSink {
+	return &pluginSink{cmd: cmd, buff: buff}
+}
+
+type pluginSink struct {
+	cmd  *exec.Cmd
+	buff Buffer
+	raw  bool
+}
+
+// Raw has the program read the stream itself, in order from offset 0, rather than
+// regions (see plugin.Raw).
+func (s *pluginSink) Raw() *pluginSink {
+	s.raw = true
+	return s
+}
+
+func (s *pluginSink) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	proc, err := startProcess(ctx, s.cmd, false)
+	if err != nil {
+		errs <- err
+		return
+	}
+
+	w := bufio.NewWriter(proc.stdin)
+	var next int64
+	for {
+		r, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+
+		if err = unresolved(r); err == nil && s.raw {
+			if r.Off != next {
+				err = fmt.Errorf("%w: region at offset %d (expected %d)", pipe.ErrOutOfOrder, r.Off, next)
+			} else {
+				_, err = w.Write(r.Data)
+				next += int64(len(r.Data))
+			}
+		} else if err == nil {
+			err = writeRegion(w, r)
+		}
+		if err != nil {
+			pipe.Fail(ctx, r, err)
+			errs <- proc.wait(err)
+			return
+		}
+		pipe.Commit(ctx, r)
+		Release(s.buff, r)
+	}
+
+	if err = ctx.Err(); err == nil {
+		if !s.raw {
+			err = w.WriteByte(frameEnd)
+		}
+		err = errors.Join(err, w.Flush(), proc.stdin.Close())
+	}
+	errs <- proc.wait(err)
+}
+
+// Exchange returns a Valve running regions through a program reached over conn (a unix
+// socket, say) rather than started by the pipe, in the wire format of Plugin: regions go
+// out as frames ending with 'E', and the program answers with frames of its own ending
+// with 'E' (or with 'S' and a message, to fail the pipe). conn is the caller's to close;
+// if it has deadlines (as net.Conn does), they're used to stop waiting on the program
+// once the pipe is canceled.
+func Exchange(conn io.ReadWriter, buff Buffer) pipe.Valve {
+	return &exchange{conn: conn, buff: buff}
+}
+
+type exchange struct {
+	conn io.ReadWriter
+	buff Buffer
+}
+
+func (e *exchange) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+
+		l := link{r: e.conn, w: e.conn}
+		if d, ok := e.conn.(interface{ SetDeadline(time.Time) error }); ok {
+			l.abort = func() { d.SetDeadline(time.Now()) }
+			stop := context.AfterFunc(ctx, l.abort)
+			defer stop()
+		}
+
+		if err := l.exchange(ctx, source, sink, e.buff); err != nil && ctx.Err() == nil {
+			errs <- err
+		}
+		close(sink)
+	}()
+
+	return source
+}
+
+// link is what regions go to a plugin over, and come back from it over
+type link struct {
+	r io.Reader
+	w io.Writer
+	// closeWrite tells the plugin the stream is over, once it's sent (if not nil)
+	closeWrite func() error
+	// abort unblocks the link both ways once the exchange fails (if not nil)
+	abort func()
+}
+
+// exchange sends the regions of source as frames, and passes on the frames coming back
+func (l link) exchange(ctx context.Context, source, sink chan pipe.Region, buff Buffer) error {
+	sent := make(chan error, 1)
+	go func() {
+		sent <- l.send(ctx, source, func(w *bufio.Writer, r pipe.Region, _ int64) error {
+			return writeRegion(w, r)
+		}, frameEnd)
+	}()
+
+	err := l.receive(ctx, sink, buff)
+	if err != nil && l.abort != nil {
+		l.abort()
+	}
+	return errors.Join(err, <-sent)
+}
+
+// receive passes on the frames coming back up to the end of the stream
+func (l link) receive(ctx context.Context, sink chan pipe.Region, buff Buffer) error {
+	r := bufio.NewReader(l.r)
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("error reading from plugin: %w", err)
+		}
+
+		switch typ {
+		case frameEnd:
+			return nil
+		case frameResult:
+			msg, err := readString(r)
+			if err != nil {
+				return fmt.Errorf("error reading result: %w", err)
+			}
+			return fmt.Errorf("plugin failed: %s", msg)
+		case frameRegion:
+		default:
+			return fmt.Errorf("%w: unknown frame %q from plugin", ErrProtocol, typ)
+		}
+
+		region, err := readRegion(r, buff)
+		if err != nil {
+			return fmt.Errorf("error reading region: %w", err)
+		}
+		if !send(ctx, sink, region) {
+			Release(buff, region)
+			return nil
+		}
+	}
+}
+
+// filter writes the stream of source to the plugin in order, and passes on what comes
+// back as a stream of its own
+func (l link) filter(ctx context.Context, source, sink chan pipe.Region, buff Buffer) error {
+	sent := make(chan error, 1)
+	go func() {
+		sent <- l.send(ctx, source, func(w *bufio.Writer, r pipe.Region, next int64) error {
+			if r.Off != next {
+				return fmt.Errorf("%w: region at offset %d (expected %d)", pipe.ErrOutOfOrder, r.Off, next)
+			}
+			_, err := w.Write(r.Data)
+			return err
+		}, 0)
+	}()
+
+	var (
+		off int64
+		err error
+	)
+	for err == nil {
+		data, aerr := Acquire(ctx, buff)
+		if aerr != nil {
+			break
+		}
+
+		var n int
+		n, err = fill(l.r, data)
+		if n == 0 {
+			buff.Put(data)
+			continue
+		}
+		if !send(ctx, sink, pipe.Region{Data: data[:n], Off: off}) {
+			buff.Put(data)
+			break
+		}
+		off += int64(n)
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("error reading from plugin: %w", err)
+		if l.abort != nil {
+			l.abort()
+		}
+	}
+	return errors.Join(err, <-sent)
+}
+
+// send writes the regions of source to the plugin with write (given where the stream is
+// at), followed by end (unless it's 0), releasing them as they're sent: their buffers
+// weren't handed out by the buffer of the link, which only holds what comes back
+func (l link) send(ctx context.Context, source chan pipe.Region, write func(w *bufio.Writer, r pipe.Region, next int64) error, end byte) error {
+	w := bufio.NewWriter(l.w)
+	var next int64
+	for {
+		r, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+
+		err := write(w, r, next)
+		r.Release()
+		if err != nil {
+			go func() {
+				for r := range source {
+					r.Release()
+				}
+			}()
+			return fmt.Errorf("error sending region at offset=%d: %w", r.Off, err)
+		}
+		next += int64(len(r.Data))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil
+	}
+
+	var err error
+	if end != 0 {
+		err = w.WriteByte(end)
+	}
+	if err = errors.Join(err, w.Flush()); err != nil {
+		return fmt.Errorf("error ending stream: %w", err)
+	}
+	if l.closeWrite != nil {
+		return l.closeWrite()
+	}
+	return nil
+}
+
+// stderrTail is how much of the end of its standard error a failed plugin reports
+const stderrTail = 4 * 1024
+
+// process is a plugin started by the pipe
+type process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr *tail // nil if the caller has cmd.Stderr
+	stop   func() bool
+}
+
+// startProcess starts cmd with its standard input (and output, if piped) piped to the
+// pipe, to be killed once ctx is done
+func startProcess(ctx context.Context, cmd *exec.Cmd, piped bool) (*process, error) {
+	p := &process{cmd: cmd}
+	if cmd.Stderr == nil {
+		p.stderr = &tail{}
+		cmd.Stderr = p.stderr
+	}
+
+	var err error
+	if p.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if piped {
+		if p.stdout, err = cmd.StdoutPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting plugin %s: %w", p.name(), err)
+	}
+
+	p.stop = context.AfterFunc(ctx, p.kill)
+	return p, nil
+}
+
+func (p *process) name() string {
+	return filepath.Base(p.cmd.Path)
+}
+
+func (p *process) kill() {
+	p.cmd.Process.Kill()
+}
+
+// wait waits for the program to exit (killing it first if the pipe failed with err), and
+// returns what the pipe failed with: the program's own failure if it had one, err if not
+func (p *process) wait(err error) error {
+	p.stop()
+	if err != nil {
+		p.kill()
+	}
+
+	werr := p.cmd.Wait()
+	if werr == nil {
+		return err
+	}
+
+	var exit *exec.ExitError
+	if err != nil && !errors.As(werr, &exit) {
+		return err
+	}
+	if exit != nil && !exit.Exited() && err != nil {
+		// killed because of err
+		return err
+	}
+	if p.stderr != nil {
+		if msg := strings.TrimSpace(p.stderr.String()); msg != "" {
+			return fmt.Errorf("plugin %s failed: %w: %s", p.name(), werr, msg)
+		}
+	}
+	return fmt.Errorf("plugin %s failed: %w", p.name(), werr)
+}
+
+// tail keeps the last stderrTail bytes written to it
+type tail struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.b = append(t.b, p...)
+	if over := len(t.b) - stderrTail; over > 0 {
+		t.b = append(t.b[:0], t.b[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return string(t.b)
+}
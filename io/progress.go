@@ -0,0 +1,279 @@
+package io
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// ErrStaleProgress is returned by LoadProgress when the source changed since the progress
+// was saved, in which case resuming would stitch together two different versions of it.
+var ErrStaleProgress = errors.New("progress was saved for a different version of the source")
+
+// Identity identifies a version of a source, to tell whether saved progress still applies
+// to it. Sources that aren't files fill in what they have (e.g. the ETag of an object).
+type Identity struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	ETag    string    `json:"etag,omitempty"`
+}
+
+// FileIdentity returns the Identity of the file at path.
+func FileIdentity(path string) (Identity, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Name: path, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (id Identity) matches(other Identity) bool {
+	return id.Name == other.Name && id.Size == other.Size && id.ModTime.Equal(other.ModTime) && id.ETag == other.ETag
+}
+
+// Progress is how far copying a source got, kept in a sidecar file by Checkpoint so an
+// interrupted copy can be resumed (copying only what Remaining returns) rather than
+// started over.
+type Progress struct {
+	Source  Identity     `json:"source"`
+	Written []pipe.Range `json:"written"`
+
+	// Manifest optionally carries the checksums of what's been written (see Record)
+	// across resumes.
+	Manifest *Manifest `json:"manifest,omitempty"`
+
+	mu  sync.Mutex
+	dst interface{ Sync() error } // synced before every save, if set (see Durable)
+}
+
+// NewProgress starts keeping track of the progress of copying the source.
+func NewProgress(source Identity) *Progress {
+	return &Progress{Source: source, Written: []pipe.Range{}}
+}
+
+// LoadProgress reads the progress saved by Checkpoint at path, checking that it was saved
+// for the same version of the source. If there's no progress to resume from, the error
+// is os.ErrNotExist.
+func LoadProgress(path string, source Identity) (*Progress, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pr Progress
+	if err := json.Unmarshal(b, &pr); err != nil {
+		return nil, fmt.Errorf("error decoding progress: %w", err)
+	}
+	if !pr.Source.matches(source) {
+		return nil, ErrStaleProgress
+	}
+
+	return &pr, nil
+}
+
+// Remaining returns the ranges of the source that are yet to be written.
+func (pr *Progress) Remaining() []pipe.Range {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	remaining := make([]pipe.Range, 0)
+	var off int64
+	for _, r := range pr.Written {
+		if r.Off > off {
+			remaining = append(remaining, pipe.Range{Off: off, Len: r.Off - off})
+		}
+		off = max(off, r.End())
+	}
+	if off < pr.Source.Size {
+		remaining = append(remaining, pipe.Range{Off: off, Len: pr.Source.Size - off})
+	}
+	return remaining
+}
+
+// Durable has the progress sync dst, the destination the ranges are written to, before
+// every save, and returns it. Sinks commit ranges once they're written, not once they're
+// on disk: without it, the ranges saved may be lost to a crash even though the progress
+// isn't, and a resumed copy would skip them.
+func (pr *Progress) Durable(dst interface{ Sync() error }) *Progress {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.dst = dst
+	return pr
+}
+
+// Checkpoint saves the progress of p to the file at path every interval while the pipe
+// runs, on top of what had been written before. Calling the returned func stops
+// checkpointing and saves the progress one last time, so it's meant to be called once the
+// pipe is done:
+//
+//	stop := pr.Checkpoint(path, p, time.Second)
+//	err := p.Pipe(ctx)
+//	if serr := stop(); err == nil {
+//		err = serr
+//	}
+//
+// Files are replaced atomically, so a crash never leaves a torn one behind. An interval of
+// 0 or less only saves the progress when stop is called.
+func (pr *Progress) Checkpoint(path string, p *pipe.Pipe, interval time.Duration) (stop func() error) {
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+
+		var ticks <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+
+		for {
+			select {
+			case <-ticks:
+				// failing to save is fine until it's the last time
+				_ = pr.save(path, p.Report().Written, false)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() error {
+		once.Do(func() { close(done) })
+		<-exited
+
+		return pr.save(path, p.Report().Written, true)
+	}
+}
+
+// Store returns the progress as a pipe.CheckpointStore kept in the file at path, for
+// pipes that checkpoint themselves (see pipe.WithCheckpoint) rather than being
+// checkpointed by Checkpoint.
+func (pr *Progress) Store(path string) pipe.CheckpointStore {
+	return &progressStore{progress: pr, path: path}
+}
+
+type progressStore struct {
+	progress *Progress
+	path     string
+}
+
+func (s *progressStore) Load(ctx context.Context) ([]pipe.Range, error) {
+	s.progress.mu.Lock()
+	defer s.progress.mu.Unlock()
+
+	return slices.Clone(s.progress.Written), nil
+}
+
+func (s *progressStore) Save(ctx context.Context, written []pipe.Range) error {
+	return s.progress.save(s.path, written, true)
+}
+
+// save writes the progress to path, with the ranges written by the current run merged in
+// (and kept, once the run is over). The destination is synced first if need be (see
+// Durable): written is what was written before then, so it's all on disk once it's saved.
+func (pr *Progress) save(path string, written []pipe.Range, final bool) error {
+	pr.mu.Lock()
+	dst := pr.dst
+	pr.mu.Unlock()
+	if dst != nil {
+		if err := dst.Sync(); err != nil {
+			return fmt.Errorf("error syncing destination: %w", err)
+		}
+	}
+
+	pr.mu.Lock()
+	snapshot := Progress{Source: pr.Source, Written: merge(pr.Written, written)}
+	if final {
+		pr.Written = snapshot.Written
+	}
+	pr.mu.Unlock()
+
+	if pr.Manifest != nil {
+		snapshot.Manifest = &Manifest{Entries: pr.Manifest.sorted()}
+	}
+
+	b, err := json.Marshal(&snapshot)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFile(path, b); err != nil {
+		return fmt.Errorf("error saving progress: %w", err)
+	}
+	return nil
+}
+
+// replaceFile replaces the file at path with b by way of a temporary file, synced before
+// it's renamed over path so the rename can't land ahead of the data. The directory is
+// synced too, so the rename itself sticks; where directories can't be synced (Windows)
+// that's skipped.
+func replaceFile(path string, b []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// merge returns the union of the ranges, sorted and with overlapping or touching ranges
+// merged
+func merge(a, b []pipe.Range) []pipe.Range {
+	all := slices.Concat(a, b)
+	slices.SortFunc(all, func(x, y pipe.Range) int { return cmp.Compare(x.Off, y.Off) })
+
+	merged := make([]pipe.Range, 0, len(all))
+	for _, r := range all {
+		if r.Len == 0 {
+			continue
+		}
+		if last := len(merged) - 1; last >= 0 && merged[last].End() >= r.Off {
+			merged[last].Len = max(merged[last].End(), r.End()) - merged[last].Off
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,399 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Window is the credit a RemoteSource extends to the RemoteSink at the other end: how
+// many regions, and how many bytes, may be on their way or held on its side at once.
+type Window struct {
+	Regions int
+	Bytes   int64
+}
+
+// ErrProtocol is what the ends of a remote pipe (and plugins, which speak the same
+// frames) fail with when the other end says something that makes no sense.
+var ErrProtocol = errors.New("protocol error")
+
+// frame types; regions and the end of the stream go one way, credit and the outcome of
+// the remote pipe the other
+const (
+	frameRegion = 'R'
+	frameEnd    = 'E'
+	frameCredit = 'C'
+	frameResult = 'S'
+)
+
+// RemoteSink implements pipe.Sink and sends regions over conn (a TCP connection, a QUIC
+// stream...) to a RemoteSource at the other end, which feeds them to a pipe of its own.
+// Regions are only sent as long as the remote side has extended credit for them (see
+// Window), and the remote side only extends it as its pipe is done with regions, so a
+// slow remote writer throttles the local reader rather than regions piling up on either
+// side. A single region larger than the window still goes through once nothing else is
+// outstanding.
+//
+// Regions count as written once they're sent; the pipe succeeds once the remote pipe
+// did, and fails with its error otherwise. conn is the caller's to close.
+func RemoteSink(conn io.ReadWriter, buff Buffer) *remoteSink {
+	return &remoteSink{conn: conn, buff: buff, credited: make(chan struct{}, 1)}
+}
+
+type remoteSink struct {
+	conn io.ReadWriter
+	buff Buffer
+
+	mu       sync.Mutex
+	regions  int   // credit left
+	bytes    int64 // credit left
+	window   Window
+	credited chan struct{} // signaled as credit comes in
+	result   error         // of the remote pipe, once told
+	done     bool          // the remote side is done (or gone)
+}
+
+func (s *remoteSink) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	go s.listen()
+
+	w := bufio.NewWriter(s.conn)
+	for {
+		r, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+
+		if err := unresolved(r); err != nil {
+			pipe.Fail(ctx, r, err)
+			errs <- err
+			return
+		}
+		if err := s.await(ctx, int64(len(r.Data))); err != nil {
+			pipe.Fail(ctx, r, err)
+			errs <- err
+			return
+		}
+		if err := writeRegion(w, r); err != nil {
+			err = fmt.Errorf("error sending region: %w", err)
+			pipe.Fail(ctx, r, err)
+			errs <- err
+			return
+		}
+		pipe.Commit(ctx, r)
+		Release(s.buff, r)
+	}
+	if ctx.Err() != nil {
+		errs <- ctx.Err()
+		return
+	}
+
+	if err := errors.Join(w.WriteByte(frameEnd), w.Flush()); err != nil {
+		errs <- fmt.Errorf("error ending stream: %w", err)
+		return
+	}
+	for {
+		s.mu.Lock()
+		done, result := s.done, s.result
+		s.mu.Unlock()
+		if done {
+			errs <- result
+			return
+		}
+
+		select {
+		case <-s.credited:
+		case <-ctx.Done():
+			errs <- ctx.Err()
+			return
+		}
+	}
+}
+
+// await takes credit for a region of n bytes, waiting for the remote side to extend it
+func (s *remoteSink) await(ctx context.Context, n int64) error {
+	for {
+		s.mu.Lock()
+		switch {
+		case s.done && s.result != nil:
+			s.mu.Unlock()
+			return s.result
+		case s.done:
+			s.mu.Unlock()
+			return fmt.Errorf("%w: remote side is done before the stream is", ErrProtocol)
+		case s.regions > 0 && (s.bytes >= n || s.bytes == s.window.Bytes):
+			s.regions--
+			s.bytes -= n
+			s.mu.Unlock()
+			return nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.credited:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// listen takes in what the remote side sends back: credit, and the result of its pipe
+func (s *remoteSink) listen() {
+	r := bufio.NewReader(s.conn)
+	first := true
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			s.tell(fmt.Errorf("error reading from remote side: %w", err))
+			return
+		}
+
+		switch typ {
+		case frameCredit:
+			var c struct {
+				Regions uint32
+				Bytes   uint64
+			}
+			if err := binary.Read(r, binary.BigEndian, &c); err != nil {
+				s.tell(fmt.Errorf("error reading credit: %w", err))
+				return
+			}
+			s.mu.Lock()
+			if first {
+				// the first credit is the whole window
+				s.window = Window{Regions: int(c.Regions), Bytes: int64(c.Bytes)}
+				first = false
+			}
+			s.regions += int(c.Regions)
+			s.bytes += int64(c.Bytes)
+			s.mu.Unlock()
+			s.signal()
+
+		case frameResult:
+			msg, err := readString(r)
+			if err != nil {
+				s.tell(fmt.Errorf("error reading result: %w", err))
+				return
+			}
+			var result error
+			if msg != "" {
+				result = fmt.Errorf("remote pipe failed: %s", msg)
+			}
+			s.tell(result)
+			return
+
+		default:
+			s.tell(fmt.Errorf("%w: unknown frame %q from remote side", ErrProtocol, typ))
+			return
+		}
+	}
+}
+
+// tell records that the remote side is done, with result
+func (s *remoteSink) tell(result error) {
+	s.mu.Lock()
+	if !s.done {
+		s.done, s.result = true, result
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *remoteSink) signal() {
+	select {
+	case s.credited <- struct{}{}:
+	default:
+	}
+}
+
+// RemoteSource implements pipe.Source and takes in the regions sent by a RemoteSink over
+// conn, extending it credit of up to window.
+//
+// The source is also the Buffer the sink of its pipe should put the data of regions back
+// into (see Pool, StreamSink): credit for a region goes back to the remote side as its
+// data comes back, which is what makes for flow control. Regions are read into buffers
+// of buff. Once the pipe is done, Reply tells the remote side how it went:
+//
+//	src := pipeio.RemoteSource(conn, buff, pipeio.Window{Regions: 16, Bytes: 16 * pipe.MiB})
+//	err := pipe.New(src, pipeio.Pool(src, w)).Pipe(ctx)
+//	src.Reply(err)
+func RemoteSource(conn io.ReadWriter, buff Buffer, window Window) *remoteSource {
+	return &remoteSource{conn: conn, buff: buff, window: window, held: make(map[*byte]int64)}
+}
+
+type remoteSource struct {
+	conn   io.ReadWriter
+	buff   Buffer
+	window Window
+
+	mu   sync.Mutex // guards the writes to conn as well
+	held map[*byte]int64
+}
+
+func (s *remoteSource) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	if err := s.credit(s.window.Regions, s.window.Bytes); err != nil {
+		errs <- err
+		return
+	}
+
+	r := bufio.NewReader(s.conn)
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			errs <- fmt.Errorf("error reading from remote side: %w", err)
+			return
+		}
+
+		switch typ {
+		case frameEnd:
+			return
+		case frameRegion:
+		default:
+			errs <- fmt.Errorf("%w: unknown frame %q from remote side", ErrProtocol, typ)
+			return
+		}
+
+		region, err := s.readRegion(r)
+		if err != nil {
+			errs <- fmt.Errorf("error reading region: %w", err)
+			return
+		}
+		select {
+		case sink <- region:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readRegion reads a region into a buffer of its own, holding on to its credit
+func (s *remoteSource) readRegion(r io.Reader) (pipe.Region, error) {
+	region, err := readRegion(r, s.buff)
+	if err != nil {
+		return pipe.Region{}, err
+	}
+
+	if data := region.Data; len(data) > 0 {
+		s.mu.Lock()
+		s.held[&data[0]] = int64(len(data))
+		s.mu.Unlock()
+	} else {
+		// nothing to put back, so the credit goes back right away
+		if err := s.credit(1, 0); err != nil {
+			return pipe.Region{}, err
+		}
+	}
+	return region, nil
+}
+
+// Get implements Buffer.
+func (s *remoteSource) Get() []byte {
+	return s.buff.Get()
+}
+
+// Put implements Buffer, giving back the credit held by the region of buff.
+func (s *remoteSource) Put(buff []byte) {
+	if cap(buff) > 0 {
+		first := &buff[:1][0]
+		s.mu.Lock()
+		n, ok := s.held[first]
+		delete(s.held, first)
+		s.mu.Unlock()
+		if ok {
+			// failing to, the remote side finds out on its own soon enough
+			_ = s.credit(1, n)
+		}
+	}
+	s.buff.Put(buff)
+}
+
+// credit extends credit for regions and bytes to the remote side
+func (s *remoteSource) credit(regions int, bytes int64) error {
+	var b [13]byte
+	b[0] = frameCredit
+	binary.BigEndian.PutUint32(b[1:], uint32(regions))
+	binary.BigEndian.PutUint64(b[5:], uint64(bytes))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.conn.Write(b[:])
+	return err
+}
+
+// Reply tells the remote side the result of the pipe, err; the pipe at the other end
+// fails with it, if it isn't nil.
+func (s *remoteSource) Reply(err error) error {
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := bufio.NewWriter(s.conn)
+	return errors.Join(w.WriteByte(frameResult), writeString(w, msg), w.Flush())
+}
+
+// writeRegion writes a region frame to w, flushing it
+func writeRegion(w *bufio.Writer, r pipe.Region) error {
+	var h [13]byte
+	h[0] = frameRegion
+	binary.BigEndian.PutUint64(h[1:], uint64(r.Off))
+	binary.BigEndian.PutUint32(h[9:], uint32(len(r.Data)))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(r.Data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// readRegion reads what follows the type of a region frame from r, into a buffer of buff
+// (or a larger one, if the region doesn't fit)
+func readRegion(r io.Reader, buff Buffer) (pipe.Region, error) {
+	var h struct {
+		Off int64
+		Len uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return pipe.Region{}, err
+	}
+
+	data := buff.Get()
+	if cap(data) < int(h.Len) {
+		data = make([]byte, h.Len)
+	}
+	data = data[:h.Len]
+	if _, err := io.ReadFull(r, data); err != nil {
+		return pipe.Region{}, err
+	}
+	return pipe.Region{Data: data, Off: h.Off}, nil
+}
+
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,74 @@
+package io
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// RetryPolicy is how the sinks of this package retry failed writes (see RetryWrites and
+// RetryWith), so a transient failure - an NFS hiccup, a throttled object store - doesn't
+// fail the whole pipe. The part of a region that's yet to be written is retried, and
+// the region only counts as failed (see pipe.Fail) once the policy gives up on it.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a region's write is attempted in all, the first
+	// attempt included: 1 or less doesn't retry at all.
+	MaxAttempts int
+	// Backoff is how long to wait before the first retry, doubled for every retry after
+	// that, up to MaxBackoff (if set).
+	Backoff, MaxBackoff time.Duration
+	// Retryable decides which errors are worth retrying: by default, those the
+	// classifier of the pipe deems transient (see pipe.Classify).
+	Retryable func(err error) bool
+}
+
+// RetryWrites has the sink retry failed writes according to policy.
+func RetryWrites(policy RetryPolicy) SinkOption {
+	return func(s *sink) {
+		s.retry = &policy
+	}
+}
+
+// RetryWith has the pool retry failed writes according to policy, and returns it.
+func (p *pool) RetryWith(policy RetryPolicy) *pool {
+	p.retry = &policy
+	return p
+}
+
+// again waits out the backoff after the given attempt at a write failed with err, and
+// returns whether to attempt it again: not if err isn't worth retrying, the attempts
+// have run out or ctx is done in the meantime
+func (rp *RetryPolicy) again(ctx context.Context, attempt int, err error) bool {
+	if rp == nil || attempt >= rp.MaxAttempts || !rp.retryable(ctx, err) {
+		return false
+	}
+
+	backoff := rp.Backoff
+	for range attempt - 1 {
+		if rp.MaxBackoff > 0 && backoff >= rp.MaxBackoff || backoff > math.MaxInt64/2 {
+			break
+		}
+		backoff *= 2
+	}
+	if rp.MaxBackoff > 0 {
+		backoff = min(backoff, rp.MaxBackoff)
+	}
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (rp *RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if rp.Retryable != nil {
+		return rp.Retryable(err)
+	}
+	return pipe.Classify(ctx, err) == pipe.Transient
+}
@@ -0,0 +1,104 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Scrub re-reads every region of dst recorded in the manifest and returns the entries
+// whose checksums no longer match (i.e. the regions that have rotted since they were
+// written).
+func Scrub(ctx context.Context, dst io.ReaderAt, m *Manifest) ([]Entry, error) {
+	damaged := make([]Entry, 0)
+
+	var data []byte
+	for _, e := range m.sorted() {
+		if err := ctx.Err(); err != nil {
+			return damaged, err
+		}
+
+		if int64(cap(data)) < e.Len {
+			data = make([]byte, e.Len)
+		}
+		data = data[:e.Len]
+
+		n, err := dst.ReadAt(data, e.Off)
+		if err != nil && err != io.EOF {
+			return damaged, fmt.Errorf("error scrubbing region at offset=%d: %w", e.Off, err)
+		}
+
+		// a truncated destination is just as damaged as a flipped bit
+		if int64(n) != e.Len || crc32.Checksum(data, castagnoli) != e.Sum {
+			damaged = append(damaged, e)
+		}
+	}
+
+	return damaged, nil
+}
+
+// Repair re-pipes the given (damaged) entries from src to dst. The data read from src
+// must still match the manifest entry, otherwise the repair is aborted rather than
+// "fixing" the destination with data that's different from what was recorded.
+func Repair(ctx context.Context, src io.ReaderAt, dst io.WriterAt, damaged []Entry, buff Buffer) error {
+	if len(damaged) == 0 {
+		return nil
+	}
+
+	p := pipe.New(&entries{r: src, entries: damaged, buff: buff}, Sink(dst, buff))
+	return p.Pipe(ctx)
+}
+
+// entries implements pipe.Source and reads exactly the regions in a manifest from r,
+// into buffers of buff (or of their own, for regions that don't fit in one)
+type entries struct {
+	r       io.ReaderAt
+	entries []Entry
+	buff    Buffer
+}
+
+func (s *entries) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	for _, e := range s.entries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		data, err := Acquire(ctx, s.buff)
+		if err != nil {
+			// the run is over
+			return
+		}
+		if int64(cap(data)) < e.Len {
+			s.buff.Put(data)
+			data = make([]byte, e.Len)
+		}
+		data = data[:e.Len]
+
+		n, err := s.r.ReadAt(data, e.Off)
+		if int64(n) != e.Len {
+			s.buff.Put(data)
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			errs <- fmt.Errorf("error reading region at offset=%d: %w", e.Off, err)
+			return
+		}
+		if crc32.Checksum(data, castagnoli) != e.Sum {
+			s.buff.Put(data)
+			errs <- fmt.Errorf("source region at offset=%d does not match manifest", e.Off)
+			return
+		}
+
+		select {
+		case sink <- pipe.Region{Data: data, Off: e.Off}:
+		case <-ctx.Done():
+			s.buff.Put(data)
+			return
+		}
+	}
+}
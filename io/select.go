@@ -0,0 +1,94 @@
+package io
+
+import (
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Selector picks which of the writers of a Pool writes a region (see SelectWith).
+//
+// It's given the state of every writer in circulation and returns the index of one of
+// them. If that writer has no room for another region, the region waits and the selector
+// is asked again once some writer makes progress; returning -1 waits all the same. Select
+// is never called concurrently, and mustn't hold on to writers.
+type Selector interface {
+	Select(r pipe.Region, writers []WriterState) int
+}
+
+// WriterState is what a Selector gets to know about a writer of a Pool.
+type WriterState struct {
+	// Queued is the number of regions handed to the writer that it hasn't written yet
+	// (the one it's writing included); a writer has room for poolDepth of them.
+	Queued int
+	// Outstanding is the number of bytes in those regions.
+	Outstanding int64
+	// Handed is the number of regions handed to the writer so far.
+	Handed int64
+}
+
+// poolDepth is how many regions a writer of a Pool can have handed to it at once: one
+// being written, and the next one lined up
+const poolDepth = 2
+
+// LeastOutstanding picks the writer with the fewest bytes outstanding, so idle writers
+// go first. It's what Pool uses unless told otherwise.
+func LeastOutstanding() Selector {
+	return leastOutstanding{}
+}
+
+type leastOutstanding struct{}
+
+func (leastOutstanding) Select(_ pipe.Region, writers []WriterState) int {
+	best := -1
+	for i, w := range writers {
+		if w.Queued >= poolDepth {
+			continue
+		}
+		if best < 0 || w.Outstanding < writers[best].Outstanding {
+			best = i
+		}
+	}
+	return best
+}
+
+// RoundRobin has the writers take turns, however busy they are: a region waits for its
+// writer rather than going to another one.
+func RoundRobin() Selector {
+	return roundRobin{}
+}
+
+type roundRobin struct{}
+
+func (roundRobin) Select(_ pipe.Region, writers []WriterState) int {
+	next := -1
+	for i, w := range writers {
+		if next < 0 || w.Handed < writers[next].Handed {
+			next = i
+		}
+	}
+	return next
+}
+
+// Affinity sends the regions within the same span of offsets to the same writer, so a
+// writer keeps to a part of the destination rather than hopping all over it. That helps
+// locality on spinning disks and on network filesystems that cache per file handle. A
+// span of 0 or less stands for defaultSpan.
+func Affinity(span int64) Selector {
+	if span <= 0 {
+		span = defaultSpan
+	}
+	return affinity{span: span}
+}
+
+// defaultSpan is the span Affinity goes with when not given one
+const defaultSpan = 64 * pipe.MiB
+
+type affinity struct {
+	span int64
+}
+
+func (a affinity) Select(r pipe.Region, writers []WriterState) int {
+	if len(writers) == 0 {
+		return -1
+	}
+	return int((r.Off / a.span) % int64(len(writers)))
+}
@@ -0,0 +1,129 @@
+package io
+
+import (
+	"context"
+	"sync"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Sharded implements pipe.Source, reading [0, size) in shards of shardSize bytes with n
+// readers at once, each made with source. Readers pick up the next shard not yet read
+// once they're done with theirs, so Sharded implements pipe.Scalable with no bound but
+// the number of shards: raising the concurrency starts more readers right away, while
+// lowering it has readers stop once they're done with the shard they're reading. Shards
+// smaller than size/n leave room to scale up.
+func Sharded(size, shardSize int64, n int, source func(off, n int64) pipe.Source) *sharded {
+	return &sharded{
+		size:      size,
+		shardSize: max(shardSize, 1),
+		source:    source,
+		target:    max(n, 1),
+	}
+}
+
+type sharded struct {
+	size      int64
+	shardSize int64
+	source    func(off, n int64) pipe.Source
+
+	mu      sync.Mutex
+	target  int
+	running int
+	run     *shardRun // of the current run, if any
+}
+
+type shardRun struct {
+	ctx  context.Context
+	sink chan pipe.Region
+	errs chan error
+
+	next int64         // the start of the next shard to read
+	done chan struct{} // closed once the last reader is done
+}
+
+func (s *sharded) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	r := &shardRun{ctx: ctx, sink: sink, errs: errs, done: make(chan struct{})}
+	s.mu.Lock()
+	s.run = r
+	s.spawn()
+	if s.running == 0 {
+		close(r.done)
+	}
+	s.mu.Unlock()
+
+	<-r.done
+
+	s.mu.Lock()
+	s.run = nil
+	s.mu.Unlock()
+}
+
+// spawn starts readers until there are as many as called for, or as many as there are
+// shards left; s.mu must be held
+func (s *sharded) spawn() {
+	r := s.run
+	for r != nil && s.running < s.target && r.next < s.size {
+		s.running++
+		go s.read(r)
+	}
+}
+
+// read reads shards until there are none left, or there are too many readers
+func (s *sharded) read(r *shardRun) {
+	defer pipe.Pin(r.ctx)()
+
+	for {
+		s.mu.Lock()
+		if s.running > s.target || r.next >= s.size || r.ctx.Err() != nil {
+			s.running--
+			if s.running == 0 {
+				close(r.done)
+			}
+			s.mu.Unlock()
+			return
+		}
+		off := r.next
+		n := min(s.shardSize, s.size-off)
+		r.next += n
+		s.mu.Unlock()
+
+		in := make(chan pipe.Region)
+		go s.source(off, n).Write(r.ctx, in, r.errs)
+		r.pass(in)
+	}
+}
+
+// pass hands the regions of a shard on, until the shard is done or the run is over
+func (r *shardRun) pass(in chan pipe.Region) {
+	for {
+		region, more := pipe.Next(r.ctx, in)
+		if !more {
+			return
+		}
+		select {
+		case r.sink <- region:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// Concurrency implements pipe.Scalable.
+func (s *sharded) Concurrency() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.target
+}
+
+// SetConcurrency implements pipe.Scalable.
+func (s *sharded) SetConcurrency(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.target = max(n, 1)
+	s.spawn()
+}
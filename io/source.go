@@ -5,34 +5,99 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
 
 	"github.com/naylorpmax-joyent/pipe"
 )
 
 // Source implements pipe.Source
-func Source(r io.Reader, off int64, buff Buffer) pipe.Source {
-	return &source{
+func Source(r io.Reader, off int64, buff Buffer, opts ...SourceOption) pipe.Source {
+	s := &source{
 		r:    r,
 		off:  off,
 		buff: buff,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// SourceOption configures a Source.
+type SourceOption func(*source)
+
+// ReadAhead keeps up to k reads in flight ahead of the regions consumed by the rest of the
+// pipe (k=1 is plain double-buffering), so the latency of the sink doesn't serialize with
+// the latency of the reads. When reading from a file, the kernel is also advised to fetch
+// the data about to be read (posix_fadvise WILLNEED) where the platform supports it.
+func ReadAhead(k int) SourceOption {
+	return func(s *source) {
+		s.ahead = k
+	}
+}
+
+// Lent has a source lend the regions it reads to the pipe (see pipe.Lend), with their
+// buffers handed back to the source's Buffer once released.
+func Lent() SourceOption {
+	return func(s *source) {
+		s.lent = true
+	}
 }
 
 type source struct {
 	r   io.Reader
 	off int64
 
-	buff Buffer
+	buff  Buffer
+	ahead int
+	lent  bool
 }
 
 func (b *source) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	if b.ahead <= 0 {
+		b.read(ctx, sink, errs)
+		return
+	}
+
+	defer close(sink)
+
+	ahead := make(chan pipe.Region, b.ahead)
+	go b.read(ctx, ahead, errs)
+
+	for r := range ahead {
+		select {
+		case sink <- r:
+		case <-ctx.Done():
+			go discard(ahead)
+			return
+		}
+	}
+}
+
+func (b *source) read(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	if b.ahead > 0 {
+		// reading ahead, so this is running in its own goroutine
+		defer pipe.Pin(ctx)()
+	}
 	defer close(sink)
 
 	reader := bufio.NewReader(b.r)
+	f, _ := b.r.(*os.File)
 
 	var done bool
-	for !done || ctx.Err() != nil {
-		data := b.buff.Get()
+	for !done && ctx.Err() == nil {
+		data, err := Acquire(ctx, b.buff)
+		if err != nil {
+			// the run is over
+			return
+		}
+		if f != nil && b.ahead > 0 {
+			// hint at the reads we're about to do; the file position is ahead of
+			// what's been handed out (courtesy of bufio) but it's only a hint
+			willNeed(f, int64(b.ahead*len(data)))
+		}
+
 		n, err := reader.Read(data)
 		if err != nil && !errors.Is(err, io.EOF) {
 			errs <- err
@@ -44,11 +109,40 @@ func (b *source) Write(ctx context.Context, sink chan pipe.Region, errs chan err
 			return
 		}
 		if n == 0 {
+			b.buff.Put(data)
 			break
 		}
 
 		r := pipe.Region{Data: data[:n], Off: b.off}
-		sink <- r
+		if b.lent {
+			r = pipe.Lend(r, b.buff.Put)
+		}
+		select {
+		case sink <- r:
+		case <-ctx.Done():
+			b.buff.Put(data)
+			return
+		}
 		b.off += int64(n)
 	}
 }
+
+// discard drains in, releasing the regions dropped (see pipe.Lend)
+func discard(in chan pipe.Region) {
+	for r := range in {
+		r.Release()
+	}
+}
+
+// reject reports err, and drains the source so whatever's upstream doesn't get stuck
+func reject(ctx context.Context, source chan pipe.Region, errs chan error, buff Buffer, err error) {
+	go func() {
+		for r := range source {
+			Release(buff, r)
+		}
+	}()
+	select {
+	case errs <- err:
+	case <-ctx.Done():
+	}
+}
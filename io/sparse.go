@@ -0,0 +1,110 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// SkipZeros returns a Valve that drops the regions whose data is all zeros, handing their
+// buffers back to buff, so the long runs of zeros of a disk image or a sparse file aren't
+// written out byte by byte. The destination must read back zeros where nothing's written
+// for the copy to come out right: a file extended to its full size up front does (see
+// TruncateTo), and takes no space for them if the filesystem supports sparse files (see
+// Sparse).
+//
+// The regions dropped are reported written (see pipe.Commit), as they will be once the
+// destination is extended over them. Since they don't get to the sink, they don't get to
+// whatever's in between either: a digest of the stream (see pipe.WithExpectedDigest) or a
+// Reorder in front of the sink would find a gap in it.
+func SkipZeros(buff Buffer) pipe.Valve {
+	return &skipZeros{buff: buff}
+}
+
+type skipZeros struct {
+	buff Buffer
+}
+
+func (z *skipZeros) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+		defer close(sink)
+
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				return
+			}
+
+			if len(r.Data) > 0 && zero(r.Data) {
+				pipe.Commit(ctx, r)
+				Release(z.buff, r)
+				continue
+			}
+
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// zeros is what data is compared with, a slice of it at a time
+var zeros = make([]byte, 64*1024)
+
+// zero returns whether data is all zeros
+func zero(data []byte) bool {
+	for len(data) > 0 {
+		n := min(len(data), len(zeros))
+		if !bytes.Equal(data[:n], zeros[:n]) {
+			return false
+		}
+		data = data[n:]
+	}
+	return true
+}
+
+// truncater is implemented by destinations whose size can be changed (see TruncateTo)
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// TruncateTo has the sink set the size of its destination to size before writing to it,
+// extending it with zeros (see SkipZeros) or cutting off whatever's past the end of the
+// stream. The destination must have a Truncate method, as files do.
+func TruncateTo(size int64) SinkOption {
+	return func(s *sink) {
+		s.size = &size
+	}
+}
+
+// TruncateTo has the pool set the size of its destination (that of its first writer) to
+// size before writing to it, as the option of the same name does for a Sink, and returns
+// it.
+func (p *pool) TruncateTo(size int64) *pool {
+	p.size = &size
+	return p
+}
+
+// truncate sets the size of w to size, if it's set
+func truncate(w io.WriterAt, size *int64) error {
+	if size == nil {
+		return nil
+	}
+	t, ok := w.(truncater)
+	if !ok {
+		return fmt.Errorf("can't truncate %T", w)
+	}
+	if err := t.Truncate(*size); err != nil {
+		return fmt.Errorf("error truncating the destination: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+var errSpliceUnsupported = errors.New("splice not supported")
+
+// Splice copies from src to dst until EOF. When both ends are backed by file descriptors
+// (files, sockets, pipes) and the platform supports it, the data is moved by the kernel
+// without ever being copied into user space; otherwise Splice falls back to io.Copy.
+func Splice(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	d, dok := dst.(syscall.Conn)
+	s, sok := src.(syscall.Conn)
+	if dok && sok {
+		n, err := spliceConns(ctx, d, s, nil)
+		if !errors.Is(err, errSpliceUnsupported) {
+			return n, err
+		}
+	}
+
+	return io.Copy(dst, src)
+}
+
+// Shortcut implements pipe.Shortcut: when the source reads straight from a file or a
+// socket and the sink writes to a file, the regions are spliced by the kernel instead.
+func (w *sink) Shortcut(ctx context.Context, s pipe.Source) (bool, error) {
+	src, ok := s.(*source)
+	if !ok {
+		return false, nil
+	}
+	from, ok := src.r.(syscall.Conn)
+	if !ok {
+		return false, nil
+	}
+	to, ok := w.w.(syscall.Conn)
+	if !ok {
+		return false, nil
+	}
+
+	if err := truncate(w.w, w.size); err != nil {
+		return true, err
+	}
+
+	off := src.off
+	n, err := spliceConns(ctx, to, from, &off)
+	if errors.Is(err, errSpliceUnsupported) {
+		return false, nil
+	}
+
+	pipe.CommitRange(ctx, pipe.Range{Off: src.off, Len: n})
+	if err != nil {
+		return true, fmt.Errorf("error splicing regions: %w", err)
+	}
+	return true, nil
+}
+
+func spliceConns(ctx context.Context, dst, src syscall.Conn, dstOff *int64) (int64, error) {
+	d, err := dst.SyscallConn()
+	if err != nil {
+		return 0, errSpliceUnsupported
+	}
+	s, err := src.SyscallConn()
+	if err != nil {
+		return 0, errSpliceUnsupported
+	}
+
+	return splice(ctx, d, s, dstOff)
+}
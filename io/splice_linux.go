@@ -0,0 +1,96 @@
+package io
+
+import (
+	"context"
+	"io"
+	"syscall"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+const (
+	spliceMove     = 0x1 // SPLICE_F_MOVE
+	spliceNonblock = 0x2 // SPLICE_F_NONBLOCK
+
+	// the default pipe capacity; splice can't move more than that through a pipe at once
+	maxSplice = 64 * pipe.KiB
+)
+
+// splice moves data from src to dst through an intermediate pipe (splice needs one end
+// of every call to be a pipe), which means the pages are handed around inside the
+// kernel rather than being copied into and back out of user space.
+func splice(ctx context.Context, dst, src syscall.RawConn, dstOff *int64) (int64, error) {
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return 0, errSpliceUnsupported
+	}
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+
+	var written int64
+	for ctx.Err() == nil {
+		// source --> pipe
+		var n int64
+		var serr error
+		err := src.Read(func(fd uintptr) bool {
+			n, serr = splice64(syscall.Splice(int(fd), nil, p[1], nil, maxSplice, spliceMove|spliceNonblock))
+			return serr != syscall.EAGAIN && serr != syscall.EINTR
+		})
+		if err == nil {
+			err = serr
+		}
+		if err != nil {
+			if written == 0 && (err == syscall.EINVAL || err == syscall.ENOSYS) {
+				// one of the fds doesn't support splice and nothing's been moved yet,
+				// so the caller can still go the long way around
+				return 0, errSpliceUnsupported
+			}
+			return written, err
+		}
+		if n == 0 {
+			// EOF
+			return written, nil
+		}
+
+		// pipe --> destination
+		for n > 0 {
+			var m int64
+			err := dst.Write(func(fd uintptr) bool {
+				m, serr = splice64(syscall.Splice(p[0], nil, int(fd), dstOff, int(n), spliceMove|spliceNonblock))
+				return serr != syscall.EAGAIN && serr != syscall.EINTR
+			})
+			if err == nil {
+				err = serr
+			}
+			if err != nil {
+				if written == 0 && (err == syscall.EINVAL || err == syscall.ENOSYS) && unread(src, n) {
+					// the destination doesn't take splice (e.g. it's in append mode), and
+					// what was moved into the pipe went back to the source
+					return 0, errSpliceUnsupported
+				}
+				return written, err
+			}
+
+			n -= m
+			written += m
+		}
+	}
+
+	return written, ctx.Err()
+}
+
+// splice64 makes up for syscall.Splice returning an int on 32-bit platforms
+func splice64[N int | int64](n N, err error) (int64, error) {
+	return int64(n), err
+}
+
+// unread rewinds src by n bytes, if it can be rewound at all
+func unread(src syscall.RawConn, n int64) bool {
+	var serr error
+	if err := src.Control(func(fd uintptr) {
+		_, serr = syscall.Seek(int(fd), -n, io.SeekCurrent)
+	}); err != nil {
+		return false
+	}
+	return serr == nil
+}
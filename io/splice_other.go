@@ -0,0 +1,12 @@
+//go:build !linux
+
+package io
+
+import (
+	"context"
+	"syscall"
+)
+
+func splice(_ context.Context, _, _ syscall.RawConn, _ *int64) (int64, error) {
+	return 0, errSpliceUnsupported
+}
@@ -0,0 +1,56 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// StreamSink implements pipe.Sink and writes regions to w one after the other, for
+// destinations that can only be written sequentially (a request body, a socket...). The
+// regions have to come in order, without gaps, starting at offset off; anything else
+// fails the pipe.
+func StreamSink(w io.Writer, off int64, buff Buffer) *streamSink {
+	return &streamSink{w: w, off: off, buff: buff}
+}
+
+type streamSink struct {
+	w    io.Writer
+	off  int64
+	buff Buffer
+}
+
+func (s *streamSink) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	for {
+		data, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+
+		if data.Off != s.off {
+			err := fmt.Errorf("%w: region at offset=%d, expected offset=%d", pipe.ErrOutOfOrder, data.Off, s.off)
+			pipe.Fail(ctx, data, err)
+			errs <- err
+			return
+		}
+
+		if err := unresolved(data); err != nil {
+			pipe.Fail(ctx, data, err)
+			errs <- err
+			return
+		}
+		if _, err := s.w.Write(data.Data); err != nil {
+			pipe.Fail(ctx, data, err)
+			errs <- fmt.Errorf("error writing region: %w", err)
+			return
+		}
+		pipe.Commit(ctx, data)
+		s.off += int64(len(data.Data))
+
+		Release(s.buff, data) // release buffer
+	}
+
+	errs <- ctx.Err()
+}
@@ -0,0 +1,172 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// WasmLimits bound what a module run by Wasm may use.
+type WasmLimits struct {
+	// Memory caps the linear memory of the module, in bytes (0 leaves it to the runtime).
+	Memory int64
+	// Time caps how long the module may take over a region (0 for no limit).
+	Time time.Duration
+}
+
+// WasmRuntime instantiates WebAssembly modules, each in a sandbox of its own. There's no
+// runtime in the standard library: wrap the one of your choosing (wazero, say, with its
+// memory limit set and WithCloseOnContextDone so calls stop once their context is done).
+type WasmRuntime interface {
+	Instantiate(ctx context.Context, module []byte, limits WasmLimits) (WasmInstance, error)
+}
+
+// WasmInstance is an instance of a module, as instantiated by a WasmRuntime.
+type WasmInstance interface {
+	// Call calls the function the module exports as name. It has to return (failing) once
+	// ctx is done, whatever the module is up to.
+	Call(ctx context.Context, name string, params ...uint64) ([]uint64, error)
+	// Read returns the n bytes of the memory of the instance at off, false if they're out
+	// of its range. They're only good until the next call.
+	Read(off, n uint32) ([]byte, bool)
+	// Write writes b to the memory of the instance at off, false if it's out of its range.
+	Write(off uint32, b []byte) bool
+	Close(ctx context.Context) error
+}
+
+// ErrWasmLimit is reported by Wasm for modules going over their WasmLimits.
+var ErrWasmLimit = errors.New("wasm module over its limits")
+
+// Wasm returns a Valve transforming the data of every region with a WebAssembly module,
+// run in-process by rt, so transforms supplied by customers (or anyone not trusted with
+// the process) can run inside a transfer service: the module sees nothing but the data
+// it's handed, within limits.
+//
+// The module exports:
+//
+//	memory
+//	alloc(size i32) -> (ptr i32)
+//	transform(ptr i32, size i32) -> (result i64)
+//
+// For every region, alloc returns where in memory to put its data, and transform turns
+// the size bytes at ptr into the data of the region coming out, returned as its pointer
+// (the high 32 bits of result) and size (the low 32 bits). The module is free to reuse
+// its memory from one region to the next; to fail the pipe, it traps. The regions coming
+// out keep the offsets of the regions they're made from (as with Compress), with data
+// taken from buff; the data of the regions transformed is handed back to buff.
+//
+// A module going over limits fails the pipe with ErrWasmLimit, as does one returning
+// data out of its memory. The module is instantiated when the pipe opens the valve, and
+// closed once the run is over.
+func Wasm(rt WasmRuntime, module []byte, limits WasmLimits, buff Buffer) pipe.Valve {
+	return &wasm{rt: rt, module: module, limits: limits, buff: buff}
+}
+
+type wasm struct {
+	rt     WasmRuntime
+	module []byte
+	limits WasmLimits
+	buff   Buffer
+}
+
+func (w *wasm) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer pipe.Pin(ctx)()
+
+		inst, err := w.rt.Instantiate(ctx, w.module, w.limits)
+		if err != nil {
+			close(sink)
+			reject(ctx, source, errs, w.buff, fmt.Errorf("wasm: error instantiating module: %w", err))
+			return
+		}
+		defer inst.Close(context.WithoutCancel(ctx))
+		defer close(sink)
+
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				return
+			}
+
+			data, err := w.transform(ctx, inst, r.Data)
+			Release(w.buff, r)
+			if err != nil {
+				if ctx.Err() == nil {
+					errs <- fmt.Errorf("wasm: region at offset=%d: %w", r.Off, err)
+				}
+				go func() {
+					for r := range source {
+						Release(w.buff, r)
+					}
+				}()
+				return
+			}
+
+			r.Data = data
+			if !send(ctx, sink, r) {
+				w.buff.Put(data)
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// transform runs the data through the module, returning what comes out in a buffer of
+// its own
+func (w *wasm) transform(ctx context.Context, inst WasmInstance, data []byte) ([]byte, error) {
+	if w.limits.Time > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, w.limits.Time, fmt.Errorf("%w: took over %v", ErrWasmLimit, w.limits.Time))
+		defer cancel()
+	}
+	call := func(name string, params ...uint64) (uint64, error) {
+		res, err := inst.Call(ctx, name, params...)
+		if cause := context.Cause(ctx); cause != nil {
+			// whatever the runtime made of it
+			return 0, cause
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+		if len(res) != 1 {
+			return 0, fmt.Errorf("%s returned %d results rather than 1", name, len(res))
+		}
+		return res[0], nil
+	}
+
+	if int64(len(data)) > w.limits.Memory && w.limits.Memory > 0 || uint64(len(data)) > 1<<32-1 {
+		return nil, fmt.Errorf("%w: %d bytes don't fit in memory", ErrWasmLimit, len(data))
+	}
+	ptr, err := call("alloc", uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if !inst.Write(uint32(ptr), data) {
+		return nil, fmt.Errorf("%w: alloc returned %d bytes at %d, out of memory", ErrWasmLimit, len(data), uint32(ptr))
+	}
+
+	res, err := call("transform", uint64(uint32(ptr)), uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	out, ok := inst.Read(uint32(res>>32), uint32(res))
+	if !ok {
+		return nil, fmt.Errorf("%w: transform returned %d bytes at %d, out of memory", ErrWasmLimit, uint32(res), uint32(res>>32))
+	}
+
+	buf, err := Acquire(ctx, w.buff)
+	if err != nil {
+		return nil, err
+	}
+	if cap(buf) < len(out) {
+		w.buff.Put(buf)
+		buf = make([]byte, len(out))
+	}
+	return append(buf[:0], out...), nil
+}
@@ -1,94 +1,417 @@
 package io
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 
 	"github.com/naylorpmax-joyent/pipe"
 )
 
-// Pool implements pipe.Sink and writes regions using a pool of writers
+// Pool implements pipe.Sink and writes regions using a pool of writers, picking the
+// writer of every region with a Selector (LeastOutstanding unless told otherwise, see
+// SelectWith). Pool implements pipe.Scalable, so the number of writers in use can be
+// changed while the pipe runs - and more writers opened then, see Grow.
 func Pool(buff Buffer, writers ...io.WriterAt) *pool {
-	p := make(chan io.WriterAt, len(writers))
-	for i := range writers {
-		p <- writers[i]
+	p := &pool{
+		buff:     buff,
+		selector: LeastOutstanding(),
+		active:   len(writers),
+		wake:     make(chan struct{}),
 	}
-
-	return &pool{
-		buff:    buff,
-		writers: p,
+	for _, w := range writers {
+		p.writers = append(p.writers, &poolWriter{w: w})
 	}
+	return p
+}
+
+// SelectWith has the pool pick writers with s, and returns it.
+func (p *pool) SelectWith(s Selector) *pool {
+	p.selector = s
+	return p
+}
+
+var errNoWriters = errors.New("no writers in the pool")
+
+// Grow has the pool open more writers with open when its concurrency is set higher than
+// the number of writers it has (see SetConcurrency), up to max writers in all, rather
+// than being stuck with the writers it was made with. A writer that fails to open leaves
+// the pool as large as it was, see Err. The writers opened stay in the pool (out of
+// circulation once the concurrency is lowered), and are the caller's to close once done
+// with the pool, see Writers.
+func (p *pool) Grow(max int, open func() (io.WriterAt, error)) *pool {
+	p.max = max
+	p.open = open
+	return p
 }
 
 type pool struct {
-	writers chan io.WriterAt
-	buff    Buffer
+	buff     Buffer
+	selector Selector
+	retry    *RetryPolicy
+	size     *int64 // to truncate the destination to, if set
+
+	grow sync.Mutex // held while opening writers
+	max  int
+	open func() (io.WriterAt, error)
+	err  error
+
+	mu      sync.Mutex
+	writers []*poolWriter
+	active  int           // number of writers in circulation: the first ones
+	states  []WriterState // scratch space for asking the selector
+	wake    chan struct{} // closed (and replaced) whenever a writer makes progress
+}
+
+type poolWriter struct {
+	w     io.WriterAt
+	state WriterState
 }
 
 func (p *pool) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
-	var waiter sync.WaitGroup
-	for {
-		data, more := <-source
-		if !more || ctx.Err() != nil {
-			// all out of data to write !
-			break
+	if p.Concurrency() == 0 {
+		// a pool made to grow may start out empty
+		p.SetConcurrency(1)
+		if p.Concurrency() == 0 {
+			go func() {
+				for r := range source {
+					Release(p.buff, r)
+				}
+			}()
+			errs <- fmt.Errorf("error opening writer: %w", cmp.Or(p.Err(), errNoWriters))
+			return
 		}
+	}
+	p.mu.Lock()
+	first := p.writers[0].w
+	p.mu.Unlock()
+	if err := truncate(first, p.size); err != nil {
+		go func() {
+			for r := range source {
+				Release(p.buff, r)
+			}
+		}()
+		errs <- err
+		return
+	}
+
+	var (
+		waiter sync.WaitGroup
+		failed atomic.Bool
+	)
+
+	// every writer works through the regions handed to it in order, on a goroutine of
+	// its own - started once it's first handed a region, since writers may be added
+	// along the way
+	var queues []chan pipe.Region
+	start := func() {
+		i := len(queues)
+		queue := make(chan pipe.Region, poolDepth)
+		queues = append(queues, queue)
+
+		p.mu.Lock()
+		w := p.writers[i].w
+		p.mu.Unlock()
 
 		waiter.Add(1)
-		// acquire an idle writer from the pool
-		writer := <-p.writers
 		go func() {
-			written := 0
-			for written < len(data.Data) {
-				n, err := writer.WriteAt(data.Data[written:], data.Off)
-				if err != nil {
-					errs <- fmt.Errorf("error writing regions: %w", err)
-					return
-				}
-				written += n
+			defer waiter.Done()
+			for data := range queue {
+				p.write(ctx, i, w, data, &failed, errs)
 			}
-
-			p.writers <- writer   // release writer
-			p.buff.Put(data.Data) // release buffer
-			waiter.Done()
 		}()
 	}
 
+	for {
+		data, more := pipe.Next(ctx, source)
+		if !more {
+			// all out of data to write !
+			break
+		}
+
+		i, ok := p.dispatch(ctx, data)
+		if !ok {
+			Release(p.buff, data)
+			break
+		}
+		for len(queues) <= i {
+			start()
+		}
+		queues[i] <- data
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
 	waiter.Wait()
-	errs <- nil
+	if !failed.Load() {
+		errs <- ctx.Err()
+	}
+}
+
+// dispatch picks the writer of a region, waiting for it to have room for the region,
+// and hands the region to it; it returns false if the context is done first
+func (p *pool) dispatch(ctx context.Context, data pipe.Region) (int, bool) {
+	for {
+		p.mu.Lock()
+		p.states = p.states[:0]
+		for _, w := range p.writers[:p.active] {
+			p.states = append(p.states, w.state)
+		}
+
+		if i := p.selector.Select(data, p.states); i >= 0 && i < p.active && p.writers[i].state.Queued < poolDepth {
+			w := &p.writers[i].state
+			w.Queued++
+			w.Outstanding += int64(len(data.Data))
+			w.Handed++
+			p.mu.Unlock()
+			return i, true
+		}
+		wake := p.wake
+		p.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return 0, false
+		}
+	}
+}
+
+// write has writer i (w) write a region handed to it, unless the run is over already
+func (p *pool) write(ctx context.Context, i int, w io.WriterAt, data pipe.Region, failed *atomic.Bool, errs chan<- error) {
+	defer p.done(i, data)
+	defer Release(p.buff, data) // release buffer
+
+	if ctx.Err() != nil || failed.Load() {
+		return
+	}
+	release, ok := pipe.Worker(ctx)
+	if !ok {
+		return
+	}
+	defer release()
+
+	err := writeAll(ctx, w, data, p.retry)
+	if err == nil {
+		return
+	}
+	err = fmt.Errorf("error writing regions: %w", err)
+	if !pipe.Divert(ctx, data, err) && failed.CompareAndSwap(false, true) {
+		// the first failure ends the run, the others would go unheard
+		errs <- err
+	}
+}
+
+// done accounts for writer i being done with a region
+func (p *pool) done(i int, data pipe.Region) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w := &p.writers[i].state
+	w.Queued--
+	w.Outstanding -= int64(len(data.Data))
+	p.broadcast()
 }
 
-func Sink(w io.WriterAt, b Buffer) *sink {
-	return &sink{w: w, buff: b}
+// Concurrency implements pipe.Scalable.
+func (p *pool) Concurrency() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.active
+}
+
+// SetConcurrency implements pipe.Scalable. Writers taken out of circulation aren't handed
+// any more regions, but write the ones they already have. Writers are opened if need be
+// (and the pool can, see Grow).
+func (p *pool) SetConcurrency(n int) {
+	n = max(n, 1)
+	p.openWriters(n)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active = min(n, len(p.writers))
+	p.broadcast()
+}
+
+// openWriters opens writers until there are n of them, if the pool can grow
+func (p *pool) openWriters(n int) {
+	p.grow.Lock()
+	defer p.grow.Unlock()
+
+	if p.open == nil {
+		return
+	}
+	p.mu.Lock()
+	have := len(p.writers)
+	p.mu.Unlock()
+
+	for ; have < min(n, p.max); have++ {
+		w, err := p.open()
+		if err != nil {
+			p.err = err
+			return
+		}
+
+		p.mu.Lock()
+		p.writers = append(p.writers, &poolWriter{w: w})
+		p.mu.Unlock()
+	}
+}
+
+// Err returns the error the last writer that failed to open failed with, see Grow.
+func (p *pool) Err() error {
+	p.grow.Lock()
+	defer p.grow.Unlock()
+
+	return p.err
+}
+
+// Writers returns the writers of the pool: those it was made with, and those it opened
+// since (see Grow).
+func (p *pool) Writers() []io.WriterAt {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	writers := make([]io.WriterAt, len(p.writers))
+	for i, w := range p.writers {
+		writers[i] = w.w
+	}
+	return writers
+}
+
+// broadcast must be called with the lock held
+func (p *pool) broadcast() {
+	close(p.wake)
+	p.wake = make(chan struct{})
 }
 
+// Sink implements pipe.Sink and writes regions using a single writer
+func Sink(w io.WriterAt, b Buffer, opts ...SinkOption) *sink {
+	s := &sink{w: w, buff: b}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// SinkOption configures a Sink.
+type SinkOption func(*sink)
+
 type sink struct {
 	w    io.WriterAt
 	buff Buffer
+
+	batch   *batch
+	retry   *RetryPolicy
+	size    *int64 // to truncate the destination to, if set
+	resolve bool   // copy the data of duplicates, see ResolveDuplicates
 }
 
 func (w *sink) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	if err := truncate(w.w, w.size); err != nil {
+		go func() {
+			for r := range source {
+				Release(w.buff, r)
+			}
+		}()
+		errs <- err
+		return
+	}
+
+	if w.batch != nil {
+		w.readBatched(ctx, source, errs)
+		return
+	}
+
 	for {
-		data, more := <-source
-		if !more || ctx.Err() != nil {
+		data, more := pipe.Next(ctx, source)
+		if !more {
 			// all out of data to write !
 			break
 		}
 
-		written := 0
-		for written < len(data.Data) {
-			n, err := w.w.WriteAt(data.Data[written:], data.Off)
-			if err != nil {
-				errs <- fmt.Errorf("error writing region: %w", err)
-				return
+		if err := w.write(ctx, data); err != nil {
+			errs <- err
+			return
+		}
+
+		Release(w.buff, data) // release buffer
+	}
+
+	errs <- ctx.Err()
+}
+
+// write writes a region, unless it fails and the region is diverted (see pipe.Divert)
+func (w *sink) write(ctx context.Context, data pipe.Region) error {
+	if ref, ok := Duplicate.Get(data); ok && w.resolve {
+		copied, err := copyDuplicate(ctx, w.w, w.buff, ref)
+		if err != nil {
+			pipe.Fail(ctx, data, err)
+			if pipe.Divert(ctx, data, err) {
+				return nil
 			}
-			written += n
+			return err
+		}
+		defer w.buff.Put(copied)
+
+		data.Data = copied
+		data = Duplicate.Delete(data)
+	}
+
+	if err := writeAll(ctx, w.w, data, w.retry); err != nil {
+		err = fmt.Errorf("error writing region: %w", err)
+		if pipe.Divert(ctx, data, err) {
+			return nil
 		}
+		return err
+	}
+	return nil
+}
+
+// contextWriterAt is implemented by writers that can give up on a write once ctx is done
+type contextWriterAt interface {
+	writeAt(ctx context.Context, p []byte, off int64) (int, error)
+}
 
-		w.buff.Put(data.Data) // release buffer
+// writeAll writes the whole region with w, retrying failures according to retry (if
+// any), and reports the outcome to the pipe
+func writeAll(ctx context.Context, w io.WriterAt, data pipe.Region, retry *RetryPolicy) error {
+	writeAt := w.WriteAt
+	if cw, ok := w.(contextWriterAt); ok {
+		writeAt = func(p []byte, off int64) (int, error) { return cw.writeAt(ctx, p, off) }
+	}
+
+	if err := unresolved(data); err != nil {
+		pipe.Fail(ctx, data, err)
+		return err
+	}
+
+	written := 0
+	for attempt := 1; written < len(data.Data); {
+		n, err := writeAt(data.Data[written:], data.Off+int64(written))
+		written += n
+		if n == 0 && err == nil {
+			// (which io.WriterAt doesn't allow, and which would have us write forever)
+			err = pipe.ErrShortWrite
+		}
+		if err != nil {
+			if retry.again(ctx, attempt, err) {
+				attempt++
+				continue
+			}
+			pipe.Fail(ctx, data, err)
+			return err
+		}
 	}
+	pipe.Commit(ctx, data)
 
-	errs <- nil
+	return nil
 }
@@ -0,0 +1,85 @@
+package pipe
+
+import "sync/atomic"
+
+// lease is the hold of the regions lent a buffer on it (see Lend)
+type lease struct {
+	data []byte // the buffer as it was lent, whatever the regions made of it since
+	put  func(data []byte)
+	refs atomic.Int64
+}
+
+var leaseKey = NewKey[*lease]("pipe.lease")
+
+// Lend returns r with its data lent to the pipe rather than owned by whichever stage
+// holds the region: the buffer is handed back with put once every stage done with the
+// region has released it (see Release), and stages keeping the region around for longer
+// than it takes to hand it on (to retry it, hold it back, or hand it on twice) retain it
+// first (see Retain). Stages can then drop, hold or copy regions without keeping track of
+// who's to hand their buffer back, or the buffer being handed back twice.
+//
+// Sources lend regions right after reading data into a buffer of a pool (see
+// pipeio.Lent); the sinks and valves of package io release the regions they're done
+// with, and hand the buffers of regions that aren't lent back as before.
+func Lend(r Region, put func(data []byte)) Region {
+	l := &lease{data: r.Data, put: put}
+	l.refs.Store(1)
+	return leaseKey.Set(r, l)
+}
+
+// Retain takes another reference to the buffer of r, if it's lent (see Lend), which is to
+// be released on its own: the buffer is only handed back once every reference is. It
+// returns r, for it to be handed on.
+func (r Region) Retain() Region {
+	if l, ok := leaseKey.Get(r); ok {
+		l.refs.Add(1)
+	}
+	return r
+}
+
+// Release releases a reference to the buffer of r, handing the buffer back once it was
+// the last one, and reports whether r is lent at all (see Lend): if it isn't, its buffer
+// is the caller's to hand back, as it was before regions were lent. Releasing more
+// references than were taken does nothing, so a buffer is never handed back twice.
+func (r Region) Release() bool {
+	l, ok := leaseKey.Get(r)
+	if !ok {
+		return false
+	}
+	if l.refs.Add(-1) == 0 {
+		l.put(l.data)
+	}
+	return true
+}
+
+// unlent returns r without the lease of its data, for copies of the region whose data is
+// a buffer of their own
+func unlent(r Region) Region {
+	return leaseKey.Delete(r)
+}
+
+// Pool is where CloneRegion gets the buffer of a copy from, and hands it back to: a
+// pipeio.Buffer is one.
+type Pool interface {
+	Get() []byte
+	Put(buff []byte)
+}
+
+// CloneRegion returns a copy of r whose data is a buffer got from buff, lent to the pipe
+// (see Lend) so that it's handed back to buff once the copy is released, whatever becomes
+// of r: for stages handing a region on more than once (as Tee does), or keeping it around
+// once they've handed it on, without copies that bypass the pool. Data that doesn't fit
+// in a buffer of buff is copied to one of its own, which buff may keep or drop once it's
+// handed back.
+func CloneRegion(r Region, buff Pool) Region {
+	data := buff.Get()
+	if cap(data) < len(r.Data) {
+		buff.Put(data)
+		data = make([]byte, len(r.Data))
+	}
+	data = data[:len(r.Data)]
+	copy(data, r.Data)
+
+	r.Data = data
+	return Lend(r, buff.Put)
+}
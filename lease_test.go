@@ -0,0 +1,113 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestRegion_Release(t *testing.T) {
+	// given: a region lent, and retained once
+	var put [][]byte
+	data := []byte("AAAAAAAAAA")
+	r := pipe.Lend(pipe.Region{Data: data[:5]}, func(data []byte) { put = append(put, data) })
+	held := r.Retain()
+
+	// when/then: the buffer is handed back, whole, once both references are released
+	assert.Assert(t, r.Release())
+	assert.Equal(t, len(put), 0)
+	assert.Assert(t, held.Release())
+	assert.DeepEqual(t, put, [][]byte{data[:5]})
+
+	// and never twice
+	assert.Assert(t, held.Release())
+	assert.Equal(t, len(put), 1)
+
+	// and regions that aren't lent are their holder's to hand back
+	assert.Assert(t, !pipe.Region{Data: data}.Release())
+}
+
+func TestLent(t *testing.T) {
+	// given: lent regions going through a valve handing every one of them on twice
+	data := bytes.Repeat([]byte("ABCDEFGHIJ"), 10)
+	buff := &puttingBuffer{Buffer: pipeio.NewBuffer(10, 4)}
+	twice := &sendTwice{}
+	sink := pipeio.BytesSink().From(buff)
+	p := pipe.New(pipeio.Source(bytes.NewReader(data), 0, buff, pipeio.Lent()), sink, twice)
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: every buffer was handed back, once
+	assert.NilError(t, err)
+	assert.DeepEqual(t, sink.Bytes(), data)
+	assert.Equal(t, buff.puts.Load(), buff.gets.Load())
+}
+
+// puttingBuffer counts the buffers got and put
+type puttingBuffer struct {
+	pipeio.Buffer
+	gets, puts atomic.Int64
+}
+
+func (b *puttingBuffer) Get() []byte {
+	b.gets.Add(1)
+	return b.Buffer.Get()
+}
+
+func (b *puttingBuffer) Put(buff []byte) {
+	b.puts.Add(1)
+	b.Buffer.Put(buff)
+}
+
+// sendTwice hands every region on twice, retaining it for the second time
+type sendTwice struct{}
+
+func (sendTwice) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer close(sink)
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				return
+			}
+			for _, r := range []pipe.Region{r.Retain(), r} {
+				select {
+				case sink <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return source
+}
+
+func TestCloneRegion(t *testing.T) {
+	// given: a region lent, and a copy of it
+	buff := &puttingBuffer{Buffer: pipeio.NewBuffer(10, 4)}
+	var put int
+	r := pipe.Lend(pipe.Region{Data: []byte("ABCDE"), Off: 5}, func([]byte) { put++ })
+	clone := pipe.CloneRegion(r, buff)
+
+	// then: the copy has data of its own, from the pool
+	assert.DeepEqual(t, clone.Data, []byte("ABCDE"))
+	assert.Equal(t, clone.Off, int64(5))
+	assert.Equal(t, buff.gets.Load(), int64(1))
+	clone.Data[0] = 'Z'
+	assert.DeepEqual(t, r.Data, []byte("ABCDE"))
+
+	// and each is released on its own, to where its buffer came from
+	assert.Assert(t, clone.Release())
+	assert.Equal(t, buff.puts.Load(), int64(1))
+	assert.Equal(t, put, 0)
+	assert.Assert(t, r.Release())
+	assert.Equal(t, put, 1)
+}
@@ -0,0 +1,77 @@
+package pipe
+
+import (
+	"context"
+	"log/slog"
+	"runtime/pprof"
+	"time"
+)
+
+// WithName names the pipe, so whatever it logs (see WithLogger) and the profiles of its
+// stages (see WithProfile) can be told apart from those of other pipes in the process.
+func WithName(name string) Option {
+	return func(p *Pipe) {
+		p.name = name
+	}
+}
+
+// Name returns the name the pipe was given with WithName, if any.
+func (p *Pipe) Name() string {
+	return p.name
+}
+
+// WithLogger has the pipe log what it's up to with l: every run starting, each stage
+// being turned on (at debug level), and the run being done, with how long it took and
+// what it failed with (at error level) if it did. Everything is logged with the name of
+// the pipe, if it has one (see WithName). Pipes log nothing by default.
+func WithLogger(l *slog.Logger) Option {
+	return func(p *Pipe) {
+		p.logger = l
+	}
+}
+
+// log returns the logger of the pipe, one discarding everything if it has none
+func (p *Pipe) log() *slog.Logger {
+	l := p.logger
+	if l == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	if p.name != "" {
+		l = l.With("pipe", p.name)
+	}
+	return l
+}
+
+// logRun logs the run starting, and returns the func that logs it being done with err
+func (p *Pipe) logRun(ctx context.Context) func(err error) {
+	l := p.log()
+	l.DebugContext(ctx, "pipe started", "valves", len(p.valves))
+
+	start := time.Now()
+	return func(err error) {
+		elapsed := time.Since(start)
+		if err != nil {
+			l.ErrorContext(ctx, "pipe failed", "elapsed", elapsed, "err", err)
+			return
+		}
+		l.InfoContext(ctx, "pipe done", "elapsed", elapsed)
+	}
+}
+
+// stage labels the calling goroutine, and the goroutines it starts from then on, with the
+// name of the stage (and of the pipe) when the pipe is profiled, logs it being turned on,
+// and starts its span if the run is traced (see WithTracer)
+func (p *Pipe) stage(ctx context.Context, name string) {
+	p.log().DebugContext(ctx, "stage started", "stage", name)
+	if t, ok := ctx.Value(traceKey{}).(*trace); ok {
+		t.stage(name)
+	}
+	if p.profile == "" {
+		return
+	}
+	labels := []string{"stage", name}
+	if p.name != "" {
+		labels = append(labels, "pipe", p.name)
+	}
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels(labels...)))
+}
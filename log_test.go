@@ -0,0 +1,183 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithLogger(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected []string
+	}{
+		"done": {
+			expected: []string{
+				`level=DEBUG msg="pipe started" pipe=backup valves=1`,
+				`level=DEBUG msg="stage started" pipe=backup stage=sink`,
+				`level=DEBUG msg="stage started" pipe=backup stage="valve 0"`,
+				`level=DEBUG msg="stage started" pipe=backup stage=gate`,
+				`level=DEBUG msg="stage started" pipe=backup stage=source`,
+				`level=INFO msg="pipe done" pipe=backup elapsed=`,
+			},
+		},
+		"failed": {
+			err: errors.New("disk on fire"),
+			expected: []string{
+				`level=DEBUG msg="pipe started" pipe=backup valves=1`,
+				`level=ERROR msg="pipe failed" pipe=backup elapsed=`,
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			var out bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{
+				Level: slog.LevelDebug,
+				ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			}))
+			valve := &noopValve{f: func(pipe.Region) error { return nil }}
+			p := pipe.New(&source{regions: pipetest.Regions(4, 8)}, &sink{f: func(pipe.Region) error { return test.err }}, valve).
+				With(pipe.WithName("backup"), pipe.WithLogger(logger))
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, p.Name(), "backup")
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if test.err == nil {
+				assert.Equal(t, len(lines), len(test.expected))
+			}
+			for _, expected := range test.expected {
+				assert.Assert(t, containsPrefix(lines, expected), "no %q in:\n%s", expected, out.String())
+			}
+			if test.err != nil {
+				assert.Assert(t, strings.HasSuffix(lines[len(lines)-1], `err="sink (*pipe_test.sink): disk on fire"`), lines[len(lines)-1])
+			}
+		})
+	}
+}
+
+func containsPrefix(lines []string, prefix string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPipe_WithChannelCapacity(t *testing.T) {
+	tests := map[string]struct {
+		capacity int
+		ahead    bool
+	}{
+		"unbuffered": {capacity: 0, ahead: false},
+		"buffered":   {capacity: 4, ahead: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given: a sink holding on to the first region, and a source with as many
+			// regions as fit in the channels (and the gate) past it
+			src := &wrote{source: source{regions: pipetest.Regions(8, 8)}, done: make(chan struct{})}
+			first := true
+			var ahead bool
+			p := pipe.New(src, &sink{f: func(pipe.Region) error {
+				if first {
+					first = false
+					select {
+					case <-src.done:
+						ahead = true
+					case <-time.After(50 * time.Millisecond):
+					}
+				}
+				return nil
+			}}).With(pipe.WithChannelCapacity(test.capacity))
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then: the source got through them without waiting on the sink
+			assert.NilError(t, err)
+			assert.Equal(t, ahead, test.ahead)
+		})
+	}
+}
+
+func TestPipe_WithChannelCapacities(t *testing.T) {
+	tests := map[string]struct {
+		capacities []int
+		taken      int // by the first valve, with the sink holding on to the first region
+	}{
+		// the one held by the sink, and one held by each valve
+		"unbuffered": {taken: 3},
+		// and four more in the connector between the valves, and one held by the
+		// goroutine handing them on
+		"between valves": {capacities: []int{0, 4}, taken: 8},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			release := make(chan struct{})
+			first := &pipetest.Valve{}
+			sink := &pipetest.Sink{Check: func(r pipe.Region) error {
+				if r.Off == 0 {
+					<-release
+				}
+				return nil
+			}}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(20, 10)}, sink, first, &pipetest.Valve{}).
+				With(pipe.WithChannelCapacities(test.capacities...))
+
+			// when
+			done := make(chan error)
+			go func() { done <- p.Pipe(context.Background()) }()
+			time.Sleep(50 * time.Millisecond)
+			taken := len(first.Regions())
+			close(release)
+
+			// then
+			assert.NilError(t, <-done)
+			assert.Equal(t, taken, test.taken)
+			assert.Equal(t, len(sink.Regions()), 20)
+		})
+	}
+}
+
+func TestChannelCapacity(t *testing.T) {
+	assert.Equal(t, pipeio.ChannelCapacity(pipeio.NewBuffer(10, 16), 4), 2)
+	assert.Equal(t, pipeio.ChannelCapacity(pipeio.Limit(pipeio.NewBuffer(10, 16), 400), 4), 5)
+	assert.Equal(t, pipeio.ChannelCapacity(pipeio.NewSyncBuffer(10), 4), 1)
+}
+
+// wrote is a source closing done once it's done writing its regions
+type wrote struct {
+	source
+	done chan struct{}
+}
+
+func (s *wrote) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	s.source.Write(ctx, sink, errs)
+	close(s.done)
+}
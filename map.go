@@ -0,0 +1,72 @@
+package pipe
+
+import "context"
+
+// MappedFrom is the key of the offset a region's data was at before a Map transformed it,
+// for stages further down to tie the transformed stream back to the original (to build
+// an index of a compressed archive, say).
+var MappedFrom = NewKey[int64]("pipe.mapped-from")
+
+// Map returns a Valve applying fn to the data of every region passing through, for
+// transforms whose output isn't the size of their input (compressing, encoding...). Since
+// offsets can't carry over from the input then, the transformed regions make up a stream
+// of their own, at offsets starting at 0 (see From) in the order the regions come in, one
+// after the other: a sink writes them where they belong in the transformed stream with
+// WriteAt, as it would any other. The offset each region was at is kept as its MappedFrom.
+//
+// Put a Reorder in front of the map for the transformed stream to be in the order of the
+// original. fn is handed the region's data to do as it pleases with: if it came from a
+// pool of buffers, fn hands it back once done with it.
+func Map(fn func(data []byte) ([]byte, error)) *mapper {
+	return &mapper{fn: fn}
+}
+
+type mapper struct {
+	fn  func(data []byte) ([]byte, error)
+	off int64
+}
+
+// From has the transformed stream start at off rather than 0 (for a transfer resuming
+// halfway, say).
+func (m *mapper) From(off int64) *mapper {
+	m.off = off
+	return m
+}
+
+func (m *mapper) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+
+		yield := yielder(ctx)
+		off := m.off
+		for {
+			in, more := Next(ctx, source)
+			if !more {
+				return
+			}
+
+			data, err := m.fn(in.Data)
+			if err != nil {
+				if divert(ctx, in, err) {
+					continue
+				}
+				errs <- err
+				return
+			}
+
+			r := MappedFrom.Set(Region{Data: data, Off: off, Scope: in.Scope, Meta: in.Meta}, in.Off)
+			off += int64(len(data))
+
+			yield(r)
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
@@ -0,0 +1,55 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestMap(t *testing.T) {
+	// given: a transform shrinking every region to a byte per 5
+	shrink := func(data []byte) ([]byte, error) {
+		return bytes.Repeat(data[:1], len(data)/5), nil
+	}
+	sink := &pipetest.Sink{}
+
+	// when
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(4, 10)}, sink, pipe.Map(shrink).From(100))
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: the transformed regions follow each other, tied back to where they came from
+	type mapped struct {
+		Off, From int64
+		Data      string
+	}
+	var got []mapped
+	for _, r := range sink.Regions() {
+		from, ok := pipe.MappedFrom.Get(r)
+		assert.Assert(t, ok)
+		got = append(got, mapped{Off: r.Off, From: from, Data: string(r.Data)})
+	}
+	want := []mapped{
+		{Off: 100, From: 0, Data: "AA"},
+		{Off: 102, From: 10, Data: "BB"},
+		{Off: 104, From: 20, Data: "CC"},
+		{Off: 106, From: 30, Data: "DD"},
+	}
+	assert.DeepEqual(t, got, want)
+}
+
+func TestMap_error(t *testing.T) {
+	// given
+	fail := func([]byte) ([]byte, error) { return nil, errors.New("can't") }
+
+	// when
+	err := pipe.New(&pipetest.Source{Regions: pipetest.Regions(4, 10)}, &pipetest.Sink{}, pipe.Map(fail)).Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "can't")
+}
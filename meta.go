@@ -0,0 +1,56 @@
+package pipe
+
+import "maps"
+
+// Meta holds information stages attach to a region on its way through the pipe (a
+// checksum, whether it's compressed, the file it was read from...) for stages further
+// down to make decisions with. Values are set and read with Keys, which give them their
+// type.
+//
+// Unlike the scope, which is the same for every region of a run, metadata belongs to a
+// region. Copies of a region share its metadata, so it's never modified in place: Set
+// returns a region with metadata of its own.
+type Meta map[any]any
+
+// Key identifies a value of type T in the metadata of regions. Keys are compared by
+// name and type, so packages should name theirs after themselves to avoid clashes.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns the key of values of type T named name.
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+func (k Key[T]) String() string {
+	return k.name
+}
+
+// Get returns the value r has for k, false if it has none.
+func (k Key[T]) Get(r Region) (T, bool) {
+	v, ok := r.Meta[k].(T)
+	return v, ok
+}
+
+// Set returns r with v as its value for k, leaving the metadata of r (and of its other
+// copies) as it was.
+func (k Key[T]) Set(r Region, v T) Region {
+	meta := make(Meta, len(r.Meta)+1)
+	maps.Copy(meta, r.Meta)
+	meta[k] = v
+	r.Meta = meta
+	return r
+}
+
+// Delete returns r without a value for k, leaving the metadata of r (and of its other
+// copies) as it was.
+func (k Key[T]) Delete(r Region) Region {
+	if _, ok := r.Meta[k]; !ok {
+		return r
+	}
+	meta := maps.Clone(r.Meta)
+	delete(meta, k)
+	r.Meta = meta
+	return r
+}
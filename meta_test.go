@@ -0,0 +1,72 @@
+package pipe_test
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestKey(t *testing.T) {
+	// given
+	checksum := pipe.NewKey[uint32]("checksum")
+	compressed := pipe.NewKey[bool]("compressed")
+	r := pipe.Region{Data: []byte("abc")}
+
+	// when
+	tagged := checksum.Set(r, 42)
+	both := compressed.Set(tagged, true)
+	untagged := checksum.Delete(both)
+
+	// then: every region has metadata of its own
+	_, ok := checksum.Get(r)
+	assert.Assert(t, !ok)
+	sum, ok := checksum.Get(tagged)
+	assert.Assert(t, ok)
+	assert.Equal(t, sum, uint32(42))
+	_, ok = compressed.Get(tagged)
+	assert.Assert(t, !ok)
+
+	sum, _ = checksum.Get(both)
+	flag, _ := compressed.Get(both)
+	assert.Equal(t, sum, uint32(42))
+	assert.Equal(t, flag, true)
+
+	_, ok = checksum.Get(untagged)
+	assert.Assert(t, !ok)
+	_, ok = compressed.Get(untagged)
+	assert.Assert(t, ok)
+
+	// and keys of other types don't see the value, whatever their name
+	_, ok = pipe.NewKey[string]("checksum").Get(both)
+	assert.Assert(t, !ok)
+	assert.Equal(t, checksum.String(), "checksum")
+}
+
+func TestPipe_meta(t *testing.T) {
+	// given: a valve attaching checksums, and a sink checking them
+	checksum := pipe.NewKey[uint32]("checksum")
+	tag := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		return checksum.Set(r, crc32.ChecksumIEEE(r.Data)), nil
+	})
+	sink := &pipetest.Sink{Check: func(r pipe.Region) error {
+		sum, ok := checksum.Get(r)
+		if !ok || sum != crc32.ChecksumIEEE(r.Data) {
+			return fmt.Errorf("region at offset=%d has checksum %d (%v)", r.Off, sum, ok)
+		}
+		return nil
+	}}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, tag, pipe.Reorder(0))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	assert.NilError(t, err)
+	assert.Equal(t, len(sink.Regions()), 10)
+}
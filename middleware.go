@@ -0,0 +1,267 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPanic is what a valve wrapped with Recover fails with when it panics, along with
+// what it panicked with.
+var ErrPanic = errors.New("valve panicked")
+
+// Logging returns v, logging to l every region it takes in and hands on (at debug level)
+// and what it fails with (at error level), so a valve can be looked into without being
+// rewritten. Like the other wrappers of valves (Metrics, Recover, Timeout), it can be
+// wrapped around any valve, and around the others: a Func is wrapped into a Func, which
+// can still be fused and batched.
+func Logging(v Valve, l *slog.Logger) Valve {
+	name := fmt.Sprintf("%T", v)
+	return around(v, hooks{
+		in: func(r Region) {
+			l.Debug("region in", "valve", name, "off", r.Off, "len", len(r.Data))
+		},
+		out: func(r Region) {
+			l.Debug("region out", "valve", name, "off", r.Off, "len", len(r.Data))
+		},
+		fail: func(err error) {
+			l.Error("valve failed", "valve", name, "err", err)
+		},
+	})
+}
+
+// ValveMetrics counts what goes through a valve wrapped with Metrics, and can be read
+// while the pipe runs.
+type ValveMetrics struct {
+	RegionsIn, RegionsOut atomic.Int64
+	BytesIn, BytesOut     atomic.Int64
+	Errors                atomic.Int64
+}
+
+// Metrics returns v, counting what it takes in, hands on and fails with in m: for valves
+// whose pipe doesn't keep stats (see WithStats), or which are part of a Chain or Branch
+// the stats don't see into.
+func Metrics(v Valve, m *ValveMetrics) Valve {
+	return around(v, hooks{
+		in: func(r Region) {
+			m.RegionsIn.Add(1)
+			m.BytesIn.Add(int64(len(r.Data)))
+		},
+		out: func(r Region) {
+			m.RegionsOut.Add(1)
+			m.BytesOut.Add(int64(len(r.Data)))
+		},
+		fail: func(error) {
+			m.Errors.Add(1)
+		},
+	})
+}
+
+// Recover returns v, failing with ErrPanic rather than crashing the process when it
+// panics. Only panics in the goroutines of the pipe are recovered: those of a Func
+// (applied to regions by the pipe) and of Open, not those of the goroutines a valve
+// starts of its own accord.
+func Recover(v Valve) Valve {
+	if f, ok := v.(Func); ok {
+		return Func(func(r Region) (out Region, err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					out, err = r, fmt.Errorf("%w: %v", ErrPanic, p)
+				}
+			}()
+			return f(r)
+		})
+	}
+	return &recovered{v: v}
+}
+
+type recovered struct {
+	v Valve
+}
+
+func (rv *recovered) Open(ctx context.Context, sink chan Region, errs chan error) (source chan Region) {
+	defer func() {
+		if p := recover(); p != nil {
+			errs <- fmt.Errorf("%w: %v", ErrPanic, p)
+			// nothing gets through a valve that failed to open
+			source = make(chan Region)
+			go func() {
+				defer close(sink)
+				discard(source)
+			}()
+		}
+	}()
+	return rv.v.Open(ctx, sink, errs)
+}
+
+// Timeout returns v, failing with ErrRegionTimeout when v goes d without taking in the
+// region it's handed or handing one on, unless the stages after it are holding it up (or
+// goes d without being done, once the stream is over): a hung valve then fails the pipe
+// rather than have it hang too, as WithRegionTimeout does for the stages of the pipe.
+func Timeout(v Valve, d time.Duration) Valve {
+	return &wrapped{v: v, timeout: d}
+}
+
+// hooks are told about what goes through a wrapped valve
+type hooks struct {
+	in   func(r Region) // every region handed to the valve
+	out  func(r Region) // every region the valve hands on
+	fail func(err error)
+}
+
+// around returns v with h told about what goes through it: a Func if v is one, and a
+// valve wrapping its channels otherwise
+func around(v Valve, h hooks) Valve {
+	if f, ok := v.(Func); ok {
+		return Func(func(r Region) (Region, error) {
+			h.in(r)
+			out, err := f(r)
+			if err != nil {
+				h.fail(err)
+				return out, err
+			}
+			h.out(out)
+			return out, nil
+		})
+	}
+	return &wrapped{v: v, hooks: h}
+}
+
+// wrapped is a valve standing between v and the stages around it
+type wrapped struct {
+	v       Valve
+	hooks   hooks
+	timeout time.Duration // if > 0, how long v may go without taking in or handing on
+}
+
+func (w *wrapped) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	rl := newRelay(ctx, errs, 2, w.hooks.fail)
+	own := rl.errs[1] // the wrapper's own failures
+
+	out := make(chan Region)
+	in := w.v.Open(ctx, out, rl.errs[0])
+
+	var (
+		handed  atomic.Int64 // when v last handed a region on, in unix nanoseconds
+		sending atomic.Bool  // whether a region v handed on is waiting for the next stage
+		drained = make(chan struct{})
+	)
+	handed.Store(time.Now().UnixNano())
+	// stuck returns whether v went the timeout without handing a region on, of its own
+	// doing (handed is always fresh once sending is false)
+	stuck := func() bool {
+		return !sending.Load() && time.Since(time.Unix(0, handed.Load())) >= w.timeout
+	}
+	// timedOut returns whether v is stuck, and fails the wrapper if so
+	timedOut := func() bool {
+		if !stuck() {
+			return false
+		}
+		own <- fmt.Errorf("%w: %T went %v without taking in or handing on a region", ErrRegionTimeout, w.v, w.timeout)
+		return true
+	}
+	// timer returns a timer going off once the timeout's gone by (a timer that never does,
+	// with no timeout)
+	timer := func() *time.Timer {
+		t := time.NewTimer(w.timeout)
+		if w.timeout <= 0 {
+			t.Stop()
+		}
+		return t
+	}
+
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+		defer rl.stop()
+		defer close(drained)
+
+		for {
+			r, more := Next(ctx, out)
+			if !more {
+				return
+			}
+			if w.hooks.out != nil {
+				w.hooks.out(r)
+			}
+			handed.Store(time.Now().UnixNano())
+
+			// v isn't to blame for the time the next stage takes to take r
+			sending.Store(true)
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+			handed.Store(time.Now().UnixNano())
+			sending.Store(false)
+		}
+	}()
+
+	// send hands r to v, unless v is stuck or ctx is done first
+	send := func(r Region) bool {
+		select {
+		case in <- r:
+			return true
+		default:
+		}
+
+		t := timer()
+		defer t.Stop()
+		for {
+			select {
+			case in <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			case <-t.C:
+				if timedOut() {
+					return false
+				}
+				t.Reset(w.timeout)
+			}
+		}
+	}
+
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+
+		for {
+			r, more := Next(ctx, source)
+			if !more {
+				break
+			}
+			if !send(r) {
+				close(in)
+				return
+			}
+			if w.hooks.in != nil {
+				w.hooks.in(r)
+			}
+		}
+		close(in)
+
+		// v has until the timeout to be done too
+		t := timer()
+		defer t.Stop()
+		for {
+			select {
+			case <-drained:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if timedOut() {
+					return
+				}
+				t.Reset(w.timeout)
+			}
+		}
+	}()
+
+	return source
+}
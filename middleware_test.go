@@ -0,0 +1,134 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestLogging(t *testing.T) {
+	tests := map[string]pipe.Valve{
+		"func":  passthrough,
+		"valve": &pipetest.Valve{},
+	}
+
+	for name, valve := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			var logs bytes.Buffer
+			l := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			sink := &pipetest.Sink{}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, pipe.Logging(valve, l))
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			assert.NilError(t, err)
+			assert.Equal(t, len(sink.Regions()), 10)
+			assert.Equal(t, strings.Count(logs.String(), "msg=\"region in\""), 10)
+			assert.Equal(t, strings.Count(logs.String(), "msg=\"region out\""), 10)
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	// given: a valve failing on the sixth region
+	boom := errors.New("boom")
+	var m pipe.ValveMetrics
+	valve := &pipetest.Valve{Func: func(r pipe.Region) (pipe.Region, error) {
+		if r.Off == 50 {
+			return r, boom
+		}
+		return r, nil
+	}}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}, pipe.Metrics(valve, &m))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	assert.ErrorIs(t, err, boom)
+	assert.Equal(t, m.RegionsIn.Load(), int64(6))
+	assert.Equal(t, m.RegionsOut.Load(), int64(5))
+	assert.Equal(t, m.BytesOut.Load(), int64(50))
+	assert.Equal(t, m.Errors.Load(), int64(1))
+}
+
+func TestRecover(t *testing.T) {
+	tests := map[string]pipe.Valve{
+		"func": pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+			if r.Off == 30 {
+				panic("oops")
+			}
+			return r, nil
+		}),
+		"open": panicking{},
+	}
+
+	for name, valve := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}, pipe.Recover(valve))
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			assert.ErrorIs(t, err, pipe.ErrPanic)
+			assert.ErrorContains(t, err, "oops")
+		})
+	}
+}
+
+type panicking struct{}
+
+func (panicking) Open(context.Context, chan pipe.Region, chan error) chan pipe.Region {
+	panic("oops")
+}
+
+func TestTimeout(t *testing.T) {
+	tests := map[string]struct {
+		valve pipe.Valve
+		sink  *pipetest.Sink
+		err   error
+	}{
+		"hung": {
+			valve: &pipetest.Valve{Delay: 100 * time.Millisecond},
+			sink:  &pipetest.Sink{},
+			err:   pipe.ErrRegionTimeout,
+		},
+		"held up by the sink": {
+			valve: &pipetest.Valve{},
+			sink:  &pipetest.Sink{Delay: 30 * time.Millisecond},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			source := &pipetest.Source{Regions: pipetest.Regions(4, 10)}
+			p := pipe.New(source, test.sink, pipe.Timeout(test.valve, 10*time.Millisecond))
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			} else {
+				assert.NilError(t, err)
+				assert.Equal(t, len(test.sink.Regions()), 4)
+			}
+		})
+	}
+}
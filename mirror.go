@@ -0,0 +1,105 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Mirror returns a Valve that passes regions straight on, and copies each of them to sink
+// on the side: cheap asynchronous replication alongside the authoritative copy. The
+// mirror never holds the pipe up. Copies wait for the secondary sink in a queue of up to
+// n regions, and regions arriving while it's full aren't mirrored (see Dropped).
+//
+// Regions are copied as they go by, since the pipe is free to reuse their data as soon
+// as they've landed; sinks that hand data back to a Buffer shouldn't be given the pipe's
+// own to mirror to, as the copies don't come from it. The secondary sink runs with the
+// values of the pipe's context but not its cancellation, so it gets through the queue
+// after the run is over (see Wait); what it writes doesn't count towards the pipe's
+// Report, and it can't fail the pipe.
+func Mirror(sink Sink, n int) *mirror {
+	return &mirror{sink: sink, n: n}
+}
+
+type mirror struct {
+	sink Sink
+	n    int
+
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	err     error
+}
+
+func (m *mirror) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	queue := make(chan Region, m.n)
+	m.wg.Add(1)
+	go m.mirror(ctx, queue)
+
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+		defer close(queue)
+
+		for {
+			r, more := Next(ctx, source)
+			if !more {
+				break
+			}
+
+			select {
+			case queue <- unlent(Region{Data: bytes.Clone(r.Data), Off: r.Off, Scope: r.Scope, Meta: r.Meta}):
+			default:
+				m.dropped.Add(1)
+			}
+
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// mirror has the secondary sink work through the queue, apart from the run
+func (m *mirror) mirror(ctx context.Context, queue chan Region) {
+	defer m.wg.Done()
+
+	ctx = context.WithoutCancel(ctx)
+	ctx = context.WithValue(ctx, trackerKey{}, nil)
+	ctx = context.WithValue(ctx, schedKey{}, nil)
+
+	errs := make(chan error, 1)
+	m.sink.Read(ctx, queue, errs)
+	discard(queue)
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			m.mu.Lock()
+			m.err = err
+			m.mu.Unlock()
+		}
+	default:
+	}
+}
+
+// Dropped returns the number of regions that weren't mirrored because the queue was full.
+func (m *mirror) Dropped() int64 {
+	return m.dropped.Load()
+}
+
+// Wait blocks until the secondary sink is done with the regions queued up so far, and
+// returns its error if it failed.
+func (m *mirror) Wait() error {
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
@@ -0,0 +1,75 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestMirror(t *testing.T) {
+	// given: a primary sink that reuses region data as soon as it's done with it
+	primary := &pipetest.Sink{Check: func(r pipe.Region) error {
+		defer func() {
+			for i := range r.Data {
+				r.Data[i] = 'X'
+			}
+		}()
+		return nil
+	}}
+	secondary := &pipetest.Sink{}
+	mirror := pipe.Mirror(secondary, 10)
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, primary, mirror)
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: the secondary got its own copy of every region, without counting as written
+	assert.NilError(t, err)
+	assert.NilError(t, mirror.Wait())
+	assert.Equal(t, mirror.Dropped(), int64(0))
+	assert.DeepEqual(t, secondary.Regions(), pipetest.Regions(10, 10))
+	assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 100}})
+}
+
+func TestMirror_overloaded(t *testing.T) {
+	// given: a secondary sink that can't keep up
+	secondary := &pipetest.Sink{Delay: 20 * time.Millisecond}
+	mirror := pipe.Mirror(secondary, 1)
+	primary := &pipetest.Sink{}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, primary, mirror)
+
+	// when
+	start := time.Now()
+	err := p.Pipe(context.Background())
+	elapsed := time.Since(start)
+
+	// then: the primary flow didn't wait on it, and what didn't fit was dropped
+	assert.NilError(t, err)
+	assert.Assert(t, elapsed < 100*time.Millisecond, "took %s", elapsed)
+	assert.Equal(t, len(primary.Regions()), 10)
+	assert.NilError(t, mirror.Wait())
+	assert.Equal(t, int64(len(secondary.Regions()))+mirror.Dropped(), int64(10))
+	assert.Assert(t, mirror.Dropped() > 0)
+}
+
+func TestMirror_failed(t *testing.T) {
+	// given
+	secondary := &pipetest.Sink{Check: func(pipe.Region) error { return errors.New("replica down") }}
+	mirror := pipe.Mirror(secondary, 10)
+	primary := &pipetest.Sink{}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, primary, mirror)
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: the pipe went through regardless
+	assert.NilError(t, err)
+	assert.Equal(t, len(primary.Regions()), 10)
+	assert.ErrorContains(t, mirror.Wait(), "replica down")
+}
@@ -0,0 +1,94 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestMmap(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, size := range []int{0, 10, 3*KiB + 7} {
+		// given
+		want := bytes.Repeat([]byte("0123456789"), size/10)
+		want = append(want, bytes.Repeat([]byte("x"), size%10)...)
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, "src"), want, 0o644))
+
+		src, err := os.Open(filepath.Join(dir, "src"))
+		assert.NilError(t, err)
+		defer src.Close()
+		dst, err := os.Create(filepath.Join(dir, "dst"))
+		assert.NilError(t, err)
+		defer dst.Close()
+
+		m, err := pipeio.Mmap(src, KiB)
+		assert.NilError(t, err)
+		p := pipe.New(m, pipeio.Sink(dst, m))
+
+		// when
+		assert.NilError(t, p.Pipe(context.Background()))
+
+		// then
+		got, err := os.ReadFile(filepath.Join(dir, "dst"))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, got, want)
+
+		// the mapping was released once all regions were written
+		assert.ErrorContains(t, p.Pipe(context.Background()), "mapping already released")
+		assert.NilError(t, m.Close())
+	}
+}
+
+func TestMmap_foreign(t *testing.T) {
+	// given: a valve putting buffers of its own into the mapping, on top of its regions
+	dir := t.TempDir()
+	want := bytes.Repeat([]byte("0123456789"), 1000)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "src"), want, 0o644))
+	src, err := os.Open(filepath.Join(dir, "src"))
+	assert.NilError(t, err)
+	defer src.Close()
+	dst, err := os.Create(filepath.Join(dir, "dst"))
+	assert.NilError(t, err)
+	defer dst.Close()
+
+	m, err := pipeio.Mmap(src, KiB)
+	assert.NilError(t, err)
+	defer m.Close()
+	foreign := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		for range 3 {
+			m.Put(make([]byte, KiB))
+		}
+		return r, nil
+	})
+
+	// when
+	assert.NilError(t, pipe.New(m, pipeio.Sink(dst, m), foreign).Pipe(context.Background()))
+
+	// then: they were ignored, and the mapping outlived its regions
+	got, err := os.ReadFile(filepath.Join(dir, "dst"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, want)
+}
+
+func TestMmap_invalid(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "src")
+	assert.NilError(t, os.WriteFile(path, []byte("0123456789"), 0o644))
+	src, err := os.Open(path)
+	assert.NilError(t, err)
+	defer src.Close()
+
+	// when
+	_, err = pipeio.Mmap(src, 0)
+
+	// then
+	assert.ErrorContains(t, err, "invalid region size")
+}
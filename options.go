@@ -0,0 +1,16 @@
+package pipe
+
+// Option configures a Pipe.
+type Option func(*Pipe)
+
+// With applies the options to the pipe and returns it, so it can be chained onto New:
+//
+//	p := pipe.New(source, sink, valves...).With(opts...)
+//
+// Options should be applied before the pipe is run.
+func (p *Pipe) With(opts ...Option) *Pipe {
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
@@ -0,0 +1,103 @@
+package pipe
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FanOrdered combines sources into a single Source as Fan does, passing regions on in
+// order of offset across all of them: so that strictly sequential sinks (sockets, pipes,
+// tape) can take the stream of sharded readers. Each source must pass its own regions on
+// in order of offset, and the fan fails if one doesn't; the regions of different sources
+// may interleave any which way.
+//
+// A region is only passed on once every source has passed on a region at or after it, or
+// is done, so the fan goes as fast as its slowest source, holding a region for each of
+// them. Unlike Reorder, the stream may have gaps in it, and regions may overlap.
+func FanOrdered(sources ...Source) Source {
+	return &fanOrdered{sources: sources}
+}
+
+type fanOrdered struct {
+	sources []Source
+}
+
+func (s *fanOrdered) Write(ctx context.Context, sink chan Region, errs chan error) {
+	defer close(sink)
+
+	ins := make([]chan Region, len(s.sources))
+	var writing sync.WaitGroup
+	for i, src := range s.sources {
+		ins[i] = make(chan Region)
+		writing.Add(1)
+		go func() {
+			defer writing.Done()
+			src.Write(ctx, ins[i], errs)
+		}()
+	}
+	defer func() {
+		// sources left behind are done once ctx is, whatever they were waiting on
+		for _, in := range ins {
+			go discard(in)
+		}
+		writing.Wait()
+	}()
+
+	// the next region of every source that isn't done, lowest offset first
+	var heads regionHeap
+	for i := range ins {
+		if r, more := Next(ctx, ins[i]); more {
+			heap.Push(&heads, head{r: r, i: i})
+		}
+	}
+
+	yield := yielder(ctx)
+	for heads.Len() > 0 && ctx.Err() == nil {
+		h := heap.Pop(&heads).(head)
+
+		yield(h.r)
+		select {
+		case sink <- h.r:
+		case <-ctx.Done():
+			return
+		}
+
+		r, more := Next(ctx, ins[h.i])
+		if !more {
+			continue
+		}
+		if r.Off < h.r.Off {
+			errs <- fmt.Errorf("fan: %w: source %d went back from offset=%d to offset=%d", ErrOutOfOrder, h.i, h.r.Off, r.Off)
+			return
+		}
+		heap.Push(&heads, head{r: r, i: h.i})
+	}
+}
+
+// head is the next region of the i-th source of an ordered fan
+type head struct {
+	r Region
+	i int
+}
+
+// regionHeap is a heap of heads, by offset (and then by source, to keep the order of
+// regions at the same offset stable)
+type regionHeap []head
+
+func (h regionHeap) Len() int { return len(h) }
+func (h regionHeap) Less(i, j int) bool {
+	if h[i].r.Off != h[j].r.Off {
+		return h[i].r.Off < h[j].r.Off
+	}
+	return h[i].i < h[j].i
+}
+func (h regionHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *regionHeap) Push(x any)   { *h = append(*h, x.(head)) }
+func (h *regionHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,54 @@
+package pipe_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestFanOrdered(t *testing.T) {
+	// given: three shards striped across the stream, read at different speeds
+	regions := pipetest.Regions(30, 10)
+	sources := make([]pipe.Source, 3)
+	for i := range sources {
+		var shard []pipe.Region
+		for j := i; j < len(regions); j += len(sources) {
+			shard = append(shard, regions[j])
+		}
+		sources[i] = &pipetest.Source{Regions: shard, Delay: time.Duration(i) * time.Millisecond}
+	}
+	sink := &pipetest.Sink{}
+
+	// when
+	err := pipe.New(pipe.FanOrdered(sources...), sink, passthrough).Pipe(context.Background())
+
+	// then: the regions came out in order
+	assert.NilError(t, err)
+	var offs []int64
+	for _, r := range sink.Regions() {
+		offs = append(offs, r.Off)
+	}
+	assert.Equal(t, len(offs), 30)
+	assert.Assert(t, slices.IsSorted(offs), "%v", offs)
+}
+
+func TestFanOrdered_backwards(t *testing.T) {
+	// given: a source going back
+	regions := pipetest.Regions(4, 10)
+	p := pipe.New(pipe.FanOrdered(
+		&pipetest.Source{Regions: regions[:2]},
+		&pipetest.Source{Regions: []pipe.Region{regions[3], regions[2]}},
+	), &pipetest.Sink{})
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "fan: regions out of order: source 1 went back from offset=30 to offset=20")
+}
@@ -0,0 +1,106 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipefs "github.com/naylorpmax-joyent/pipe/fs"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestPlan_PackTo(t *testing.T) {
+	// given: a tree of small files, a large one and an empty one
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "packs")
+	files := tree(t, src, map[string]int{
+		"big":      10 * KiB,
+		"a/one":    300,
+		"a/two":    300,
+		"a/three":  300,
+		"a/b/four": 300,
+		"empty":    0,
+	})
+	pl, err := pipefs.NewPlan(src, pipefs.SmallFiles(KiB), pipefs.PackSize(700), pipefs.BufferSize(128))
+	assert.NilError(t, err)
+
+	// when
+	x, err := pl.PackTo(context.Background(), dst)
+
+	// then: every small file can be read back from its pack, the large one is left out
+	assert.NilError(t, err)
+	assert.Equal(t, len(x.Packs), 2)
+	assert.Equal(t, len(x.Entries), 5)
+	_, ok := x.Lookup("big")
+	assert.Assert(t, !ok)
+
+	for path, want := range files {
+		if path == "big" {
+			continue
+		}
+		e, ok := x.Lookup(path)
+		assert.Assert(t, ok, path)
+		pack, err := os.ReadFile(filepath.Join(dst, e.Pack))
+		assert.NilError(t, err)
+		assert.Assert(t, bytes.Equal(pack[e.Off:e.Off+e.Len], want), path)
+	}
+
+	// and: the index is stored alongside
+	f, err := os.Open(filepath.Join(dst, "index.json"))
+	assert.NilError(t, err)
+	defer f.Close()
+	stored, err := pipefs.ReadPackIndex(f)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, stored, x)
+}
+
+func TestPlan_PackInto_progress(t *testing.T) {
+	// given
+	src := t.TempDir()
+	tree(t, src, map[string]int{"a": 300, "b": 300, "c": 0, "d": 500})
+
+	var (
+		mu   sync.Mutex
+		last pipefs.Progress
+	)
+	pl, err := pipefs.NewPlan(src, pipefs.SmallFiles(KiB), pipefs.PackSize(700), pipefs.BufferSize(100),
+		pipefs.OnProgress(0, func(pr pipefs.Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			last = pr
+		}))
+	assert.NilError(t, err)
+
+	// when
+	_, err = pl.PackInto(context.Background(), func(name string, size int64, buff pipeio.Buffer) (pipe.Sink, error) {
+		return pipeio.StreamSink(&bytes.Buffer{}, 0, buff), nil
+	})
+
+	// then
+	assert.NilError(t, err)
+	assert.DeepEqual(t, last, pipefs.Progress{Files: 4, FilesDone: 4, Bytes: 1100, BytesDone: 1100})
+}
+
+func TestPlan_PackInto_failed(t *testing.T) {
+	// given
+	src := t.TempDir()
+	tree(t, src, map[string]int{"a": 300, "b": 300})
+	pl, err := pipefs.NewPlan(src, pipefs.SmallFiles(KiB))
+	assert.NilError(t, err)
+
+	// when
+	boom := errors.New("boom")
+	x, err := pl.PackInto(context.Background(), func(name string, size int64, buff pipeio.Buffer) (pipe.Sink, error) {
+		return nil, boom
+	})
+
+	// then
+	assert.ErrorIs(t, err, boom)
+	assert.Assert(t, x == nil)
+}
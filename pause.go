@@ -0,0 +1,83 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+)
+
+// Pause holds back the flow of regions into the pipe, at the source connector: no more
+// regions are taken off the source, and the pipe sits idle once the regions in flight
+// have landed, until Resume is called. Nothing is torn down meanwhile, and the run can
+// still be canceled or shut down while paused. A pipe paused before it runs starts out
+// paused; pausing a paused pipe does nothing.
+func (p *Pipe) Pause() {
+	p.pauser.pause(pausedByCaller)
+}
+
+// Resume lets regions flow into a paused pipe again (see Pause). Resuming a pipe that
+// isn't paused does nothing, and neither does resuming a pipe its Group preempted, until
+// the group lets it run again.
+func (p *Pipe) Resume() {
+	p.pauser.resume(pausedByCaller)
+}
+
+// pauser holds regions back at the gate while the pipe is paused, which in turn holds
+// the source back: the pipe drains what's in flight, then sits idle until resumed
+type pauser struct {
+	mu      sync.Mutex
+	held    holds         // who's holding the pipe paused
+	resumed chan struct{} // nil unless paused, closed on resume
+}
+
+// holds are the reasons a pipe is paused: it resumes once there are none left
+type holds uint8
+
+const (
+	pausedByCaller holds = 1 << iota // see Pause
+	pausedByGroup                    // preempted by a higher priority job
+)
+
+func (p *pauser) pause(by holds) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.held |= by
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+	}
+}
+
+func (p *pauser) resume(by holds) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.held &^= by
+	if p.held == 0 && p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+func (p *pauser) wait() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.resumed
+}
+
+// hold blocks the gate while the pipe is paused, and returns false if the run was
+// stopped or canceled in the meantime
+func (r *run) hold(ctx context.Context) bool {
+	resumed := r.pauser.wait()
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-r.stop:
+	case <-ctx.Done():
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package pipe_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+func TestPipe_Pause(t *testing.T) {
+	tests := map[string]struct {
+		before bool // paused before it runs
+		batch  int
+	}{
+		"running":         {batch: 1},
+		"running/batch=4": {batch: 4},
+		"before running":  {before: true, batch: 1},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			var read atomic.Int64
+			sinkFunc := func(r pipe.Region) error {
+				read.Add(1)
+				return nil
+			}
+			p := pipe.New(&endlessSource{}, &sink{f: sinkFunc}).With(pipe.WithBatches(test.batch))
+			if test.before {
+				p.Pause()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errs := make(chan error)
+			go func() { errs <- p.Pipe(ctx) }()
+			time.Sleep(20 * time.Millisecond)
+
+			// when: paused, and once the regions in flight have landed
+			p.Pause()
+			time.Sleep(20 * time.Millisecond)
+			paused := read.Load()
+			time.Sleep(30 * time.Millisecond)
+
+			// then: nothing more goes through until resumed
+			assert.Equal(t, read.Load(), paused)
+			if test.before {
+				assert.Equal(t, paused, int64(0))
+			}
+
+			p.Resume()
+			time.Sleep(20 * time.Millisecond)
+			assert.Assert(t, read.Load() > paused)
+
+			cancel()
+			assert.ErrorIs(t, <-errs, context.Canceled)
+		})
+	}
+}
@@ -2,6 +2,11 @@ package pipe
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 )
 
 const (
@@ -17,9 +22,7 @@ const (
 // To interrupt execution, a Source can place an error on the errs channel. If the
 // Source detects that execution has been interrupted by another component via the
 // context, the Source should exit gracefully.
-type Source interface {
-	Write(ctx context.Context, sink chan Region, errs chan error)
-}
+type Source = SourceOf[Region]
 
 // Sink reads Regions from the source channel. Sinks are responsible for placing
 // the result of the execution on the errs channel once the source channel has
@@ -28,9 +31,7 @@ type Source interface {
 // To interrupt execution, a Sink can place an error on the errs channel. If the
 // Sink detects that execution has been interrupted by another component via the
 // context, the Sink should exit gracefully.
-type Sink interface {
-	Read(ctx context.Context, source <-chan Region, errs chan<- error)
-}
+type Sink = SinkOf[Region]
 
 // Valve reads Regions from its source channel and writes Regions to its sink
 // channel. Valves are responsible for closing their sink channel to communicate
@@ -39,10 +40,55 @@ type Sink interface {
 // To interrupt execution, a Valve can place an error on the errs channel. If the
 // Valve detects that execution has been interrupted by another component via the
 // context, the Valve should exit gracefully.
-type Valve interface {
-	// Open is a non-blocking method that returns the channel off of which the Valve
-	// will read regions from.
-	Open(ctx context.Context, sink chan Region, errs chan error) (source chan Region)
+type Valve = ValveOf[Region]
+
+// Shortcut can be implemented by a Sink that is able to consume a Source directly (e.g.
+// by moving data between file descriptors inside the kernel), bypassing the region
+// channels entirely. Pipe only tries the shortcut when asked to (see WithShortcut) and
+// there are no valves, and falls back to piping regions as usual when the sink reports
+// that it can't take it.
+type Shortcut interface {
+	Shortcut(ctx context.Context, source Source) (ok bool, err error)
+}
+
+// WithShortcut lets the sink consume the source directly when it can (see Shortcut).
+// Since no regions go through the pipe then, none of what happens to them applies
+// either: Shutdown can't drain the run (it's canceled instead), the pipe can't be
+// paused, and chaos, the scheduler, worker bounds and buffer limits are bypassed. Pipes
+// run by a Group never take the shortcut, as the group couldn't pause or limit them.
+func WithShortcut() Option {
+	return func(p *Pipe) {
+		p.shortcut = true
+	}
+}
+
+// WithChannelCapacity buffers the channels the pipe connects its components with, so
+// that a stage can get up to n regions ahead of the next one before blocking (they're
+// unbuffered by default, each region being handed off as the next stage takes it). The
+// pipe only makes the channel the source writes to and the one the sink reads from;
+// valves make the channels they read from themselves.
+//
+// Regions sitting in buffered channels hold on to their data, so a pool of buffers
+// needs to be that much bigger for the pipe to make use of them.
+func WithChannelCapacity(n int) Option {
+	return func(p *Pipe) {
+		p.capacity = max(n, 0)
+	}
+}
+
+// WithChannelCapacities sets the capacity of each of the pipe's connectors in turn: the
+// k-th one leads into the k-th valve (the sink, for the last one), as the pipe runs them
+// (see WithFusion, WithStats). It's for pipes with a stage much burstier than the others,
+// which only that stage's connectors need to make up for. Connectors between valves are
+// buffered by way of a goroutine of the pipe's handing regions on to the next valve, and
+// those past the end of ns are left as WithChannelCapacity has them.
+//
+// See pipeio.ChannelCapacity for a capacity suited to the pool of buffers the regions
+// come from.
+func WithChannelCapacities(ns ...int) Option {
+	return func(p *Pipe) {
+		p.capacities = ns
+	}
 }
 
 // Region is a piece of contiguous data with a reference to its offset in the overall
@@ -50,6 +96,25 @@ type Valve interface {
 type Region struct {
 	Data []byte
 	Off  int64
+
+	// Scope is the scope of the request the region is piped for (see Scope).
+	Scope Scope
+	// Meta is what stages attached to the region (see Key).
+	Meta Meta
+}
+
+// Next takes the next value (a region, or a batch of them) off c, and returns false once
+// c is closed or ctx is done. Components should take their input with Next rather than
+// straight off the channel, so that they stop as soon as the run is canceled rather than
+// once whatever's upstream gets around to closing the channel.
+func Next[T any](ctx context.Context, c <-chan T) (T, bool) {
+	select {
+	case v, more := <-c:
+		return v, more && ctx.Err() == nil
+	case <-ctx.Done():
+		var zero T
+		return zero, false
+	}
 }
 
 // New constructs a new pipe that streams a sequence of Regions from a Source to a Sink,
@@ -73,6 +138,38 @@ type Pipe struct {
 	source Source
 	sink   Sink
 	valves []Valve
+
+	// options
+	maxWorkers       int
+	lockThreads      bool
+	fuse             bool
+	batch            int
+	ring             int
+	profile          string
+	shortcut         bool
+	scheduler        Scheduler
+	chaos            *Chaos
+	classifier       ErrorClassifier
+	digest           *digest
+	capacity         int
+	capacities       []int
+	name             string
+	logger           *slog.Logger
+	tracer           Tracer
+	regionEvents     int
+	progress         *progress
+	stats            bool
+	drain            time.Duration
+	checkpoint       *checkpoint
+	deadLetters      chan<- DeadLetter
+	tolerance        int
+	tolerancePercent float64
+	regionTimeout    time.Duration
+	stallTimeout     time.Duration
+
+	mu     sync.Mutex
+	run    *run
+	pauser pauser
 }
 
 // Pipe executes the pipe, first connecting each of its components together and then
@@ -82,58 +179,240 @@ type Pipe struct {
 // occurs:
 //   - execution is done: a nil error has been placed on the `done` channel by the Sink
 //   - execution has been interrupted: an error has been placed on the `done` channel
-//     by one of the components; this error is returned to the caller
-//   - execution timed out: the context is done
+//     by one of the components; this error is returned to the caller once the sink is
+//     done, joined with the errors the other components reported meanwhile (other than
+//     being canceled, see errors.Join); each error is a *StageError naming the
+//     component it comes from
+//   - execution timed out: the context is done (see WithDrainOnCancel to let the regions
+//     in flight land first)
+//   - execution was stopped by Shutdown: ErrShutdown is returned
+//
+// Every component runs with a context derived from ctx, so whatever values it carries
+// (request-scoped ones in particular, see Scope) reach all of them. Components
+// interrupted by the run being canceled learn why with context.Cause: the *StageError of
+// the component that failed, ErrShutdown for a source stopped by Shutdown (the cause of
+// ctx itself, if it's done first).
 //
 // Finally, Pipe will close the connector channels (sink to source / in "reverse" order)
 // to ensure no goroutines are left running.
 func (p *Pipe) Pipe(ctx context.Context) error {
+	return p.pipe(ctx, nil)
+}
+
+// pipe runs the pipe, sharing sh with the other jobs of a Group if it's part of one
+func (p *Pipe) pipe(ctx context.Context, sh *share) (err error) {
 	// go p.logGoroutines()
 
-	// communicate to all components via the context if the execution is interrupted
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	logged := p.logRun(ctx)
+	defer func() { logged(err) }()
 
-	done := make(chan error, 1)
+	// communicate to all components via the context if the execution is interrupted; when
+	// draining on cancel, the components only learn of ctx being done once the drain's
+	// over (see WithDrainOnCancel)
+	parent := ctx
+	if p.drain > 0 {
+		ctx = context.WithoutCancel(ctx)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-	// hook up the valves by passing the sink channel of each valve to the previous valve;
-	// data flows through valves sequentially, in the order they are provided
-	connectors := p.open(ctx, done)
+	r := newRun(cancel)
+	if p.classifier != nil {
+		r.tracker.classifier = p.classifier
+	}
+	r.chaos = newChaos(p.chaos)
+	r.pauser = &p.pauser
+	r.share = sh
+	r.scope = ScopeOf(ctx)
+	if sh != nil && sh.budget != nil {
+		r.charges = &charges{budget: sh.budget}
+		r.tracker.landed = r.charges.landed
+		defer r.charges.settle()
+	}
+	defer close(r.done)
+	if p.drain > 0 {
+		go r.drainOnCancel(parent, p.drain)
+	}
 
-	// pipe data from each reader onto an idle writer
-	go func() {
-		// source pushes region onto the first sink channel
-		first := connectors[len(connectors)-1]
-		go p.source.Write(ctx, first, done)
+	// sinks account for written and failed regions through the context, and every
+	// component gets the scheduling hints the same way
+	ctx = context.WithValue(ctx, trackerKey{}, r.tracker)
+	ctx, traced := p.trace(ctx, r)
+	defer func() { traced(err) }()
+	if p.deadLetters != nil {
+		ctx = context.WithValue(ctx, deadLetterKey{}, p.deadLetters)
+	}
+	ctx = p.tolerate(ctx, r)
+	ctx = context.WithValue(ctx, schedKey{}, p.sched(r.chaos, sh))
 
-		// write takes region off of the last sink channel
-		last := connectors[0]
-		p.sink.Read(ctx, last, done)
+	p.mu.Lock()
+	p.run = r
+	p.mu.Unlock()
+
+	if p.progress != nil {
+		defer p.progress.report(r)()
+	}
+
+	stopProfile, err := p.startProfile()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if perr := stopProfile(); err == nil {
+			err = perr
+		}
 	}()
 
+	if p.checkpoint != nil {
+		var stopCheckpoint func() error
+		if stopCheckpoint, err = p.checkpoint.start(ctx, r); err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := stopCheckpoint(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	if sc, ok := p.sink.(Shortcut); ok && p.shortcut && len(p.valves) == 0 && p.digest == nil && sh == nil {
+		if ok, err := sc.Shortcut(ctx, p.source); ok {
+			return err
+		}
+	}
+
+	// room for a result from every stage (the gate and the watch included), so none of
+	// them get stuck reporting theirs once the run is over
+	done := make(chan error, len(p.fused())+4)
+	r.errs = done
+
+	// the stages report their results by way of r.stages, which labels their errors
+	r.stages = p.stages(p.fused())
+	go r.stages.forward(done, r.done)
+
+	fns, ok := p.funcs()
+	switch {
+	case ok && p.ring > 0:
+		go p.startRings(ctx, r, fns)
+	case ok && p.batch > 1:
+		go p.startBatches(ctx, r, fns)
+	default:
+		if p.stats {
+			r.stats.Store(newStats(r.stages))
+		}
+		if p.regionTimeout > 0 || p.stallTimeout > 0 {
+			r.watch = newWatch(p.regionTimeout, p.stallTimeout, len(r.stages.names))
+			r.tracker.released = r.watch.landed
+			go r.watch.run(ctx, r)
+		}
+		go p.start(ctx, r)
+	}
+
 	// wait for `something` to happen . . .
 	select {
 	case err := <-done:
-		cancel()
-		return err
+		if ctx.Err() != nil {
+			// whatever was reported, it was in response to the run being canceled
+			break
+		}
+		cancel(err)
+		if err == nil && r.stopped.Load() {
+			if r.canceled.Load() {
+				return parent.Err()
+			}
+			return ErrShutdown
+		}
+		if err != nil && !errors.Is(err, ErrRegionTimeout) && !errors.Is(err, ErrStalled) {
+			// (the stage that timed out, or stalled, may never be done)
+			err = r.settle(err, done)
+		}
+		if err == nil {
+			err = r.allowance.check()
+		}
+		return p.abort(ctx, err)
 	case <-ctx.Done():
-		return ctx.Err()
 	}
+
+	switch {
+	case r.canceled.Load() && r.forced.Load():
+		return fmt.Errorf("%w: drain deadline exceeded", parent.Err())
+	case r.canceled.Load():
+		return parent.Err()
+	case r.forced.Load():
+		return fmt.Errorf("%w: drain deadline exceeded", ErrShutdown)
+	}
+	return ctx.Err()
 }
 
-func (p *Pipe) open(ctx context.Context, done chan error) []chan Region {
-	connectors := make([]chan Region, len(p.valves)+1)
-	connectors[0] = make(chan Region)
+// Report returns the ranges written and failed by the sink during the most recent (or
+// current) execution of the pipe. This is mostly useful once a pipe has failed, to
+// learn which parts of the stream need to be retried.
+func (p *Pipe) Report() Report {
+	p.mu.Lock()
+	r := p.run
+	p.mu.Unlock()
 
-	i := 1
-	out := connectors[0]
-	for back := len(p.valves) - 1; back >= 0; back-- {
-		in := p.valves[back].Open(ctx, out, done)
-		out = in
+	if r == nil {
+		return Report{}
+	}
+	return r.tracker.report()
+}
+
+func (p *Pipe) start(ctx context.Context, r *run) {
+	defer r.stages.sunk(r.done)
+
+	// hook up the valves by passing the sink channel of each valve to the previous valve;
+	// data flows through valves sequentially, in the order they are provided
+	last, first := p.open(ctx, r)
+
+	// source pushes region onto the first sink channel, by way of the gate (which lets
+	// Shutdown stop the flow of new regions into the pipe)
+	in := p.connector(0)
+	if st := r.stats.Load(); st != nil && cap(in) > 0 {
+		st.edges[0].queue.Store(&in)
+	}
+	sourceCtx, stopSource := context.WithCancelCause(ctx)
+	p.stage(ctx, "gate")
+	go r.gate(ctx, in, first, stopSource)
+	p.stage(ctx, "source")
+	go func() {
+		defer Pin(ctx)()
+		p.source.Write(sourceCtx, in, r.stages.reports(0))
+	}()
+
+	// write takes region off of the last sink channel
+	p.stage(ctx, "sink")
+	defer Pin(ctx)()
+	p.sink.Read(ctx, last, r.stages.reports(len(r.stages.errs)-1))
+}
 
-		connectors[i] = in
-		i++
+// open opens the valves, back to front, and returns the channel the sink reads from and
+// the one the gate writes to (by way of a goroutine handing regions on to the next stage,
+// for each stage, when the pipe keeps stats or watches regions: see link)
+func (p *Pipe) open(ctx context.Context, r *run) (last, first chan Region) {
+	valves := p.fused()
+	st := r.stats.Load()
+
+	last = p.connector(len(valves))
+	out := link(ctx, st, r.watch, len(valves), last, 0)
+	for back := len(valves) - 1; back >= 0; back-- {
+		p.stage(ctx, fmt.Sprintf("valve %d", back))
+		in := valves[back].Open(ctx, out, r.stages.reports(back+1))
+		// (the first connector is the one the source writes to)
+		capacity := 0
+		if back > 0 && back < len(p.capacities) {
+			capacity = max(p.capacities[back], 0)
+		}
+		out = link(ctx, st, r.watch, back, in, capacity)
 	}
 
-	return connectors
+	return last, out
+}
+
+// connector makes the k-th channel for the pipe to connect its components with
+func (p *Pipe) connector(k int) chan Region {
+	if k < len(p.capacities) {
+		return make(chan Region, max(p.capacities[k], 0))
+	}
+	return make(chan Region, p.capacity)
 }
@@ -0,0 +1,289 @@
+package pipetest
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// RunSourceConformance checks that the sources made by newSource (a fresh one for every
+// check) honor the contract pipes rely on:
+//   - the sink channel gets closed once the source is done, and promptly once the context
+//     is done, even if nothing reads from it anymore
+//   - nothing but errors is placed on the errs channel (a nil would end the run early)
+//   - no regions are sent after an error
+//
+// Sources are read from as fast as they produce regions, and need to run out of regions
+// eventually: the first check waits for them to.
+func RunSourceConformance(t *testing.T, newSource func(t *testing.T) pipe.Source) {
+	t.Run("done", func(t *testing.T) {
+		events := observeSource(t, newSource(t), -1)
+		events.check(t)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		events := observeSource(t, newSource(t), 1)
+		events.check(t)
+	})
+}
+
+// RunValveConformance checks that the valves made by newValve (a fresh one for every
+// check) honor the contract pipes rely on:
+//   - the sink channel gets closed once the source channel is closed, even after the valve
+//     failed, and promptly once the context is done, even if the source channel stays open
+//   - nothing but errors is placed on the errs channel (a nil would end the run early)
+//   - no regions are sent after an error
+func RunValveConformance(t *testing.T, newValve func(t *testing.T) pipe.Valve) {
+	t.Run("done", func(t *testing.T) {
+		events := observeValve(t, newValve(t), -1)
+		events.check(t)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		events := observeValve(t, newValve(t), 1)
+		events.check(t)
+	})
+}
+
+// RunSinkConformance checks that the sinks made by newSink (a fresh one for every check)
+// honor the contract pipes rely on:
+//   - Read returns once the source channel is closed, and promptly once the context is
+//     done, even if the source channel stays open
+//   - a single result is placed on the errs channel, and a nil one only once the source
+//     channel is closed
+//
+// The sinks are given regions from Regions, which they're expected to handle without
+// failing.
+func RunSinkConformance(t *testing.T, newSink func(t *testing.T) pipe.Sink) {
+	t.Run("done", func(t *testing.T) {
+		results := observeSink(t, newSink(t), false)
+		if len(results) != 1 || results[0].err != nil || !results[0].closed {
+			t.Errorf("expected a single nil result once the source was closed, got %v", results)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		results := observeSink(t, newSink(t), true)
+		if len(results) > 1 {
+			t.Errorf("expected at most one result, got %v", results)
+		}
+		for _, r := range results {
+			if r.err == nil {
+				t.Errorf("reported a nil result while the source channel was still open")
+			}
+		}
+	})
+}
+
+// events is what a source or valve got up to during a check
+type events struct {
+	regions    int
+	nils       int
+	afterError int
+	errs       []error
+}
+
+func (e events) check(t *testing.T) {
+	t.Helper()
+
+	if e.nils > 0 {
+		t.Errorf("placed %d nil(s) on the errs channel", e.nils)
+	}
+	if e.afterError > 0 {
+		t.Errorf("sent %d region(s) after an error (%v)", e.afterError, e.errs[0])
+	}
+}
+
+// prompt is how long a component can keep running once the context is done
+const prompt = time.Second
+
+// observe records what arrives on out and errs until out is closed and exited is too,
+// canceling the context once the given number of regions have arrived. Once it's
+// canceled, out is only read from any further if drain is set: either way, out has to be
+// closed and exited has to be too within prompt.
+func observe(t *testing.T, out chan pipe.Region, errs chan error, exited chan struct{}, cancelAfter int, cancel context.CancelFunc, drain bool) events {
+	t.Helper()
+
+	timeout := time.NewTimer(hang)
+	defer timeout.Stop()
+
+	var (
+		e        events
+		reading  = out
+		deadline <-chan time.Time
+	)
+	for reading != nil || exited != nil {
+		select {
+		case _, more := <-reading:
+			if !more {
+				out, reading = nil, nil
+				continue
+			}
+			e.regions++
+			if len(e.errs) > 0 {
+				e.afterError++
+			}
+			if e.regions == cancelAfter {
+				cancel()
+				deadline = time.After(prompt)
+				if !drain {
+					reading = nil
+				}
+			}
+		case err := <-errs:
+			if err == nil {
+				e.nils++
+				continue
+			}
+			e.errs = append(e.errs, err)
+		case <-exited:
+			exited = nil
+		case <-deadline:
+			t.Fatalf("kept running for %s after the context was done", prompt)
+		case <-timeout.C:
+			if out != nil {
+				t.Fatalf("sink channel wasn't closed within %s", hang)
+			}
+			t.Fatalf("didn't return within %s", hang)
+		}
+	}
+
+	// out went unread since the context was done: it has to be closed by now
+	if out != nil {
+		select {
+		case _, more := <-out:
+			if more {
+				t.Errorf("sent a region after the context was done and it returned")
+			}
+		default:
+			t.Errorf("returned without closing the sink channel")
+		}
+	}
+	return e
+}
+
+func observeSource(t *testing.T, source pipe.Source, cancelAfter int) events {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out, errs, exited := make(chan pipe.Region), make(chan error), make(chan struct{})
+	go func() {
+		defer close(exited)
+		source.Write(ctx, out, errs)
+	}()
+
+	return observe(t, out, errs, exited, cancelAfter, cancel, false)
+}
+
+func observeValve(t *testing.T, valve pipe.Valve, cancelAfter int) events {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out, errs, fed := make(chan pipe.Region), make(chan error), make(chan struct{})
+	released := make(chan struct{})
+	defer close(released)
+
+	in := valve.Open(ctx, out, errs)
+	go func() {
+		// feed the valve until the regions run out, then close the channel the way the
+		// gate would; once the context is done, stop feeding but leave the channel open
+		// until the check is over, so the valve has to stop on the context alone
+		defer close(fed)
+		defer close(in)
+
+		for _, r := range Regions(10, 10) {
+			select {
+			case in <- r:
+			case <-ctx.Done():
+				<-released
+				return
+			}
+		}
+	}()
+
+	if cancelAfter < 0 {
+		return observe(t, out, errs, fed, cancelAfter, cancel, true)
+	}
+	return observe(t, out, errs, nil, cancelAfter, cancel, true)
+}
+
+// result is what a sink placed on the errs channel, and whether the source channel was
+// closed by then
+type result struct {
+	err    error
+	closed bool
+}
+
+func observeSink(t *testing.T, sink pipe.Sink, cancel bool) []result {
+	t.Helper()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	in, errs := make(chan pipe.Region), make(chan error)
+	released := make(chan struct{})
+	defer close(released)
+
+	var closed atomic.Bool
+	go func() {
+		// once the context is done, stop feeding but leave the channel open until the
+		// check is over, so the sink has to stop on the context alone
+		defer func() {
+			closed.Store(true)
+			close(in)
+		}()
+
+		for i, r := range Regions(10, 10) {
+			if cancel && i == 1 {
+				stop()
+			}
+			select {
+			case in <- r:
+			case <-ctx.Done():
+				<-released
+				return
+			}
+		}
+	}()
+
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		sink.Read(ctx, in, errs)
+	}()
+
+	timeout := time.NewTimer(hang)
+	defer timeout.Stop()
+
+	var deadline <-chan time.Time
+	if cancel {
+		deadline = time.After(prompt)
+	}
+
+	var results []result
+	for {
+		select {
+		case err := <-errs:
+			results = append(results, result{err: err, closed: closed.Load()})
+		case <-exited:
+			// the sink may have handed off a last result from another goroutine
+			select {
+			case err := <-errs:
+				results = append(results, result{err: err, closed: closed.Load()})
+			case <-time.After(10 * time.Millisecond):
+			}
+			return results
+		case <-deadline:
+			t.Fatalf("kept running for %s after the context was done", prompt)
+		case <-timeout.C:
+			t.Fatalf("didn't return within %s", hang)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package pipetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+// ErrInjected is the error scripted sources fail with.
+var ErrInjected = errors.New("pipetest: injected error")
+
+// Script is a randomized run of a pipe, decoded from fuzzer input by NewScript.
+type Script struct {
+	Regions []pipe.Region
+
+	// CancelAfter is the number of regions after which the run is canceled (-1 for never).
+	CancelAfter int
+	// FailAt is the number of regions after which the source fails with ErrInjected (-1
+	// for never).
+	FailAt int
+}
+
+// maxRegions caps the number of regions in a script, to keep runs quick
+const maxRegions = 256
+
+// NewScript decodes a script from the input of a fuzzer: the first two bytes decide
+// whether and when the run is canceled and the source fails, and every byte after that
+// is a region of 1 to 128 bytes (the high bit leaving a gap of the same size before it).
+func NewScript(data []byte) Script {
+	s := Script{CancelAfter: -1, FailAt: -1}
+	if len(data) < 2 {
+		return s
+	}
+
+	control, data := data[:2], data[2:]
+	if len(data) > maxRegions {
+		data = data[:maxRegions]
+	}
+
+	var off int64
+	for i, c := range data {
+		size := int(c&0x7f) + 1
+		if c&0x80 != 0 {
+			off += int64(size)
+		}
+		s.Regions = append(s.Regions, pipe.Region{Data: bytes.Repeat([]byte{byte(i)}, size), Off: off})
+		off += int64(size)
+	}
+
+	if control[0]%4 == 0 {
+		s.CancelAfter = int(control[0]/4) % (len(s.Regions) + 1)
+	}
+	if control[1]%4 == 0 {
+		s.FailAt = int(control[1]/4) % (len(s.Regions) + 1)
+	}
+	return s
+}
+
+// clean is whether the run is left to complete undisturbed
+func (s Script) clean() bool {
+	return s.CancelAfter < 0 && s.FailAt < 0
+}
+
+// Bytes returns the data of the regions laid out at their offsets.
+func (s Script) Bytes() []byte {
+	var r recorder
+	for _, region := range s.Regions {
+		r.record(region)
+	}
+	return r.Bytes()
+}
+
+// FuzzValve fuzzes valves made by newValve (a fresh one for every run) with scripted
+// regions, cancellations and source failures, and checks that every run ends (i.e. the
+// valve closes its sink channel), doesn't leak goroutines, and that undisturbed runs
+// succeed. If check is set, it's given the regions of undisturbed runs going in and
+// coming out of the valve; SameRegions is the check for valves that pass regions on
+// as-is.
+func FuzzValve(f *testing.F, newValve func(t *testing.T) pipe.Valve, check func(in, out []pipe.Region) error) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := NewScript(data)
+		defer checkLeaks(t, runtime.NumGoroutine())
+
+		sink := &Sink{}
+		err := run(t, s, &scripted{script: s, buff: &countingBuffer{}}, sink, newValve(t))
+
+		if s.clean() && err == nil && check != nil {
+			if err := check(s.Regions, sink.Regions()); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+}
+
+// FuzzSink fuzzes sinks made by newSink (a fresh one for every run) with scripted
+// regions, cancellations and source failures. On top of the checks of FuzzValve, it
+// checks that undisturbed runs put every buffer the regions came in back into buff. If
+// written is set, it's called after undisturbed runs to get what the sink wrote, which
+// should be the data of the regions laid out at their offsets.
+func FuzzSink(f *testing.F, newSink func(t *testing.T, buff pipeio.Buffer) pipe.Sink, written func(t *testing.T) []byte) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := NewScript(data)
+		defer checkLeaks(t, runtime.NumGoroutine())
+
+		buff := &countingBuffer{}
+		err := run(t, s, &scripted{script: s, buff: buff}, newSink(t, buff))
+		if !s.clean() || err != nil {
+			return
+		}
+
+		if gets, puts := buff.gets.Load(), buff.puts.Load(); gets != puts {
+			t.Errorf("sink put back %d of the %d buffers", puts, gets)
+		}
+		if written != nil {
+			if got, want := written(t), s.Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("sink wrote %d bytes, expected %d bytes (%q...)", len(got), len(want), head(want))
+			}
+		}
+	})
+}
+
+// FuzzSource fuzzes sources made by newSource, which should produce the given data (e.g.
+// by reading it), with cancellations. It checks that every run ends, doesn't leak
+// goroutines, and that the regions produced by undisturbed runs add up to the data.
+func FuzzSource(f *testing.F, newSource func(t *testing.T, data []byte) pipe.Source) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := NewScript(data)
+		s.FailAt = -1
+		defer checkLeaks(t, runtime.NumGoroutine())
+
+		want := s.Bytes()
+		sink := &Sink{}
+		err := run(t, s, newSource(t, want), sink)
+
+		if s.clean() && err == nil {
+			if got := sink.Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("source produced %d bytes, expected %d bytes (%q...)", len(got), len(want), head(want))
+			}
+		}
+	})
+}
+
+func seed(f *testing.F) {
+	f.Add([]byte{1, 1, 9, 9, 9})                 // undisturbed
+	f.Add([]byte{1, 1, 0x7f, 0x80, 0xff, 3})     // with gaps
+	f.Add([]byte{8, 1, 9, 9, 9, 9, 9})           // canceled midway
+	f.Add([]byte{1, 4, 9, 9, 9})                 // source fails midway
+	f.Add([]byte{0, 0, 9, 9, 9})                 // canceled and failed right away
+	f.Add([]byte{1, 1})                          // no regions
+	f.Add(bytes.Repeat([]byte{1, 1, 2, 3}, 100)) // lots of regions
+}
+
+// hang is how long a run can take before it's considered stuck
+const hang = 10 * time.Second
+
+// run runs the pipe through the script and checks the outcome is consistent with it
+func run(t *testing.T, s Script, source pipe.Source, sink pipe.Sink, valves ...pipe.Valve) error {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), hang)
+	defer cancel()
+
+	// the run is canceled from the sink's end, so it's as far along as it can get
+	canceled, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	sink = &canceling{Sink: sink, after: s.CancelAfter, cancel: cancelRun}
+	if s.CancelAfter == 0 {
+		cancelRun()
+	}
+
+	err := pipe.New(source, sink, valves...).Pipe(canceled)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		t.Fatalf("run didn't end within %s: a channel wasn't closed, or a result wasn't reported", hang)
+	case s.clean() && err != nil:
+		t.Errorf("undisturbed run failed: %v", err)
+	case err != nil && !errors.Is(err, ErrInjected) && !errors.Is(err, context.Canceled):
+		t.Errorf("run failed with %v, expected %v or %v", err, ErrInjected, context.Canceled)
+	}
+	return err
+}
+
+// checkLeaks checks that the number of goroutines goes back to what it was before the
+// run, giving the stragglers some time to exit
+func checkLeaks(t *testing.T, before int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Errorf("%d goroutines leaked", runtime.NumGoroutine()-before)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func head(b []byte) []byte {
+	return b[:min(len(b), 16)]
+}
+
+// scripted is the source playing back a script
+type scripted struct {
+	script Script
+	buff   pipeio.Buffer
+}
+
+func (s *scripted) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	for i, r := range s.script.Regions {
+		if i == s.script.FailAt {
+			errs <- ErrInjected
+			return
+		}
+
+		data, err := pipeio.Acquire(ctx, s.buff)
+		if err != nil {
+			return
+		}
+		r.Data = data[:copy(data, r.Data)]
+		select {
+		case sink <- r:
+		case <-ctx.Done():
+			s.buff.Put(data)
+			return
+		}
+	}
+
+	if s.script.FailAt == len(s.script.Regions) {
+		errs <- ErrInjected
+	}
+}
+
+// canceling cancels the run once the sink has been handed enough regions
+type canceling struct {
+	pipe.Sink
+	after  int
+	cancel context.CancelFunc
+}
+
+func (c *canceling) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	if c.after < 0 {
+		c.Sink.Read(ctx, source, errs)
+		return
+	}
+
+	counted := make(chan pipe.Region)
+	go func() {
+		defer close(counted)
+
+		n := 0
+		for r := range source {
+			counted <- r
+			if n++; n == c.after {
+				c.cancel()
+			}
+		}
+	}()
+
+	c.Sink.Read(ctx, counted, errs)
+
+	// the sink may bail before the source is done
+	for range counted {
+	}
+}
+
+// countingBuffer hands out buffers big enough for any scripted region, and counts them
+// going out and coming back
+type countingBuffer struct {
+	gets, puts atomic.Int64
+}
+
+func (b *countingBuffer) Get() []byte {
+	b.gets.Add(1)
+	return make([]byte, 128)
+}
+
+func (b *countingBuffer) Put(_ []byte) {
+	b.puts.Add(1)
+}
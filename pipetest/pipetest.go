@@ -0,0 +1,223 @@
+// Package pipetest provides in-memory fakes of the pipe components, for testing custom
+// Sources, Valves and Sinks against something predictable: sources play back scripted
+// regions, valves and sinks record what passes through them, and all of them can be
+// slowed down or made to fail on cue.
+package pipetest
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Regions returns n contiguous regions of size bytes each, starting at offset 0. The data
+// of each region is filled with a letter of the alphabet (cycling), so regions are easy
+// to tell apart when a test fails.
+func Regions(n, size int) []pipe.Region {
+	regions := make([]pipe.Region, n)
+	for i := range regions {
+		regions[i] = pipe.Region{
+			Data: bytes.Repeat([]byte{'A' + byte(i%26)}, size),
+			Off:  int64(i * size),
+		}
+	}
+	return regions
+}
+
+// Source is a pipe.Source that plays back Regions, in order.
+type Source struct {
+	Regions []pipe.Region
+
+	// Err, if set, is placed on the errs channel once all the regions have been sent.
+	Err error
+	// Delay is how long the source waits before sending each region.
+	Delay time.Duration
+	// Loop has the source play back the regions over and over (shifting their offsets
+	// along each time) until the context is done, rather than just once.
+	Loop bool
+}
+
+func (s *Source) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	var shift int64
+	for {
+		for _, r := range s.Regions {
+			if !sleep(ctx, s.Delay) {
+				return
+			}
+
+			r.Off += shift
+			pipe.Yield(ctx, r)
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if !s.Loop || len(s.Regions) == 0 {
+			break
+		}
+		last := s.Regions[len(s.Regions)-1]
+		shift += last.Off + int64(len(last.Data))
+	}
+
+	if s.Err != nil {
+		errs <- s.Err
+	}
+}
+
+// Valve is a pipe.Valve that records the regions passing through it.
+type Valve struct {
+	// Func, if set, is applied to every region; an error interrupts the pipe.
+	Func func(pipe.Region) (pipe.Region, error)
+	// Delay is how long the valve waits before passing on each region.
+	Delay time.Duration
+
+	recorder
+}
+
+func (v *Valve) Open(ctx context.Context, sink chan pipe.Region, errs chan error) chan pipe.Region {
+	source := make(chan pipe.Region)
+	go func() {
+		defer close(sink)
+
+		for {
+			r, more := pipe.Next(ctx, source)
+			if !more {
+				break
+			}
+
+			v.record(r)
+			if !sleep(ctx, v.Delay) {
+				break
+			}
+
+			if v.Func != nil {
+				var err error
+				if r, err = v.Func(r); err != nil {
+					errs <- err
+					break
+				}
+			}
+
+			pipe.Yield(ctx, r)
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// Sink is a pipe.Sink that records the regions it's given, and reports them to the pipe
+// as written (see pipe.Commit).
+type Sink struct {
+	// Check, if set, is called for every region; an error interrupts the pipe (and the
+	// region is reported as failed rather than written), unless the region can be
+	// diverted (see pipe.Divert).
+	Check func(pipe.Region) error
+	// Delay is how long the sink takes to "write" each region.
+	Delay time.Duration
+
+	recorder
+}
+
+func (s *Sink) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	for {
+		r, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+
+		if !sleep(ctx, s.Delay) {
+			break
+		}
+
+		if s.Check != nil {
+			if err := s.Check(r); err != nil {
+				pipe.Fail(ctx, r, err)
+				if pipe.Divert(ctx, r, err) {
+					continue
+				}
+				errs <- err
+				return
+			}
+		}
+
+		s.record(r)
+		pipe.Commit(ctx, r)
+	}
+
+	errs <- ctx.Err()
+}
+
+// recorder keeps copies of the regions it's been given
+type recorder struct {
+	mu      sync.Mutex
+	regions []pipe.Region
+}
+
+// Regions returns the regions recorded so far, in the order they were seen.
+func (r *recorder) Regions() []pipe.Region {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	regions := make([]pipe.Region, len(r.regions))
+	copy(regions, r.regions)
+	return regions
+}
+
+// Bytes returns the data of the regions recorded so far, laid out at their offsets (as
+// a sink writing them to a file would). Empty regions don't write anything, wherever
+// they are.
+func (r *recorder) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []byte
+	for _, region := range r.regions {
+		if len(region.Data) == 0 {
+			continue
+		}
+		end := region.Off + int64(len(region.Data))
+		if int64(len(out)) < end {
+			out = append(out, make([]byte, end-int64(len(out)))...)
+		}
+		copy(out[region.Off:], region.Data)
+	}
+	return out
+}
+
+func (r *recorder) record(region pipe.Region) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the region's buffer may well be reused once it's been passed on
+	region.Data = bytes.Clone(region.Data)
+	r.regions = append(r.regions, region)
+}
+
+// sleep waits for d, returning false if the context is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
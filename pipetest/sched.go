@@ -0,0 +1,126 @@
+package pipetest
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// Deterministic returns a pipe.Scheduler that has the stages of a pipe hand off regions
+// one at a time, in an order drawn from a generator seeded with seed. Goroutines reaching
+// a yield point are held there until everything else has settled (i.e. is held too, or
+// blocked on something), then one of them - picked at random, in the order of the
+// regions they're holding - is let through, and so on.
+//
+// Each seed makes for a different interleaving of the stages, and running again with the
+// same seed replays the same interleaving, provided the pipe is confined to a single P
+// (see Explore) and its stages don't block on anything but each other. Handing regions
+// off one at a time is slow, so this is only for tests.
+func Deterministic(seed int64) *deterministic {
+	return &deterministic{rand: rand.New(rand.NewSource(seed))}
+}
+
+type deterministic struct {
+	mu      sync.Mutex
+	rand    *rand.Rand
+	held    []held
+	seq     int
+	driving bool
+}
+
+type held struct {
+	r    pipe.Region
+	seq  int
+	next chan struct{}
+}
+
+// Yield implements pipe.Scheduler.
+func (s *deterministic) Yield(r pipe.Region) {
+	h := held{r: r, next: make(chan struct{})}
+
+	s.mu.Lock()
+	h.seq, s.seq = s.seq, s.seq+1
+	s.held = append(s.held, h)
+	if !s.driving {
+		s.driving = true
+		go s.drive()
+	}
+	s.mu.Unlock()
+
+	<-h.next
+}
+
+// settle is how many times the driver yields the processor, for everything that can run
+// to get to a point where it can't anymore
+const settle = 64
+
+// drive lets held goroutines through one at a time, for as long as there are any
+func (s *deterministic) drive() {
+	for {
+		for range settle {
+			runtime.Gosched()
+		}
+
+		s.mu.Lock()
+		if len(s.held) == 0 {
+			s.driving = false
+			s.mu.Unlock()
+			return
+		}
+
+		slices.SortFunc(s.held, func(a, b held) int {
+			return cmp.Or(
+				cmp.Compare(a.r.Off, b.r.Off),
+				cmp.Compare(len(a.r.Data), len(b.r.Data)),
+				cmp.Compare(a.seq, b.seq),
+			)
+		})
+		i := s.rand.Intn(len(s.held))
+		h := s.held[i]
+		s.held = slices.Delete(s.held, i, i+1)
+		s.mu.Unlock()
+
+		close(h.next)
+	}
+}
+
+// SeedEnv is the environment variable that has Explore replay a single seed.
+const SeedEnv = "PIPETEST_SEED"
+
+// Explore runs f once for each of the given number of seeds, as a subtest, with the pipe
+// option to schedule the pipe under test deterministically with that seed. Runs are
+// confined to a single P for the duration. When a run fails, the seed is logged so the
+// failure can be replayed by setting SeedEnv, in which case only that seed is run.
+func Explore(t *testing.T, runs int, f func(t *testing.T, opt pipe.Option)) {
+	seeds := make([]int64, runs)
+	for i := range seeds {
+		seeds[i] = int64(i)
+	}
+	if env := os.Getenv(SeedEnv); env != "" {
+		seed, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			t.Fatalf("invalid %s: %v", SeedEnv, err)
+		}
+		seeds = []int64{seed}
+	}
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	for _, seed := range seeds {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			defer func() {
+				if t.Failed() {
+					t.Logf("replay with %s=%d", SeedEnv, seed)
+				}
+			}()
+			f(t, pipe.WithScheduler(Deterministic(seed)))
+		})
+	}
+}
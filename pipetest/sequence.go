@@ -0,0 +1,135 @@
+package pipetest
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+// MaxRegion is the size of the largest regions in a Sequence.
+const MaxRegion = 64 * pipe.KiB
+
+// Sequence is a sequence of regions for property tests, generated by testing/quick. The
+// sequences are valid (offsets aren't negative) but otherwise adversarial: regions come
+// out of order, at the same offsets as others, empty, as large as MaxRegion, and with
+// gaps between them.
+//
+// Whatever the sequence, components are expected to maintain these invariants:
+//   - valves that pass regions through untouched (or sources passing them on) hand them
+//     on as they came in, see SameRegions
+//   - sinks write every byte of every region at its offset: with regions that don't
+//     overlap (see Overlaps), what ends up written is Bytes
+//   - sinks report (see pipe.Commit) all of that as written, and nothing else: the
+//     pipe's Report has Ranges as written
+type Sequence []pipe.Region
+
+// Generate implements quick.Generator.
+func (Sequence) Generate(r *rand.Rand, size int) reflect.Value {
+	n := r.Intn(size + 1)
+	seq := make(Sequence, 0, n)
+
+	var off int64
+	for i := 0; i < n; i++ {
+		var length int
+		switch p := r.Intn(20); {
+		case p == 0:
+			length = 0
+		case p == 1:
+			length = MaxRegion
+		default:
+			length = 1 + r.Intn(4*pipe.KiB)
+		}
+
+		switch p := r.Intn(10); {
+		case p == 0 && len(seq) > 0:
+			// same offset as an earlier region
+			off = seq[r.Intn(len(seq))].Off
+		case p == 1:
+			// leave a gap
+			off += int64(r.Intn(MaxRegion))
+		}
+
+		seq = append(seq, pipe.Region{Data: fill(r, length), Off: off})
+		off += int64(length)
+	}
+
+	if r.Intn(2) == 0 {
+		r.Shuffle(len(seq), func(i, j int) { seq[i], seq[j] = seq[j], seq[i] })
+	}
+	return reflect.ValueOf(seq)
+}
+
+func fill(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+// Overlaps is whether any regions of the sequence overlap.
+func (s Sequence) Overlaps() bool {
+	sorted := slices.Clone(s)
+	slices.SortFunc(sorted, func(a, b pipe.Region) int { return cmp.Compare(a.Off, b.Off) })
+
+	var end int64
+	for _, r := range sorted {
+		if len(r.Data) == 0 {
+			continue
+		}
+		if r.Off < end {
+			return true
+		}
+		end = r.Off + int64(len(r.Data))
+	}
+	return false
+}
+
+// Bytes returns the data of the regions laid out at their offsets, gaps zeroed; where
+// regions overlap, the later ones win.
+func (s Sequence) Bytes() []byte {
+	var r recorder
+	for _, region := range s {
+		r.record(region)
+	}
+	return r.Bytes()
+}
+
+// Ranges returns the ranges covered by the regions, merged the way a pipe's Report
+// merges them.
+func (s Sequence) Ranges() []pipe.Range {
+	sorted := slices.Clone(s)
+	slices.SortFunc(sorted, func(a, b pipe.Region) int { return cmp.Compare(a.Off, b.Off) })
+
+	ranges := make([]pipe.Range, 0)
+	for _, r := range sorted {
+		if len(r.Data) == 0 {
+			continue
+		}
+
+		next := pipe.Range{Off: r.Off, Len: int64(len(r.Data))}
+		if last := len(ranges) - 1; last >= 0 && ranges[last].End() >= next.Off {
+			end := max(ranges[last].End(), next.End())
+			ranges[last].Len = end - ranges[last].Off
+			continue
+		}
+		ranges = append(ranges, next)
+	}
+	return ranges
+}
+
+// SameRegions checks that the regions came out the way they went in.
+func SameRegions(in, out []pipe.Region) error {
+	if len(in) != len(out) {
+		return fmt.Errorf("%d regions went in, %d came out", len(in), len(out))
+	}
+	for i := range in {
+		if in[i].Off != out[i].Off || !bytes.Equal(in[i].Data, out[i].Data) {
+			return fmt.Errorf("region %d went in at offset %d and came out at offset %d", i, in[i].Off, out[i].Off)
+		}
+	}
+	return nil
+}
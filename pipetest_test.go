@@ -0,0 +1,85 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipetest(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		// given
+		want := pipetest.Regions(5, 10)
+		valve := &pipetest.Valve{Func: func(r pipe.Region) (pipe.Region, error) {
+			r.Data = bytes.ToLower(r.Data)
+			return r, nil
+		}}
+		sink := &pipetest.Sink{}
+		p := pipe.New(&pipetest.Source{Regions: want}, sink, valve)
+
+		// when
+		assert.NilError(t, p.Pipe(context.Background()))
+
+		// then
+		assert.DeepEqual(t, valve.Regions(), want)
+		assert.Equal(t, len(sink.Regions()), 5)
+		assert.DeepEqual(t, sink.Bytes(), bytes.ToLower(bytes.Join(dataOf(want), nil)))
+		assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 50}})
+	})
+
+	t.Run("error/source", func(t *testing.T) {
+		// given
+		p := pipe.New(&pipetest.Source{Err: errors.New("welp")}, &pipetest.Sink{})
+
+		// when / then
+		assert.ErrorContains(t, p.Pipe(context.Background()), "welp")
+	})
+
+	t.Run("error/sink", func(t *testing.T) {
+		// given
+		sink := &pipetest.Sink{Check: func(r pipe.Region) error {
+			if r.Off == 20 {
+				return errors.New("welp")
+			}
+			return nil
+		}}
+		p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(5, 10)}, sink)
+
+		// when / then
+		assert.ErrorContains(t, p.Pipe(context.Background()), "welp")
+		assert.Equal(t, len(sink.Regions()), 2)
+		assert.Equal(t, p.Report().Failed[0].Off, int64(20))
+	})
+
+	t.Run("timeout/loop", func(t *testing.T) {
+		// given
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		sink := &pipetest.Sink{Delay: time.Millisecond}
+		p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(2, 10), Loop: true}, sink)
+
+		// when / then
+		assert.ErrorIs(t, p.Pipe(ctx), context.DeadlineExceeded)
+		got := sink.Regions()
+		assert.Assert(t, len(got) > 2)
+		for i, r := range got {
+			assert.Equal(t, r.Off, int64(i*10))
+		}
+	})
+}
+
+func dataOf(regions []pipe.Region) [][]byte {
+	data := make([][]byte, len(regions))
+	for i, r := range regions {
+		data[i] = r.Data
+	}
+	return data
+}
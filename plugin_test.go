@@ -0,0 +1,238 @@
+package pipe_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// TestPluginProcess isn't a test, but the plugin the tests run: this test binary, run
+// again with PIPE_PLUGIN set to how it should behave. It speaks the wire format by hand,
+// as a plugin written in another language would.
+func TestPluginProcess(t *testing.T) {
+	mode := os.Getenv("PIPE_PLUGIN")
+	if mode == "" {
+		t.Skip("only run as a plugin")
+	}
+
+	in, out := bufio.NewReader(os.Stdin), bufio.NewWriter(os.Stdout)
+	switch mode {
+	case "upper":
+		// answers every region with its data in upper case
+		for {
+			off, data, ok := readFrame(in)
+			if !ok {
+				break
+			}
+			writeFrame(out, off, bytes.ToUpper(data))
+		}
+		out.WriteByte('E')
+	case "reject":
+		msg := "no thanks"
+		out.WriteByte('S')
+		binary.Write(out, binary.BigEndian, uint32(len(msg)))
+		out.WriteString(msg)
+	case "crash":
+		in.ReadByte()
+		fmt.Fprintln(os.Stderr, "out of cheese")
+		os.Exit(3)
+	case "hang":
+		time.Sleep(time.Hour)
+	case "raw-upper":
+		io.Copy(out, readerFunc(func(p []byte) (int, error) {
+			n, err := in.Read(p)
+			copy(p, bytes.ToUpper(p[:n]))
+			return n, err
+		}))
+	case "store":
+		// writes the regions to the file at PIPE_PLUGIN_OUT
+		f, _ := os.Create(os.Getenv("PIPE_PLUGIN_OUT"))
+		for {
+			off, data, ok := readFrame(in)
+			if !ok {
+				break
+			}
+			f.WriteAt(data, off)
+		}
+		f.Close()
+	}
+	out.Flush()
+	os.Exit(0)
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func readFrame(r *bufio.Reader) (int64, []byte, bool) {
+	if typ, err := r.ReadByte(); err != nil || typ != 'R' {
+		return 0, nil, false
+	}
+	var h struct {
+		Off int64
+		Len uint32
+	}
+	binary.Read(r, binary.BigEndian, &h)
+	data := make([]byte, h.Len)
+	io.ReadFull(r, data)
+	return h.Off, data, true
+}
+
+func writeFrame(w *bufio.Writer, off int64, data []byte) {
+	w.WriteByte('R')
+	binary.Write(w, binary.BigEndian, struct {
+		Off int64
+		Len uint32
+	}{off, uint32(len(data))})
+	w.Write(data)
+}
+
+// plugin returns the command running the plugin in mode
+func plugin(mode string, env ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPluginProcess$")
+	// (the race detector would otherwise linger a second after every run)
+	cmd.Env = append(os.Environ(), append(env, "PIPE_PLUGIN="+mode, "GORACE=atexit_sleep_ms=0")...)
+	return cmd
+}
+
+// text returns n regions of lower case text, size bytes each
+func text(n, size int) []pipe.Region {
+	regions := make([]pipe.Region, n)
+	for i := range regions {
+		data := bytes.Repeat([]byte{byte('a' + i%26)}, size)
+		regions[i] = pipe.Region{Data: data, Off: int64(i * size)}
+	}
+	return regions
+}
+
+func TestPlugin(t *testing.T) {
+	tests := []struct {
+		name  string
+		valve func(buff pipeio.Buffer) pipe.Valve
+	}{
+		{
+			name:  "regions",
+			valve: func(buff pipeio.Buffer) pipe.Valve { return pipeio.Plugin(plugin("upper"), buff) },
+		},
+		{
+			name:  "raw",
+			valve: func(buff pipeio.Buffer) pipe.Valve { return pipeio.Plugin(plugin("raw-upper"), buff).Raw() },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			regions := text(64, 4*KiB)
+			want := bytes.ToUpper(pipetest.Sequence(regions).Bytes())
+			sink := &pipetest.Sink{}
+			buff := pipeio.NewBuffer(4*KiB, 4)
+
+			// when
+			err := pipe.New(&pipetest.Source{Regions: regions}, sink, test.valve(buff)).Pipe(context.Background())
+
+			// then
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(sink.Bytes(), want))
+		})
+	}
+}
+
+func TestPlugin_failure(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		want string
+	}{
+		{name: "failed by the plugin", mode: "reject", want: "plugin failed: no thanks"},
+		{name: "exit status", mode: "crash", want: "exit status 3: out of cheese"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			buff := pipeio.NewBuffer(4*KiB, 4)
+			valve := pipeio.Plugin(plugin(test.mode), buff)
+
+			// when
+			err := pipe.New(&pipetest.Source{Regions: text(64, 4*KiB)}, &pipetest.Sink{}, valve).Pipe(context.Background())
+
+			// then
+			assert.ErrorContains(t, err, test.want)
+		})
+	}
+}
+
+func TestPlugin_canceled(t *testing.T) {
+	// given: a plugin that never answers
+	valve := pipeio.Plugin(plugin("hang"), pipeio.NewBuffer(4*KiB, 4))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// when
+	start := time.Now()
+	err := pipe.New(&pipetest.Source{Regions: text(4, 4*KiB)}, &pipetest.Sink{}, valve).Pipe(ctx)
+
+	// then: the plugin is killed rather than waited for
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Assert(t, time.Since(start) < 10*time.Second)
+}
+
+func TestPluginSink(t *testing.T) {
+	// given
+	regions := text(64, 4*KiB)
+	out := t.TempDir() + "/out"
+	sink := pipeio.PluginSink(plugin("store", "PIPE_PLUGIN_OUT="+out), pipeio.NewBuffer(4*KiB, 4))
+
+	// when
+	err := pipe.New(&pipetest.Source{Regions: regions}, sink).Pipe(context.Background())
+
+	// then
+	assert.NilError(t, err)
+	got, err := os.ReadFile(out)
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, pipetest.Sequence(regions).Bytes()))
+}
+
+func TestExchange(t *testing.T) {
+	// given: a plugin listening on the other end of a connection
+	local, remote := connected(t)
+	go func() {
+		in, out := bufio.NewReader(remote), bufio.NewWriter(remote)
+		for {
+			off, data, ok := readFrame(in)
+			if !ok {
+				break
+			}
+			writeFrame(out, off, bytes.ToUpper(data))
+			out.Flush()
+		}
+		out.WriteByte('E')
+		out.Flush()
+	}()
+	regions := text(64, 4*KiB)
+	sink := &pipetest.Sink{}
+
+	// when
+	err := pipe.New(&pipetest.Source{Regions: regions}, sink, pipeio.Exchange(local, pipeio.NewBuffer(4*KiB, 4))).Pipe(context.Background())
+
+	// then
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(sink.Bytes(), bytes.ToUpper(pipetest.Sequence(regions).Bytes())))
+}
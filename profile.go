@@ -0,0 +1,88 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync/atomic"
+)
+
+// WithProfile captures CPU, heap and block profiles for every run of the pipe, and
+// writes them to cpu.pprof, heap.pprof and block.pprof in dir (overwriting the profiles
+// of any previous run). The goroutines of each stage are labeled with the stage they
+// belong to ("source", "gate", "valve N", "sink"), so the CPU profile can be broken down
+// per stage, e.g. with `go tool pprof -tagfocus stage=sink` (and with the pipe they
+// belong to, if it's named: see WithName).
+//
+// CPU profiling and the block profile rate are process-wide, so only one pipe at a time
+// can be profiled; the run fails to start otherwise. The block profile rate is put back
+// to what it was once the run is done, as long as it was set with SetBlockProfileRate.
+//
+// The heap and block profiles are the process's too: the heap profile covers every
+// allocation since the process started, and the block profile every goroutine that
+// blocked while it was profiling, whether or not it belongs to the pipe.
+func WithProfile(dir string) Option {
+	return func(p *Pipe) {
+		p.profile = dir
+	}
+}
+
+// blockProfileRate is the rate set through SetBlockProfileRate, since the runtime has no
+// way of telling what it is
+var blockProfileRate atomic.Int64
+
+// SetBlockProfileRate is runtime.SetBlockProfileRate, except that the rate is also
+// remembered so that profiled runs (see WithProfile) can restore it once they're done.
+func SetBlockProfileRate(rate int) {
+	blockProfileRate.Store(int64(rate))
+	runtime.SetBlockProfileRate(rate)
+}
+
+// startProfile starts profiling the run if the pipe is to be profiled, and returns the
+// func that writes the profiles out once the run is done
+func (p *Pipe) startProfile() (stop func() error, err error) {
+	if p.profile == "" {
+		return func() error { return nil }, nil
+	}
+
+	if err := os.MkdirAll(p.profile, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating profile dir: %w", err)
+	}
+	cpu, err := os.Create(filepath.Join(p.profile, "cpu.pprof"))
+	if err != nil {
+		return nil, fmt.Errorf("error creating CPU profile: %w", err)
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		cpu.Close()
+		return nil, fmt.Errorf("error starting CPU profile: %w", err)
+	}
+	runtime.SetBlockProfileRate(1)
+
+	return func() error {
+		pprof.StopCPUProfile()
+		runtime.SetBlockProfileRate(int(blockProfileRate.Load()))
+
+		errs := []error{cpu.Close()}
+		runtime.GC() // get the heap profile up to date
+		for _, name := range []string{"heap", "block"} {
+			errs = append(errs, writeProfile(p.profile, name))
+		}
+		return errors.Join(errs...)
+	}, nil
+}
+
+func writeProfile(dir, name string) error {
+	f, err := os.Create(filepath.Join(dir, name+".pprof"))
+	if err != nil {
+		return fmt.Errorf("error creating %s profile: %w", name, err)
+	}
+	defer f.Close()
+
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		return fmt.Errorf("error writing %s profile: %w", name, err)
+	}
+	return f.Close()
+}
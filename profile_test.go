@@ -0,0 +1,73 @@
+package pipe_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+func TestPipe_WithProfile(t *testing.T) {
+	// given
+	dir := filepath.Join(t.TempDir(), "profiles")
+	valve := &noopValve{f: func(pipe.Region) error { return nil }}
+	p := pipe.New(&source{regions: regions}, &sink{f: func(pipe.Region) error { return nil }}, valve).
+		With(pipe.WithProfile(dir))
+
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then
+	for _, name := range []string{"cpu.pprof", "heap.pprof", "block.pprof"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		assert.NilError(t, err)
+		assert.Assert(t, info.Size() > 0, name)
+	}
+}
+
+func TestPipe_WithProfile_blockRate(t *testing.T) {
+	// given: the process profiles blocking events on its own
+	pipe.SetBlockProfileRate(1)
+	defer pipe.SetBlockProfileRate(0)
+
+	p := pipe.New(&source{regions: regions}, &sink{f: func(pipe.Region) error { return nil }}).
+		With(pipe.WithProfile(t.TempDir()))
+
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: it still does
+	before := blockEvents()
+	block()
+	assert.Assert(t, blockEvents() > before)
+}
+
+// blockEvents returns the number of blocking events profiled so far, across every stack
+// (the number of stacks doesn't grow once a stack has blocked before)
+func blockEvents() int64 {
+	n, _ := runtime.BlockProfile(nil)
+	records := make([]runtime.BlockProfileRecord, n+10)
+	n, _ = runtime.BlockProfile(records)
+
+	var events int64
+	for _, r := range records[:n] {
+		events += r.Count
+	}
+	return events
+}
+
+// block blocks on a channel for a bit
+func block() {
+	c := make(chan struct{})
+	go func() {
+		time.Sleep(time.Millisecond)
+		close(c)
+	}()
+	<-c
+}
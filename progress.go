@@ -0,0 +1,88 @@
+package pipe
+
+import "time"
+
+// ProgressEvent is how far along a run of the pipe is (see WithProgress).
+type ProgressEvent struct {
+	// Bytes is how many bytes the sink has written so far, as it reports them (see
+	// Commit).
+	Bytes int64
+	// Regions is how many regions have been taken off the source so far.
+	Regions int64
+	// Total is the size of the stream, as given to WithProgress (0 if unknown).
+	Total   int64
+	Elapsed time.Duration
+	// Throughput is the number of bytes written per second, since the run started.
+	Throughput float64
+	// ETA is how much longer the run should take at that throughput, 0 if there's no
+	// telling (the total is unknown, or nothing's been written yet).
+	ETA time.Duration
+	// Done is set on the last event of the run, sent once it's over.
+	Done bool
+}
+
+// WithProgress has fn called with the progress of every run of the pipe every interval,
+// and once more when the run is over (with Done set), to drive progress bars and
+// dashboards. total is the size of the stream, for the ETA, or 0 if it's unknown. fn is
+// never called concurrently, and holds up nothing but further calls.
+func WithProgress(interval time.Duration, total int64, fn func(ProgressEvent)) Option {
+	return func(p *Pipe) {
+		p.progress = &progress{interval: interval, total: total, fn: fn}
+	}
+}
+
+type progress struct {
+	interval time.Duration
+	total    int64
+	fn       func(ProgressEvent)
+}
+
+// report calls fn with the progress of r every interval until stopped, and once more then
+func (pr *progress) report(r *run) (stop func()) {
+	start := time.Now()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		var tick <-chan time.Time
+		if pr.interval > 0 {
+			ticker := time.NewTicker(pr.interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-tick:
+				pr.fn(pr.event(r, time.Since(start)))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+
+		ev := pr.event(r, time.Since(start))
+		ev.Done = true
+		pr.fn(ev)
+	}
+}
+
+func (pr *progress) event(r *run, elapsed time.Duration) ProgressEvent {
+	ev := ProgressEvent{
+		Bytes:   r.tracker.bytes(),
+		Regions: r.regions.Load(),
+		Total:   pr.total,
+		Elapsed: elapsed,
+	}
+	if elapsed > 0 {
+		ev.Throughput = float64(ev.Bytes) / elapsed.Seconds()
+	}
+	if left := ev.Total - ev.Bytes; left > 0 && ev.Throughput > 0 {
+		ev.ETA = time.Duration(float64(left) / ev.Throughput * float64(time.Second))
+	}
+	return ev
+}
@@ -0,0 +1,161 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestProgress(t *testing.T) {
+	// given: a source file, and a copy of it that fails halfway through
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	sidecar := filepath.Join(dir, "src.progress")
+	assert.NilError(t, os.WriteFile(src, make([]byte, 100), 0o644))
+
+	id, err := pipeio.FileIdentity(src)
+	assert.NilError(t, err)
+
+	_, err = pipeio.LoadProgress(sidecar, id)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	pr := pipeio.NewProgress(id)
+	pr.Manifest = &pipeio.Manifest{}
+	sink := &pipetest.Sink{Check: func(r pipe.Region) error {
+		if r.Off == 50 {
+			return errors.New("welp")
+		}
+		return nil
+	}}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, pipeio.Record(pr.Manifest))
+
+	// when
+	stop := pr.Checkpoint(sidecar, p, time.Millisecond)
+	assert.ErrorContains(t, p.Pipe(context.Background()), "welp")
+	assert.NilError(t, stop())
+
+	// then: the next run picks up where this one left off
+	resumed, err := pipeio.LoadProgress(sidecar, id)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, resumed.Written, []pipe.Range{{Off: 0, Len: 50}})
+	assert.DeepEqual(t, resumed.Remaining(), []pipe.Range{{Off: 50, Len: 50}})
+	assert.Assert(t, len(resumed.Manifest.Entries) >= 5)
+
+	// and: once the rest is written, nothing remains
+	p = pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)[5:]}, &pipetest.Sink{})
+	stop = resumed.Checkpoint(sidecar, p, time.Hour)
+	assert.NilError(t, p.Pipe(context.Background()))
+	assert.NilError(t, stop())
+
+	done, err := pipeio.LoadProgress(sidecar, id)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, done.Written, []pipe.Range{{Off: 0, Len: 100}})
+	assert.DeepEqual(t, done.Remaining(), []pipe.Range{})
+
+	// but: progress doesn't carry over to a changed source
+	assert.NilError(t, os.Chtimes(src, time.Now(), time.Now().Add(time.Hour)))
+	id, err = pipeio.FileIdentity(src)
+	assert.NilError(t, err)
+	_, err = pipeio.LoadProgress(sidecar, id)
+	assert.ErrorIs(t, err, pipeio.ErrStaleProgress)
+}
+
+func TestProgress_Durable(t *testing.T) {
+	// given
+	sidecar := filepath.Join(t.TempDir(), "src.progress")
+	id := pipeio.Identity{Name: "src", Size: 100}
+	dst := &syncCounter{}
+	pr := pipeio.NewProgress(id).Durable(dst)
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{})
+
+	// when
+	stop := pr.Checkpoint(sidecar, p, time.Hour)
+	assert.NilError(t, p.Pipe(context.Background()))
+	assert.NilError(t, stop())
+
+	// then: the destination was synced before the ranges were saved
+	assert.Equal(t, dst.syncs, 1)
+	saved, err := pipeio.LoadProgress(sidecar, id)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, saved.Written, []pipe.Range{{Off: 0, Len: 100}})
+
+	// and: nothing is saved if it can't be synced
+	dst.err = errors.New("sync failed")
+	assert.ErrorIs(t, pr.Store(sidecar).Save(context.Background(), nil), dst.err)
+}
+
+func TestProgress_Checkpoint_noInterval(t *testing.T) {
+	// given
+	sidecar := filepath.Join(t.TempDir(), "src.progress")
+	id := pipeio.Identity{Name: "src", Size: 100}
+	pr := pipeio.NewProgress(id)
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{})
+
+	// when
+	stop := pr.Checkpoint(sidecar, p, 0)
+	assert.NilError(t, p.Pipe(context.Background()))
+	assert.NilError(t, stop())
+
+	// then: the progress was saved when stopped
+	saved, err := pipeio.LoadProgress(sidecar, id)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, saved.Written, []pipe.Range{{Off: 0, Len: 100}})
+}
+
+// syncCounter counts the times it's synced
+type syncCounter struct {
+	syncs int
+	err   error
+}
+
+func (w *syncCounter) Sync() error {
+	w.syncs++
+	return w.err
+}
+
+func TestPipe_WithProgress(t *testing.T) {
+	// given
+	var events []pipe.ProgressEvent
+	source := &pipetest.Source{Regions: pipetest.Regions(20, 10), Delay: 5 * time.Millisecond}
+	p := pipe.New(source, &pipetest.Sink{}).
+		With(pipe.WithProgress(10*time.Millisecond, 200, func(ev pipe.ProgressEvent) {
+			events = append(events, ev)
+		}))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: the run was reported on as it went, and once more at the end
+	assert.NilError(t, err)
+	assert.Assert(t, len(events) > 2, len(events))
+	for i, ev := range events[:len(events)-1] {
+		assert.Assert(t, !ev.Done)
+		assert.Assert(t, ev.Bytes <= events[i+1].Bytes)
+		assert.Assert(t, ev.Regions <= events[i+1].Regions)
+	}
+	var midway bool
+	for _, ev := range events {
+		if ev.Bytes > 0 && ev.Bytes < 200 {
+			midway = true
+			assert.Assert(t, ev.ETA > 0)
+			assert.Assert(t, ev.Throughput > 0)
+		}
+	}
+	assert.Assert(t, midway)
+
+	last := events[len(events)-1]
+	assert.Assert(t, last.Done)
+	assert.Equal(t, last.Bytes, int64(200))
+	assert.Equal(t, last.Regions, int64(20))
+	assert.Equal(t, last.Total, int64(200))
+	assert.Equal(t, last.ETA, time.Duration(0))
+}
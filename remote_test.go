@@ -0,0 +1,139 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// connected returns both ends of a TCP connection over loopback, so that (unlike
+// net.Pipe) there's buffering in between for a sender to race ahead into
+func connected(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	local, err := net.Dial("tcp", l.Addr().String())
+	assert.NilError(t, err)
+	remote := <-accepted
+	assert.Assert(t, remote != nil)
+
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	return local, remote
+}
+
+// remoteSink is a sink putting the data of the regions back into buff once done with them
+type remoteSink struct {
+	buff  pipeio.Buffer
+	check func(pipe.Region) error
+	delay time.Duration
+
+	mu   sync.Mutex
+	data map[int64][]byte
+}
+
+func (s *remoteSink) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	for {
+		r, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+		time.Sleep(s.delay)
+
+		if s.check != nil {
+			if err := s.check(r); err != nil {
+				errs <- err
+				return
+			}
+		}
+		s.mu.Lock()
+		s.data[r.Off] = append([]byte(nil), r.Data...)
+		s.mu.Unlock()
+		pipe.Commit(ctx, r)
+		s.buff.Put(r.Data)
+	}
+	errs <- ctx.Err()
+}
+
+func TestRemote(t *testing.T) {
+	// given: a slow remote writer, allowing 4 regions at once
+	local, remote := connected(t)
+	regions := pipetest.Regions(50, 64*KiB)
+
+	var sent, received, lead atomic.Int64
+	count := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		sent.Add(1)
+		return r, nil
+	})
+
+	src := pipeio.RemoteSource(remote, pipeio.NewBuffer(64*KiB, 8), pipeio.Window{Regions: 4, Bytes: 4 * 64 * KiB})
+	dst := &remoteSink{buff: src, delay: 2 * time.Millisecond, data: map[int64][]byte{}, check: func(pipe.Region) error {
+		received.Add(1)
+		if d := sent.Load() - received.Load(); d > lead.Load() {
+			lead.Store(d)
+		}
+		return nil
+	}}
+	served := make(chan error, 1)
+	go func() {
+		err := pipe.New(src, dst).Pipe(context.Background())
+		served <- errors.Join(err, src.Reply(err))
+	}()
+
+	// when
+	err := pipe.New(&pipetest.Source{Regions: regions}, pipeio.RemoteSink(local, pipeio.NewBuffer(64*KiB, 1)), count).Pipe(context.Background())
+
+	// then: everything made it across
+	assert.NilError(t, err)
+	assert.NilError(t, <-served)
+	for _, r := range regions {
+		assert.Assert(t, bytes.Equal(dst.data[r.Off], r.Data), r.Off)
+	}
+
+	// and: the local side never got further ahead than the window (give or take the
+	// regions on their way to the sink on either side)
+	assert.Assert(t, lead.Load() <= 4+3, "lead of %d regions", lead.Load())
+}
+
+func TestRemote_failed(t *testing.T) {
+	// given: a remote writer failing partway through
+	local, remote := connected(t)
+	src := pipeio.RemoteSource(remote, pipeio.NewBuffer(KiB, 8), pipeio.Window{Regions: 2, Bytes: 2 * KiB})
+	dst := &remoteSink{buff: src, data: map[int64][]byte{}, check: func(r pipe.Region) error {
+		if r.Off >= 10*KiB {
+			return errors.New("disk full")
+		}
+		return nil
+	}}
+	go func() {
+		err := pipe.New(src, dst).Pipe(context.Background())
+		_ = src.Reply(err)
+	}()
+
+	// when
+	err := pipe.New(&pipetest.Source{Regions: pipetest.Regions(100, KiB)}, pipeio.RemoteSink(local, pipeio.NewBuffer(KiB, 1))).Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "remote pipe failed: sink (*pipe_test.remoteSink): disk full")
+}
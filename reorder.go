@@ -0,0 +1,118 @@
+package pipe
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Reorder returns a Valve passing regions on in order of offset, from offset 0 (see
+// From), for sinks that write the stream as it comes (an io.Writer, a socket...) behind
+// sources or valves delivering regions out of order (Fan, sharded sources, pools of
+// workers). Regions arriving early are held until the regions before them have gone by.
+//
+// Held regions keep their data, so no more than n of them are held at once (n <= 0 for no
+// limit): the pipe fails once the region the stream is waiting for doesn't come in time,
+// rather than taking every buffer of the pool and getting stuck. With sources reading
+// regions in turn from a pool of buffers, n is best left below the size of the pool. The
+// pipe fails too if regions overlap, or if the stream ends with a gap in it. Empty
+// regions behind the stream are dropped, as they'd be out of order.
+func Reorder(n int) *reorder {
+	return &reorder{n: n}
+}
+
+type reorder struct {
+	n   int
+	off int64
+}
+
+// From has the stream start at off rather than 0 (for a transfer resuming halfway, say).
+func (o *reorder) From(off int64) *reorder {
+	o.off = off
+	return o
+}
+
+func (o *reorder) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+
+		var (
+			yield   = yielder(ctx)
+			next    = o.off
+			pending []Region // arrived early, by offset
+		)
+		pass := func(r Region) bool {
+			next = r.Off + int64(len(r.Data))
+			yield(r)
+			select {
+			case sink <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// behind returns whether r is behind the stream, failing if it overlaps it (empty
+		// regions, with nothing to hold back for, are dropped, and released: see Lend)
+		behind := func(r Region) (bool, error) {
+			if r.Off >= next {
+				return false, nil
+			}
+			if len(r.Data) > 0 {
+				return true, fmt.Errorf("reorder: %w: region at offset=%d overlaps the stream, at offset=%d", ErrOutOfOrder, r.Off, next)
+			}
+			return true, nil
+		}
+
+		for {
+			r, more := Next(ctx, source)
+			if !more {
+				break
+			}
+
+			if late, err := behind(r); late {
+				if err != nil {
+					errs <- err
+					return
+				}
+				r.Release()
+				continue
+			}
+			if r.Off > next {
+				i, _ := slices.BinarySearchFunc(pending, r.Off, func(p Region, off int64) int { return cmp.Compare(p.Off, off) })
+				pending = slices.Insert(pending, i, r)
+				if o.n > 0 && len(pending) > o.n {
+					errs <- fmt.Errorf("reorder: %d regions held waiting for offset=%d", len(pending), next)
+					return
+				}
+				continue
+			}
+
+			if !pass(r) {
+				return
+			}
+			for len(pending) > 0 && pending[0].Off <= next {
+				early := pending[0]
+				pending = pending[1:]
+				late, err := behind(early)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if late {
+					early.Release()
+				} else if !pass(early) {
+					return
+				}
+			}
+		}
+
+		if ctx.Err() == nil && len(pending) > 0 {
+			errs <- fmt.Errorf("reorder: %w: gap in the stream at offset=%d", ErrOutOfOrder, next)
+		}
+	}()
+
+	return source
+}
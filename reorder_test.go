@@ -0,0 +1,91 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestReorder(t *testing.T) {
+	// regions in order, played back in the order given by the indices
+	shuffle := func(from int64, order ...int) []pipe.Region {
+		regions := pipetest.Regions(slices.Max(order)+1, 10)
+		shuffled := make([]pipe.Region, len(order))
+		for i, j := range order {
+			shuffled[i] = regions[j]
+			shuffled[i].Off += from
+		}
+		return shuffled
+	}
+
+	tests := map[string]struct {
+		regions  []pipe.Region
+		valve    pipe.Valve
+		from     int64
+		expected string
+	}{
+		"in order": {
+			regions: shuffle(0, 0, 1, 2, 3),
+			valve:   pipe.Reorder(1),
+		},
+		"shuffled": {
+			regions: shuffle(0, 2, 0, 3, 1, 5, 4),
+			valve:   pipe.Reorder(2),
+		},
+		"reversed": {
+			regions: shuffle(0, 5, 4, 3, 2, 1, 0),
+			valve:   pipe.Reorder(0),
+		},
+		"empty regions": {
+			regions: append(shuffle(0, 1, 0), pipe.Region{Data: []byte{}, Off: 20}, pipe.Region{Data: []byte{}, Off: 0}),
+			valve:   pipe.Reorder(0),
+		},
+		"from": {
+			regions: shuffle(100, 1, 2, 0),
+			valve:   pipe.Reorder(2).From(100),
+			from:    100,
+		},
+		"held": {
+			regions:  shuffle(0, 5, 4, 3, 2, 1, 0),
+			valve:    pipe.Reorder(2),
+			expected: "reorder: 3 regions held waiting for offset=0",
+		},
+		"overlap": {
+			regions:  append(shuffle(0, 0, 1), pipe.Region{Data: []byte("abc"), Off: 15}),
+			valve:    pipe.Reorder(0),
+			expected: "reorder: regions out of order: region at offset=15 overlaps the stream, at offset=20",
+		},
+		"gap": {
+			regions:  shuffle(0, 0, 2),
+			valve:    pipe.Reorder(0),
+			expected: "reorder: regions out of order: gap in the stream at offset=10",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given: a sink that can only write the stream in order
+			var got bytes.Buffer
+			sink := pipeio.StreamSink(&got, test.from, pipeio.NewBuffer(10, 1))
+			want := pipetest.Sequence(slices.Clone(test.regions)).Bytes()
+
+			// when
+			err := pipe.New(&pipetest.Source{Regions: test.regions}, sink, test.valve).Pipe(context.Background())
+
+			// then
+			if test.expected != "" {
+				assert.ErrorContains(t, err, test.expected)
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got.Bytes(), want[test.from:])
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package pipe
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Range is a contiguous span of the overall data stream.
+type Range struct {
+	Off int64 `json:"off"`
+	Len int64 `json:"len"`
+}
+
+// End returns the offset just past the end of the range.
+func (r Range) End() int64 {
+	return r.Off + r.Len
+}
+
+// Failure is a range that could not be written, and why.
+type Failure struct {
+	Range
+	Class  ErrorClass `json:"class"`
+	Reason string     `json:"reason"`
+	Err    error      `json:"-"`
+}
+
+// Report lists exactly which ranges of the stream were written by the sink and which
+// failed. Written isn't synced: the destination may still lose ranges to a crash, unless
+// it's synced before they're relied on (see io.Progress.Durable). Written ranges are
+// sorted by offset and merged where contiguous.
+type Report struct {
+	Written []Range   `json:"written"`
+	Failed  []Failure `json:"failed"`
+}
+
+// Committed returns the length of the contiguous prefix of the stream that was written,
+// from offset 0 up to the first gap: what a sequential destination (a stream, an object
+// uploaded in order) can be trusted with, and where a transfer resumes from. It's always
+// measured from offset 0, so it's 0 for a stream starting further on (a run resuming a
+// transfer, say), whose report doesn't know what came before.
+func (r Report) Committed() int64 {
+	if len(r.Written) == 0 || r.Written[0].Off != 0 {
+		return 0
+	}
+	return r.Written[0].Len
+}
+
+// Commit is called by Sinks once a region has been written to the destination (which
+// needn't have synced it yet), so the pipe can account for it in its Report.
+func Commit(ctx context.Context, r Region) {
+	if t, ok := ctx.Value(trackerKey{}).(*tracker); ok {
+		t.commit(Range{Off: r.Off, Len: int64(len(r.Data))})
+	}
+}
+
+// CommitRange is like Commit, for sinks that write data without it ever passing through
+// a Region (see Shortcut).
+func CommitRange(ctx context.Context, r Range) {
+	if t, ok := ctx.Value(trackerKey{}).(*tracker); ok {
+		t.commit(r)
+	}
+}
+
+// Fail is called by Sinks when a region could not be written, so the pipe can account
+// for it in its Report.
+func Fail(ctx context.Context, r Region, err error) {
+	if t, ok := ctx.Value(trackerKey{}).(*tracker); ok {
+		t.fail(Failure{
+			Range:  Range{Off: r.Off, Len: int64(len(r.Data))},
+			Class:  t.classifier.Classify(err),
+			Reason: err.Error(),
+			Err:    err,
+		})
+	}
+}
+
+type trackerKey struct{}
+
+type tracker struct {
+	classifier ErrorClassifier
+	landed     func(n int64)             // if set, told about every byte written or failed
+	released   func(r Range)             // if set, told about every range written or failed
+	traced     func(r Range, f *Failure) // if set, told about every range written or failed
+	// relay, if set, is handed what's written and failed instead of it being recorded
+	relay func(r Range, f *Failure)
+
+	mu      sync.Mutex
+	written ranges
+	failed  []Failure
+}
+
+func (t *tracker) commit(r Range) {
+	if t.relay != nil {
+		t.relay(r, nil)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.written = t.written.add(r)
+	if t.landed != nil {
+		t.landed(r.Len)
+	}
+	if t.released != nil {
+		t.released(r)
+	}
+	if t.traced != nil {
+		t.traced(r, nil)
+	}
+}
+
+func (t *tracker) fail(f Failure) {
+	if t.relay != nil {
+		t.relay(f.Range, &f)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.failed = append(t.failed, f)
+	if t.landed != nil {
+		t.landed(f.Len)
+	}
+	if t.released != nil {
+		t.released(f.Range)
+	}
+	if t.traced != nil {
+		t.traced(f.Range, &f)
+	}
+}
+
+// bytes returns the number of bytes written
+func (t *tracker) bytes() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var n int64
+	for _, r := range t.written {
+		n += r.Len
+	}
+	return n
+}
+
+func (t *tracker) report() Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Report{
+		Written: slices.Clone(t.written),
+		Failed:  slices.Clone(t.failed),
+	}
+}
+
+// ranges is a sorted list of non-overlapping, non-adjacent ranges
+type ranges []Range
+
+func (rs ranges) add(r Range) ranges {
+	if r.Len == 0 {
+		return rs
+	}
+
+	// first range that ends at or after the start of r, i.e. the first one that could
+	// overlap or touch it
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].End() >= r.Off })
+
+	j := i
+	for j < len(rs) && rs[j].Off <= r.End() {
+		start := min(r.Off, rs[j].Off)
+		end := max(r.End(), rs[j].End())
+		r = Range{Off: start, Len: end - start}
+		j++
+	}
+
+	return slices.Replace(rs, i, j, r)
+}
+
+// intersect returns the ranges covered by both rs and other
+func (rs ranges) intersect(other ranges) ranges {
+	var out ranges
+	for i, j := 0, 0; i < len(rs) && j < len(other); {
+		start := max(rs[i].Off, other[j].Off)
+		end := min(rs[i].End(), other[j].End())
+		if start < end {
+			out = append(out, Range{Off: start, Len: end - start})
+		}
+		if rs[i].End() < other[j].End() {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+// minus returns the ranges covered by rs but not by other
+func (rs ranges) minus(other ranges) ranges {
+	var out ranges
+	j := 0
+	for _, r := range rs {
+		off := r.Off
+		for ; j < len(other) && other[j].Off < r.End(); j++ {
+			if other[j].End() <= off {
+				continue
+			}
+			if other[j].Off > off {
+				out = append(out, Range{Off: off, Len: other[j].Off - off})
+			}
+			off = other[j].End()
+			if off >= r.End() {
+				break
+			}
+		}
+		if off < r.End() {
+			out = append(out, Range{Off: off, Len: r.End() - off})
+		}
+	}
+	return out
+}
@@ -0,0 +1,131 @@
+package pipe_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestPipe_Report(t *testing.T) {
+	// given
+	w := &failingWriter{off: 90, err: errors.New("bad block")}
+	p := pipe.New(&source{regions: regions}, pipeio.Sink(w, pipeio.NewBuffer(10, 1)))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "bad block")
+
+	report := p.Report()
+	assert.DeepEqual(t, report.Written, []pipe.Range{{Off: 0, Len: 20}})
+	assert.Equal(t, len(report.Failed), 1)
+	assert.Equal(t, report.Failed[0].Range, pipe.Range{Off: 90, Len: 10})
+	assert.Equal(t, report.Failed[0].Class, pipe.Permanent)
+
+	b, err := json.Marshal(report)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), `{"written":[{"off":0,"len":20}],`+
+		`"failed":[{"off":90,"len":10,"class":"permanent","reason":"bad block"}]}`)
+}
+
+func TestReport_Committed(t *testing.T) {
+	tests := []struct {
+		name     string
+		written  []pipe.Range
+		expected int64
+	}{
+		{
+			name: "nothing written",
+		},
+		{
+			name:     "gap",
+			written:  []pipe.Range{{Off: 0, Len: 20}, {Off: 30, Len: 10}},
+			expected: 20,
+		},
+		{
+			name:    "start missing",
+			written: []pipe.Range{{Off: 10, Len: 20}},
+		},
+		{
+			name:    "non-zero start",
+			written: []pipe.Range{{Off: 4096, Len: 20}, {Off: 4126, Len: 10}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			report := pipe.Report{Written: test.written}
+
+			// when
+			committed := report.Committed()
+
+			// then
+			assert.Equal(t, committed, test.expected)
+		})
+	}
+}
+
+func TestPipe_Report_Pool(t *testing.T) {
+	// given
+	w := &failingWriter{off: 90, err: errors.New("bad block")}
+	pool := &returning{Sink: pipeio.Pool(pipeio.NewBuffer(10, 1), w, w), done: make(chan struct{})}
+	p := pipe.New(&source{regions: regions}, pool)
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: the pool is done too, rather than waiting on the failed write
+	assert.ErrorContains(t, err, "bad block")
+	select {
+	case <-pool.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the pool to be done")
+	}
+	assert.Equal(t, p.Report().Failed[0].Range, pipe.Range{Off: 90, Len: 10})
+}
+
+func TestPipe_WithClassifier(t *testing.T) {
+	// given
+	w := &failingWriter{off: 90, err: errors.New("bad block")}
+	transient := pipe.ClassifierFunc(func(error) pipe.ErrorClass { return pipe.Transient })
+	p := pipe.New(&source{regions: regions}, pipeio.Sink(w, pipeio.NewBuffer(10, 1))).
+		With(pipe.WithClassifier(transient))
+
+	// when
+	assert.ErrorContains(t, p.Pipe(context.Background()), "bad block")
+
+	// then
+	assert.Equal(t, p.Report().Failed[0].Class, pipe.Transient)
+}
+
+// returning lets the test know when the sink is done reading
+type returning struct {
+	pipe.Sink
+	done chan struct{}
+}
+
+func (s *returning) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	defer close(s.done)
+	s.Sink.Read(ctx, source, errs)
+}
+
+type failingWriter struct {
+	off int64
+	err error
+}
+
+func (w *failingWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off == w.off {
+		return 0, w.err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,47 @@
+package pipe
+
+import (
+	"context"
+	"time"
+)
+
+// Result is what a run of a pipe came to (see Run).
+type Result struct {
+	// Bytes is how many bytes the sink reported written (see Commit), and Regions how many
+	// regions were taken off the source.
+	Bytes   int64
+	Regions int64
+	// Committed is the length of the contiguous prefix of the stream that was written, and
+	// Written the ranges that were, whether or not the run failed (see Report).
+	Committed int64
+	Written   []Range
+	// Elapsed is how long the run took.
+	Elapsed time.Duration
+	// Stages is what went through each stage, if the pipe keeps stats (see WithStats).
+	Stages []StageStats
+	// Failed is the offsets of the regions the pipe carried on past rather than fail (see
+	// WithTolerance, WithDeadLetters), sorted.
+	Failed []int64
+}
+
+// Run runs the pipe as Pipe does, and returns what the run came to along with its error,
+// so the caller learns how much was transferred without looking at the destination. The
+// result of a failed run is what the pipe got through before it failed.
+func (p *Pipe) Run(ctx context.Context) (Result, error) {
+	start := time.Now()
+	err := p.Pipe(ctx)
+	res := Result{Elapsed: time.Since(start), Stages: p.Stats()}
+
+	p.mu.Lock()
+	r := p.run
+	p.mu.Unlock()
+
+	if r != nil {
+		res.Bytes = r.tracker.bytes()
+		rep := r.tracker.report()
+		res.Committed, res.Written = rep.Committed(), rep.Written
+		res.Regions = r.regions.Load()
+		res.Failed = r.allowance.offsets()
+	}
+	return res, err
+}
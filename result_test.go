@@ -0,0 +1,51 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_Run(t *testing.T) {
+	// given
+	source := &pipetest.Source{Regions: pipetest.Regions(10, 10), Delay: time.Millisecond}
+	p := pipe.New(source, &pipetest.Sink{}, passthrough).With(pipe.WithStats())
+
+	// when
+	res, err := p.Run(context.Background())
+
+	// then
+	assert.NilError(t, err)
+	assert.Equal(t, res.Bytes, int64(100))
+	assert.Equal(t, res.Regions, int64(10))
+	assert.Assert(t, res.Elapsed >= 10*time.Millisecond)
+	assert.Equal(t, len(res.Stages), 3)
+	assert.Equal(t, res.Stages[2].BytesIn, int64(100))
+}
+
+func TestPipe_Run_failed(t *testing.T) {
+	// given: a sink failing on the sixth region
+	sink := &pipetest.Sink{Check: func(r pipe.Region) error {
+		if r.Off == 50 {
+			return errors.New("disk full")
+		}
+		return nil
+	}}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink)
+
+	// when
+	res, err := p.Run(context.Background())
+
+	// then: what got through before it failed
+	assert.ErrorContains(t, err, "disk full")
+	assert.Equal(t, res.Bytes, int64(50))
+	assert.Equal(t, res.Committed, int64(50))
+	assert.DeepEqual(t, res.Written, []pipe.Range{{Off: 0, Len: 50}})
+	assert.Assert(t, res.Stages == nil)
+}
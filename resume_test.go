@@ -0,0 +1,249 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+// versioned serves data as the version etag (if set) of a resource, last modified at
+// modTime (or a fixed time, if unset), counting the bytes it sends
+type versioned struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+	sent    atomic.Int64
+
+	mu       sync.Mutex
+	ifRanges []string // the If-Range of every ranged request
+	breaks   int      // how many responses to break off halfway
+}
+
+func (v *versioned) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if v.etag != "" {
+		w.Header().Set("ETag", v.etag)
+	}
+	v.mu.Lock()
+	if r.Header.Get("Range") != "" {
+		v.ifRanges = append(v.ifRanges, r.Header.Get("If-Range"))
+	}
+	broken := v.breaks > 0 && r.Method == http.MethodGet
+	if broken {
+		v.breaks--
+	}
+	v.mu.Unlock()
+
+	if broken {
+		// promise all of it, send half and hang up
+		w.Header().Set("Content-Length", strconv.Itoa(len(v.data)))
+		n, _ := w.Write(v.data[:len(v.data)/2])
+		v.sent.Add(int64(n))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	cw := &countingWriter{ResponseWriter: w, n: &v.sent}
+	modTime := v.modTime
+	if modTime.IsZero() {
+		modTime = time.Unix(1700000000, 0)
+	}
+	http.ServeContent(cw, r, "blob", modTime, bytes.NewReader(v.data))
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+func TestHTTPSource_resume(t *testing.T) {
+	// given: a server hanging up halfway through the body
+	want := make([]byte, 100*KiB)
+	_, _ = rand.Read(want)
+	v := &versioned{data: want, etag: `"v1"`, breaks: 1}
+	srv := httptest.NewServer(v)
+	defer srv.Close()
+
+	var got bytes.Buffer
+	buff := pipeio.NewBuffer(32*KiB, 1)
+	source := pipeio.HTTPSource(srv.Client(), srv.URL, 0, -1, buff)
+
+	// when
+	err := pipe.New(source, pipeio.StreamSink(&got, 0, buff)).Pipe(context.Background())
+
+	// then: the rest is fetched from the same version
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got.Bytes(), want))
+	assert.DeepEqual(t, v.ifRanges, []string{`"v1"`})
+}
+
+func TestHTTPSource_IfRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		etag   string
+		off    int64
+		change func(v *versioned)
+	}{
+		{
+			name:   "etag",
+			etag:   `"v1"`,
+			off:    KiB,
+			change: func(v *versioned) { v.etag = `"v2"` },
+		},
+		{
+			name:   "last-modified",
+			off:    KiB,
+			change: func(v *versioned) { v.modTime = time.Unix(1800000000, 0) },
+		},
+		{
+			name:   "last-modified/from the start",
+			change: func(v *versioned) { v.modTime = time.Unix(1800000000, 0) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given: a resource that changed since it was identified
+			v := &versioned{data: make([]byte, 10*KiB), etag: test.etag}
+			srv := httptest.NewServer(v)
+			defer srv.Close()
+
+			id, err := pipeio.HTTPIdentity(context.Background(), srv.Client(), srv.URL)
+			assert.NilError(t, err)
+			test.change(v)
+
+			source := pipeio.HTTPSource(srv.Client(), srv.URL, test.off, -1, pipeio.NewBuffer(KiB, 1)).IfRange(id)
+
+			// when
+			err = pipe.New(source, &sink{f: func(pipe.Region) error { return nil }}).Pipe(context.Background())
+
+			// then
+			assert.ErrorIs(t, err, pipeio.ErrStaleProgress)
+		})
+	}
+}
+
+func TestDownload_ResumeFrom(t *testing.T) {
+	want := make([]byte, 3*MiB+7)
+	_, _ = rand.Read(want)
+	sum := sha256.Sum256(want)
+	half := int64(len(want) / 2)
+
+	tests := []struct {
+		name  string
+		etag  string // of the version the progress was saved for
+		sent  int64  // bytes the download should take
+		extra []pipeio.CopyOption
+	}{
+		{name: "resumed", etag: `"v1"`, sent: int64(len(want)) - half},
+		{name: "resumed with digest", etag: `"v1"`, sent: int64(len(want)) - half, extra: []pipeio.CopyOption{pipeio.ExpectDigest(crypto.SHA256, sum[:])}},
+		{name: "stale", etag: `"v0"`, sent: int64(len(want))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given: the first half downloaded by an earlier run
+			v := &versioned{data: want, etag: `"v1"`}
+			srv := httptest.NewServer(v)
+			defer srv.Close()
+
+			dir := t.TempDir()
+			path, sidecar := filepath.Join(dir, "dst"), filepath.Join(dir, "dst.progress")
+			partial := append(bytes.Clone(want[:half]), make([]byte, int64(len(want))-half)...)
+			assert.NilError(t, os.WriteFile(path, partial, 0o644))
+
+			id, err := pipeio.HTTPIdentity(context.Background(), srv.Client(), srv.URL)
+			assert.NilError(t, err)
+			id.ETag = test.etag
+			pr := pipeio.NewProgress(id)
+			pr.Written = []pipe.Range{{Off: 0, Len: half}}
+			b, err := json.Marshal(pr)
+			assert.NilError(t, err)
+			assert.NilError(t, os.WriteFile(sidecar, b, 0o644))
+
+			// when
+			opts := append([]pipeio.CopyOption{pipeio.Client(srv.Client()), pipeio.BufferSize(256 * KiB), pipeio.ResumeFrom(sidecar)}, test.extra...)
+			err = pipeio.Download(context.Background(), srv.URL, path, opts...)
+
+			// then
+			assert.NilError(t, err)
+			got, err := os.ReadFile(path)
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(got, want))
+			assert.Equal(t, v.sent.Load(), test.sent)
+			_, err = os.Stat(sidecar)
+			assert.Assert(t, errors.Is(err, os.ErrNotExist))
+		})
+	}
+}
+
+func TestDownload_ResumeFrom_interrupted(t *testing.T) {
+	// given: a download that fails partway through
+	want := make([]byte, MiB)
+	_, _ = rand.Read(want)
+	v := &versioned{data: want, etag: `"v1"`}
+	var fail atomic.Bool
+	fail.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() && r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=0-262143" {
+			// late enough for the first shard to land
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		v.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	path, sidecar := filepath.Join(dir, "dst"), filepath.Join(dir, "dst.progress")
+	opts := []pipeio.CopyOption{pipeio.Client(srv.Client()), pipeio.BufferSize(64 * KiB), pipeio.Shards(4), pipeio.ResumeFrom(sidecar)}
+
+	err := pipeio.Download(context.Background(), srv.URL, path, opts...)
+	assert.ErrorContains(t, err, "503")
+
+	id, err := pipeio.HTTPIdentity(context.Background(), srv.Client(), srv.URL)
+	assert.NilError(t, err)
+	pr, err := pipeio.LoadProgress(sidecar, id)
+	assert.NilError(t, err)
+	var remaining int64
+	for _, r := range pr.Remaining() {
+		remaining += r.Len
+	}
+	assert.Assert(t, remaining < int64(len(want)))
+
+	// when: it's run again
+	fail.Store(false)
+	v.sent.Store(0)
+	err = pipeio.Download(context.Background(), srv.URL, path, opts...)
+
+	// then: what the first run wrote isn't fetched again
+	assert.NilError(t, err)
+	got, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, want))
+	assert.Equal(t, v.sent.Load(), remaining)
+}
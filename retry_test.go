@@ -0,0 +1,114 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	sinks := map[string]func(w io.WriterAt, policy pipeio.RetryPolicy) pipe.Sink{
+		"sink": func(w io.WriterAt, policy pipeio.RetryPolicy) pipe.Sink {
+			return pipeio.Sink(w, pipeio.NewBuffer(10, 1), pipeio.RetryWrites(policy))
+		},
+		"pool": func(w io.WriterAt, policy pipeio.RetryPolicy) pipe.Sink {
+			return pipeio.Pool(pipeio.NewBuffer(10, 1), w).RetryWith(policy)
+		},
+	}
+
+	tests := map[string]struct {
+		failures int
+		err      error
+		policy   pipeio.RetryPolicy
+		opts     []pipe.Option
+		expected error
+		attempts int
+		elapsed  time.Duration // at least
+	}{
+		"recovers": {
+			failures: 2,
+			err:      syscall.ECONNRESET,
+			policy:   pipeio.RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond},
+			attempts: 4 + 2,
+			elapsed:  10*time.Millisecond + 20*time.Millisecond,
+		},
+		"recovers/max backoff": {
+			failures: 3,
+			err:      syscall.ECONNRESET,
+			policy:   pipeio.RetryPolicy{MaxAttempts: 4, Backoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond},
+			attempts: 4 + 3,
+			elapsed:  3 * 10 * time.Millisecond,
+		},
+		"gives up": {
+			failures: 5,
+			err:      syscall.ECONNRESET,
+			policy:   pipeio.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+			expected: syscall.ECONNRESET,
+			attempts: 3,
+		},
+		"not retryable": {
+			failures: 5,
+			err:      syscall.EIO,
+			policy:   pipeio.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+			expected: syscall.EIO,
+			attempts: 1,
+		},
+		"retryable": {
+			failures: 1,
+			err:      syscall.EIO,
+			policy: pipeio.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Retryable: func(err error) bool {
+				return errors.Is(err, syscall.EIO)
+			}},
+			attempts: 4 + 1,
+		},
+		"classified by the pipe": {
+			failures: 1,
+			err:      syscall.EIO,
+			policy:   pipeio.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+			opts: []pipe.Option{pipe.WithClassifier(pipe.ClassifierFunc(func(err error) pipe.ErrorClass {
+				return pipe.Transient
+			}))},
+			attempts: 4 + 1,
+		},
+		"no retries": {
+			failures: 1,
+			err:      syscall.ECONNRESET,
+			expected: syscall.ECONNRESET,
+			attempts: 1,
+		},
+	}
+
+	for sinkName, newSink := range sinks {
+		for name, test := range tests {
+			t.Run(sinkName+"/"+name, func(t *testing.T) {
+				// given
+				w := &flakyWriter{failures: test.failures, err: test.err}
+				p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(4, 10)}, newSink(w, test.policy)).With(test.opts...)
+
+				// when
+				start := time.Now()
+				err := p.Pipe(context.Background())
+
+				// then
+				if test.expected != nil {
+					assert.ErrorIs(t, err, test.expected)
+					assert.Equal(t, len(p.Report().Failed), 1)
+				} else {
+					assert.NilError(t, err)
+					assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 40}})
+				}
+				assert.Equal(t, w.count(), test.attempts)
+				assert.Assert(t, time.Since(start) >= test.elapsed)
+			})
+		}
+	}
+}
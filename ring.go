@@ -0,0 +1,271 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+)
+
+// WithRing (experimental) swaps the channels between stages for bounded ring buffers of
+// (at least) size regions, which consumers drain in batches. Producers and consumers
+// spin for a little while when the ring is full or empty before parking, which trades
+// some CPU for latency - only worth it when benchmarks show that channels are the
+// bottleneck, i.e. at multi-GB/s rates.
+//
+// As with WithBatches, only pipes whose valves are all Funcs can use rings; other pipes
+// ignore the option.
+func WithRing(size int) Option {
+	return func(p *Pipe) {
+		p.ring = size
+	}
+}
+
+// spins is how many times a producer or consumer yields before parking
+const spins = 64
+
+// ring is a bounded multi-producer/single-consumer queue of regions; each cell carries a
+// sequence number telling producers and the consumer whose turn it is
+type ring struct {
+	cells []cell
+	mask  uint64
+
+	_    [56]byte // keep head and tail on separate cache lines
+	head atomic.Uint64
+	_    [56]byte
+	tail atomic.Uint64
+
+	closed   atomic.Bool
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+type cell struct {
+	seq atomic.Uint64
+	r   Region
+}
+
+func newRing(size int) *ring {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+
+	q := &ring{
+		cells:    make([]cell, n),
+		mask:     uint64(n - 1),
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+	for i := range q.cells {
+		q.cells[i].seq.Store(uint64(i))
+	}
+	return q
+}
+
+func (q *ring) tryPush(r Region) bool {
+	for {
+		pos := q.tail.Load()
+		c := &q.cells[pos&q.mask]
+		switch seq := c.seq.Load(); {
+		case seq == pos:
+			if q.tail.CompareAndSwap(pos, pos+1) {
+				c.r = r
+				c.seq.Store(pos + 1)
+				return true
+			}
+		case seq < pos:
+			// full
+			return false
+		}
+	}
+}
+
+func (q *ring) tryPop() (Region, bool) {
+	pos := q.head.Load()
+	c := &q.cells[pos&q.mask]
+	if c.seq.Load() != pos+1 {
+		// empty
+		return Region{}, false
+	}
+
+	r := c.r
+	c.r = Region{}
+	q.head.Store(pos + 1)
+	c.seq.Store(pos + q.mask + 1)
+	return r, true
+}
+
+// push adds the region to the ring, waiting for room if it's full
+func (q *ring) push(ctx context.Context, r Region) bool {
+	for i := 0; ; i++ {
+		if q.tryPush(r) {
+			signal(q.notEmpty)
+			return true
+		}
+		if !wait(ctx, i, q.notFull) {
+			return false
+		}
+	}
+}
+
+// pop takes up to len(batch) regions off the ring, waiting for at least one; it returns
+// false once the ring is closed and drained (or the context is done)
+func (q *ring) pop(ctx context.Context, batch []Region) ([]Region, bool) {
+	batch = batch[:0]
+	for i := 0; ; i++ {
+		for len(batch) < cap(batch) {
+			r, ok := q.tryPop()
+			if !ok {
+				break
+			}
+			batch = append(batch, r)
+		}
+		if len(batch) > 0 {
+			signal(q.notFull)
+			return batch, true
+		}
+
+		if q.closed.Load() {
+			// producers are done, but one may have slipped in right before closing
+			if r, ok := q.tryPop(); ok {
+				return append(batch, r), true
+			}
+			return batch, false
+		}
+		if !wait(ctx, i, q.notEmpty) {
+			return batch, false
+		}
+	}
+}
+
+// close marks the ring as done; producers must not push afterwards
+func (q *ring) close() {
+	q.closed.Store(true)
+	signal(q.notEmpty)
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// wait spins (yielding the processor) for the first few attempts, then parks until woken
+func wait(ctx context.Context, attempt int, wake chan struct{}) bool {
+	if attempt < spins {
+		runtime.Gosched()
+		return ctx.Err() == nil
+	}
+
+	select {
+	case <-wake:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *Pipe) startRings(ctx context.Context, r *run, fns []Func) {
+	defer r.stages.sunk(r.done)
+
+	in := p.connector(0)
+	sourceCtx, stopSource := context.WithCancelCause(ctx)
+	p.stage(ctx, "source")
+	go func() {
+		defer Pin(ctx)()
+		p.source.Write(sourceCtx, in, r.stages.reports(0))
+	}()
+
+	q := newRing(p.ring)
+	p.stage(ctx, "gate")
+	go r.ringGate(ctx, in, q, stopSource)
+
+	for i, f := range fns {
+		p.stage(ctx, fmt.Sprintf("valve %d", i))
+		q = f.rings(ctx, q, p.ring, r.stages.reports(i+1))
+	}
+
+	// the sink still takes regions off of a channel
+	p.stage(ctx, "sink")
+	last := p.connector(len(fns))
+	go func() {
+		defer close(last)
+
+		batch := make([]Region, 0, p.ring)
+		for {
+			var more bool
+			if batch, more = q.pop(ctx, batch); !more {
+				return
+			}
+			for _, region := range batch {
+				select {
+				case last <- region:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	defer Pin(ctx)()
+	p.sink.Read(ctx, last, r.stages.reports(len(fns)+1))
+}
+
+// ringGate is the gate for pipes running on rings
+func (r *run) ringGate(ctx context.Context, in chan Region, out *ring, stopSource context.CancelCauseFunc) {
+	defer out.close()
+
+	for r.hold(ctx) {
+		select {
+		case region, more := <-in:
+			if !more {
+				return
+			}
+			if r.strike(ctx) && r.admit(ctx, region) && out.push(ctx, r.stamp(region)) {
+				continue
+			}
+		case <-r.stop:
+		case <-ctx.Done():
+		}
+
+		break
+	}
+
+	r.halt(ctx, in, stopSource)
+}
+
+func (f Func) rings(ctx context.Context, in *ring, size int, errs chan error) *ring {
+	out := newRing(size)
+	go func() {
+		defer Pin(ctx)()
+		defer out.close()
+
+		yield := yielder(ctx)
+		batch := make([]Region, 0, size)
+		for {
+			var more bool
+			if batch, more = in.pop(ctx, batch); !more {
+				return
+			}
+
+			for _, in := range batch {
+				region, err := f(in)
+				if err != nil {
+					if divert(ctx, in, err) {
+						continue
+					}
+					errs <- err
+					return
+				}
+				yield(region)
+				if !out.push(ctx, region) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
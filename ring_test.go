@@ -0,0 +1,57 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+func TestPipe_WithRing(t *testing.T) {
+	many := make([]pipe.Region, 1000)
+	for i := range many {
+		many[i] = pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+	}
+	shift := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		r.Off++
+		return r, nil
+	})
+
+	t.Run("success", func(t *testing.T) {
+		// given
+		read := make([]pipe.Region, 0)
+		sinkFunc := func(r pipe.Region) error {
+			read = append(read, r)
+			return nil
+		}
+		p := pipe.New(&source{regions: many}, &sink{f: sinkFunc}, shift, shift).
+			With(pipe.WithRing(16))
+
+		// when
+		assert.NilError(t, p.Pipe(context.Background()))
+
+		// then
+		assert.Equal(t, len(read), len(many))
+		for i := range read {
+			assert.Equal(t, read[i].Off, many[i].Off+2)
+		}
+	})
+
+	t.Run("error/func", func(t *testing.T) {
+		// given
+		fail := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+			if r.Off == 5000 {
+				return r, errors.New("welp")
+			}
+			return r, nil
+		})
+		p := pipe.New(&source{regions: many}, &sink{f: func(pipe.Region) error { return nil }}, fail).
+			With(pipe.WithRing(16))
+
+		// when / then
+		assert.ErrorContains(t, p.Pipe(context.Background()), "welp")
+	})
+}
@@ -0,0 +1,119 @@
+package pipe
+
+import (
+	"context"
+	"runtime"
+)
+
+// WithMaxWorkers bounds the number of goroutines the pipe's components have doing work at
+// once, on top of the one goroutine per stage. Components that spin up a goroutine per
+// unit of work (such as pipeio.Pool, per region) take a Worker before each one. To bound
+// the number of sources Fan reads from at once, see Fan.SetConcurrency instead.
+func WithMaxWorkers(n int) Option {
+	return func(p *Pipe) {
+		p.maxWorkers = n
+	}
+}
+
+// WithLockedThreads pins the hot goroutine of every stage to its own OS thread for the
+// duration of the run (see runtime.LockOSThread). Scheduler migration hurts some
+// latency-sensitive device-to-device copies; for everything else, leave it off.
+func WithLockedThreads() Option {
+	return func(p *Pipe) {
+		p.lockThreads = true
+	}
+}
+
+// Scheduler decides how the stages of a pipe interleave, by way of the yield points the
+// stages go through as they hand off regions: stages call Yield with the region they're
+// about to pass on, and the scheduler may hold them there while other goroutines run.
+// It's meant for tests, to shake out (and then replay) concurrency bugs - see
+// pipetest.Deterministic.
+type Scheduler interface {
+	Yield(r Region)
+}
+
+// WithScheduler has the stages of the pipe go through s at every handoff.
+func WithScheduler(s Scheduler) Option {
+	return func(p *Pipe) {
+		p.scheduler = s
+	}
+}
+
+// Yield is a yield point for the Scheduler of the pipe, if it has one (see
+// WithScheduler). Components call it right before handing off a region.
+func Yield(ctx context.Context, r Region) {
+	yielder(ctx)(r)
+}
+
+// yielder returns the func for a stage to call at its yield points, for stages to look up
+// once rather than for every region
+func yielder(ctx context.Context) func(Region) {
+	s, _ := ctx.Value(schedKey{}).(*sched)
+	switch {
+	case s == nil:
+		return func(Region) {}
+	case s.chaos != nil && s.scheduler != nil:
+		return func(r Region) {
+			s.chaos.stall(ctx)
+			s.scheduler.Yield(r)
+		}
+	case s.chaos != nil:
+		return func(Region) { s.chaos.stall(ctx) }
+	case s.scheduler != nil:
+		return s.scheduler.Yield
+	default:
+		return func(Region) {}
+	}
+}
+
+// Worker blocks until the pipe allows one more worker goroutine, and returns the func to
+// call when the worker is done. It returns false if the context is done first. Pipes
+// without WithMaxWorkers always allow more workers.
+func Worker(ctx context.Context) (release func(), ok bool) {
+	s, _ := ctx.Value(schedKey{}).(*sched)
+	if s == nil || s.workers == nil {
+		return func() {}, true
+	}
+
+	if !s.workers.acquire(ctx) {
+		return func() {}, false
+	}
+	return s.workers.release, true
+}
+
+// Pin locks the calling goroutine to its OS thread if the pipe runs WithLockedThreads,
+// and returns the func that unlocks it. Stages call it at the top of their hot
+// goroutine:
+//
+//	defer pipe.Pin(ctx)()
+func Pin(ctx context.Context) (unpin func()) {
+	s, _ := ctx.Value(schedKey{}).(*sched)
+	if s == nil || !s.lockThreads {
+		return func() {}
+	}
+
+	runtime.LockOSThread()
+	return runtime.UnlockOSThread
+}
+
+type schedKey struct{}
+
+// sched holds the scheduling hints of a run
+type sched struct {
+	workers     *slots
+	lockThreads bool
+	scheduler   Scheduler
+	chaos       *chaos
+}
+
+func (p *Pipe) sched(c *chaos, sh *share) *sched {
+	s := &sched{lockThreads: p.lockThreads, scheduler: p.scheduler, chaos: c}
+	switch {
+	case sh != nil && sh.workers != nil:
+		s.workers = sh.workers
+	case p.maxWorkers > 0:
+		s.workers = newSlots(p.maxWorkers)
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package pipe_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithMaxWorkers(t *testing.T) {
+	// given
+	var mu sync.Mutex
+	var inFlight, peak int
+	writers := make([]io.WriterAt, 4)
+	for i := range writers {
+		writers[i] = writerFunc(func(p []byte, off int64) (int, error) {
+			mu.Lock()
+			inFlight++
+			peak = max(peak, inFlight)
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return len(p), nil
+		})
+	}
+
+	many := make([]pipe.Region, 20)
+	for i := range many {
+		many[i] = pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+	}
+
+	p := pipe.New(&source{regions: many}, pipeio.Pool(pipeio.NewBuffer(10, 1), writers...)).
+		With(pipe.WithMaxWorkers(2), pipe.WithLockedThreads())
+
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then
+	assert.Equal(t, peak, 2)
+	assert.Equal(t, len(p.Report().Written), 1)
+}
+
+type writerFunc func(p []byte, off int64) (int, error)
+
+func (f writerFunc) WriteAt(p []byte, off int64) (int, error) {
+	return f(p, off)
+}
+
+func TestPipe_WithScheduler(t *testing.T) {
+	// the order in which the sources of a Fan get their regions through is down to how
+	// their goroutines interleave
+	trace := func(t *testing.T, opt pipe.Option) string {
+		sink := &pipetest.Sink{}
+		fan := pipe.Fan(
+			&pipetest.Source{Regions: pipetest.Regions(10, 10)},
+			&pipetest.Source{Regions: pipetest.Regions(20, 10)[10:]},
+		)
+		passthrough := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+		assert.NilError(t, pipe.New(fan, sink, passthrough).With(opt).Pipe(context.Background()))
+
+		offs := make([]int64, 0)
+		for _, r := range sink.Regions() {
+			offs = append(offs, r.Off)
+		}
+		return fmt.Sprint(offs)
+	}
+
+	traces := make(map[string]bool)
+	pipetest.Explore(t, 10, func(t *testing.T, opt pipe.Option) {
+		traces[trace(t, opt)] = true
+	})
+	assert.Assert(t, len(traces) > 1, "expected different seeds to interleave differently")
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	for seed := range int64(10) {
+		first := trace(t, pipe.WithScheduler(pipetest.Deterministic(seed)))
+		again := trace(t, pipe.WithScheduler(pipetest.Deterministic(seed)))
+		assert.Equal(t, first, again, "seed %d", seed)
+	}
+}
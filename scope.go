@@ -0,0 +1,46 @@
+package pipe
+
+import (
+	"context"
+	"maps"
+)
+
+// Scope holds request-scoped values (trace IDs, tenant, auth tokens...) that the stages
+// of a pipe should carry along, so that whatever they log or call out to can be tied
+// back to the request the pipe runs for.
+//
+// A scope is attached to the context a pipe runs with (see WithScope), which is where
+// every stage finds it (see ScopeOf). Regions are stamped with it as they enter the pipe,
+// so it also goes wherever they go: into goroutines or pipes of a stage's own, running
+// with contexts of their own.
+type Scope map[string]string
+
+type scopeKey struct{}
+
+// WithScope returns a copy of ctx carrying scope, on top of whatever scope ctx already
+// carries.
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	merged := maps.Clone(ScopeOf(ctx))
+	if merged == nil {
+		merged = make(Scope, len(scope))
+	}
+	maps.Copy(merged, scope)
+
+	return context.WithValue(ctx, scopeKey{}, merged)
+}
+
+// ScopeOf returns the scope ctx carries, or nil. It's shared, and mustn't be modified.
+func ScopeOf(ctx context.Context) Scope {
+	scope, _ := ctx.Value(scopeKey{}).(Scope)
+	return scope
+}
+
+// stamp gives a region entering the pipe the scope of the run, unless it came with one,
+// and counts it in
+func (r *run) stamp(region Region) Region {
+	r.regions.Add(1)
+	if region.Scope == nil {
+		region.Scope = r.scope
+	}
+	return region
+}
@@ -0,0 +1,100 @@
+package pipe_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestWithScope(t *testing.T) {
+	// given
+	ctx := pipe.WithScope(context.Background(), pipe.Scope{"trace": "abc", "tenant": "acme"})
+
+	// when
+	ctx = pipe.WithScope(ctx, pipe.Scope{"tenant": "initech"})
+
+	// then
+	assert.DeepEqual(t, pipe.ScopeOf(ctx), pipe.Scope{"trace": "abc", "tenant": "initech"})
+	assert.Assert(t, pipe.ScopeOf(context.Background()) == nil)
+}
+
+func TestPipe_scope(t *testing.T) {
+	passthrough := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+	want := pipe.Scope{"trace": "abc"}
+
+	tests := []struct {
+		name string
+		opts []pipe.Option
+	}{
+		{name: "channels"},
+		{name: "batches", opts: []pipe.Option{pipe.WithBatches(4)}},
+		{name: "ring", opts: []pipe.Option{pipe.WithRing(4)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sink := &scopeSink{}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, passthrough).With(test.opts...)
+
+			// when
+			err := p.Pipe(pipe.WithScope(context.Background(), want))
+
+			// then: the sink found the scope in its context, and on every region
+			assert.NilError(t, err)
+			assert.DeepEqual(t, sink.scope, want)
+			assert.Equal(t, len(sink.regions), 10)
+			for _, r := range sink.regions {
+				assert.DeepEqual(t, r.Scope, want)
+			}
+		})
+	}
+}
+
+func TestPipe_scope_kept(t *testing.T) {
+	// given: a region that already belongs to another request
+	theirs := pipe.Scope{"trace": "xyz"}
+	source := sourceFunc(func(ctx context.Context, sink chan pipe.Region, errs chan error) {
+		defer close(sink)
+		sink <- pipe.Region{Data: []byte("AAAAAAAAAA"), Scope: theirs}
+	})
+	sink := &scopeSink{}
+
+	// when
+	err := pipe.New(source, sink).Pipe(pipe.WithScope(context.Background(), pipe.Scope{"trace": "abc"}))
+
+	// then
+	assert.NilError(t, err)
+	assert.DeepEqual(t, sink.regions[0].Scope, theirs)
+}
+
+// scopeSink records the scope it runs with, and the regions it's given
+type scopeSink struct {
+	mu      sync.Mutex
+	scope   pipe.Scope
+	regions []pipe.Region
+}
+
+func (s *scopeSink) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	s.mu.Lock()
+	s.scope = pipe.ScopeOf(ctx)
+	s.mu.Unlock()
+
+	for {
+		r, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+
+		s.mu.Lock()
+		s.regions = append(s.regions, r)
+		s.mu.Unlock()
+		pipe.Commit(ctx, r)
+	}
+	errs <- ctx.Err()
+}
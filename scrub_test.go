@@ -0,0 +1,65 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestScrubAndRepair(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 100)
+
+	src, err := os.Create(filepath.Join(dir, "src.bin"))
+	assert.NilError(t, err)
+	defer src.Close()
+	_, err = src.Write(data)
+	assert.NilError(t, err)
+	_, err = src.Seek(0, 0)
+	assert.NilError(t, err)
+
+	dst, err := os.Create(filepath.Join(dir, "dst.bin"))
+	assert.NilError(t, err)
+	defer dst.Close()
+
+	buff := pipeio.Limit(pipeio.NewBuffer(100, 4), 200)
+	manifest := &pipeio.Manifest{}
+	p := pipe.New(pipeio.Source(src, 0, buff), pipeio.Sink(dst, buff), pipeio.Record(manifest))
+	assert.NilError(t, p.Pipe(context.Background()))
+	assert.Equal(t, len(manifest.Entries), 10)
+
+	// round-trip the manifest through storage
+	var stored bytes.Buffer
+	_, err = manifest.WriteTo(&stored)
+	assert.NilError(t, err)
+	manifest, err = pipeio.ReadManifest(&stored)
+	assert.NilError(t, err)
+
+	// when: flip a couple of bits
+	_, err = dst.WriteAt([]byte("X"), 150)
+	assert.NilError(t, err)
+	_, err = dst.WriteAt([]byte("Y"), 999)
+	assert.NilError(t, err)
+
+	damaged, err := pipeio.Scrub(context.Background(), dst, manifest)
+	assert.NilError(t, err)
+
+	// then
+	assert.Equal(t, len(damaged), 2)
+	assert.Equal(t, damaged[0].Off, int64(100))
+	assert.Equal(t, damaged[1].Off, int64(900))
+
+	assert.NilError(t, pipeio.Repair(context.Background(), src, dst, damaged, buff))
+
+	damaged, err = pipeio.Scrub(context.Background(), dst, manifest)
+	assert.NilError(t, err)
+	assert.Equal(t, len(damaged), 0)
+}
@@ -0,0 +1,89 @@
+package pipe_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPool_SelectWith(t *testing.T) {
+	offsets := func(w *recordingWriter) []int64 {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		var offs []int64
+		for _, r := range w.writes {
+			offs = append(offs, r.Off)
+		}
+		return offs
+	}
+
+	tests := []struct {
+		name     string
+		selector pipeio.Selector
+		want     [][]int64
+	}{
+		{
+			name:     "round robin",
+			selector: pipeio.RoundRobin(),
+			want:     [][]int64{{0, 30, 60, 90}, {10, 40, 70}, {20, 50, 80}},
+		},
+		{
+			name:     "affinity",
+			selector: pipeio.Affinity(30),
+			want:     [][]int64{{0, 10, 20, 90}, {30, 40, 50}, {60, 70, 80}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			writers := []*recordingWriter{{}, {}, {}}
+			pool := pipeio.Pool(pipeio.NewBuffer(10, 1), writers[0], writers[1], writers[2]).SelectWith(test.selector)
+
+			// when
+			err := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, pool).Pipe(context.Background())
+
+			// then: every writer wrote its regions, in order
+			assert.NilError(t, err)
+			for i, w := range writers {
+				assert.DeepEqual(t, offsets(w), test.want[i])
+			}
+		})
+	}
+}
+
+func TestPool_LeastOutstanding(t *testing.T) {
+	// given: slow writers, so that no single one can keep up
+	var c concurrency
+	writers := make([]io.WriterAt, 3)
+	for i := range writers {
+		writers[i] = writerFunc(func(p []byte, off int64) (int, error) {
+			defer c.enter()()
+			time.Sleep(2 * time.Millisecond)
+			return len(p), nil
+		})
+	}
+	pool := pipeio.Pool(pipeio.NewBuffer(10, 1), writers...)
+
+	many := make([]pipe.Region, 30)
+	for i := range many {
+		many[i] = pipe.Region{Data: []byte("AAAAAAAAAA"), Off: int64(i * 10)}
+	}
+
+	// when
+	p := pipe.New(&source{regions: many}, pool)
+	err := p.Pipe(context.Background())
+
+	// then: idle writers were put to work rather than waited on
+	assert.NilError(t, err)
+	assert.Equal(t, c.peak(), int64(3))
+	assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 300}})
+}
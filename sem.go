@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+)
+
+// slots is a counting semaphore whose limit can be changed while it's in use
+type slots struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+	wake  chan struct{} // closed (and replaced) whenever a slot may have opened up
+}
+
+func newSlots(limit int) *slots {
+	return &slots{limit: limit, wake: make(chan struct{})}
+}
+
+func (s *slots) acquire(ctx context.Context) bool {
+	for {
+		s.mu.Lock()
+		if s.used < s.limit {
+			s.used++
+			s.mu.Unlock()
+			return true
+		}
+		wake := s.wake
+		s.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (s *slots) release() {
+	s.mu.Lock()
+	s.used--
+	s.broadcast()
+	s.mu.Unlock()
+}
+
+func (s *slots) resize(limit int) {
+	s.mu.Lock()
+	s.limit = limit
+	s.broadcast()
+	s.mu.Unlock()
+}
+
+func (s *slots) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limit
+}
+
+// broadcast must be called with the lock held
+func (s *slots) broadcast() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// budget is a semaphore on a number of bytes. An acquisition larger than the limit still
+// goes through once nothing else is held, otherwise it never could.
+type budget struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+	wake  chan struct{} // closed (and replaced) whenever bytes may have been released
+}
+
+func newBudget(limit int64) *budget {
+	return &budget{limit: limit, wake: make(chan struct{})}
+}
+
+func (b *budget) acquire(ctx context.Context, n int64) bool {
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return true
+		}
+		wake := b.wake
+		b.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// inUse returns the number of bytes acquired and not released yet
+func (b *budget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+func (b *budget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	close(b.wake)
+	b.wake = make(chan struct{})
+	b.mu.Unlock()
+}
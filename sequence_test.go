@@ -0,0 +1,48 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"testing/quick"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestSequence(t *testing.T) {
+	// a lone empty region doesn't write anything, wherever it is
+	empty := pipetest.Sequence{{Data: []byte{}, Off: 718}}
+	assert.Equal(t, len(empty.Bytes()), 0)
+
+	passthrough := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+
+	t.Run("valve", func(t *testing.T) {
+		property := func(seq pipetest.Sequence) bool {
+			sink := &pipetest.Sink{}
+			p := pipe.New(&pipetest.Source{Regions: seq}, sink, passthrough)
+			return p.Pipe(context.Background()) == nil && pipetest.SameRegions(seq, sink.Regions()) == nil
+		}
+		assert.NilError(t, quick.Check(property, nil))
+	})
+
+	t.Run("sink", func(t *testing.T) {
+		property := func(seq pipetest.Sequence) bool {
+			sink := pipeio.BytesSink()
+			p := pipe.New(&pipetest.Source{Regions: seq}, sink)
+			if err := p.Pipe(context.Background()); err != nil {
+				return false
+			}
+
+			if !seq.Overlaps() && !bytes.Equal(sink.Bytes(), seq.Bytes()) {
+				return false
+			}
+			return slices.Equal(p.Report().Written, seq.Ranges())
+		}
+		assert.NilError(t, quick.Check(property, nil))
+	})
+}
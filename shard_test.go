@@ -0,0 +1,118 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestSharded(t *testing.T) {
+	data := make([]byte, 100*KiB+7)
+	_, _ = rand.Read(data)
+
+	tests := []struct {
+		shardSize int64
+		n         int
+	}{
+		{shardSize: 10 * KiB, n: 1},
+		{shardSize: 10 * KiB, n: 4},
+		{shardSize: 7, n: 3},
+		{shardSize: MiB, n: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("shard=%d/n=%d", test.shardSize, test.n), func(t *testing.T) {
+			// given
+			buff := pipeio.NewBuffer(KiB, 8)
+			sink := pipeio.BytesSink().From(buff)
+			source := pipeio.Sharded(int64(len(data)), test.shardSize, test.n, func(off, n int64) pipe.Source {
+				return pipeio.Source(bytes.NewReader(data[off:off+n]), off, buff)
+			})
+
+			// when
+			err := pipe.New(source, sink).Pipe(context.Background())
+
+			// then
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(sink.Bytes(), data))
+		})
+	}
+}
+
+// slowShards returns a source of shards of 3 regions of 10 bytes, read slowly, calling
+// started with the offset of every shard as it starts
+func slowShards(c *concurrency, started func(off int64)) func(off, n int64) pipe.Source {
+	return func(off, n int64) pipe.Source {
+		return sourceFunc(func(ctx context.Context, sink chan pipe.Region, errs chan error) {
+			defer close(sink)
+			started(off)
+			defer c.enter()()
+
+			for j := int64(0); j < n; j += 10 {
+				time.Sleep(2 * time.Millisecond)
+				sink <- pipe.Region{Data: []byte("AAAAAAAAAA"), Off: off + j}
+			}
+		})
+	}
+}
+
+func TestSharded_SetConcurrency(t *testing.T) {
+	t.Run("up", func(t *testing.T) {
+		// given: a single reader
+		var c concurrency
+		var once sync.Once
+		var source interface {
+			pipe.Source
+			pipe.Scalable
+		}
+		source = pipeio.Sharded(300, 30, 1, slowShards(&c, func(int64) {
+			// when: more are called for as soon as it starts
+			once.Do(func() { source.SetConcurrency(3) })
+		}))
+
+		p := pipe.New(source, &pipetest.Sink{})
+		err := p.Pipe(context.Background())
+
+		// then: they were started right away
+		assert.NilError(t, err)
+		assert.Equal(t, c.peak(), int64(3))
+		assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 300}})
+	})
+
+	t.Run("down", func(t *testing.T) {
+		// given: three readers
+		var c, after concurrency
+		var source interface {
+			pipe.Source
+			pipe.Scalable
+		}
+		source = pipeio.Sharded(300, 30, 3, slowShards(&c, func(off int64) {
+			if off == 0 {
+				// when: one is called for as soon as they start
+				source.SetConcurrency(1)
+			}
+			if off >= 90 {
+				defer after.enter()()
+				time.Sleep(time.Millisecond)
+			}
+		}))
+
+		p := pipe.New(source, &pipetest.Sink{})
+		err := p.Pipe(context.Background())
+
+		// then: the shards they had were read, and the rest one at a time
+		assert.NilError(t, err)
+		assert.Equal(t, after.peak(), int64(1))
+		assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 300}})
+	})
+}
@@ -0,0 +1,208 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShutdown is returned by Pipe when the run was ended by Shutdown before the source
+// was done producing regions.
+var ErrShutdown = errors.New("pipe shut down")
+
+// ShutdownMode reports how a call to Shutdown ended the run.
+type ShutdownMode int
+
+const (
+	// ShutdownNone means there was nothing to shut down: the pipe wasn't running, or the
+	// run ended on its own before Shutdown could stop it.
+	ShutdownNone ShutdownMode = iota
+	// ShutdownDrained means every region in flight landed before the deadline.
+	ShutdownDrained
+	// ShutdownForced means the deadline passed and the run was canceled, possibly
+	// dropping regions that were still in flight.
+	ShutdownForced
+)
+
+func (m ShutdownMode) String() string {
+	switch m {
+	case ShutdownNone:
+		return "none"
+	case ShutdownDrained:
+		return "drained"
+	case ShutdownForced:
+		return "forced"
+	default:
+		return "unknown"
+	}
+}
+
+// Shutdown stops a running pipe in two phases: first the source is stopped (no new
+// regions enter the pipe) and the regions already in flight are given until ctx is done
+// to land at the sink; if they don't make it in time the run is canceled outright.
+//
+// Shutdown blocks until the run has ended and reports which of the two phases ended it.
+// If the run had to be forced, the context's error is returned too.
+func (p *Pipe) Shutdown(ctx context.Context) (ShutdownMode, error) {
+	p.mu.Lock()
+	r := p.run
+	p.mu.Unlock()
+
+	if r == nil {
+		return ShutdownNone, nil
+	}
+
+	r.stopOnce.Do(func() { close(r.stop) })
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		r.forced.Store(true)
+		r.cancel(fmt.Errorf("%w: drain deadline exceeded", ErrShutdown))
+		<-r.done
+
+		return ShutdownForced, ctx.Err()
+	}
+
+	if r.stopped.Load() {
+		return ShutdownDrained, nil
+	}
+	return ShutdownNone, nil
+}
+
+// WithDrainOnCancel has the pipe drain when the context it runs with is done, rather than
+// tear down at once and drop the regions in flight: the source is stopped, as by
+// Shutdown, and the valves and the sink are given up to timeout to land the regions they
+// hold before the run is canceled outright. Pipe then returns the context's error, wrapped
+// if the drain didn't make it in time; see Report for what landed. The components run
+// with a context that isn't done until then, carrying ctx's values but not its deadline.
+func WithDrainOnCancel(timeout time.Duration) Option {
+	return func(p *Pipe) {
+		p.drain = timeout
+	}
+}
+
+// drainOnCancel stops the source once ctx is done, and cancels the run if it hasn't
+// drained within timeout
+func (r *run) drainOnCancel(ctx context.Context, timeout time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-r.done:
+		return
+	}
+
+	r.canceled.Store(true)
+	r.stopOnce.Do(func() { close(r.stop) })
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		r.forced.Store(true)
+		r.cancel(fmt.Errorf("%w: drain deadline exceeded", context.Cause(ctx)))
+	case <-r.done:
+	}
+}
+
+// run holds the state of a single execution of a pipe
+type run struct {
+	cancel    context.CancelCauseFunc
+	tracker   *tracker
+	errs      chan error // where the stages place their results
+	chaos     *chaos
+	pauser    *pauser
+	share     *share   // shared with the other jobs of a Group, if any
+	charges   *charges // owed to the group's buffer budget, if any
+	scope     Scope
+	regions   atomic.Int64 // taken off the source
+	allowance *allowance   // counting the regions carried on past
+	stages    *stages
+	watch     *watch                // watching how long stages hold regions for, if at all
+	stats     atomic.Pointer[stats] // kept if the pipe keeps stats, and runs over channels
+
+	stop     chan struct{} // closed to ask the gate to stop the source
+	stopOnce sync.Once
+	stopped  atomic.Bool // the gate stopped the source before it was done
+	forced   atomic.Bool // Shutdown gave up on draining and canceled the run
+	canceled atomic.Bool // the run's context was done, and the run drained (see WithDrainOnCancel)
+
+	done chan struct{} // closed when the run has ended
+}
+
+func newRun(cancel context.CancelCauseFunc) *run {
+	return &run{
+		cancel:  cancel,
+		tracker: &tracker{classifier: DefaultClassifier},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// gate passes regions from the source onto the first connector until either the source
+// is done or the run is asked to stop, at which point it closes the connector (the same
+// way the source would have) so the rest of the pipe can drain.
+func (r *run) gate(ctx context.Context, in, out chan Region, stopSource context.CancelCauseFunc) {
+	defer close(out)
+
+	yield := yielder(ctx)
+	for r.hold(ctx) {
+		select {
+		case region, more := <-in:
+			if !more {
+				return
+			}
+
+			if !r.strike(ctx) || !r.admit(ctx, region) {
+				// dropped along with the rest of the source (see discard)
+				region.Release()
+				break
+			}
+
+			// the region was taken off the source, so it's in flight: it goes through
+			// unless the run is canceled outright
+			region = r.stamp(region)
+			yield(region)
+			select {
+			case out <- region:
+				continue
+			case <-ctx.Done():
+				region.Release()
+			}
+		case <-r.stop:
+		case <-ctx.Done():
+		}
+
+		break
+	}
+
+	r.halt(ctx, in, stopSource)
+}
+
+// halt stops the source early: let it know, and make sure it doesn't get stuck trying to
+// hand over a region no one's going to take. The run only counts as stopped if Shutdown
+// asked for it, rather than chaos or a cancellation.
+func (r *run) halt(ctx context.Context, in chan Region, stopSource context.CancelCauseFunc) {
+	var cause error
+	select {
+	case <-r.stop:
+		if ctx.Err() == nil {
+			r.stopped.Store(true)
+		}
+		if !r.canceled.Load() {
+			cause = ErrShutdown
+		}
+	default:
+	}
+	stopSource(cause)
+	go discard(in)
+}
+
+// discard drains in, releasing the regions dropped (see Lend)
+func discard(in chan Region) {
+	for r := range in {
+		r.Release()
+	}
+}
@@ -0,0 +1,191 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+)
+
+func TestPipe_Shutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		expected pipe.ShutdownMode
+	}{
+		{
+			name:     "drained",
+			delay:    0,
+			expected: pipe.ShutdownDrained,
+		},
+		{
+			name:     "forced",
+			delay:    time.Second,
+			expected: pipe.ShutdownForced,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			var read int
+			sinkFunc := func(r pipe.Region) error {
+				time.Sleep(test.delay)
+				read++
+				return nil
+			}
+			p := pipe.New(&endlessSource{}, &sink{f: sinkFunc})
+
+			errs := make(chan error)
+			go func() { errs <- p.Pipe(context.Background()) }()
+			time.Sleep(20 * time.Millisecond)
+
+			// when
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			mode, err := p.Shutdown(ctx)
+
+			// then
+			assert.Equal(t, mode, test.expected)
+			assert.Assert(t, errors.Is(<-errs, pipe.ErrShutdown))
+			if test.expected == pipe.ShutdownForced {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+			} else {
+				assert.NilError(t, err)
+				assert.Assert(t, read > 0)
+			}
+		})
+	}
+
+	for _, batch := range []int{1, 4} {
+		t.Run(fmt.Sprintf("drained/in-flight/batch=%d", batch), func(t *testing.T) {
+			// given: the gate holds a region while the sink is busy with another
+			var read atomic.Int64
+			sinkFunc := func(r pipe.Region) error {
+				time.Sleep(50 * time.Millisecond)
+				read.Add(1)
+				return nil
+			}
+			p := pipe.New(&stallingSource{regions: regions[:2]}, &sink{f: sinkFunc}).
+				With(pipe.WithBatches(batch))
+
+			errs := make(chan error)
+			go func() { errs <- p.Pipe(context.Background()) }()
+			time.Sleep(10 * time.Millisecond)
+
+			// when
+			mode, err := p.Shutdown(context.Background())
+
+			// then: the held region landed too
+			assert.NilError(t, err)
+			assert.Equal(t, mode, pipe.ShutdownDrained)
+			assert.Assert(t, errors.Is(<-errs, pipe.ErrShutdown))
+			assert.Equal(t, read.Load(), int64(2))
+		})
+	}
+
+	t.Run("not-running", func(t *testing.T) {
+		p := pipe.New(&source{regions: regions}, &sink{f: func(pipe.Region) error { return nil }})
+		assert.NilError(t, p.Pipe(context.Background()))
+
+		mode, err := p.Shutdown(context.Background())
+		assert.NilError(t, err)
+		assert.Equal(t, mode, pipe.ShutdownNone)
+	})
+}
+
+func TestPipe_WithDrainOnCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		batch   int
+		timeout time.Duration
+		drained bool
+	}{
+		{
+			name:    "drained",
+			batch:   1,
+			timeout: time.Second,
+			drained: true,
+		},
+		{
+			name:    "drained/batch=4",
+			batch:   4,
+			timeout: time.Second,
+			drained: true,
+		},
+		{
+			name:    "forced",
+			batch:   1,
+			timeout: 20 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given: the gate holds a region while the sink is busy with another
+			var read atomic.Int64
+			sinkFunc := func(r pipe.Region) error {
+				time.Sleep(50 * time.Millisecond)
+				read.Add(1)
+				return nil
+			}
+			p := pipe.New(&stallingSource{regions: regions[:2]}, &sink{f: sinkFunc}).
+				With(pipe.WithBatches(test.batch), pipe.WithDrainOnCancel(test.timeout))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error)
+			go func() { errs <- p.Pipe(ctx) }()
+			time.Sleep(10 * time.Millisecond)
+
+			// when
+			cancel()
+			err := <-errs
+
+			// then: the regions in flight landed, unless the drain ran out of time
+			assert.ErrorIs(t, err, context.Canceled)
+			if test.drained {
+				assert.Equal(t, err, context.Canceled)
+				assert.Equal(t, read.Load(), int64(2))
+			} else {
+				assert.ErrorContains(t, err, "drain deadline exceeded")
+				assert.Assert(t, read.Load() < 2)
+			}
+		})
+	}
+}
+
+// endlessSource produces regions until its context is done
+type endlessSource struct{}
+
+func (s *endlessSource) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	var off int64
+	for ctx.Err() == nil {
+		select {
+		case sink <- pipe.Region{Data: []byte("ZZZZZZZZZZ"), Off: off}:
+			off += 10
+		case <-ctx.Done():
+		}
+	}
+}
+
+// stallingSource produces its regions, then waits for its context to be done
+type stallingSource struct {
+	regions []pipe.Region
+}
+
+func (s *stallingSource) Write(ctx context.Context, sink chan pipe.Region, errs chan error) {
+	defer close(sink)
+
+	for _, r := range s.regions {
+		sink <- r
+	}
+	<-ctx.Done()
+}
@@ -0,0 +1,158 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StageModel is a model of how long a stage of a pipe takes over regions, for Simulate.
+type StageModel struct {
+	Name string
+	// Latency is what every region costs, whatever its size (a round trip, a seek...).
+	Latency time.Duration
+	// Bandwidth is how many bytes per second a worker of the stage gets through, on top
+	// of the latency (0 for no limit).
+	Bandwidth float64
+	// Concurrency is how many regions the stage works on at once (shard readers, pool
+	// writers...); the Concurrency of the Settings if 0.
+	Concurrency int
+}
+
+// MeasuredStage returns the model of a stage that ran as fast as the probe did, with the
+// concurrency of its settings, so what Calibrate measured can be extrapolated to other
+// sizes and settings.
+func MeasuredStage(name string, p Probe) StageModel {
+	n := max(p.Settings.Concurrency, 1)
+	return StageModel{Name: name, Bandwidth: p.Throughput() / float64(n), Concurrency: n}
+}
+
+// cost returns how long a worker of the stage takes over a region of n bytes
+func (m StageModel) cost(n int64) time.Duration {
+	d := m.Latency
+	if m.Bandwidth > 0 {
+		d += time.Duration(float64(n) / m.Bandwidth * float64(time.Second))
+	}
+	return d
+}
+
+// Simulation describes a transfer for Simulate to estimate.
+type Simulation struct {
+	// Size is how many bytes the source produces.
+	Size int64
+	// Settings are what the pipe runs with: regions of BufferSize bytes, no more than
+	// PoolSize of them in flight at once (as many as there are buffers to read them
+	// into), and stages working on Concurrency regions at once unless their model says
+	// otherwise.
+	Settings Settings
+	// Stages are the models of the stages of the pipe, in order: the source first, then
+	// the valves, the sink last.
+	Stages []StageModel
+}
+
+// Estimate is the outcome of Simulate.
+type Estimate struct {
+	Duration time.Duration
+	// PeakMemory is the most bytes of buffers in use at once.
+	PeakMemory int64
+	// Bottleneck is the name of the stage that's busiest, for the number of workers it
+	// has: the one to give more of them (or a faster device) to speed the transfer up.
+	Bottleneck string
+	// Throughput is the number of bytes transferred per second.
+	Throughput float64
+}
+
+// Simulate estimates how long a transfer takes, and how much memory it takes, without
+// doing any I/O: for capacity planning, or for scheduling transfers. It plays the
+// transfer out region by region the way a pipe runs it. A region is read once a buffer
+// is free for it, and handed from stage to stage as soon as a worker of the next stage is
+// free to take it; until then it holds up the worker it's with, as regions do in a pipe.
+// It's only as good as the models of the stages; models made from probes (see
+// MeasuredStage) make for better estimates than guesses.
+//
+// Simulating takes time in proportion to the number of regions (and the pool size), not
+// to the duration of the transfer.
+func Simulate(s Simulation) (Estimate, error) {
+	set := s.Settings
+	switch {
+	case s.Size < 0:
+		return Estimate{}, fmt.Errorf("invalid size %d", s.Size)
+	case set.BufferSize <= 0:
+		return Estimate{}, fmt.Errorf("invalid buffer size %d", set.BufferSize)
+	case set.PoolSize <= 0:
+		return Estimate{}, fmt.Errorf("invalid pool size %d", set.PoolSize)
+	case len(s.Stages) < 2:
+		return Estimate{}, errors.New("a source and a sink are needed at least")
+	}
+
+	type stage struct {
+		StageModel
+		free []time.Duration // when each worker is free again, in the order they're taken
+		next int             // worker taking the next region
+		last time.Duration   // when the previous region was taken
+		busy time.Duration
+	}
+	stages := make([]*stage, len(s.Stages))
+	for k, m := range s.Stages {
+		if m.Concurrency == 0 {
+			m.Concurrency = max(set.Concurrency, 1)
+		}
+		stages[k] = &stage{StageModel: m, free: make([]time.Duration, m.Concurrency)}
+	}
+
+	var (
+		est    Estimate
+		landed = make([]time.Duration, set.PoolSize) // when the regions holding the buffers landed
+		size   = int64(set.BufferSize)
+		start  = make([]time.Duration, len(stages))
+	)
+	for i := int64(0); i*size < s.Size; i++ {
+		n := min(size, s.Size-i*size)
+
+		// a buffer frees up once the region holding it lands, and they're taken in turn
+		buffer := int(i % int64(set.PoolSize))
+		ready := landed[buffer]
+
+		// regions are handed over in order, each once its own stage is done with it and a
+		// worker of the next one is free; until then, it holds up its worker
+		for k, st := range stages {
+			w := st.next
+			start[k] = max(ready, st.free[w], st.last)
+			st.last = start[k]
+			if k > 0 {
+				prev := stages[k-1]
+				prev.free[(prev.next+len(prev.free)-1)%len(prev.free)] = start[k]
+			}
+			cost := st.cost(n)
+			st.busy += cost
+			ready = start[k] + cost
+			st.free[w] = ready
+			st.next = (w + 1) % len(st.free)
+		}
+
+		// buffers in use as the region is read: its own, and those of the regions read
+		// before it that haven't landed yet
+		inUse := int64(1)
+		for j, t := range landed {
+			if j != buffer && t > start[0] {
+				inUse++
+			}
+		}
+		est.PeakMemory = max(est.PeakMemory, inUse*size)
+
+		landed[buffer] = ready
+		est.Duration = max(est.Duration, ready)
+	}
+
+	if est.Duration > 0 {
+		est.Throughput = float64(s.Size) / est.Duration.Seconds()
+	}
+
+	var busiest time.Duration
+	for _, st := range stages {
+		if busy := st.busy / time.Duration(len(st.free)); est.Bottleneck == "" || busy > busiest {
+			busiest, est.Bottleneck = busy, st.Name
+		}
+	}
+	return est, nil
+}
@@ -0,0 +1,131 @@
+package pipe_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestSimulate(t *testing.T) {
+	settings := pipe.Settings{BufferSize: MiB, PoolSize: 8, Concurrency: 1}
+	disk := pipe.StageModel{Name: "disk", Bandwidth: 100 * MiB}
+
+	tests := []struct {
+		name       string
+		settings   pipe.Settings
+		stages     []pipe.StageModel
+		duration   time.Duration
+		memory     int64
+		bottleneck string
+	}{
+		{
+			// the sink takes 100ms a region; the source waits on it with the next one, so
+			// there are never more than two buffers in use, however many there are
+			name:       "slow sink",
+			settings:   settings,
+			stages:     []pipe.StageModel{disk, {Name: "wan", Bandwidth: 10 * MiB}},
+			duration:   100*100*time.Millisecond + 10*time.Millisecond,
+			memory:     2 * MiB,
+			bottleneck: "wan",
+		},
+		{
+			// four regions written at once, once the source has read the first four
+			name:       "more writers",
+			settings:   settings,
+			stages:     []pipe.StageModel{disk, {Name: "wan", Bandwidth: 10 * MiB, Concurrency: 4}},
+			duration:   100*100*time.Millisecond/4 + 4*10*time.Millisecond,
+			memory:     5 * MiB,
+			bottleneck: "wan",
+		},
+		{
+			// every region is in a buffer, in the sink, before the next can be read
+			name:       "too few buffers",
+			settings:   pipe.Settings{BufferSize: MiB, PoolSize: 1},
+			stages:     []pipe.StageModel{disk, {Name: "wan", Latency: 90 * time.Millisecond, Concurrency: 4}},
+			duration:   100 * 100 * time.Millisecond,
+			memory:     MiB,
+			bottleneck: "wan",
+		},
+		{
+			// two regions at most: one being read, one being written
+			name:       "slow source",
+			settings:   settings,
+			stages:     []pipe.StageModel{{Name: "http", Latency: 50 * time.Millisecond}, {Name: "ssd", Bandwidth: 1000 * MiB}},
+			duration:   100*50*time.Millisecond + time.Millisecond,
+			memory:     2 * MiB,
+			bottleneck: "http",
+		},
+		{
+			// a region waiting on the valve holds up the source
+			name:       "slow valve",
+			settings:   settings,
+			stages:     []pipe.StageModel{disk, {Name: "encrypt", Bandwidth: 20 * MiB}, {Name: "ssd", Bandwidth: 1000 * MiB}},
+			duration:   10*time.Millisecond + 100*50*time.Millisecond + time.Millisecond,
+			memory:     3 * MiB,
+			bottleneck: "encrypt",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			est, err := pipe.Simulate(pipe.Simulation{Size: 100 * MiB, Settings: test.settings, Stages: test.stages})
+
+			// then
+			assert.NilError(t, err)
+			assert.Assert(t, est.Duration-test.duration < time.Millisecond && test.duration-est.Duration < time.Millisecond,
+				"%v rather than %v", est.Duration, test.duration)
+			assert.Equal(t, est.PeakMemory, test.memory)
+			assert.Equal(t, est.Bottleneck, test.bottleneck)
+			assert.Assert(t, est.Throughput > 0)
+		})
+	}
+}
+
+func TestSimulate_invalid(t *testing.T) {
+	stages := []pipe.StageModel{{Name: "source"}, {Name: "sink"}}
+
+	tests := []struct {
+		name string
+		sim  pipe.Simulation
+		want string
+	}{
+		{name: "size", sim: pipe.Simulation{Size: -1, Settings: pipe.Settings{BufferSize: 1, PoolSize: 1}, Stages: stages}, want: "invalid size -1"},
+		{name: "buffer size", sim: pipe.Simulation{Size: 1, Settings: pipe.Settings{PoolSize: 1}, Stages: stages}, want: "invalid buffer size 0"},
+		{name: "pool size", sim: pipe.Simulation{Size: 1, Settings: pipe.Settings{BufferSize: 1}, Stages: stages}, want: "invalid pool size 0"},
+		{name: "stages", sim: pipe.Simulation{Size: 1, Settings: pipe.Settings{BufferSize: 1, PoolSize: 1}, Stages: stages[:1]}, want: "a source and a sink are needed at least"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := pipe.Simulate(test.sim)
+			assert.ErrorContains(t, err, test.want)
+		})
+	}
+}
+
+func TestSimulate_pipe(t *testing.T) {
+	// given: a pipe whose sink takes 5ms a region
+	regions := pipetest.Regions(40, KiB)
+	sink := &pipetest.Sink{Delay: 5 * time.Millisecond}
+
+	// when
+	est, err := pipe.Simulate(pipe.Simulation{
+		Size:     40 * KiB,
+		Settings: pipe.Settings{BufferSize: KiB, PoolSize: 4, Concurrency: 1},
+		Stages:   []pipe.StageModel{{Name: "source"}, {Name: "sink", Latency: 5 * time.Millisecond}},
+	})
+	assert.NilError(t, err)
+	start := time.Now()
+	assert.NilError(t, pipe.New(&pipetest.Source{Regions: regions}, sink).Pipe(context.Background()))
+
+	// then: the estimate is what the pipe takes
+	assert.Equal(t, est.Duration, 200*time.Millisecond)
+	took := time.Since(start)
+	assert.Assert(t, took >= est.Duration && took < 2*est.Duration, "took %v, estimated %v", took, est.Duration)
+}
@@ -2,45 +2,254 @@ package pipe
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"sync"
 )
 
-func Fan(sources ...Source) *fan {
-	return &fan{sources: sources}
+// Fan combines sources into a single Source. By default every source is read from at
+// once; the number of sources being read concurrently can be limited with Limit, or by
+// way of Scalable, which Fan implements (the rest are started as earlier ones complete).
+func Fan(sources ...Source) *fan[Region] {
+	return FanOf[Region](sources...)
 }
 
-type fan struct {
-	sources []Source
+// FanOf is Fan, for sources of values of any type (see PipeOf).
+func FanOf[T any](sources ...SourceOf[T]) *fan[T] {
+	return &fan[T]{sources: sources, slots: newSlots(len(sources))}
 }
 
-func (s *fan) Write(ctx context.Context, sink chan Region, errs chan error) {
+type fan[T any] struct {
+	sources []SourceOf[T]
+	slots   *slots
+	weights []int // of the sources, if the fan is fair
+
+	isolated bool
+	mu       sync.Mutex
+	failed   []error // by each source, if the fan is isolated
+}
+
+func (s *fan[T]) Write(ctx context.Context, sink chan T, errs chan error) {
+	if s.isolated {
+		s.mu.Lock()
+		s.failed = make([]error, len(s.sources))
+		s.mu.Unlock()
+	}
+
 	// fan out : each source writes to its own separate sink
-	sinks := make([]chan Region, len(s.sources))
+	sinks := make([]chan T, len(s.sources))
 	for i := range s.sources {
-		sinks[i] = make(chan Region)
-		go s.sources[i].Write(ctx, sinks[i], errs)
+		sinks[i] = make(chan T)
+	}
+
+	// fan in : items on the source-specific sinks are written to the final sink, or to the
+	// lanes the fan takes turns reading from if it's fair
+	outs := make([]chan T, len(s.sources))
+	merged := make(chan struct{})
+	if s.weights != nil {
+		for i := range outs {
+			outs[i] = make(chan T, s.weight(i))
+		}
+		go func() {
+			defer close(merged)
+			s.merge(ctx, outs, sink)
+		}()
+	} else {
+		for i := range outs {
+			outs[i] = sink
+		}
+		close(merged)
 	}
 
-	// fan in : items on the source-specific sinks are written to the final sink
 	var waiter sync.WaitGroup
 	defer close(sink)
 	for i := range sinks {
 		waiter.Add(1)
 		go func() {
-			s.pass(ctx, sinks[i], sink)
-			waiter.Done()
+			defer waiter.Done()
+			if s.weights != nil {
+				defer close(outs[i])
+			}
+
+			// sources only start once there's a slot for them
+			if !s.slots.acquire(ctx) {
+				return
+			}
+			defer s.slots.release()
+
+			if s.isolated {
+				go s.isolate(ctx, i, sinks[i])
+			} else {
+				go s.sources[i].Write(ctx, sinks[i], errs)
+			}
+			s.pass(ctx, sinks[i], outs[i])
 		}()
 	}
 
 	waiter.Wait()
+	<-merged
+}
+
+// Limit has the fan read from up to n of its sources at once (see SetConcurrency), so
+// that reading hundreds of shards doesn't overwhelm the disk they're on:
+//
+//	source := pipe.Fan(shards...).Limit(8)
+func (s *fan[T]) Limit(n int) *fan[T] {
+	s.SetConcurrency(n)
+	return s
+}
+
+// Fair has the fan take turns reading from its sources, rather than pass on whatever
+// comes first, so that fast sources don't starve slow ones of the pipe's capacity: every
+// source with regions to pass on gets to pass on as many in a turn as its weight (1 for
+// sources weights doesn't go as far as), and with no weights every source gets the same
+// share. A source that has nothing to pass on loses its turn, so the fan doesn't wait on
+// slow sources either.
+//
+// Up to its weight of regions are held for every source while it waits for its turn.
+func (s *fan[T]) Fair(weights ...int) *fan[T] {
+	s.weights = append([]int{}, weights...)
+	return s
+}
+
+// weight returns the weight of the i-th source
+func (s *fan[T]) weight(i int) int {
+	if i < len(s.weights) && s.weights[i] > 0 {
+		return s.weights[i]
+	}
+	return 1
+}
+
+// merge takes turns passing on items from lanes, each taking as many items as its weight
+// in a turn, until every lane is closed
+func (s *fan[T]) merge(ctx context.Context, lanes []chan T, out chan T) {
+	live := len(lanes)
+	send := func(v T) bool {
+		select {
+		case out <- v:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for live > 0 {
+		passed := false
+		for i, lane := range lanes {
+		turn:
+			for n := 0; lane != nil && n < s.weight(i); n++ {
+				select {
+				case v, more := <-lane:
+					if !more {
+						lanes[i] = nil
+						live--
+						break turn
+					}
+					if !send(v) {
+						return
+					}
+					passed = true
+				default:
+					break turn
+				}
+			}
+		}
+		if passed || live == 0 {
+			continue
+		}
+
+		// nothing to pass on: wait for any lane to have something
+		cases := make([]reflect.SelectCase, 0, len(lanes)+1)
+		idx := make([]int, 0, len(lanes))
+		for i, lane := range lanes {
+			if lane != nil {
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(lane)})
+				idx = append(idx, i)
+			}
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+		chosen, v, more := reflect.Select(cases)
+		if chosen == len(idx) {
+			return
+		}
+		if !more {
+			lanes[idx[chosen]] = nil
+			live--
+			continue
+		}
+		item, _ := v.Interface().(T) // (a nil interface, if T is one)
+		if !send(item) {
+			return
+		}
+	}
+}
+
+// Isolate has a source failing not fail the pipe, so that the other sources carry on: the
+// regions the source passed on before it failed go through, and what it failed with is
+// the fan's to report once the run is over (see Err). It's meant for merging independent
+// inputs, where one of them failing doesn't make the rest any less worth having.
+func (s *fan[T]) Isolate() *fan[T] {
+	s.isolated = true
+	return s
 }
 
-func (b *fan) pass(ctx context.Context, in, out chan Region) {
+// Err returns what the sources of an isolated fan failed with during its last run (see
+// Isolate), joined, or nil if none of them did.
+func (s *fan[T]) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []error
+	for i, err := range s.failed {
+		if err != nil {
+			all = append(all, fmt.Errorf("fan: source %d: %w", i, err))
+		}
+	}
+	return errors.Join(all...)
+}
+
+// isolate has the i-th source write to sink, keeping what it fails with to itself
+func (s *fan[T]) isolate(ctx context.Context, i int, sink chan T) {
+	errs := make(chan error, 1)
+	s.sources[i].Write(ctx, sink, errs)
+
+	select {
+	case err := <-errs:
+		if err != nil && !errors.Is(err, context.Canceled) {
+			s.mu.Lock()
+			s.failed[i] = err
+			s.mu.Unlock()
+		}
+	default:
+	}
+}
+
+// Concurrency implements Scalable.
+func (s *fan[T]) Concurrency() int {
+	return s.slots.size()
+}
+
+// SetConcurrency implements Scalable. Lowering the limit doesn't interrupt sources that
+// are already running, it only holds back the ones that haven't started yet.
+func (s *fan[T]) SetConcurrency(n int) {
+	s.slots.resize(min(max(n, 1), len(s.sources)))
+}
+
+func (b *fan[T]) pass(ctx context.Context, in, out chan T) {
+	yield := yielder(ctx)
 	for {
-		curr, more := <-in
-		if !more || ctx.Err() != nil {
+		curr, more := Next(ctx, in)
+		if !more {
+			return
+		}
+		if r, ok := any(curr).(Region); ok {
+			yield(r)
+		}
+		select {
+		case out <- curr:
+		case <-ctx.Done():
 			return
 		}
-		out <- curr
 	}
 }
@@ -0,0 +1,81 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestSkipZeros(t *testing.T) {
+	// given: a disk image that's mostly zeros, ending on a run of them
+	want := make([]byte, 10*KiB)
+	copy(want[KiB:], "boot sector")
+	copy(want[5*KiB+3:], "data")
+	path := filepath.Join(t.TempDir(), "image")
+	f, err := pipeio.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644, pipeio.Sparse())
+	assert.NilError(t, err)
+	defer f.Close()
+
+	buff := pipeio.NewBuffer(KiB, 4)
+	written := &fileCounter{f: f}
+	sink := pipeio.Sink(written, buff, pipeio.TruncateTo(int64(len(want))))
+	p := pipe.New(pipeio.Source(bytes.NewReader(want), 0, buff), sink, pipeio.SkipZeros(buff))
+
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: only the regions with data were written, the rest reported written all the same
+	assert.Equal(t, written.n, 2*KiB)
+	assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: int64(len(want))}})
+	assert.NilError(t, f.Close())
+	got, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, want))
+}
+
+func TestTruncateTo_unsupported(t *testing.T) {
+	// given: a destination that can't be truncated
+	buff := pipeio.NewBuffer(KiB, 4)
+	sink := pipeio.Sink(&writeCounter{}, buff, pipeio.TruncateTo(KiB))
+
+	// when
+	err := pipe.New(pipeio.Source(bytes.NewReader(make([]byte, KiB)), 0, buff), sink).Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, "can't truncate *pipe_test.writeCounter")
+}
+
+// writeCounter counts the bytes written through it
+type writeCounter struct {
+	n int
+}
+
+func (c *writeCounter) WriteAt(p []byte, off int64) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
+
+// fileCounter counts the bytes written to a file through it
+type fileCounter struct {
+	writeCounter
+	f interface {
+		WriteAt(p []byte, off int64) (int, error)
+		Truncate(size int64) error
+	}
+}
+
+func (c *fileCounter) WriteAt(p []byte, off int64) (int, error) {
+	c.n += len(p)
+	return c.f.WriteAt(p, off)
+}
+
+func (c *fileCounter) Truncate(size int64) error {
+	return c.f.Truncate(size)
+}
@@ -0,0 +1,121 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+)
+
+func TestSplice_FileToSocket(t *testing.T) {
+	// given
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*KiB)
+	path := filepath.Join(t.TempDir(), "src.bin")
+	assert.NilError(t, os.WriteFile(path, data, 0o644))
+
+	src, err := os.Open(path)
+	assert.NilError(t, err)
+	defer src.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+
+	received := make(chan []byte)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+
+		b, _ := io.ReadAll(conn)
+		received <- b
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NilError(t, err)
+
+	// when
+	n, err := pipeio.Splice(context.Background(), conn, src)
+	assert.NilError(t, conn.Close())
+
+	// then
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(len(data)))
+	assert.Assert(t, bytes.Equal(<-received, data))
+}
+
+func TestSplice_Append(t *testing.T) {
+	// given: a destination splice can't write to
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4*KiB)
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "src.bin"), data, 0o644))
+
+	src, err := os.Open(filepath.Join(dir, "src.bin"))
+	assert.NilError(t, err)
+	defer src.Close()
+	dst, err := os.OpenFile(filepath.Join(dir, "dst.bin"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NilError(t, err)
+	defer dst.Close()
+
+	// when
+	n, err := pipeio.Splice(context.Background(), dst, src)
+
+	// then: it went the long way around, without losing what splice had read
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(len(data)))
+	got, err := os.ReadFile(filepath.Join(dir, "dst.bin"))
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, data))
+}
+
+func TestPipe_WithShortcut(t *testing.T) {
+	for _, shortcut := range []bool{false, true} {
+		t.Run(fmt.Sprintf("shortcut=%t", shortcut), func(t *testing.T) {
+			// given
+			data := bytes.Repeat([]byte("0123456789abcdef"), 4*KiB)
+			dir := t.TempDir()
+			assert.NilError(t, os.WriteFile(filepath.Join(dir, "src.bin"), data, 0o644))
+
+			src, err := os.Open(filepath.Join(dir, "src.bin"))
+			assert.NilError(t, err)
+			defer src.Close()
+			dst, err := os.Create(filepath.Join(dir, "dst.bin"))
+			assert.NilError(t, err)
+			defer dst.Close()
+
+			buff := &countingBuffer{Buffer: pipeio.NewBuffer(4*KiB, 10)}
+			p := pipe.New(pipeio.Source(src, 0, buff), pipeio.Sink(dst, buff))
+			if shortcut {
+				p = p.With(pipe.WithShortcut())
+			}
+
+			// when
+			assert.NilError(t, p.Pipe(context.Background()))
+
+			// then: regions only go through the pipe without the shortcut (or where
+			// the platform can't splice)
+			got, err := os.ReadFile(filepath.Join(dir, "dst.bin"))
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(got, data))
+			assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: int64(len(data))}})
+			if !shortcut || runtime.GOOS != "linux" {
+				assert.Assert(t, buff.gets > 0)
+			} else {
+				assert.Equal(t, buff.gets, 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package pipe
+
+import "context"
+
+// Split returns a Valve that splits regions larger than size bytes into regions of size
+// bytes (but for the last one, which gets what's left), at the offsets their data was at,
+// for sinks with a limit on how much they can write at once (parts of a multipart upload,
+// messages of an RPC). Regions of size bytes or less go through as they are.
+//
+// The pieces are slices of the region's data, each clipped to its own length: a pool the
+// sink hands them back to doesn't take them for buffers of its own (see io.NewBuffer), so
+// a split region's buffer is left to the garbage collector rather than handed out again
+// before all of it is written. The pieces of a lent region (see Lend) each hold a
+// reference to its buffer instead, so it's handed back once all of them are released.
+func Split(size int) Valve {
+	return &split{size: size}
+}
+
+type split struct {
+	size int
+}
+
+func (s *split) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+
+		yield := yielder(ctx)
+		for {
+			r, more := Next(ctx, source)
+			if !more {
+				return
+			}
+
+			pieces := []Region{r}
+			if s.size > 0 && len(r.Data) > s.size {
+				pieces = make([]Region, 0, (len(r.Data)+s.size-1)/s.size)
+				for start := 0; start < len(r.Data); start += s.size {
+					end := min(start+s.size, len(r.Data))
+					pieces = append(pieces, Region{Data: r.Data[start:end:end], Off: r.Off + int64(start), Scope: r.Scope, Meta: r.Meta})
+				}
+			}
+
+			for range pieces[1:] {
+				r.Retain()
+			}
+			for i, piece := range pieces {
+				yield(piece)
+				select {
+				case sink <- piece:
+				case <-ctx.Done():
+					for _, piece := range pieces[i:] {
+						piece.Release()
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	return source
+}
@@ -0,0 +1,62 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestSplit(t *testing.T) {
+	// given: regions of 10, 25 and 30 bytes
+	regions := []pipe.Region{
+		{Off: 0, Data: []byte("AAAAAAAAAA")},
+		{Off: 10, Data: []byte("BBBBBBBBBBCCCCCCCCCCDDDDD")},
+		{Off: 35, Data: []byte("EEEEEEEEEEFFFFFFFFFFGGGGGGGGGG")},
+	}
+	sink := &pipetest.Sink{}
+
+	// when
+	p := pipe.New(&pipetest.Source{Regions: regions}, sink, pipe.Split(10))
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: none of more than 10 bytes, each at the offset its data was at
+	var got []pipe.Region
+	for _, r := range sink.Regions() {
+		got = append(got, pipe.Region{Off: r.Off, Data: r.Data})
+	}
+	want := []pipe.Region{
+		{Off: 0, Data: []byte("AAAAAAAAAA")},
+		{Off: 10, Data: []byte("BBBBBBBBBB")},
+		{Off: 20, Data: []byte("CCCCCCCCCC")},
+		{Off: 30, Data: []byte("DDDDD")},
+		{Off: 35, Data: []byte("EEEEEEEEEE")},
+		{Off: 45, Data: []byte("FFFFFFFFFF")},
+		{Off: 55, Data: []byte("GGGGGGGGGG")},
+	}
+	assert.DeepEqual(t, got, want)
+}
+
+func TestSplit_lent(t *testing.T) {
+	// given: a lent region, whose lender scribbles over its buffer once it's handed back
+	data := bytes.Repeat([]byte{1}, 8192)
+	scribble := func(data []byte) {
+		for i := range data {
+			data[i] = 0xff
+		}
+	}
+	regions := []pipe.Region{pipe.Lend(pipe.Region{Data: bytes.Clone(data)}, scribble)}
+	sink := pipeio.BytesSink()
+
+	// when
+	p := pipe.New(&pipetest.Source{Regions: regions}, sink, pipe.Split(4096))
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then: the buffer wasn't handed back before every piece was written
+	assert.Assert(t, bytes.Equal(sink.Bytes(), data))
+}
@@ -0,0 +1,125 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+// StageError is the error of a stage of a pipe, as the pipe fails with it: which of the
+// stages failed is found with errors.As.
+type StageError struct {
+	// Stage is the name of the stage: "source", "valve N" or "sink" (valves are numbered
+	// from 0, once fused: see WithFusion).
+	Stage string
+	// Index is the position of the stage in the pipe, from 0 for the source to one past
+	// the last valve for the sink.
+	Index int
+	// Type is the type of the component, e.g. "*io.pool".
+	Type string
+	Err  error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s (%s): %v", e.Stage, e.Type, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// stages are the stages of a run, reporting their results to the pipe through them
+type stages struct {
+	names  []string
+	types  []string
+	errs   []chan error // where each stage reports its result, to be passed on in order
+	errors []atomic.Int64
+	// drained is closed once the sink is done, and its result passed on
+	drained chan struct{}
+}
+
+// errSunk is put after the result of the sink once it's done
+var errSunk = errors.New("sink done")
+
+// stages returns the stages of the pipe, with valves the valves it runs
+func (p *Pipe) stages(valves []Valve) *stages {
+	n := len(valves) + 2
+	s := &stages{
+		names:   make([]string, n),
+		types:   make([]string, n),
+		errs:    make([]chan error, n),
+		errors:  make([]atomic.Int64, n),
+		drained: make(chan struct{}),
+	}
+	s.names[0], s.types[0] = "source", fmt.Sprintf("%T", p.source)
+	for i, v := range valves {
+		s.names[i+1], s.types[i+1] = fmt.Sprintf("valve %d", i), fmt.Sprintf("%T", v)
+	}
+	s.names[n-1], s.types[n-1] = "sink", fmt.Sprintf("%T", p.sink)
+	for i := range s.errs {
+		// every stage reports its result once, at most, and mustn't get stuck doing so
+		s.errs[i] = make(chan error, 1)
+	}
+	return s
+}
+
+// reports returns the channel the i-th stage reports its result on
+func (s *stages) reports(i int) chan error {
+	return s.errs[i]
+}
+
+// sunk lets the stages know the sink is done (unless stop is closed first)
+func (s *stages) sunk(stop chan struct{}) {
+	select {
+	case s.errs[len(s.errs)-1] <- errSunk:
+	case <-stop:
+	}
+}
+
+// fail counts err against the i-th stage, and returns it labeled with the stage
+func (s *stages) fail(i int, err error) error {
+	s.errors[i].Add(1)
+	return &StageError{Stage: s.names[i], Index: i, Type: s.types[i], Err: err}
+}
+
+// forward passes the results of the stages on to done until stop is closed, errors
+// labeled with the stage they come from. Results are passed on in the order they're
+// reported, as they would be if the stages reported them on done themselves: a valve
+// reports its failure before letting the stages after it know the stream is over, which
+// mustn't let their results get to done first.
+func (s *stages) forward(done chan error, stop chan struct{}) {
+	cases := make([]reflect.SelectCase, len(s.errs)+1)
+	for i, c := range s.errs {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)}
+	}
+	cases[len(s.errs)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stop)}
+
+	pass := func(i int, err error) {
+		if err == errSunk {
+			close(s.drained)
+			return
+		}
+		if err != nil {
+			err = s.fail(i, err)
+		}
+		done <- err
+	}
+	for {
+		chosen, v, _ := reflect.Select(cases)
+		if chosen == len(s.errs) {
+			return
+		}
+
+		// results reported upstream at the same time (before the ones they led to) go first
+		for i := range chosen {
+			select {
+			case err := <-s.errs[i]:
+				pass(i, err)
+			default:
+			}
+		}
+		err, _ := v.Interface().(error)
+		pass(chosen, err)
+	}
+}
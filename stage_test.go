@@ -0,0 +1,73 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestStageError(t *testing.T) {
+	boom := errors.New("boom")
+	passthrough := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+	fail := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		return r, boom
+	})
+
+	tests := map[string]struct {
+		source pipe.Source
+		sink   pipe.Sink
+		valves []pipe.Valve
+		stage  string
+		index  int
+		typ    string
+	}{
+		"source": {
+			source: &pipetest.Source{Regions: pipetest.Regions(4, 10), Err: boom},
+			sink:   &pipetest.Sink{},
+			valves: []pipe.Valve{passthrough, passthrough},
+			stage:  "source",
+			index:  0,
+			typ:    "*pipetest.Source",
+		},
+		"valve": {
+			source: &pipetest.Source{Regions: pipetest.Regions(4, 10)},
+			sink:   &pipetest.Sink{},
+			valves: []pipe.Valve{passthrough, passthrough, fail, passthrough, passthrough},
+			stage:  "valve 2",
+			index:  3,
+			typ:    "pipe.Func",
+		},
+		"sink": {
+			source: &pipetest.Source{Regions: pipetest.Regions(4, 10)},
+			sink:   &pipetest.Sink{Check: func(pipe.Region) error { return boom }},
+			valves: []pipe.Valve{passthrough},
+			stage:  "sink",
+			index:  2,
+			typ:    "*pipetest.Sink",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			p := pipe.New(test.source, test.sink, test.valves...)
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then: the error says which stage it comes from
+			assert.ErrorIs(t, err, boom)
+			var stageErr *pipe.StageError
+			assert.Assert(t, errors.As(err, &stageErr))
+			assert.Equal(t, stageErr.Stage, test.stage)
+			assert.Equal(t, stageErr.Index, test.index)
+			assert.Equal(t, stageErr.Type, test.typ)
+			assert.Error(t, err, test.stage+" ("+test.typ+"): boom")
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStalled is what pipes fail with when no region has moved through them for too long
+// (see WithStallTimeout), by way of a StallError.
+var ErrStalled = errors.New("pipe stalled")
+
+// StallError is the error of a pipe that stalled, with a snapshot of where it was at.
+type StallError struct {
+	// Stage is the name of the stage the pipe stalled at, as in StageError: the last one
+	// along the pipe to have taken a region in without handing it on since (or reporting
+	// it written or failed, for the sink), or the source if none has. Index is its
+	// position in the pipe.
+	Stage string
+	Index int
+	// Type is the type of the component, e.g. "*io.pool".
+	Type string
+	// Off is the offset of the region the stage took in, or of the last region the source
+	// handed on.
+	Off int64
+	// Idle is how long the pipe went without a region moving.
+	Idle time.Duration
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("%v: no region moved in %v, %s (%s) last active at offset %d", ErrStalled, e.Idle, e.Stage, e.Type, e.Off)
+}
+
+func (e *StallError) Unwrap() error {
+	return ErrStalled
+}
+
+// WithStallTimeout has the pipe fail with a StallError if no region moves through it for
+// d: none taken off the source, handed from stage to stage, or reported written or
+// failed by the sink. This turns a pipe silently hung somewhere into one failing with
+// where it was at, which WithRegionTimeout can't do for stages that hang without holding
+// a region (a source stuck reading, say). A paused pipe isn't stalled, and its clock
+// starts over once it's resumed.
+//
+// The pipe fails without waiting for its stages to be done, as they may never be. Like
+// WithRegionTimeout, regions are watched by way of goroutines of the pipe's, and pipes
+// running batches, rings or their shortcut aren't watched.
+func WithStallTimeout(d time.Duration) Option {
+	return func(p *Pipe) {
+		p.stallTimeout = d
+	}
+}
+
+// stalled returns a StallError if no region has moved for longer than the stall timeout
+// as of now, restarting the clock instead if the pipe's paused
+func (w *watch) stalled(now time.Time, sg *stages, paused bool) error {
+	if w.stall == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if paused {
+		w.moved = now
+		return nil
+	}
+	idle := now.Sub(w.moved)
+	if idle <= w.stall {
+		return nil
+	}
+	i, off := 0, w.outs[0].off
+	for j := w.stage; j > 0; j-- {
+		if in := w.ins[j]; !in.at.IsZero() && in.at.After(w.outs[j].at) {
+			i, off = j, in.off
+			break
+		}
+	}
+	return &StallError{Stage: sg.names[i], Index: i, Type: sg.types[i], Off: off, Idle: idle.Truncate(time.Millisecond)}
+}
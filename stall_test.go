@@ -0,0 +1,97 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithStallTimeout(t *testing.T) {
+	// hangAt returns a check hanging on the region at off until the test is over
+	hangAt := func(t *testing.T, off int64) func(pipe.Region) error {
+		hung := make(chan struct{})
+		t.Cleanup(func() { close(hung) })
+		return func(r pipe.Region) error {
+			if r.Off == off {
+				<-hung
+			}
+			return nil
+		}
+	}
+
+	tests := map[string]struct {
+		valve func(t *testing.T) func(pipe.Region) error
+		sink  func(t *testing.T) func(pipe.Region) error
+		delay time.Duration
+		stage string
+		off   int64
+	}{
+		"hung sink": {
+			sink:  func(t *testing.T) func(pipe.Region) error { return hangAt(t, 30) },
+			stage: "sink",
+			off:   30,
+		},
+		"hung valve": {
+			valve: func(t *testing.T) func(pipe.Region) error { return hangAt(t, 20) },
+			stage: "valve 0",
+			off:   20,
+		},
+		"slow": {
+			delay: 5 * time.Millisecond,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			check := func(pipe.Region) error { return nil }
+			if test.valve != nil {
+				check = test.valve(t)
+			}
+			valve := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, check(r) })
+			sink := &pipetest.Sink{Delay: test.delay}
+			if test.sink != nil {
+				sink.Check = test.sink(t)
+			}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10), Delay: time.Millisecond}, sink, valve).
+				With(pipe.WithStallTimeout(50 * time.Millisecond))
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			if test.stage == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, pipe.ErrStalled)
+			var stallErr *pipe.StallError
+			assert.Assert(t, errors.As(err, &stallErr))
+			assert.Equal(t, stallErr.Stage, test.stage)
+			assert.Equal(t, stallErr.Off, test.off)
+			assert.Assert(t, stallErr.Idle > 50*time.Millisecond)
+		})
+	}
+}
+
+func TestPipe_WithStallTimeout_paused(t *testing.T) {
+	// given: a pipe paused for longer than its stall timeout
+	sink := &pipetest.Sink{}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink).
+		With(pipe.WithStallTimeout(20 * time.Millisecond))
+	p.Pause()
+	time.AfterFunc(100*time.Millisecond, p.Resume)
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: it didn't stall
+	assert.NilError(t, err)
+	assert.Equal(t, len(sink.Regions()), 10)
+}
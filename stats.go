@@ -0,0 +1,215 @@
+package pipe
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// StageStats is what went through a stage of a pipe during a run (see Pipe.Stats).
+type StageStats struct {
+	// Name is the name of the stage: "source", "valve N" or "sink", as with WithProfile.
+	Name string
+	// RegionsIn and BytesIn are what the stage took in, RegionsOut and BytesOut what it
+	// handed on (the source takes nothing in, the sink hands nothing on).
+	RegionsIn, RegionsOut int64
+	BytesIn, BytesOut     int64
+	// Receiving is how long the stage was kept waiting for regions to come in, and Sending
+	// how long it was kept waiting for the next stage to take the regions it handed on:
+	// a stage that's quick to receive and slow to send is held up by the stages after
+	// it, while the bottleneck is the stage the others are waiting on.
+	Receiving, Sending time.Duration
+	// Errors is the number of errors the stage reported.
+	Errors int64
+}
+
+// WithStats has the pipe keep count of what goes through each of its stages (see Stats).
+// Every region is then handed from stage to stage by way of a goroutine of the pipe's,
+// which costs a little more per region and lets every stage get one region further
+// ahead of the next. Pipes running batches, rings or their shortcut don't keep stats.
+func WithStats() Option {
+	return func(p *Pipe) {
+		p.stats = true
+	}
+}
+
+// Stats returns what went through each stage of the pipe during the most recent (or
+// current) run, in order from the source to the sink, or nil if the pipe doesn't keep
+// stats (see WithStats). Pipes return as soon as one of their stages fails, so the stats
+// of a failed run may be missing the last few regions the others handed over.
+func (p *Pipe) Stats() []StageStats {
+	p.mu.Lock()
+	r := p.run
+	p.mu.Unlock()
+
+	if r == nil {
+		return nil
+	}
+	if s := r.stats.Load(); s != nil {
+		return s.snapshot()
+	}
+	return nil
+}
+
+// ConnectorStats is what went on at a connector of a pipe, between two of its stages,
+// during a run (see Pipe.Connectors).
+type ConnectorStats struct {
+	// From and To are the names of the stages on either end, as in StageStats.
+	From, To string
+	// Sending is how long From was kept waiting for To to take the regions it handed on,
+	// and Receiving how long To was kept waiting for From to hand them on: the side kept
+	// waiting is the one that could do with less concurrency, the other one with more.
+	Sending, Receiving time.Duration
+	// Queued is how many regions are waiting in the connector, of Capacity, if it's
+	// buffered (see WithChannelCapacity), and PeakQueued the most there were at once, as
+	// sampled whenever a region goes through.
+	Queued, Capacity, PeakQueued int
+}
+
+// Connectors returns what went on at each of the pipe's connectors during the most recent
+// (or current) run, in order from the source to the sink, or nil if the pipe doesn't
+// keep stats (see WithStats).
+func (p *Pipe) Connectors() []ConnectorStats {
+	p.mu.Lock()
+	r := p.run
+	p.mu.Unlock()
+
+	if r == nil {
+		return nil
+	}
+	if s := r.stats.Load(); s != nil {
+		return s.connectors()
+	}
+	return nil
+}
+
+// stats are kept for each edge between two stages (and for each stage, by the stages)
+type stats struct {
+	stages *stages
+	edges  []edge // the k-th between stage k and stage k+1
+}
+
+// edge is what went from one stage to the next
+type edge struct {
+	regions, bytes  atomic.Int64
+	receiving, sent atomic.Int64                // nanoseconds spent waiting to receive, to send
+	queue           atomic.Pointer[chan Region] // the buffered channel of the edge, if any
+	peak            atomic.Int64                // regions queued at once, at most
+}
+
+// queued counts the regions waiting in the edge's channel towards its peak
+func (e *edge) queued() {
+	q := e.queue.Load()
+	if q == nil {
+		return
+	}
+	n := int64(len(*q))
+	for peak := e.peak.Load(); n > peak && !e.peak.CompareAndSwap(peak, n); peak = e.peak.Load() {
+	}
+}
+
+func newStats(sg *stages) *stats {
+	return &stats{stages: sg, edges: make([]edge, len(sg.names)-1)}
+}
+
+// link returns the channel for the k-th stage to hand regions on to the next one with,
+// which get to it on out: out itself if no stats are kept, regions aren't watched (see
+// WithRegionTimeout) and the connector isn't buffered (see WithChannelCapacities)
+func link(ctx context.Context, s *stats, w *watch, k int, out chan Region, capacity int) chan Region {
+	if s == nil && w == nil && capacity == 0 {
+		return out
+	}
+
+	e := &edge{} // counted for nothing, if no stats are kept
+	if s != nil {
+		e = &s.edges[k]
+	}
+	in := make(chan Region, capacity)
+	switch {
+	case capacity > 0:
+		e.queue.Store(&in)
+	case cap(out) > 0:
+		// the connector the sink reads from
+		e.queue.Store(&out)
+	}
+	go func() {
+		defer Pin(ctx)()
+		defer close(out)
+
+		for {
+			// count the regions handed over even if the run's canceled at the same time,
+			// unlike Next
+			start := time.Now()
+			var (
+				r    Region
+				more bool
+			)
+			select {
+			case r, more = <-in:
+			case <-ctx.Done():
+			}
+			e.receiving.Add(int64(time.Since(start)))
+			if !more {
+				return
+			}
+			e.queued()
+			e.regions.Add(1)
+			e.bytes.Add(int64(len(r.Data)))
+			w.handed(k, r)
+			w.taking(k+1, r)
+
+			start = time.Now()
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+			e.sent.Add(int64(time.Since(start)))
+			w.took(k+1, r)
+		}
+	}()
+
+	return in
+}
+
+func (s *stats) snapshot() []StageStats {
+	sg := s.stages
+	stages := make([]StageStats, len(sg.names))
+	for i := range stages {
+		st := StageStats{Name: sg.names[i], Errors: sg.errors[i].Load()}
+		if i > 0 {
+			in := &s.edges[i-1]
+			st.RegionsIn = in.regions.Load()
+			st.BytesIn = in.bytes.Load()
+			st.Receiving = time.Duration(in.receiving.Load())
+		}
+		if i < len(s.edges) {
+			out := &s.edges[i]
+			st.RegionsOut = out.regions.Load()
+			st.BytesOut = out.bytes.Load()
+			st.Sending = time.Duration(out.sent.Load())
+		}
+		stages[i] = st
+	}
+	return stages
+}
+
+func (s *stats) connectors() []ConnectorStats {
+	names := s.stages.names
+	connectors := make([]ConnectorStats, len(s.edges))
+	for k := range connectors {
+		e := &s.edges[k]
+		c := ConnectorStats{
+			From:       names[k],
+			To:         names[k+1],
+			Sending:    time.Duration(e.sent.Load()),
+			Receiving:  time.Duration(e.receiving.Load()),
+			PeakQueued: int(e.peak.Load()),
+		}
+		if q := e.queue.Load(); q != nil {
+			c.Queued, c.Capacity = len(*q), cap(*q)
+		}
+		connectors[k] = c
+	}
+	return connectors
+}
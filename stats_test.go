@@ -0,0 +1,112 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_Stats(t *testing.T) {
+	// given: a sink slower than the rest of the pipe
+	sink := &pipetest.Sink{Delay: 5 * time.Millisecond}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, passthrough, passthrough).
+		With(pipe.WithStats())
+	assert.Assert(t, p.Stats() == nil)
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: every region went through every stage
+	assert.NilError(t, err)
+	stats := p.Stats()
+	names := make([]string, len(stats))
+	for i, st := range stats {
+		names[i] = st.Name
+		if i > 0 {
+			assert.Equal(t, st.RegionsIn, int64(10), st.Name)
+			assert.Equal(t, st.BytesIn, int64(100), st.Name)
+		}
+		if i < len(stats)-1 {
+			assert.Equal(t, st.RegionsOut, int64(10), st.Name)
+			assert.Equal(t, st.BytesOut, int64(100), st.Name)
+		}
+		assert.Equal(t, st.Errors, int64(0), st.Name)
+	}
+	assert.DeepEqual(t, names, []string{"source", "valve 0", "valve 1", "sink"})
+
+	// and the stages before the sink were kept waiting on it, not the other way around
+	source, sinkStats := stats[0], stats[len(stats)-1]
+	assert.Assert(t, source.Sending > 20*time.Millisecond, source.Sending)
+	assert.Assert(t, sinkStats.Receiving < source.Sending, "%v, %v", sinkStats.Receiving, source.Sending)
+}
+
+func TestPipe_Connectors(t *testing.T) {
+	// given: a slow sink, behind a buffered connector
+	sink := &pipetest.Sink{Delay: 5 * time.Millisecond}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, passthrough, passthrough).
+		With(pipe.WithStats(), pipe.WithChannelCapacities(0, 0, 4))
+	assert.Assert(t, p.Connectors() == nil)
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	assert.NilError(t, err)
+	connectors := p.Connectors()
+	assert.Equal(t, len(connectors), 3)
+	for i, c := range connectors {
+		assert.Equal(t, c.From, p.Stats()[i].Name)
+		assert.Equal(t, c.To, p.Stats()[i+1].Name)
+	}
+
+	// and the connector into the sink filled up, the sink keeping the others waiting
+	last := connectors[2]
+	assert.Equal(t, last.Capacity, 4)
+	assert.Equal(t, last.Queued, 0)
+	assert.Assert(t, last.PeakQueued >= 3, last.PeakQueued)
+	assert.Assert(t, last.Sending > 20*time.Millisecond, last.Sending)
+	assert.Assert(t, last.Receiving < last.Sending, "%v, %v", last.Receiving, last.Sending)
+	assert.Equal(t, connectors[0].Capacity, 0)
+}
+
+func TestPipe_Stats_errors(t *testing.T) {
+	// given
+	boom := errors.New("boom")
+	failing := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		if r.Off == 50 {
+			return r, boom
+		}
+		return r, nil
+	})
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{}, passthrough, failing).
+		With(pipe.WithStats())
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then
+	assert.ErrorIs(t, err, boom)
+	stats := p.Stats()
+	assert.Equal(t, stats[2].Name, "valve 1")
+	assert.Equal(t, stats[2].Errors, int64(1))
+	// the stages may still be winding down, and counting the last regions handed over
+	assert.Assert(t, stats[2].RegionsOut <= 5, stats[2].RegionsOut)
+	assert.Assert(t, stats[2].RegionsIn > stats[2].RegionsOut, stats[2].RegionsIn)
+}
+
+func TestPipe_Stats_off(t *testing.T) {
+	// given
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, &pipetest.Sink{})
+
+	// when
+	assert.NilError(t, p.Pipe(context.Background()))
+
+	// then
+	assert.Assert(t, p.Stats() == nil)
+}
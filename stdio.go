@@ -0,0 +1,213 @@
+package pipe
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// AsReader runs the source (through the valves) as a pipe, and returns what it produces
+// as an io.Reader, for code that expects one: the regions in order of offset, starting
+// at offset 0. Regions arriving early (from a sharded source, say) are held until the
+// regions before them have been read, so the reader takes care of the ordering; regions
+// overlapping what's been read already are trimmed. Reading returns the error of the
+// pipe if it fails, and io.ErrUnexpectedEOF if it ends with a gap in the stream.
+//
+// The pipe starts right away, and stops once the reader is closed.
+func AsReader(source Source, valves ...Valve) io.ReadCloser {
+	ctx, cancel := context.WithCancel(context.Background())
+	r, w := io.Pipe()
+
+	go func() {
+		err := New(source, &readerSink{w: w}, valves...).Pipe(ctx)
+		w.CloseWithError(err)
+	}()
+
+	return &reader{r: r, cancel: cancel}
+}
+
+type reader struct {
+	r      *io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	r.cancel()
+	return r.r.Close()
+}
+
+// readerSink writes regions to w in order of offset
+type readerSink struct {
+	w *io.PipeWriter
+}
+
+func (s *readerSink) Read(ctx context.Context, source <-chan Region, errs chan<- error) {
+	var (
+		next    int64
+		pending []Region // arrived early, by offset
+	)
+
+	for {
+		r, more := Next(ctx, source)
+		if !more {
+			break
+		}
+
+		i, _ := slices.BinarySearchFunc(pending, r.Off, func(p Region, off int64) int { return cmp.Compare(p.Off, off) })
+		pending = slices.Insert(pending, i, r)
+
+		for len(pending) > 0 && pending[0].Off <= next {
+			r := pending[0]
+			pending = pending[1:]
+			if skip := next - r.Off; skip < int64(len(r.Data)) {
+				if _, err := s.w.Write(r.Data[skip:]); err != nil {
+					// the reader was closed
+					Fail(ctx, r, err)
+					errs <- err
+					return
+				}
+				next = r.Off + int64(len(r.Data))
+			}
+			Commit(ctx, r)
+		}
+	}
+
+	if ctx.Err() == nil && len(pending) > 0 {
+		errs <- fmt.Errorf("gap in the stream at offset=%d: %w", next, io.ErrUnexpectedEOF)
+		return
+	}
+	errs <- ctx.Err()
+}
+
+// writeSize is the most AsWriter gathers in a region
+const writeSize = MiB
+
+// AsWriter runs a pipe into the sink (through the valves), and returns an io.WriteCloser
+// feeding it, for code that expects one: what's written makes for a stream of regions
+// starting at offset 0. Writes are gathered into regions of up to 1MiB, so that many
+// small writes (of a tar.Writer, say) don't make for as many regions; errors of the pipe
+// are returned by the writes that follow, or Close.
+//
+// The pipe starts right away. Close flushes what's gathered, ends the stream and waits
+// for the pipe to be done, returning its error.
+func AsWriter(sink Sink, valves ...Valve) io.WriteCloser {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &writer{
+		regions: make(chan Region),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+		source := sourceFunc(func(ctx context.Context, sink chan Region, errs chan error) {
+			defer close(sink)
+			for r := range w.regions {
+				select {
+				case sink <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+		w.err = New(source, sink, valves...).Pipe(ctx)
+		cancel()
+	}()
+
+	return w
+}
+
+type writer struct {
+	regions chan Region
+	done    chan struct{} // closed once the pipe is done
+	err     error         // of the pipe, once done
+
+	mu     sync.Mutex
+	off    int64
+	buf    []byte
+	closed bool
+}
+
+// sourceFunc makes a Source of a function
+type sourceFunc func(ctx context.Context, sink chan Region, errs chan error)
+
+func (f sourceFunc) Write(ctx context.Context, sink chan Region, errs chan error) {
+	f(ctx, sink, errs)
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	var written int
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, writeSize)
+		}
+		n := min(len(p), writeSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p, written = p[n:], written+n
+
+		if len(w.buf) == writeSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush hands what's gathered to the pipe; w.mu must be held
+func (w *writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	r := Region{Data: w.buf, Off: w.off}
+	w.off += int64(len(w.buf))
+	w.buf = nil
+
+	select {
+	case w.regions <- r:
+		return nil
+	case <-w.done:
+		return w.failed()
+	}
+}
+
+// failed returns the error the pipe ended with, given it's over before the stream ended
+func (w *writer) failed() error {
+	if w.err != nil {
+		return w.err
+	}
+	return errors.New("pipe ended before the stream did")
+}
+
+func (w *writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.flush()
+	close(w.regions)
+	<-w.done
+	if err != nil {
+		return err
+	}
+	return w.err
+}
@@ -0,0 +1,133 @@
+package pipe_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// passthrough is a valve passing regions through untouched
+var passthrough = pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+
+func TestAsReader(t *testing.T) {
+	ordered := pipetest.Regions(10, 10)
+	want := pipetest.Sequence(ordered).Bytes()
+	shuffled := slices.Clone(ordered)
+	slices.Reverse(shuffled)
+
+	tests := []struct {
+		name    string
+		regions []pipe.Region
+		want    []byte
+		err     error
+	}{
+		{name: "in order", regions: ordered, want: want},
+		{name: "out of order", regions: shuffled, want: want},
+		{name: "overlapping", regions: append(slices.Clone(ordered[:5]), pipe.Region{Data: want[45:], Off: 45}), want: want},
+		{name: "gap", regions: append(slices.Clone(ordered[:5]), ordered[6:]...), want: want[:50], err: io.ErrUnexpectedEOF},
+		{name: "empty", regions: nil, want: []byte{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			r := pipe.AsReader(&pipetest.Source{Regions: test.regions}, passthrough)
+			defer r.Close()
+
+			// when
+			got, err := io.ReadAll(r)
+
+			// then
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.DeepEqual(t, got, test.want)
+		})
+	}
+}
+
+func TestAsReader_failed(t *testing.T) {
+	// given
+	boom := errors.New("boom")
+	fail := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		if r.Off == 50 {
+			return r, boom
+		}
+		return r, nil
+	})
+
+	// when
+	_, err := io.ReadAll(pipe.AsReader(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, fail))
+
+	// then
+	assert.ErrorIs(t, err, boom)
+}
+
+func TestAsReader_Close(t *testing.T) {
+	// given: an endless stream
+	r := pipe.AsReader(&pipetest.Source{Regions: pipetest.Regions(10, 10), Loop: true})
+	_, err := io.ReadFull(r, make([]byte, 1000))
+	assert.NilError(t, err)
+
+	// when
+	assert.NilError(t, r.Close())
+
+	// then
+	_, err = r.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+func TestAsWriter(t *testing.T) {
+	// given: a tar writer writing to a pipe
+	content := make([]byte, 3*MiB+7)
+	_, _ = rand.Read(content)
+	sink := pipeio.BytesSink()
+	w := pipe.AsWriter(sink, passthrough)
+	tw := tar.NewWriter(w)
+
+	// when
+	assert.NilError(t, tw.WriteHeader(&tar.Header{Name: "file", Size: int64(len(content)), Mode: 0o644}))
+	_, err := tw.Write(content)
+	assert.NilError(t, err)
+	assert.NilError(t, tw.Close())
+	assert.NilError(t, w.Close())
+
+	// then: the archive made it through
+	tr := tar.NewReader(bytes.NewReader(sink.Bytes()))
+	hdr, err := tr.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, hdr.Name, "file")
+	got, err := io.ReadAll(tr)
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(got, content))
+}
+
+func TestAsWriter_failed(t *testing.T) {
+	// given: a sink that fails
+	boom := errors.New("boom")
+	w := pipe.AsWriter(&pipetest.Sink{Check: func(pipe.Region) error { return boom }})
+
+	// when: writing more than fits in a region
+	_, werr := w.Write(make([]byte, 3*MiB))
+	cerr := w.Close()
+
+	// then: the failure comes out of the writes, or Close
+	assert.Assert(t, errors.Is(werr, boom) || errors.Is(cerr, boom), "write: %v, close: %v", werr, cerr)
+	assert.ErrorIs(t, cerr, boom)
+
+	// and: there's no writing anymore
+	_, err := w.Write([]byte("x"))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
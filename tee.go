@@ -0,0 +1,202 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Tee returns a Sink writing the stream to every one of sinks (a local file and a remote
+// backup, say), each of them running in a goroutine of its own. Regions are handed to the
+// sinks in lockstep, so the tee goes as fast as the slowest of them.
+//
+// The first sink gets the regions themselves, and the others get copies, since every sink
+// is free to reuse the data it's given once it's written: the first sink can be the one
+// handing data back to the pipe's Buffer, while the others shouldn't be given that same
+// buffer, as the copies don't come from it.
+//
+// A region counts as written once every sink has written it, and as failed as soon as
+// one of them fails to. The first sink to fail stops the others, and the tee fails with
+// the errors of all the sinks that did. With no sinks, the tee drops the stream.
+func Tee(sinks ...Sink) Sink {
+	return &tee{sinks: sinks}
+}
+
+type tee struct {
+	sinks []Sink
+}
+
+type teeResult struct {
+	i   int
+	err error
+}
+
+func (t *tee) Read(ctx context.Context, source <-chan Region, errs chan<- error) {
+	if len(t.sinks) == 0 {
+		for {
+			if _, more := Next(ctx, source); !more {
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}
+
+	outer := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parent, _ := ctx.Value(trackerKey{}).(*tracker)
+	l := &landing{parent: parent, written: make([]ranges, len(t.sinks))}
+
+	results := make(chan teeResult, len(t.sinks))
+	feeds := make([]chan Region, len(t.sinks))
+	for i, s := range t.sinks {
+		feeds[i] = make(chan Region)
+		sctx := context.WithValue(ctx, trackerKey{}, l.tracker(i))
+		go func() {
+			defer Pin(ctx)()
+
+			serrs := make(chan error, 1)
+			s.Read(sctx, feeds[i], serrs)
+			select {
+			case err := <-serrs:
+				results <- teeResult{i: i, err: err}
+			default:
+				results <- teeResult{i: i}
+			}
+		}()
+	}
+
+	var (
+		failed = make([]error, len(t.sinks))
+		exited = make([]bool, len(t.sinks))
+		live   = len(t.sinks)
+	)
+	exit := func(res teeResult) {
+		exited[res.i] = true
+		failed[res.i] = res.err
+		live--
+		if res.err != nil {
+			cancel()
+		}
+	}
+
+feed:
+	for live > 0 {
+		var (
+			r    Region
+			more bool
+		)
+		select {
+		case r, more = <-source:
+		case res := <-results:
+			exit(res)
+			continue
+		case <-ctx.Done():
+			break feed
+		}
+		if !more {
+			break
+		}
+
+		// copy the region before the first sink gets a chance to reuse its data
+		copies := make([]Region, len(t.sinks))
+		for i := range copies {
+			copies[i] = r
+			if i > 0 {
+				copies[i] = unlent(copies[i])
+				copies[i].Data = bytes.Clone(r.Data)
+			}
+		}
+
+		// the copies of the others are buffers of their own, the first is the region's: it's
+		// released if its sink isn't there to take it (see Lend)
+		for i, feed := range feeds {
+			sent := false
+		send:
+			for !exited[i] {
+				select {
+				case feed <- copies[i]:
+					sent = true
+					break send
+				case res := <-results:
+					exit(res)
+				case <-ctx.Done():
+					if i == 0 {
+						copies[0].Release()
+					}
+					break feed
+				}
+			}
+			if !sent && i == 0 {
+				copies[0].Release()
+			}
+		}
+	}
+
+	for _, feed := range feeds {
+		close(feed)
+	}
+	for live > 0 {
+		exit(<-results)
+	}
+
+	if err := outer.Err(); err != nil {
+		errs <- err
+		return
+	}
+	var all []error
+	for i, err := range failed {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			all = append(all, fmt.Errorf("tee: sink %d: %w", i, err))
+		}
+	}
+	errs <- errors.Join(all...)
+}
+
+// landing tells the pipe about ranges once every sink of a tee has written them
+type landing struct {
+	parent *tracker // nil if the tee isn't run by a pipe
+
+	mu        sync.Mutex
+	written   []ranges // by each sink
+	forwarded ranges   // written by all of them, and committed to the parent
+}
+
+// tracker returns the tracker of the i-th sink
+func (l *landing) tracker(i int) *tracker {
+	classifier := DefaultClassifier
+	if l.parent != nil {
+		classifier = l.parent.classifier
+	}
+	return &tracker{classifier: classifier, relay: func(r Range, f *Failure) {
+		l.land(i, r, f)
+	}}
+}
+
+func (l *landing) land(i int, r Range, f *Failure) {
+	if l.parent == nil {
+		return
+	}
+	if f != nil {
+		l.parent.fail(*f)
+		return
+	}
+
+	l.mu.Lock()
+	l.written[i] = l.written[i].add(r)
+	covered := slices.Clone(l.written[0])
+	for _, w := range l.written[1:] {
+		covered = covered.intersect(w)
+	}
+	fresh := covered.minus(l.forwarded)
+	l.forwarded = covered
+	l.mu.Unlock()
+
+	for _, r := range fresh {
+		l.parent.commit(r)
+	}
+}
@@ -0,0 +1,84 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestTee(t *testing.T) {
+	// given: a first sink that reuses region data as soon as it's done with it, and a
+	// second one committing regions two at a time
+	first := &pipetest.Sink{Check: func(r pipe.Region) error {
+		defer func() {
+			for i := range r.Data {
+				r.Data[i] = 'X'
+			}
+		}()
+		return nil
+	}}
+	second := &pairs{}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, pipe.Tee(first, second))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: the second got its own copy of every region
+	assert.NilError(t, err)
+	assert.Equal(t, len(first.Regions()), 10)
+	assert.DeepEqual(t, second.regions, pipetest.Regions(10, 10))
+	assert.DeepEqual(t, p.Report().Written, []pipe.Range{{Off: 0, Len: 100}})
+}
+
+func TestTee_failed(t *testing.T) {
+	// given: a second sink failing halfway
+	boom := errors.New("boom")
+	first := &pipetest.Sink{}
+	second := &pipetest.Sink{Check: func(r pipe.Region) error {
+		if r.Off == 50 {
+			return boom
+		}
+		return nil
+	}}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, pipe.Tee(first, second))
+
+	// when
+	err := p.Pipe(context.Background())
+
+	// then: only what both sinks wrote counts as written
+	assert.ErrorIs(t, err, boom)
+	assert.ErrorContains(t, err, "tee: sink 1: boom")
+	report := p.Report()
+	assert.DeepEqual(t, report.Written, []pipe.Range{{Off: 0, Len: 50}})
+	assert.Equal(t, len(report.Failed), 1)
+	assert.Equal(t, report.Failed[0].Range, pipe.Range{Off: 50, Len: 10})
+}
+
+// pairs is a sink committing the regions it's given two at a time
+type pairs struct {
+	regions []pipe.Region
+}
+
+func (s *pairs) Read(ctx context.Context, source <-chan pipe.Region, errs chan<- error) {
+	var from int64
+	for {
+		r, more := pipe.Next(ctx, source)
+		if !more {
+			break
+		}
+
+		s.regions = append(s.regions, pipe.Region{Data: bytes.Clone(r.Data), Off: r.Off})
+		if len(s.regions)%2 == 0 {
+			end := r.Off + int64(len(r.Data))
+			pipe.CommitRange(ctx, pipe.Range{Off: from, Len: end - from})
+			from = end
+		}
+	}
+	errs <- ctx.Err()
+}
@@ -0,0 +1,196 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Window is a time of day during which a rate limit applies. Start and End are offsets
+// into the day; a window with End before Start wraps around midnight.
+type Window struct {
+	Start, End time.Duration
+	Rate       int64 // bytes per second, 0 for unlimited
+}
+
+func (w Window) contains(d time.Duration) bool {
+	if w.End < w.Start {
+		return d >= w.Start || d < w.End
+	}
+	return d >= w.Start && d < w.End
+}
+
+// Schedule is a rate limit that varies by time of day, e.g. to keep a replication pipe
+// from hogging a link during business hours:
+//
+//	pipe.Schedule{Windows: []pipe.Window{{Start: 9 * time.Hour, End: 18 * time.Hour, Rate: 50 * pipe.MiB}}}
+type Schedule struct {
+	// Windows are checked in order; the first one containing the time of day applies.
+	Windows []Window
+	// Default is the rate outside of any window (bytes per second, 0 for unlimited).
+	Default int64
+	// Location is the time zone the windows are in (local time if nil).
+	Location *time.Location
+}
+
+// Rate returns the rate limit in effect at t (bytes per second, 0 for unlimited).
+func (s Schedule) Rate(t time.Time) int64 {
+	if s.Location != nil {
+		t = t.In(s.Location)
+	}
+	// the wall clock reading, not the time elapsed since midnight, which is an hour off on
+	// days the clocks change
+	h, m, sec := t.Clock()
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+
+	for _, w := range s.Windows {
+		if w.contains(d) {
+			return w.Rate
+		}
+	}
+	return s.Default
+}
+
+// Throttle returns a Valve that limits the rate at which regions pass through it to the
+// rate the schedule has in effect, which it keeps checking as time goes by. A throttle
+// can be shared by several pipes, to limit their combined rate.
+func Throttle(s Schedule) *throttle {
+	return &throttle{schedule: s}
+}
+
+// RateLimit returns a Valve that limits the rate at which regions pass through it to
+// bytesPerSec, letting up to burst bytes through at once after a lull (a second's worth
+// if burst is 0 or less). It's a Throttle whose schedule is the one rate, all day long:
+//
+//	p := pipe.New(source, sink, pipe.RateLimit(20*pipe.MiB, 4*pipe.MiB))
+func RateLimit(bytesPerSec, burst int64) *throttle {
+	return &throttle{schedule: Schedule{Default: bytesPerSec}, burst: max(burst, 0)}
+}
+
+var limiters = struct {
+	sync.Mutex
+	byName map[string]*throttle
+}{byName: make(map[string]*throttle)}
+
+// Limiter returns the throttle registered in the process under name, registering an
+// unlimited one if there's none yet (see Set). Whatever refers to the same name shares
+// the same limit, whichever pipe, group, source or sink it's in, so an operator can cap
+// the combined rate of every transfer running in the process:
+//
+//	pipe.Limiter("wan-egress").Set(pipe.Schedule{Default: 100 * pipe.MiB})
+//	...
+//	p := pipe.New(source, sink, pipe.Limiter("wan-egress"))
+func Limiter(name string) *throttle {
+	limiters.Lock()
+	defer limiters.Unlock()
+
+	t, ok := limiters.byName[name]
+	if !ok {
+		t = Throttle(Schedule{})
+		limiters.byName[name] = t
+	}
+	return t
+}
+
+type throttle struct {
+	schedule Schedule
+	burst    int64 // the size of the bucket, in bytes (a second's worth if 0)
+
+	mu     sync.Mutex
+	tokens float64 // bytes that can go through right away (negative when in debt)
+	last   time.Time
+}
+
+func (t *throttle) Open(ctx context.Context, sink chan Region, errs chan error) chan Region {
+	source := make(chan Region)
+	go func() {
+		defer Pin(ctx)()
+		defer close(sink)
+
+		for {
+			r, more := Next(ctx, source)
+			if !more {
+				break
+			}
+
+			if !t.wait(ctx, len(r.Data)) {
+				return
+			}
+
+			select {
+			case sink <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return source
+}
+
+// Set replaces the schedule of the throttle, taking effect for the regions waiting on it
+// as well as those to come.
+func (t *throttle) Set(s Schedule) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.schedule = s
+}
+
+// Wait blocks until n bytes are allowed through the throttle, for sources and sinks
+// limiting themselves rather than going through it as a valve (to limit what they read
+// or write on the side, say). It returns the error of ctx if it's done first.
+func (t *throttle) Wait(ctx context.Context, n int) error {
+	if !t.wait(ctx, n) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// recheck is how long a throttled region waits at most before the schedule is checked
+// again, in case the rate changed in the meantime
+const recheck = time.Second
+
+// wait blocks until n bytes are allowed through, returning false if the context is done
+// first. Up to a burst's worth of bytes (a second's worth by default) can go through at
+// once; regions larger than that go through once the bucket is full, leaving it in debt.
+func (t *throttle) wait(ctx context.Context, n int) bool {
+	for {
+		t.mu.Lock()
+		now := time.Now()
+		rate := float64(t.schedule.Rate(now))
+		if rate <= 0 {
+			t.tokens, t.last = 0, time.Time{}
+			t.mu.Unlock()
+			return true
+		}
+
+		size := rate
+		if t.burst > 0 {
+			size = float64(t.burst)
+		}
+		if t.last.IsZero() {
+			t.tokens = size // start out with a full bucket
+		} else {
+			t.tokens = min(t.tokens+now.Sub(t.last).Seconds()*rate, size)
+		}
+		t.last = now
+
+		need := min(float64(n), size)
+		if t.tokens >= need {
+			t.tokens -= float64(n)
+			t.mu.Unlock()
+			return true
+		}
+		delay := min(time.Duration((need-t.tokens)/rate*float64(time.Second)), recheck)
+		t.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package pipe_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestSchedule_Rate(t *testing.T) {
+	s := pipe.Schedule{
+		Windows: []pipe.Window{
+			{Start: 9 * time.Hour, End: 18 * time.Hour, Rate: 50 * pipe.MiB},
+			{Start: 22 * time.Hour, End: 2 * time.Hour, Rate: 0},
+		},
+		Default:  100 * pipe.MiB,
+		Location: time.UTC,
+	}
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 3, 1, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		t    time.Time
+		rate int64
+	}{
+		{t: at(8, 59), rate: 100 * pipe.MiB},
+		{t: at(9, 0), rate: 50 * pipe.MiB},
+		{t: at(17, 59), rate: 50 * pipe.MiB},
+		{t: at(18, 0), rate: 100 * pipe.MiB},
+		{t: at(23, 0), rate: 0},
+		{t: at(1, 30), rate: 0},
+		{t: at(2, 0), rate: 100 * pipe.MiB},
+		{t: at(10, 0).In(time.FixedZone("UTC+10", 10*60*60)), rate: 50 * pipe.MiB},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, s.Rate(test.t), test.rate, "at %s", test.t)
+	}
+}
+
+func TestSchedule_Rate_dst(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NilError(t, err)
+
+	s := pipe.Schedule{
+		Windows:  []pipe.Window{{Start: 9*time.Hour + 30*time.Minute, End: 18 * time.Hour, Rate: 50 * pipe.MiB}},
+		Default:  100 * pipe.MiB,
+		Location: newYork,
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		rate int64
+	}{
+		// only 9 hours have passed since midnight by 10:00 when the clocks spring forward,
+		// and 11 when they fall back: the windows go by the clock regardless
+		{name: "spring forward", t: time.Date(2024, 3, 10, 10, 0, 0, 0, newYork), rate: 50 * pipe.MiB},
+		{name: "fall back", t: time.Date(2024, 11, 3, 17, 30, 0, 0, newYork), rate: 50 * pipe.MiB},
+		{name: "fall back/after", t: time.Date(2024, 11, 3, 18, 0, 0, 0, newYork), rate: 100 * pipe.MiB},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, s.Rate(test.t), test.rate)
+		})
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule pipe.Schedule
+		atLeast  time.Duration
+	}{
+		{
+			// a second's worth goes through right away, the rest takes a second
+			name:     "limited",
+			schedule: pipe.Schedule{Default: 50 * KiB},
+			atLeast:  time.Second,
+		},
+		{
+			name:     "unlimited",
+			schedule: pipe.Schedule{Default: 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sink := &pipetest.Sink{}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10*KiB)}, sink, pipe.Throttle(test.schedule))
+
+			// when
+			start := time.Now()
+			assert.NilError(t, p.Pipe(context.Background()))
+
+			// then
+			assert.Assert(t, time.Since(start) >= test.atLeast)
+			assert.Assert(t, time.Since(start) < test.atLeast+500*time.Millisecond)
+			assert.Equal(t, len(sink.Regions()), 10)
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		burst   int64
+		atLeast time.Duration
+	}{
+		{
+			// a second's worth goes through right away, the rest takes a second
+			name:    "default burst",
+			atLeast: time.Second,
+		},
+		{
+			// a region's worth goes through right away, the rest takes 1.8s
+			name:    "small burst",
+			burst:   10 * KiB,
+			atLeast: 1800 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sink := &pipetest.Sink{}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10*KiB)}, sink, pipe.RateLimit(50*KiB, test.burst))
+
+			// when
+			start := time.Now()
+			assert.NilError(t, p.Pipe(context.Background()))
+
+			// then
+			assert.Assert(t, time.Since(start) >= test.atLeast)
+			assert.Assert(t, time.Since(start) < test.atLeast+500*time.Millisecond)
+			assert.Equal(t, len(sink.Regions()), 10)
+		})
+	}
+}
+
+// limiterName returns a name no limiter of the process has been registered under yet,
+// as limiters outlive the tests registering them
+func limiterName(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", t.Name(), limiterNames.Add(1))
+}
+
+var limiterNames atomic.Int64
+
+func TestLimiter(t *testing.T) {
+	// given: a limit shared by two pipes and a sink limiting itself
+	name := limiterName(t)
+	limiter := pipe.Limiter(name)
+	limiter.Set(pipe.Schedule{Default: 50 * KiB})
+	assert.Equal(t, pipe.Limiter(name), limiter)
+
+	sinks := []*pipetest.Sink{{}, {}}
+	var errs [3]error
+	var wg sync.WaitGroup
+
+	// when: 100KiB go through, half a second's worth of them more than once
+	start := time.Now()
+	for i, sink := range sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = pipe.New(&pipetest.Source{Regions: pipetest.Regions(4, 10*KiB)}, sink, pipe.Limiter(name)).Pipe(context.Background())
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs[2] = pipe.Limiter(name).Wait(context.Background(), 20*KiB)
+	}()
+	wg.Wait()
+
+	// then: a second's worth goes through right away, the rest takes a second
+	for _, err := range errs {
+		assert.NilError(t, err)
+	}
+	assert.Assert(t, time.Since(start) >= time.Second)
+	assert.Assert(t, time.Since(start) < 1500*time.Millisecond)
+}
+
+func TestLimiter_Set(t *testing.T) {
+	// given: a limiter in debt for a long while
+	limiter := pipe.Limiter(limiterName(t))
+	limiter.Set(pipe.Schedule{Default: KiB})
+	assert.NilError(t, limiter.Wait(context.Background(), 100*KiB))
+
+	waited := make(chan error)
+	go func() {
+		waited <- limiter.Wait(context.Background(), KiB)
+	}()
+
+	// when: the limit is lifted
+	time.Sleep(50 * time.Millisecond)
+	limiter.Set(pipe.Schedule{})
+
+	// then: what's waiting goes through (by the time the schedule is checked again)
+	select {
+	case err := <-waited:
+		assert.NilError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("still waiting")
+	}
+
+	// and: waiting gives up with the context
+	limiter.Set(pipe.Schedule{Default: KiB})
+	assert.NilError(t, limiter.Wait(context.Background(), 100*KiB))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx, KiB), context.DeadlineExceeded)
+}
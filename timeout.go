@@ -0,0 +1,205 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRegionTimeout is what pipes fail with when one of their stages holds a region for
+// too long (see WithRegionTimeout), wrapped in a StageError naming the stage.
+var ErrRegionTimeout = errors.New("region timed out")
+
+// WithRegionTimeout has the pipe fail with ErrRegionTimeout if any of its stages holds a
+// region for longer than d, rather than hang on a stuck write forever. A valve holds the
+// regions it takes in until it next hands a region on (so regions it drops count as held
+// until then), short of waiting on the next stage to take one; the sink holds every
+// region until it reports it written or failed (see Commit and Fail), so sinks that
+// don't report what they write can't be watched.
+//
+// The pipe fails without waiting for the stage that timed out to be done, as it may never
+// be. Like WithStats, regions are watched by way of goroutines of the pipe's, and pipes
+// running batches, rings or their shortcut aren't watched.
+func WithRegionTimeout(d time.Duration) Option {
+	return func(p *Pipe) {
+		p.regionTimeout = d
+	}
+}
+
+// watch keeps track of how long the stages of a run have been holding regions for, and
+// of when a region last moved through the pipe
+type watch struct {
+	timeout time.Duration // 0 if regions aren't timed (see WithRegionTimeout)
+	stall   time.Duration // 0 if the pipe isn't watched for stalls (see WithStallTimeout)
+
+	mu       sync.Mutex
+	held     []holding           // by valve, indexed by stage
+	offering []bool              // whether a region's being handed to the stage, by stage
+	sink     map[int64]time.Time // the regions held by the sink, by offset
+	stage    int                 // the sink's
+	moved    time.Time           // when a region last moved
+	ins      []moved             // the last region each stage took in, by stage
+	outs     []moved             // the last region each stage handed on (or landed), by stage
+}
+
+// moved is a region moving in or out of a stage
+type moved struct {
+	off int64
+	at  time.Time
+}
+
+// holding is the oldest region a stage took in since it last handed one on
+type holding struct {
+	off   int64
+	since time.Time
+	ok    bool
+}
+
+func newWatch(timeout, stall time.Duration, stages int) *watch {
+	return &watch{
+		timeout:  timeout,
+		stall:    stall,
+		held:     make([]holding, stages),
+		offering: make([]bool, stages),
+		sink:     make(map[int64]time.Time),
+		stage:    stages - 1,
+		moved:    time.Now(),
+		ins:      make([]moved, stages),
+		outs:     make([]moved, stages),
+	}
+}
+
+// taking is called as r is handed to the i-th stage: the stage may be done with it by
+// the time took is called
+func (w *watch) taking(i int, r Region) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timeout == 0 {
+		return
+	}
+	w.offering[i] = true
+	switch {
+	case i == w.stage:
+		// empty regions have nothing to write, so nothing to report
+		if len(r.Data) > 0 {
+			w.sink[r.Off] = time.Now()
+		}
+	case !w.held[i].ok:
+		w.held[i] = holding{off: r.Off, since: time.Now(), ok: true}
+	}
+}
+
+// took is called once the i-th stage has taken r in, which it's held since (unless it's
+// done with it already)
+func (w *watch) took(i int, r Region) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.moved = time.Now()
+	w.ins[i] = moved{off: r.Off, at: w.moved}
+	w.offering[i] = false
+	if i == w.stage {
+		if _, ok := w.sink[r.Off]; ok {
+			w.sink[r.Off] = time.Now()
+		}
+	} else if h := &w.held[i]; h.ok && h.off == r.Off {
+		h.since = time.Now()
+	}
+}
+
+// handed is called once the i-th stage has handed r on
+func (w *watch) handed(i int, r Region) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.moved = time.Now()
+	w.outs[i] = moved{off: r.Off, at: w.moved}
+	w.held[i] = holding{}
+}
+
+// landed is called once the sink has reported rg written or failed
+func (w *watch) landed(rg Range) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.moved = time.Now()
+	w.outs[w.stage] = moved{off: rg.Off, at: w.moved}
+	for off := range w.sink {
+		if off >= rg.Off && off < rg.End() {
+			delete(w.sink, off)
+		}
+	}
+}
+
+// expired returns a stage that's been holding a region for longer than the timeout as
+// of now, and the offset of the region (the oldest the sink holds). Valves waiting on the
+// next stage to take a region off them are held up by that stage, not holding up the
+// pipe, so they're left out.
+func (w *watch) expired(now time.Time) (stage int, off int64, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timeout == 0 {
+		return 0, 0, false
+	}
+	for i, h := range w.held {
+		if h.ok && !w.offering[i+1] && now.Sub(h.since) > w.timeout {
+			return i, h.off, true
+		}
+	}
+
+	var oldest time.Time
+	for o, since := range w.sink {
+		if now.Sub(since) > w.timeout && (!ok || since.Before(oldest)) {
+			off, oldest, ok = o, since, true
+		}
+	}
+	return w.stage, off, ok
+}
+
+// run fails r once one of its stages has held a region for too long, or no region has
+// moved for too long, until ctx is done
+func (w *watch) run(ctx context.Context, r *run) {
+	tick := w.timeout
+	if tick == 0 || (w.stall > 0 && w.stall < tick) {
+		tick = w.stall
+	}
+	ticker := time.NewTicker(max(tick/4, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			var err error
+			if i, off, ok := w.expired(now); ok {
+				err = r.stages.fail(i, fmt.Errorf("%w: region at offset %d held for over %v", ErrRegionTimeout, off, w.timeout))
+			} else if serr := w.stalled(now, r.stages, r.pauser.wait() != nil); serr != nil {
+				err = serr
+			} else {
+				continue
+			}
+			select {
+			case r.errs <- err:
+			case <-ctx.Done():
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
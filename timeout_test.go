@@ -0,0 +1,81 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithRegionTimeout(t *testing.T) {
+	// hangAt returns a check hanging on the region at off until the test is over
+	hangAt := func(t *testing.T, off int64) func(pipe.Region) error {
+		hung := make(chan struct{})
+		t.Cleanup(func() { close(hung) })
+		return func(r pipe.Region) error {
+			if r.Off == off {
+				<-hung
+			}
+			return nil
+		}
+	}
+
+	tests := map[string]struct {
+		valve    func(t *testing.T) func(pipe.Region) error
+		sink     func(t *testing.T) func(pipe.Region) error
+		delay    time.Duration
+		stage    string
+		expected string
+	}{
+		"hung sink": {
+			sink:     func(t *testing.T) func(pipe.Region) error { return hangAt(t, 30) },
+			stage:    "sink",
+			expected: "sink (*pipetest.Sink): region timed out: region at offset 30 held for over 50ms",
+		},
+		"hung valve": {
+			valve:    func(t *testing.T) func(pipe.Region) error { return hangAt(t, 20) },
+			stage:    "valve 1",
+			expected: "valve 1 (pipe.Func): region timed out: region at offset 20 held for over 50ms",
+		},
+		"slow": {
+			delay: 5 * time.Millisecond,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			check := func(pipe.Region) error { return nil }
+			if test.valve != nil {
+				check = test.valve(t)
+			}
+			valve := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, check(r) })
+			sink := &pipetest.Sink{Delay: test.delay}
+			if test.sink != nil {
+				sink.Check = test.sink(t)
+			}
+			passthrough := pipe.Func(func(r pipe.Region) (pipe.Region, error) { return r, nil })
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10), Delay: time.Millisecond}, sink, passthrough, valve).
+				With(pipe.WithRegionTimeout(50 * time.Millisecond))
+
+			// when
+			err := p.Pipe(context.Background())
+
+			// then
+			if test.expected == "" {
+				assert.NilError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, pipe.ErrRegionTimeout)
+			var stageErr *pipe.StageError
+			assert.Assert(t, errors.As(err, &stageErr))
+			assert.Equal(t, stageErr.Stage, test.stage)
+			assert.Error(t, err, test.expected)
+		})
+	}
+}
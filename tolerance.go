@@ -0,0 +1,108 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrTooManyFailures is what a pipe fails with when more of its regions failed than
+// WithTolerancePercent lets it carry on past.
+var ErrTooManyFailures = errors.New("too many failed regions")
+
+// WithTolerance has the pipe carry on past up to n regions failing to go through a valve
+// or to be written by the sink, as it does with dead letters (see WithDeadLetters, which
+// it can go with): a region that would fail the pipe is dropped and reported as failed
+// instead, and its offset is listed in the Result of the run (see Run). The region after
+// the n-th fails the pipe with its error. It's meant for runs where some loss is fine (a
+// cache being warmed, say), which are then not started over for the sake of one bad
+// block.
+//
+// Fatal errors still fail the pipe (see ErrorClass), and components that don't divert
+// regions fail it as they would otherwise (see Divert).
+func WithTolerance(n int) Option {
+	return func(p *Pipe) {
+		p.tolerance = n
+	}
+}
+
+// WithTolerancePercent is like WithTolerance, for up to percent of the regions taken off
+// the source rather than a number of them. How many regions there are is only known once
+// the source is done, so the pipe carries on past any number of failed regions (up to a
+// limit set with WithTolerance, if any) and then fails with ErrTooManyFailures if there
+// were more than that.
+func WithTolerancePercent(percent float64) Option {
+	return func(p *Pipe) {
+		p.tolerancePercent = percent
+	}
+}
+
+type allowanceKey struct{}
+
+// allowance counts the regions a run carried on past
+type allowance struct {
+	n       int     // regions tolerated, if > 0
+	percent float64 // of the regions taken off the source tolerated, if > 0
+	regions *atomic.Int64
+
+	mu     sync.Mutex
+	failed []int64 // the offset of every region carried on past
+}
+
+// on returns whether the run carries on past failed regions of its own accord, dead
+// letters aside
+func (t *allowance) on() bool {
+	return t.n > 0 || t.percent > 0
+}
+
+// admit returns whether the region at off can be carried on past, and counts it if so
+func (t *allowance) admit(off int64, diverted bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.on() && !diverted {
+		return false
+	}
+	if t.n > 0 && len(t.failed) >= t.n {
+		return false
+	}
+	t.failed = append(t.failed, off)
+	return true
+}
+
+// offsets returns the offsets of the regions carried on past, sorted
+func (t *allowance) offsets() []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	offs := slices.Clone(t.failed)
+	slices.Sort(offs)
+	return offs
+}
+
+// check returns ErrTooManyFailures if more regions failed than tolerated, once the run's
+// over
+func (t *allowance) check() error {
+	if t.percent <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	failed := len(t.failed)
+	t.mu.Unlock()
+
+	regions := t.regions.Load()
+	if float64(failed)*100 > t.percent*float64(regions) {
+		return fmt.Errorf("%w: %d of %d, more than %g%%", ErrTooManyFailures, failed, regions, t.percent)
+	}
+	return nil
+}
+
+// tolerate has ctx carry the allowance of run r for failed regions
+func (p *Pipe) tolerate(ctx context.Context, r *run) context.Context {
+	r.allowance = &allowance{n: p.tolerance, percent: p.tolerancePercent, regions: &r.regions}
+	return context.WithValue(ctx, allowanceKey{}, r.allowance)
+}
@@ -0,0 +1,106 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithTolerance(t *testing.T) {
+	corrupt := errors.New("corrupt")
+	// the valve fails on regions at offsets 20 and 50, the sink on those at 70 and 90
+	failAt := func(offs ...int64) func(pipe.Region) error {
+		return func(r pipe.Region) error {
+			if slices.Contains(offs, r.Off) {
+				return corrupt
+			}
+			return nil
+		}
+	}
+	valve := pipe.Func(func(r pipe.Region) (pipe.Region, error) {
+		return r, failAt(20, 50)(r)
+	})
+
+	tests := map[string]struct {
+		opts     []pipe.Option
+		expected []int64 // offsets of the regions carried on past
+		err      error
+	}{
+		"none": {
+			err: corrupt,
+		},
+		"enough": {
+			opts:     []pipe.Option{pipe.WithTolerance(4)},
+			expected: []int64{20, 50, 70, 90},
+		},
+		"not enough": {
+			opts: []pipe.Option{pipe.WithTolerance(3)},
+			err:  corrupt,
+		},
+		"percent": {
+			opts:     []pipe.Option{pipe.WithTolerancePercent(40)},
+			expected: []int64{20, 50, 70, 90},
+		},
+		"percent/not enough": {
+			opts:     []pipe.Option{pipe.WithTolerancePercent(30)},
+			expected: []int64{20, 50, 70, 90},
+			err:      pipe.ErrTooManyFailures,
+		},
+		"with batches": {
+			opts:     []pipe.Option{pipe.WithTolerance(4), pipe.WithBatches(4)},
+			expected: []int64{20, 50, 70, 90},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			sink := &pipetest.Sink{Check: failAt(70, 90)}
+			p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, valve).
+				With(test.opts...)
+
+			// when
+			res, err := p.Run(context.Background())
+
+			// then
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			} else {
+				assert.NilError(t, err)
+				assert.Equal(t, len(sink.Regions()), 6)
+				assert.Equal(t, len(p.Report().Failed), 4)
+			}
+			if test.expected != nil {
+				assert.DeepEqual(t, res.Failed, test.expected)
+			}
+		})
+	}
+}
+
+func TestPipe_WithTolerance_deadLetters(t *testing.T) {
+	// given: one dead letter more than tolerated
+	letters := make(chan pipe.DeadLetter, 10)
+	sink := &pipetest.Sink{Check: func(r pipe.Region) error {
+		if r.Off%30 == 0 {
+			return errors.New("corrupt")
+		}
+		return nil
+	}}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink).
+		With(pipe.WithDeadLetters(letters), pipe.WithTolerance(3))
+
+	// when
+	res, err := p.Run(context.Background())
+	close(letters)
+
+	// then
+	assert.ErrorContains(t, err, "corrupt")
+	assert.DeepEqual(t, res.Failed, []int64{0, 30, 60})
+	assert.Equal(t, len(letters), 3)
+}
@@ -0,0 +1,175 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// Tracer starts the spans a pipe traces its runs with (see WithTracer). It's shaped after
+// the tracers of OpenTelemetry, which it takes a few lines to adapt to one of, without the
+// pipe depending on it:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, pipe.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+// with otelSpan turning the slog attributes of events into attribute.KeyValues.
+type Tracer interface {
+	// Start starts a span named name, as a child of the span ctx carries (if any), and
+	// returns ctx carrying it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	AddEvent(name string, attrs ...slog.Attr)
+	RecordError(err error)
+	End()
+}
+
+// WithTracer has the pipe trace every run with t: a span for the run (named after the
+// pipe, see WithName), a child of whatever span the context of the run carries, and a
+// child span of it for each stage. The components run with the context carrying the span
+// of the run, so whatever they trace of their own (requests to a remote sink, say) is
+// part of it too. A stage that fails has its error recorded on its span, and the run on
+// its own; the spans of the stages carry what went through them once they end, if the
+// pipe keeps stats (see WithStats). See WithRegionEvents to trace the regions as well.
+func WithTracer(t Tracer) Option {
+	return func(p *Pipe) {
+		p.tracer = t
+	}
+}
+
+// WithRegionEvents has the pipe add an event to the span of the sink for one region in
+// every, as it's written or failed (see Commit), if it traces its runs (see WithTracer):
+// 1 for every region, more to sample them on busy pipes.
+func WithRegionEvents(every int) Option {
+	return func(p *Pipe) {
+		p.regionEvents = every
+	}
+}
+
+type traceKey struct{}
+
+// trace is what's traced of a run
+type trace struct {
+	tracer Tracer
+	ctx    context.Context // carrying the span of the run
+	span   Span
+	every  int64
+	events atomic.Int64 // regions written or failed so far
+
+	mu     sync.Mutex
+	stages []Span
+	names  []string
+}
+
+// trace starts the span of the run, and returns ctx carrying it along with the func that
+// ends it (and those of the stages) once the run is done with err
+func (p *Pipe) trace(ctx context.Context, r *run) (context.Context, func(err error)) {
+	if p.tracer == nil {
+		return ctx, func(error) {}
+	}
+
+	name := "pipe"
+	if p.name != "" {
+		name = "pipe " + p.name
+	}
+	t := &trace{tracer: p.tracer, every: int64(p.regionEvents)}
+	ctx, t.span = p.tracer.Start(ctx, name)
+	t.ctx = ctx
+	if t.every > 0 {
+		r.tracker.traced = t.region
+	}
+
+	return context.WithValue(ctx, traceKey{}, t), func(err error) {
+		t.end(err, p.Stats())
+	}
+}
+
+// stage starts the span of the stage named name
+func (t *trace) stage(name string) {
+	_, span := t.tracer.Start(t.ctx, name)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stages = append(t.stages, span)
+	t.names = append(t.names, name)
+}
+
+// region adds an event for the range written (or failed with f) to the span of the sink,
+// for one in every range
+func (t *trace) region(r Range, f *Failure) {
+	if (t.events.Add(1)-1)%t.every != 0 {
+		return
+	}
+
+	span := t.span
+	t.mu.Lock()
+	for i, name := range t.names {
+		if name == "sink" {
+			span = t.stages[i]
+		}
+	}
+	t.mu.Unlock()
+
+	if f != nil {
+		span.AddEvent("region failed", slog.Int64("off", r.Off), slog.Int64("len", r.Len),
+			slog.String("class", f.Class.String()), slog.String("reason", f.Reason))
+		return
+	}
+	span.AddEvent("region written", slog.Int64("off", r.Off), slog.Int64("len", r.Len))
+}
+
+// end ends the spans of the stages and of the run, recording err on the span of the run
+// and on those of the stages it comes from
+func (t *trace) end(err error, stats []StageStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	failed := map[string]error{}
+	for _, err := range unjoin(err) {
+		if se := (*StageError)(nil); errors.As(err, &se) {
+			failed[se.Stage] = se.Err
+		}
+	}
+
+	for i, span := range t.stages {
+		name := t.names[i]
+		if err, ok := failed[name]; ok {
+			span.RecordError(err)
+		}
+		for _, st := range stats {
+			if st.Name == name {
+				span.AddEvent("stage done",
+					slog.Int64("regions_in", st.RegionsIn), slog.Int64("bytes_in", st.BytesIn),
+					slog.Int64("regions_out", st.RegionsOut), slog.Int64("bytes_out", st.BytesOut),
+					slog.Int64("errors", st.Errors))
+			}
+		}
+		span.End()
+	}
+
+	if err != nil {
+		t.span.RecordError(err)
+	}
+	t.span.End()
+}
+
+// unjoin returns the errors joined into err (see errors.Join), err itself if it isn't
+// a join of errors
+func unjoin(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	if err == nil {
+		return nil
+	}
+	return []error{err}
+}
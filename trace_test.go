@@ -0,0 +1,107 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+func TestPipe_WithTracer(t *testing.T) {
+	// given: a pipe whose sink fails on the last region
+	tracer := &recordingTracer{}
+	sink := &pipetest.Sink{Check: func(r pipe.Region) error {
+		if r.Off == 90 {
+			return errors.New("disk full")
+		}
+		return nil
+	}}
+	p := pipe.New(&pipetest.Source{Regions: pipetest.Regions(10, 10)}, sink, passthrough).
+		With(pipe.WithName("copy"), pipe.WithTracer(tracer), pipe.WithRegionEvents(3), pipe.WithStats())
+
+	// when
+	ctx, parent := tracer.Start(context.Background(), "request")
+	err := p.Pipe(ctx)
+	parent.End()
+
+	// then: a span for the run, under the span of the caller, and one for each stage
+	assert.ErrorContains(t, err, "disk full")
+	run := tracer.span("pipe copy")
+	assert.Equal(t, run.parent, "request")
+	assert.Assert(t, run.ended)
+	assert.ErrorContains(t, run.err, "disk full")
+	for _, name := range []string{"gate", "source", "valve 0", "sink"} {
+		span := tracer.span(name)
+		assert.Equal(t, span.parent, "pipe copy", name)
+		assert.Assert(t, span.ended, name)
+	}
+
+	// and the sink's span has the error, and an event for one region in three
+	sinkSpan := tracer.span("sink")
+	assert.ErrorContains(t, sinkSpan.err, "disk full")
+	assert.DeepEqual(t, sinkSpan.events, []string{"region written", "region written", "region written", "region failed", "stage done"})
+	assert.Assert(t, tracer.span("source").err == nil)
+}
+
+// recordingTracer records the spans it starts
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string) (context.Context, pipe.Span) {
+	s := &recordedSpan{tracer: tr, name: name}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.spans = append(tr.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (tr *recordingTracer) span(name string) recordedSpan {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, s := range tr.spans {
+		if s.name == name {
+			return *s
+		}
+	}
+	return recordedSpan{}
+}
+
+type recordedSpan struct {
+	tracer       *recordingTracer
+	name, parent string
+	events       []string
+	err          error
+	ended        bool
+}
+
+func (s *recordedSpan) AddEvent(name string, _ ...slog.Attr) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.events = append(s.events, name)
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended = true
+}
@@ -0,0 +1,161 @@
+package pipe_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+
+	"github.com/naylorpmax-joyent/pipe"
+	pipeio "github.com/naylorpmax-joyent/pipe/io"
+	"github.com/naylorpmax-joyent/pipe/pipetest"
+)
+
+// wasmRuntime stands in for a WebAssembly runtime: the "module" names the behavior of
+// instances, which keep to the ABI of pipeio.Wasm over a linear memory of their own
+type wasmRuntime struct{}
+
+func (wasmRuntime) Instantiate(_ context.Context, module []byte, limits pipeio.WasmLimits) (pipeio.WasmInstance, error) {
+	size := limits.Memory
+	if size == 0 {
+		size = MiB
+	}
+	switch m := string(module); m {
+	case "upper", "spin", "wild", "greedy":
+		return &wasmInstance{module: m, memory: make([]byte, size)}, nil
+	default:
+		return nil, fmt.Errorf("invalid module %q", m)
+	}
+}
+
+type wasmInstance struct {
+	module string
+	memory []byte
+}
+
+func (i *wasmInstance) Call(ctx context.Context, name string, params ...uint64) ([]uint64, error) {
+	switch name {
+	case "alloc":
+		if i.module == "greedy" {
+			return []uint64{uint64(len(i.memory)) - 1}, nil
+		}
+		// the input at the start of memory, the output right after it
+		return []uint64{0}, nil
+	case "transform":
+		ptr, size := params[0], params[1]
+		switch i.module {
+		case "spin":
+			<-ctx.Done()
+			return nil, errors.New("module closed")
+		case "wild":
+			return []uint64{uint64(len(i.memory))<<32 | size}, nil
+		}
+		out := ptr + size
+		copy(i.memory[out:], bytes.ToUpper(i.memory[ptr:ptr+size]))
+		return []uint64{out<<32 | size}, nil
+	default:
+		return nil, fmt.Errorf("no function %s", name)
+	}
+}
+
+func (i *wasmInstance) Read(off, n uint32) ([]byte, bool) {
+	if uint64(off)+uint64(n) > uint64(len(i.memory)) {
+		return nil, false
+	}
+	return i.memory[off : off+n], true
+}
+
+func (i *wasmInstance) Write(off uint32, b []byte) bool {
+	if uint64(off)+uint64(len(b)) > uint64(len(i.memory)) {
+		return false
+	}
+	copy(i.memory[off:], b)
+	return true
+}
+
+func (i *wasmInstance) Close(context.Context) error {
+	return nil
+}
+
+func TestWasm(t *testing.T) {
+	// given
+	regions := text(64, 4*KiB)
+	want := bytes.ToUpper(pipetest.Sequence(regions).Bytes())
+	sink := &pipetest.Sink{}
+	valve := pipeio.Wasm(wasmRuntime{}, []byte("upper"), pipeio.WasmLimits{Memory: 64 * KiB, Time: time.Second}, pipeio.NewBuffer(4*KiB, 4))
+
+	// when
+	err := pipe.New(&pipetest.Source{Regions: regions}, sink, valve).Pipe(context.Background())
+
+	// then
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(sink.Bytes(), want))
+}
+
+func TestWasm_limits(t *testing.T) {
+	tests := []struct {
+		name   string
+		module string
+		limits pipeio.WasmLimits
+		size   int
+		want   string
+	}{
+		{
+			name:   "too long",
+			module: "spin",
+			limits: pipeio.WasmLimits{Time: 50 * time.Millisecond},
+			size:   KiB,
+			want:   "took over 50ms",
+		},
+		{
+			name:   "too large",
+			module: "upper",
+			limits: pipeio.WasmLimits{Memory: 64 * KiB},
+			size:   128 * KiB,
+			want:   "131072 bytes don't fit in memory",
+		},
+		{
+			name:   "input out of memory",
+			module: "greedy",
+			limits: pipeio.WasmLimits{Memory: 64 * KiB},
+			size:   KiB,
+			want:   "alloc returned 1024 bytes at 65535, out of memory",
+		},
+		{
+			name:   "output out of memory",
+			module: "wild",
+			limits: pipeio.WasmLimits{Memory: 64 * KiB},
+			size:   KiB,
+			want:   "transform returned 1024 bytes at 65536, out of memory",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			valve := pipeio.Wasm(wasmRuntime{}, []byte(test.module), test.limits, pipeio.NewBuffer(KiB, 4))
+
+			// when
+			err := pipe.New(&pipetest.Source{Regions: text(4, test.size)}, &pipetest.Sink{}, valve).Pipe(context.Background())
+
+			// then
+			assert.Assert(t, errors.Is(err, pipeio.ErrWasmLimit), err)
+			assert.ErrorContains(t, err, test.want)
+		})
+	}
+}
+
+func TestWasm_invalid(t *testing.T) {
+	// given
+	valve := pipeio.Wasm(wasmRuntime{}, []byte("junk"), pipeio.WasmLimits{}, pipeio.NewBuffer(KiB, 4))
+
+	// when
+	err := pipe.New(&pipetest.Source{Regions: text(4, KiB)}, &pipetest.Sink{}, valve).Pipe(context.Background())
+
+	// then
+	assert.ErrorContains(t, err, `wasm: error instantiating module: invalid module "junk"`)
+}